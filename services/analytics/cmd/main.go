@@ -1,27 +1,44 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
 
 	"usethislink/services/analytics/internal/db"
 	"usethislink/services/analytics/internal/handler"
+	"usethislink/services/analytics/internal/store"
+	"usethislink/services/internal/migrate"
 
 	"github.com/gorilla/mux"
 )
 
 func main() {
-	dbConn, err := db.InitDBFromEnv()
-	if err != nil {
-		log.Fatalf("Failed to initialize DB: %v", err)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
+	// DB_DRIVER=memory runs without a database; nothing survives a restart.
+	var st store.AnalyticsStore
+	if os.Getenv("DB_DRIVER") == "memory" {
+		st = store.NewMemory()
+	} else {
+		dbConn, dialect, err := db.InitDBFromEnv()
+		if err != nil {
+			log.Fatalf("Failed to initialize DB: %v", err)
+		}
+		defer dbConn.Close()
+		st = store.New(dbConn, dialect)
 	}
-	defer dbConn.Close()
 
 	r := mux.NewRouter()
-	r.HandleFunc("/stats/{shortcode}", handler.StatsHandler(dbConn)).Methods("GET")
-	r.HandleFunc("/history", handler.HistoryHandler(dbConn)).Methods("GET")
-	r.HandleFunc("/log", handler.LogHandler(dbConn)).Methods("POST")
+	r.HandleFunc("/stats/{shortcode}", handler.StatsHandler(st)).Methods("GET")
+	r.HandleFunc("/history", handler.HistoryHandler(st)).Methods("GET")
+	r.HandleFunc("/export/links", handler.ExportHandler(st)).Methods("GET")
+	r.HandleFunc("/log", handler.LogHandler(st)).Methods("POST")
+	r.HandleFunc("/link-analytics", handler.TotalsHandler(st)).Methods("POST")
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -30,3 +47,15 @@ func main() {
 	log.Printf("Analytics Service running on :%s", port)
 	log.Fatal(http.ListenAndServe(":"+port, r))
 }
+
+// runMigrate implements `analyticsservice migrate [up | down [steps] | status]`.
+func runMigrate(args []string) {
+	dbConn, dialect, err := db.Open()
+	if err != nil {
+		log.Fatalf("Failed to open DB: %v", err)
+	}
+	defer dbConn.Close()
+	if err := migrate.Command(context.Background(), db.Migrator(dbConn, dialect), args, os.Stdout); err != nil {
+		log.Fatalf("migrate: %v", err)
+	}
+}
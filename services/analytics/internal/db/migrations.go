@@ -0,0 +1,207 @@
+package db
+
+import "usethislink/services/internal/migrate"
+
+// Migrations is the analytics schema history. Never edit an applied
+// migration; add a new one instead. Version 1 uses IF NOT EXISTS so databases
+// created before migrations existed adopt it without changes.
+var Migrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "create url_access_logs and link_analytics",
+		Up: migrate.Script{
+			Postgres: `
+			CREATE TABLE IF NOT EXISTS url_access_logs (
+				id SERIAL PRIMARY KEY,
+				short_url TEXT,
+				session_id TEXT,
+				ip_address TEXT,
+				user_agent TEXT,
+				referrer TEXT,
+				accessed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				visit_type TEXT DEFAULT 'redirect' CHECK (visit_type IN ('redirect', 'preview')),
+				city TEXT,
+				country TEXT,
+				browser TEXT,
+				device TEXT,
+				operating_system TEXT,
+				deleted_at TIMESTAMP
+			);
+
+			CREATE TABLE IF NOT EXISTS link_analytics (
+				short_url TEXT PRIMARY KEY,
+				total_visits INTEGER DEFAULT 0,
+				unique_visitors INTEGER DEFAULT 0,
+				redirect_count INTEGER DEFAULT 0,
+				preview_count INTEGER DEFAULT 0,
+				country_counts TEXT,
+				browser_counts TEXT,
+				device_counts TEXT,
+				last_updated TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS url_access_logs (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				short_url TEXT,
+				session_id TEXT,
+				ip_address TEXT,
+				user_agent TEXT,
+				referrer TEXT,
+				accessed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				visit_type TEXT DEFAULT 'redirect' CHECK (visit_type IN ('redirect', 'preview')),
+				city TEXT,
+				country TEXT,
+				browser TEXT,
+				device TEXT,
+				operating_system TEXT,
+				deleted_at TIMESTAMP
+			);
+
+			CREATE TABLE IF NOT EXISTS link_analytics (
+				short_url TEXT PRIMARY KEY,
+				total_visits INTEGER DEFAULT 0,
+				unique_visitors INTEGER DEFAULT 0,
+				redirect_count INTEGER DEFAULT 0,
+				preview_count INTEGER DEFAULT 0,
+				country_counts TEXT,
+				browser_counts TEXT,
+				device_counts TEXT,
+				last_updated TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);`,
+		},
+		Down: migrate.Both(`
+			DROP TABLE IF EXISTS link_analytics;
+			DROP TABLE IF EXISTS url_access_logs;`),
+	},
+	{
+		Version: 2,
+		Name:    "access log domain",
+		Up: migrate.Script{
+			Postgres: `ALTER TABLE url_access_logs ADD COLUMN IF NOT EXISTS domain TEXT NOT NULL DEFAULT '';`,
+			SQLite:   `ALTER TABLE url_access_logs ADD COLUMN domain TEXT NOT NULL DEFAULT '';`,
+		},
+		Down: migrate.Both(`ALTER TABLE url_access_logs DROP COLUMN domain;`),
+	},
+	{
+		Version: 3,
+		Name:    "link analytics domain",
+		Up: migrate.Script{
+			Postgres: `
+			ALTER TABLE link_analytics ADD COLUMN IF NOT EXISTS domain TEXT NOT NULL DEFAULT '';
+			ALTER TABLE link_analytics DROP CONSTRAINT IF EXISTS link_analytics_pkey;
+			ALTER TABLE link_analytics ADD PRIMARY KEY (domain, short_url);`,
+			SQLite: `
+			CREATE TABLE link_analytics_v3 (
+				domain TEXT NOT NULL DEFAULT '',
+				short_url TEXT NOT NULL,
+				total_visits INTEGER DEFAULT 0,
+				unique_visitors INTEGER DEFAULT 0,
+				redirect_count INTEGER DEFAULT 0,
+				preview_count INTEGER DEFAULT 0,
+				country_counts TEXT,
+				browser_counts TEXT,
+				device_counts TEXT,
+				last_updated TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (domain, short_url)
+			);
+			INSERT INTO link_analytics_v3 (short_url, total_visits, unique_visitors, redirect_count, preview_count, country_counts, browser_counts, device_counts, last_updated)
+				SELECT short_url, total_visits, unique_visitors, redirect_count, preview_count, country_counts, browser_counts, device_counts, last_updated FROM link_analytics;
+			DROP TABLE link_analytics;
+			ALTER TABLE link_analytics_v3 RENAME TO link_analytics;`,
+		},
+		Down: migrate.Script{
+			Postgres: `
+			DELETE FROM link_analytics WHERE domain <> '';
+			ALTER TABLE link_analytics DROP CONSTRAINT IF EXISTS link_analytics_pkey;
+			ALTER TABLE link_analytics ADD PRIMARY KEY (short_url);
+			ALTER TABLE link_analytics DROP COLUMN domain;`,
+			SQLite: `
+			CREATE TABLE link_analytics_v2 (
+				short_url TEXT PRIMARY KEY,
+				total_visits INTEGER DEFAULT 0,
+				unique_visitors INTEGER DEFAULT 0,
+				redirect_count INTEGER DEFAULT 0,
+				preview_count INTEGER DEFAULT 0,
+				country_counts TEXT,
+				browser_counts TEXT,
+				device_counts TEXT,
+				last_updated TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+			INSERT INTO link_analytics_v2 (short_url, total_visits, unique_visitors, redirect_count, preview_count, country_counts, browser_counts, device_counts, last_updated)
+				SELECT short_url, total_visits, unique_visitors, redirect_count, preview_count, country_counts, browser_counts, device_counts, last_updated FROM link_analytics WHERE domain = '';
+			DROP TABLE link_analytics;
+			ALTER TABLE link_analytics_v2 RENAME TO link_analytics;`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "qr scan visits",
+		// SQLite cannot alter a CHECK constraint, so it rebuilds the table.
+		Up: migrate.Script{
+			Postgres: `
+			ALTER TABLE url_access_logs DROP CONSTRAINT IF EXISTS url_access_logs_visit_type_check;
+			ALTER TABLE url_access_logs ADD CONSTRAINT url_access_logs_visit_type_check
+				CHECK (visit_type IN ('redirect', 'preview', 'qr_scan'));
+			CREATE INDEX IF NOT EXISTS url_access_logs_link_idx ON url_access_logs (domain, short_url, visit_type);`,
+			SQLite: `
+			CREATE TABLE url_access_logs_v4 (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				short_url TEXT,
+				session_id TEXT,
+				ip_address TEXT,
+				user_agent TEXT,
+				referrer TEXT,
+				accessed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				visit_type TEXT DEFAULT 'redirect' CHECK (visit_type IN ('redirect', 'preview', 'qr_scan')),
+				city TEXT,
+				country TEXT,
+				browser TEXT,
+				device TEXT,
+				operating_system TEXT,
+				deleted_at TIMESTAMP,
+				domain TEXT NOT NULL DEFAULT ''
+			);
+			INSERT INTO url_access_logs_v4 (id, short_url, session_id, ip_address, user_agent, referrer, accessed_at, visit_type,
+				city, country, browser, device, operating_system, deleted_at, domain)
+				SELECT id, short_url, session_id, ip_address, user_agent, referrer, accessed_at, visit_type,
+				city, country, browser, device, operating_system, deleted_at, domain FROM url_access_logs;
+			DROP TABLE url_access_logs;
+			ALTER TABLE url_access_logs_v4 RENAME TO url_access_logs;
+			CREATE INDEX IF NOT EXISTS url_access_logs_link_idx ON url_access_logs (domain, short_url, visit_type);`,
+		},
+		// Scans are kept as plain redirects.
+		Down: migrate.Script{
+			Postgres: `
+			DROP INDEX IF EXISTS url_access_logs_link_idx;
+			UPDATE url_access_logs SET visit_type = 'redirect' WHERE visit_type = 'qr_scan';
+			ALTER TABLE url_access_logs DROP CONSTRAINT IF EXISTS url_access_logs_visit_type_check;
+			ALTER TABLE url_access_logs ADD CONSTRAINT url_access_logs_visit_type_check
+				CHECK (visit_type IN ('redirect', 'preview'));`,
+			SQLite: `
+			CREATE TABLE url_access_logs_v3 (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				short_url TEXT,
+				session_id TEXT,
+				ip_address TEXT,
+				user_agent TEXT,
+				referrer TEXT,
+				accessed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				visit_type TEXT DEFAULT 'redirect' CHECK (visit_type IN ('redirect', 'preview')),
+				city TEXT,
+				country TEXT,
+				browser TEXT,
+				device TEXT,
+				operating_system TEXT,
+				deleted_at TIMESTAMP,
+				domain TEXT NOT NULL DEFAULT ''
+			);
+			INSERT INTO url_access_logs_v3 (id, short_url, session_id, ip_address, user_agent, referrer, accessed_at, visit_type,
+				city, country, browser, device, operating_system, deleted_at, domain)
+				SELECT id, short_url, session_id, ip_address, user_agent, referrer, accessed_at,
+				CASE WHEN visit_type = 'qr_scan' THEN 'redirect' ELSE visit_type END,
+				city, country, browser, device, operating_system, deleted_at, domain FROM url_access_logs;
+			DROP TABLE url_access_logs;
+			ALTER TABLE url_access_logs_v3 RENAME TO url_access_logs;`,
+		},
+	},
+}
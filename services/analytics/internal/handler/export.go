@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"usethislink/services/analytics/internal/store"
+	"usethislink/services/internal/shorturl"
+	"usethislink/services/internal/workspace"
+
+	"github.com/sirupsen/logrus"
+)
+
+type exportedLink struct {
+	Domain            string   `json:"domain"`
+	ShortCode         string   `json:"short_code"`
+	ShortURL          string   `json:"short_url"`
+	OriginalURL       string   `json:"original_url"`
+	WorkspaceID       string   `json:"workspace_id"`
+	UserEmail         string   `json:"user_email"`
+	RedirectCode      int      `json:"redirect_code"`
+	DestinationLocked bool     `json:"destination_locked"`
+	Tags              []string `json:"tags"`
+	CreatedAt         string   `json:"created_at"`
+	ExpiryDate        string   `json:"expiry_date"`
+	TotalVisits       int64    `json:"total_visits"`
+	UniqueVisitors    int64    `json:"unique_visitors"`
+	RedirectCount     int64    `json:"redirect_count"`
+	PreviewCount      int64    `json:"preview_count"`
+	QRScans           int64    `json:"qr_scans"`
+}
+
+var exportCSVHeader = []string{
+	"domain", "short_code", "short_url", "original_url", "workspace_id", "user_email", "redirect_code",
+	"destination_locked", "tags", "created_at", "expiry_date", "total_visits", "unique_visitors", "redirect_count", "preview_count", "qr_scans",
+}
+
+func (e exportedLink) csvRecord() []string {
+	return []string{
+		e.Domain, e.ShortCode, e.ShortURL, e.OriginalURL, e.WorkspaceID, e.UserEmail, strconv.Itoa(e.RedirectCode),
+		strconv.FormatBool(e.DestinationLocked), strings.Join(e.Tags, ","), e.CreatedAt, e.ExpiryDate, strconv.FormatInt(e.TotalVisits, 10),
+		strconv.FormatInt(e.UniqueVisitors, 10), strconv.FormatInt(e.RedirectCount, 10), strconv.FormatInt(e.PreviewCount, 10),
+		strconv.FormatInt(e.QRScans, 10),
+	}
+}
+
+func newExportedLink(l store.ExportedLink) exportedLink {
+	e := exportedLink{
+		Domain:            l.Domain,
+		ShortCode:         l.ShortURL,
+		ShortURL:          shorturl.Build(l.Domain, l.ShortURL),
+		OriginalURL:       l.OriginalURL,
+		WorkspaceID:       l.WorkspaceID,
+		UserEmail:         l.UserEmail,
+		RedirectCode:      l.RedirectCode,
+		DestinationLocked: l.DestinationLocked,
+		Tags:              l.Tags,
+		CreatedAt:         l.CreatedAt.UTC().Format(time.RFC3339),
+		TotalVisits:       l.TotalVisits,
+		UniqueVisitors:    l.UniqueVisitors,
+		RedirectCount:     l.RedirectCount,
+		PreviewCount:      l.PreviewCount,
+		QRScans:           l.QRScans,
+	}
+	if e.Tags == nil {
+		e.Tags = []string{}
+	}
+	if !l.ExpiryDate.IsZero() {
+		e.ExpiryDate = l.ExpiryDate.UTC().Format(time.RFC3339)
+	}
+	return e
+}
+
+// exportWriter writes one export format. begin runs before the first link (or
+// at the end if there are none), so a failing query can still get a 500.
+type exportWriter struct {
+	contentType string
+	begin       func() error
+	link        func(exportedLink) error
+	end         func() error
+}
+
+func newExportWriter(w http.ResponseWriter, format string) (exportWriter, bool) {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		return exportWriter{
+			contentType: "text/csv; charset=utf-8",
+			begin:       func() error { return cw.Write(exportCSVHeader) },
+			link:        func(e exportedLink) error { return cw.Write(e.csvRecord()) },
+			end: func() error {
+				cw.Flush()
+				return cw.Error()
+			},
+		}, true
+	case "json":
+		first := true
+		return exportWriter{
+			contentType: "application/json",
+			begin: func() error {
+				_, err := w.Write([]byte("["))
+				return err
+			},
+			link: func(e exportedLink) error {
+				b, err := json.Marshal(e)
+				if err != nil {
+					return err
+				}
+				if !first {
+					b = append([]byte(","), b...)
+				}
+				first = false
+				_, err = w.Write(b)
+				return err
+			},
+			end: func() error {
+				_, err := w.Write([]byte("]\n"))
+				return err
+			},
+		}, true
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		return exportWriter{
+			contentType: "application/x-ndjson",
+			begin:       func() error { return nil },
+			link:        func(e exportedLink) error { return enc.Encode(e) },
+			end:         func() error { return nil },
+		}, true
+	}
+	return exportWriter{}, false
+}
+
+// ExportHandler serves /export/links?format=csv|json|ndjson (default json):
+// every personal link of the caller, or with ?workspace_id= every link of a
+// workspace they belong to, with its totals. Rows are written as they are read.
+func ExportHandler(st store.AnalyticsStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sid := r.Header.Get("X-Session-ID")
+		userEmail := r.Header.Get("X-User-Email")
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+		out, ok := newExportWriter(w, format)
+		if !ok {
+			http.Error(w, "format must be csv, json or ndjson", http.StatusBadRequest)
+			return
+		}
+		workspaceID := r.URL.Query().Get("workspace_id")
+		if workspaceID != "" {
+			role, err := workspaceRole(r.Context(), st, workspaceID, userEmail)
+			if err != nil {
+				logrus.Errorf("Failed to check workspace role: %v", err)
+				http.Error(w, "DB error", http.StatusInternalServerError)
+				return
+			}
+			if !role.AtLeast(workspace.Viewer) {
+				http.NotFound(w, r)
+				return
+			}
+		} else if sid == "" && userEmail == "" {
+			http.Error(w, "No session", http.StatusUnauthorized)
+			return
+		}
+
+		started := false
+		start := func() error {
+			started = true
+			w.Header().Set("Content-Type", out.contentType)
+			w.Header().Set("Content-Disposition", `attachment; filename="links.`+format+`"`)
+			return out.begin()
+		}
+		err := st.EachLink(r.Context(), sid, userEmail, workspaceID, func(l store.ExportedLink) error {
+			if !started {
+				if err := start(); err != nil {
+					return err
+				}
+			}
+			return out.link(newExportedLink(l))
+		})
+		if err == nil && !started {
+			err = start()
+		}
+		if err == nil {
+			err = out.end()
+		}
+		if err != nil {
+			logrus.Errorf("Failed to export links: %v", err)
+			if !started {
+				http.Error(w, "Failed to export links", http.StatusInternalServerError)
+			}
+			// Once streaming began the status is sent; the truncated body is all we can do.
+		}
+	}
+}
@@ -2,11 +2,15 @@ package handler
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"time"
+
+	"usethislink/services/analytics/internal/store"
+	"usethislink/services/internal/shorturl"
+	"usethislink/services/internal/workspace"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
@@ -19,48 +23,72 @@ type statsResponse struct {
 	UniqueVisitors int    `json:"unique_visitors"`
 	RedirectCount  int    `json:"redirect_count"`
 	PreviewCount   int    `json:"preview_count"`
+	Clicks         int    `json:"clicks"`
+	QRScans        int    `json:"qr_scans"`
 	CreatedAt      string `json:"created_at"`
 	CountryStats   string `json:"country_stats,omitempty"`
 	BrowserStats   string `json:"browser_stats,omitempty"`
 	DeviceStats    string `json:"device_stats,omitempty"`
 }
 
-func StatsHandler(db *sql.DB) http.HandlerFunc {
+// workspaceRole is the caller's role in workspaceID, "" for non-members.
+func workspaceRole(ctx context.Context, st store.AnalyticsStore, workspaceID, email string) (workspace.Role, error) {
+	if email == "" {
+		return "", nil
+	}
+	role, err := st.MemberRole(ctx, workspaceID, email)
+	if err == store.ErrNotFound {
+		return "", nil
+	}
+	return role, err
+}
+
+// canView reports whether the caller may see a link's stats: its creator for
+// personal links, any member for workspace links.
+func canView(ctx context.Context, st store.AnalyticsStore, stats store.LinkStats, sessionID, email string) (bool, error) {
+	if stats.WorkspaceID == "" {
+		return (email != "" && stats.UserEmail == email) || (sessionID != "" && stats.SessionID == sessionID), nil
+	}
+	role, err := workspaceRole(ctx, st, stats.WorkspaceID, email)
+	return role.AtLeast(workspace.Viewer), err
+}
+
+// StatsHandler serves /stats/{shortcode}; links on a custom domain are
+// addressed with ?domain=. Links the caller cannot see are reported as missing.
+func StatsHandler(st store.AnalyticsStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		shortcode := mux.Vars(r)["shortcode"]
-		var resp statsResponse
-		err := db.QueryRowContext(context.Background(),
-			`SELECT 
-				u.short_url, u.original_url, u.visits, u.created_at,
-				COALESCE(a.total_visits, 0) as total_visits,
-				COALESCE(a.unique_visitors, 0) as unique_visitors,
-				COALESCE(a.redirect_count, 0) as redirect_count,
-				COALESCE(a.preview_count, 0) as preview_count,
-				COALESCE(a.country_counts, '{}') as country_counts,
-				COALESCE(a.browser_counts, '{}') as browser_counts,
-				COALESCE(a.device_counts, '{}') as device_counts
-			FROM url_mappings u 
-			LEFT JOIN link_analytics a ON u.short_url = a.short_url
-			WHERE u.short_url = ?`,
-			shortcode).Scan(
-			&resp.ShortURL,
-			&resp.OriginalURL,
-			&resp.TotalVisits,
-			&resp.CreatedAt,
-			&resp.TotalVisits,
-			&resp.UniqueVisitors,
-			&resp.RedirectCount,
-			&resp.PreviewCount,
-			&resp.CountryStats,
-			&resp.BrowserStats,
-			&resp.DeviceStats,
-		)
+		domain := shorturl.Hostname(r.URL.Query().Get("domain"))
+		stats, err := st.LinkStats(r.Context(), domain, shortcode)
 		if err != nil {
 			logrus.Errorf("Failed to fetch stats: %v", err)
 			http.NotFound(w, r)
 			return
 		}
-		resp.ShortURL = os.Getenv("BASE_URL") + "/" + resp.ShortURL
+		ok, err := canView(r.Context(), st, stats, r.Header.Get("X-Session-ID"), r.Header.Get("X-User-Email"))
+		if err != nil {
+			logrus.Errorf("Failed to check workspace role: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		resp := statsResponse{
+			ShortURL:       shorturl.Build(stats.Domain, stats.ShortURL),
+			OriginalURL:    stats.OriginalURL,
+			TotalVisits:    stats.TotalVisits,
+			UniqueVisitors: stats.UniqueVisitors,
+			RedirectCount:  stats.RedirectCount,
+			PreviewCount:   stats.PreviewCount,
+			Clicks:         stats.Clicks,
+			QRScans:        stats.Scans,
+			CreatedAt:      stats.CreatedAt.Format(time.RFC3339),
+			CountryStats:   stats.CountryStats,
+			BrowserStats:   stats.BrowserStats,
+			DeviceStats:    stats.DeviceStats,
+		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(resp)
 	}
@@ -69,48 +97,56 @@ func StatsHandler(db *sql.DB) http.HandlerFunc {
 type urlHistoryResponse struct {
 	OriginalURL string `json:"original_url"`
 	ShortURL    string `json:"short_url"`
+	WorkspaceID string `json:"workspace_id,omitempty"`
 	ExpiryDate  string `json:"expiry_date"`
 	IsLoggedIn  bool   `json:"is_logged_in"`
 	UserEmail   string `json:"user_email"`
 }
 
-func HistoryHandler(db *sql.DB) http.HandlerFunc {
+// HistoryHandler lists the caller's personal links, or with ?workspace_id= the
+// links of a workspace they belong to.
+func HistoryHandler(st store.AnalyticsStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		sid := r.Header.Get("X-Session-ID")
 		userEmail := r.Header.Get("X-User-Email")
-		baseURL := os.Getenv("BASE_URL")
-		var rows *sql.Rows
+		var entries []store.HistoryEntry
 		var err error
-		if userEmail != "" {
-			rows, err = db.Query(`
-				SELECT original_url, short_url, expiry_date, is_logged_in, user_email
-				FROM url_mappings WHERE user_email = ? OR session_id = ? ORDER BY created_at DESC
-			`, userEmail, sid)
+		if workspaceID := r.URL.Query().Get("workspace_id"); workspaceID != "" {
+			role, roleErr := workspaceRole(r.Context(), st, workspaceID, userEmail)
+			if roleErr != nil {
+				logrus.Errorf("Failed to check workspace role: %v", roleErr)
+				http.Error(w, "DB error", http.StatusInternalServerError)
+				return
+			}
+			if !role.AtLeast(workspace.Viewer) {
+				http.NotFound(w, r)
+				return
+			}
+			entries, err = st.WorkspaceHistory(r.Context(), workspaceID)
 		} else {
-			rows, err = db.Query(`
-				SELECT original_url, short_url, expiry_date, is_logged_in, user_email
-				FROM url_mappings WHERE session_id = ? ORDER BY created_at DESC
-			`, sid)
+			entries, err = st.History(r.Context(), sid, userEmail)
 		}
 		if err != nil {
 			logrus.Errorf("Failed to fetch history: %v", err)
 			http.Error(w, "Failed to fetch history", http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
-		var history []urlHistoryResponse
-		for rows.Next() {
-			var h urlHistoryResponse
-			err := rows.Scan(&h.OriginalURL, &h.ShortURL, &h.ExpiryDate, &h.IsLoggedIn, &h.UserEmail)
-			if err == nil {
-				if baseURL != "" && h.ShortURL != "" {
-					h.ShortURL = baseURL + "/" + h.ShortURL
-				}
-				history = append(history, h)
+		history := make([]urlHistoryResponse, 0, len(entries))
+		for _, e := range entries {
+			h := urlHistoryResponse{
+				OriginalURL: e.OriginalURL,
+				ShortURL:    e.ShortURL,
+				WorkspaceID: e.WorkspaceID,
+				IsLoggedIn:  e.IsLoggedIn,
+				UserEmail:   e.UserEmail,
 			}
-		}
-		if history == nil {
-			history = []urlHistoryResponse{}
+			if !e.ExpiryDate.IsZero() {
+				h.ExpiryDate = e.ExpiryDate.Format(time.RFC3339)
+			}
+			if h.ShortURL != "" {
+				h.ShortURL = shorturl.Build(e.Domain, h.ShortURL)
+			}
+			history = append(history, h)
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(history)
@@ -118,6 +154,7 @@ func HistoryHandler(db *sql.DB) http.HandlerFunc {
 }
 
 type analyticsEvent struct {
+	Domain    string `json:"domain"`
 	ShortURL  string `json:"short_url"`
 	SessionID string `json:"session_id"`
 	UserEmail string `json:"user_email"`
@@ -127,7 +164,7 @@ type analyticsEvent struct {
 	Event     string `json:"event"`
 }
 
-func LogHandler(db *sql.DB) http.HandlerFunc {
+func LogHandler(st store.AnalyticsStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var event analyticsEvent
 		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
@@ -135,6 +172,12 @@ func LogHandler(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Invalid event", http.StatusBadRequest)
 			return
 		}
+		switch event.Event {
+		case store.VisitRedirect, store.VisitPreview, store.VisitQRScan:
+		default:
+			http.Error(w, "Invalid event", http.StatusBadRequest)
+			return
+		}
 		// Optionally enrich with user info from User service
 		var userInfo map[string]interface{}
 		if event.UserEmail != "" {
@@ -150,10 +193,15 @@ func LogHandler(db *sql.DB) http.HandlerFunc {
 			}
 		}
 		// Write to url_access_logs
-		_, err := db.Exec(`
-			INSERT INTO url_access_logs (short_url, session_id, ip_address, user_agent, referrer, visit_type, city, country, browser, device, operating_system)
-			VALUES ($1, $2, $3, $4, $5, $6, '', '', '', '', '', '')
-		`, event.ShortURL, event.SessionID, event.IPAddress, event.UserAgent, event.Referrer, event.Event)
+		err := st.LogAccess(r.Context(), store.AccessLog{
+			Domain:    event.Domain,
+			ShortURL:  event.ShortURL,
+			SessionID: event.SessionID,
+			IPAddress: event.IPAddress,
+			UserAgent: event.UserAgent,
+			Referrer:  event.Referrer,
+			VisitType: event.Event,
+		})
 		if err != nil {
 			logrus.Errorf("Failed to insert access log: %v", err)
 			http.Error(w, "Failed to log event", http.StatusInternalServerError)
@@ -163,3 +211,22 @@ func LogHandler(db *sql.DB) http.HandlerFunc {
 		w.Write([]byte(`{"status":"logged"}`))
 	}
 }
+
+// TotalsHandler accepts click totals from the link service's importer. It is
+// internal and not routed by the gateway.
+func TotalsHandler(st store.AnalyticsStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var totals []store.LinkTotal
+		if err := json.NewDecoder(r.Body).Decode(&totals); err != nil {
+			http.Error(w, "Invalid totals", http.StatusBadRequest)
+			return
+		}
+		if err := st.SetTotals(r.Context(), totals); err != nil {
+			logrus.Errorf("Failed to store link totals: %v", err)
+			http.Error(w, "Failed to store totals", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status":"stored"}`))
+	}
+}
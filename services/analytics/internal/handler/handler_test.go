@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"usethislink/services/analytics/internal/store"
+	"usethislink/services/internal/workspace"
+
+	"github.com/gorilla/mux"
+)
+
+// analyticsTest serves the analytics routes from a memory store seeded with a
+// personal link of me@example.com and a link of workspace ws1.
+type analyticsTest struct {
+	t      *testing.T
+	st     *store.MemoryStore
+	router *mux.Router
+}
+
+func newAnalyticsTest(t *testing.T) *analyticsTest {
+	t.Helper()
+	t.Setenv("BASE_URL", "http://ut.link")
+	st := store.NewMemory()
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	st.PutLink(store.MemoryLink{ShortURL: "mine", OriginalURL: "https://example.com/mine", UserEmail: "me@example.com",
+		SessionID: "s-me", Visits: 3, CreatedAt: created, IsLoggedIn: true, Tags: []string{"news", "promo"}})
+	st.PutLink(store.MemoryLink{Domain: "go.acme.com", ShortURL: "team", OriginalURL: "https://example.com/team",
+		WorkspaceID: "ws1", UserEmail: "owner@example.com", CreatedAt: created.Add(time.Hour)})
+	st.PutMember("ws1", "owner@example.com", workspace.Owner)
+	st.PutMember("ws1", "viewer@example.com", workspace.Viewer)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/stats/{shortcode}", StatsHandler(st)).Methods("GET")
+	r.HandleFunc("/history", HistoryHandler(st)).Methods("GET")
+	r.HandleFunc("/export/links", ExportHandler(st)).Methods("GET")
+	r.HandleFunc("/log", LogHandler(st)).Methods("POST")
+	return &analyticsTest{t: t, st: st, router: r}
+}
+
+// do sends a request as email ("" for an anonymous session).
+func (at *analyticsTest) do(method, target, email, body string) *httptest.ResponseRecorder {
+	at.t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if email != "" {
+		req.Header.Set("X-Session-ID", "s-"+strings.Split(email, "@")[0])
+		req.Header.Set("X-User-Email", email)
+	}
+	rec := httptest.NewRecorder()
+	at.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLogThenStats(t *testing.T) {
+	at := newAnalyticsTest(t)
+	for _, event := range []string{"redirect", "redirect", "qr_scan", "preview"} {
+		body := `{"short_url": "mine", "session_id": "visitor", "ip_address": "10.0.0.1", "event": "` + event + `"}`
+		if rec := at.do("POST", "/log", "", body); rec.Code != http.StatusOK {
+			t.Fatalf("log %s = %d %s", event, rec.Code, rec.Body)
+		}
+	}
+	if rec := at.do("POST", "/log", "", `{"short_url": "mine", "event": "click"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("log of an unknown event = %d, want 400", rec.Code)
+	}
+	if n := len(at.st.AccessLogs()); n != 4 {
+		t.Errorf("%d access logs, want 4", n)
+	}
+
+	rec := at.do("GET", "/stats/mine", "me@example.com", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("stats = %d %s", rec.Code, rec.Body)
+	}
+	var stats statsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.ShortURL != "http://ut.link/mine" || stats.TotalVisits != 3 || stats.Clicks != 2 || stats.QRScans != 1 ||
+		stats.PreviewCount != 1 || stats.UniqueVisitors != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestStatsAccess(t *testing.T) {
+	at := newAnalyticsTest(t)
+	for _, tc := range []struct {
+		target string
+		email  string
+		want   int
+	}{
+		{"/stats/mine", "me@example.com", http.StatusOK},
+		{"/stats/mine", "other@example.com", http.StatusNotFound},
+		{"/stats/mine", "", http.StatusNotFound},
+		{"/stats/team?domain=go.acme.com", "viewer@example.com", http.StatusOK},
+		{"/stats/team?domain=go.acme.com", "other@example.com", http.StatusNotFound},
+		{"/stats/team", "viewer@example.com", http.StatusNotFound},
+		{"/stats/missing", "me@example.com", http.StatusNotFound},
+	} {
+		if rec := at.do("GET", tc.target, tc.email, ""); rec.Code != tc.want {
+			t.Errorf("GET %s as %q = %d, want %d", tc.target, tc.email, rec.Code, tc.want)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	at := newAnalyticsTest(t)
+	var history []urlHistoryResponse
+	rec := at.do("GET", "/history", "me@example.com", "")
+	if err := json.NewDecoder(rec.Body).Decode(&history); err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].ShortURL != "http://ut.link/mine" {
+		t.Errorf("history = %+v", history)
+	}
+	if rec := at.do("GET", "/history?workspace_id=ws1", "viewer@example.com", ""); rec.Code != http.StatusOK {
+		t.Errorf("workspace history as viewer = %d", rec.Code)
+	}
+	if rec := at.do("GET", "/history?workspace_id=ws1", "other@example.com", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("workspace history as outsider = %d, want 404", rec.Code)
+	}
+}
+
+func TestExport(t *testing.T) {
+	at := newAnalyticsTest(t)
+	at.do("POST", "/log", "", `{"short_url": "mine", "session_id": "visitor", "event": "qr_scan"}`)
+
+	rec := at.do("GET", "/export/links?format=csv", "me@example.com", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("csv export = %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("csv has %d rows, want header and one link", len(rows))
+	}
+	got := map[string]string{}
+	for i, col := range rows[0] {
+		got[col] = rows[1][i]
+	}
+	for col, want := range map[string]string{
+		"short_code": "mine", "short_url": "http://ut.link/mine", "tags": "news,promo", "redirect_code": "302",
+		"created_at": "2024-03-01T12:00:00Z", "expiry_date": "", "total_visits": "3", "qr_scans": "1",
+	} {
+		if got[col] != want {
+			t.Errorf("csv %s = %q, want %q", col, got[col], want)
+		}
+	}
+
+	rec = at.do("GET", "/export/links?workspace_id=ws1", "viewer@example.com", "")
+	var links []exportedLink
+	if err := json.NewDecoder(rec.Body).Decode(&links); err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || links[0].ShortURL != "https://go.acme.com/team" || links[0].Tags == nil {
+		t.Errorf("json export = %+v", links)
+	}
+
+	rec = at.do("GET", "/export/links?format=ndjson", "me@example.com", "")
+	if lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"qr_scans":1`) {
+		t.Errorf("ndjson export = %q", rec.Body)
+	}
+
+	for _, tc := range []struct {
+		target string
+		email  string
+		want   int
+	}{
+		{"/export/links?format=xml", "me@example.com", http.StatusBadRequest},
+		{"/export/links", "", http.StatusUnauthorized},
+		{"/export/links?workspace_id=ws1", "other@example.com", http.StatusNotFound},
+	} {
+		if rec := at.do("GET", tc.target, tc.email, ""); rec.Code != tc.want {
+			t.Errorf("GET %s as %q = %d, want %d", tc.target, tc.email, rec.Code, tc.want)
+		}
+	}
+}
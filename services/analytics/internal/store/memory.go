@@ -0,0 +1,208 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"usethislink/services/internal/workspace"
+)
+
+// MemoryLink is the slice of url_mappings the analytics service reads.
+// The link service owns that table, so tests seed it with PutLink.
+type MemoryLink struct {
+	Domain      string
+	ShortURL    string
+	WorkspaceID string
+	OriginalURL string
+	SessionID   string
+	UserEmail   string
+	Visits      int
+	CreatedAt   time.Time
+	ExpiryDate  time.Time
+	IsLoggedIn  bool
+	// RedirectCode 0 reads as 302.
+	RedirectCode      int
+	DestinationLocked bool
+	Tags              []string
+}
+
+// MemoryStore is an in-process AnalyticsStore for tests and throwaway instances.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	links   map[string]MemoryLink
+	logs    []AccessLog
+	members map[string]workspace.Role // workspace ID + "/" + email
+	totals  map[string]int64          // domain + "/" + code
+}
+
+func NewMemory() *MemoryStore {
+	return &MemoryStore{links: make(map[string]MemoryLink), members: make(map[string]workspace.Role), totals: make(map[string]int64)}
+}
+
+// PutMember seeds workspace_members, which the link service owns.
+func (m *MemoryStore) PutMember(workspaceID, email string, role workspace.Role) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.members[workspaceID+"/"+email] = role
+}
+
+func (m *MemoryStore) MemberRole(ctx context.Context, workspaceID, email string) (workspace.Role, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	role, ok := m.members[workspaceID+"/"+email]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return role, nil
+}
+
+func (m *MemoryStore) PutLink(l MemoryLink) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.links[l.Domain+"/"+l.ShortURL] = l
+}
+
+// AccessLogs returns a copy of everything logged so far.
+func (m *MemoryStore) AccessLogs() []AccessLog {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]AccessLog(nil), m.logs...)
+}
+
+func (m *MemoryStore) LinkStats(ctx context.Context, domain, code string) (LinkStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	l, ok := m.links[domain+"/"+code]
+	if !ok {
+		return LinkStats{}, ErrNotFound
+	}
+	st := LinkStats{
+		Domain:       l.Domain,
+		ShortURL:     l.ShortURL,
+		WorkspaceID:  l.WorkspaceID,
+		SessionID:    l.SessionID,
+		UserEmail:    l.UserEmail,
+		OriginalURL:  l.OriginalURL,
+		TotalVisits:  l.Visits,
+		CreatedAt:    l.CreatedAt,
+		CountryStats: "{}",
+		BrowserStats: "{}",
+		DeviceStats:  "{}",
+	}
+	visitors := map[string]bool{}
+	for _, a := range m.logs {
+		if a.Domain != domain || a.ShortURL != code {
+			continue
+		}
+		visitors[a.SessionID+"|"+a.IPAddress] = true
+		switch a.VisitType {
+		case VisitRedirect:
+			st.RedirectCount++
+			st.Clicks++
+		case VisitPreview:
+			st.PreviewCount++
+		case VisitQRScan:
+			st.Scans++
+		}
+	}
+	st.UniqueVisitors = len(visitors)
+	return st, nil
+}
+
+func (m *MemoryStore) History(ctx context.Context, sessionID, userEmail string) ([]HistoryEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.history(func(l MemoryLink) bool {
+		return l.WorkspaceID == "" && ((sessionID != "" && l.SessionID == sessionID) || (userEmail != "" && l.UserEmail == userEmail))
+	}), nil
+}
+
+func (m *MemoryStore) WorkspaceHistory(ctx context.Context, workspaceID string) ([]HistoryEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.history(func(l MemoryLink) bool { return l.WorkspaceID == workspaceID }), nil
+}
+
+func (m *MemoryStore) EachLink(ctx context.Context, sessionID, userEmail, workspaceID string, fn func(ExportedLink) error) error {
+	m.mu.RLock()
+	var links []MemoryLink
+	for _, l := range m.links {
+		if workspaceID != "" && l.WorkspaceID == workspaceID ||
+			workspaceID == "" && l.WorkspaceID == "" && ((sessionID != "" && l.SessionID == sessionID) || (userEmail != "" && l.UserEmail == userEmail)) {
+			links = append(links, l)
+		}
+	}
+	m.mu.RUnlock()
+	sort.Slice(links, func(i, j int) bool { return links[i].CreatedAt.Before(links[j].CreatedAt) })
+	for _, l := range links {
+		st, err := m.LinkStats(ctx, l.Domain, l.ShortURL)
+		if err != nil {
+			return err
+		}
+		e := ExportedLink{
+			Domain:            l.Domain,
+			ShortURL:          l.ShortURL,
+			OriginalURL:       l.OriginalURL,
+			WorkspaceID:       l.WorkspaceID,
+			UserEmail:         l.UserEmail,
+			RedirectCode:      l.RedirectCode,
+			DestinationLocked: l.DestinationLocked,
+			Tags:              l.Tags,
+			CreatedAt:         l.CreatedAt,
+			ExpiryDate:        l.ExpiryDate,
+			TotalVisits:       int64(st.TotalVisits),
+			UniqueVisitors:    int64(st.UniqueVisitors),
+			RedirectCount:     int64(st.RedirectCount),
+			PreviewCount:      int64(st.PreviewCount),
+			QRScans:           int64(st.Scans),
+		}
+		if e.RedirectCode == 0 {
+			e.RedirectCode = 302
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) history(match func(MemoryLink) bool) []HistoryEntry {
+	var links []MemoryLink
+	for _, l := range m.links {
+		if match(l) {
+			links = append(links, l)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].CreatedAt.After(links[j].CreatedAt) })
+	history := make([]HistoryEntry, 0, len(links))
+	for _, l := range links {
+		history = append(history, HistoryEntry{
+			OriginalURL: l.OriginalURL,
+			Domain:      l.Domain,
+			ShortURL:    l.ShortURL,
+			WorkspaceID: l.WorkspaceID,
+			ExpiryDate:  l.ExpiryDate,
+			IsLoggedIn:  l.IsLoggedIn,
+			UserEmail:   l.UserEmail,
+		})
+	}
+	return history
+}
+
+func (m *MemoryStore) LogAccess(ctx context.Context, a AccessLog) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.logs = append(m.logs, a)
+	return nil
+}
+
+func (m *MemoryStore) SetTotals(ctx context.Context, totals []LinkTotal) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range totals {
+		m.totals[t.Domain+"/"+t.ShortURL] = t.TotalVisits
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"usethislink/services/internal/storage"
+	"usethislink/services/internal/workspace"
+)
+
+// SQLStore implements AnalyticsStore on Postgres or SQLite. Queries are
+// written once with `?` placeholders and rebound for the dialect.
+type SQLStore struct {
+	db      *sql.DB
+	dialect storage.Dialect
+}
+
+func NewPostgres(db *sql.DB) *SQLStore { return &SQLStore{db: db, dialect: storage.Postgres} }
+
+func NewSQLite(db *sql.DB) *SQLStore { return &SQLStore{db: db, dialect: storage.SQLite} }
+
+// New picks the implementation matching dialect.
+func New(db *sql.DB, dialect storage.Dialect) *SQLStore {
+	return &SQLStore{db: db, dialect: dialect}
+}
+
+func (s *SQLStore) q(query string) string { return s.dialect.Rebind(query) }
+
+func (s *SQLStore) LinkStats(ctx context.Context, domain, code string) (LinkStats, error) {
+	var st LinkStats
+	var created sql.NullTime
+	err := s.db.QueryRowContext(ctx, s.q(`
+		SELECT
+			u.domain, u.short_url, u.workspace_id, COALESCE(u.session_id, ''), COALESCE(u.user_email, ''), u.original_url, COALESCE(u.visits, 0) as total_visits, u.created_at,
+			COALESCE(a.unique_visitors, 0) as unique_visitors,
+			COALESCE(a.redirect_count, 0) as redirect_count,
+			COALESCE(a.preview_count, 0) as preview_count,
+			COALESCE(a.country_counts, '{}') as country_counts,
+			COALESCE(a.browser_counts, '{}') as browser_counts,
+			COALESCE(a.device_counts, '{}') as device_counts,
+			(SELECT COUNT(*) FROM url_access_logs l
+				WHERE l.domain = u.domain AND l.short_url = u.short_url AND l.visit_type = 'redirect' AND l.deleted_at IS NULL) as clicks,
+			(SELECT COUNT(*) FROM url_access_logs l
+				WHERE l.domain = u.domain AND l.short_url = u.short_url AND l.visit_type = 'qr_scan' AND l.deleted_at IS NULL) as scans
+		FROM url_mappings u
+		LEFT JOIN link_analytics a ON u.domain = a.domain AND u.short_url = a.short_url
+		WHERE u.domain = ? AND u.short_url = ?`), domain, code).Scan(
+		&st.Domain,
+		&st.ShortURL,
+		&st.WorkspaceID,
+		&st.SessionID,
+		&st.UserEmail,
+		&st.OriginalURL,
+		&st.TotalVisits,
+		&created,
+		&st.UniqueVisitors,
+		&st.RedirectCount,
+		&st.PreviewCount,
+		&st.CountryStats,
+		&st.BrowserStats,
+		&st.DeviceStats,
+		&st.Clicks,
+		&st.Scans,
+	)
+	if err == sql.ErrNoRows {
+		return st, ErrNotFound
+	}
+	st.CreatedAt = created.Time
+	return st, err
+}
+
+const historyColumns = `original_url, domain, short_url, workspace_id, expiry_date, COALESCE(is_logged_in, FALSE), COALESCE(user_email, '')`
+
+func (s *SQLStore) History(ctx context.Context, sessionID, userEmail string) ([]HistoryEntry, error) {
+	var rows *sql.Rows
+	var err error
+	if userEmail != "" {
+		rows, err = s.db.QueryContext(ctx, s.q(`
+			SELECT `+historyColumns+`
+			FROM url_mappings WHERE (user_email = ? OR (session_id = ? AND session_id <> '')) AND workspace_id = '' ORDER BY created_at DESC
+		`), userEmail, sessionID)
+	} else {
+		rows, err = s.db.QueryContext(ctx, s.q(`
+			SELECT `+historyColumns+`
+			FROM url_mappings WHERE session_id = ? AND session_id <> '' AND workspace_id = '' ORDER BY created_at DESC
+		`), sessionID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return scanHistory(rows)
+}
+
+func (s *SQLStore) WorkspaceHistory(ctx context.Context, workspaceID string) ([]HistoryEntry, error) {
+	rows, err := s.db.QueryContext(ctx, s.q(`
+		SELECT `+historyColumns+`
+		FROM url_mappings WHERE workspace_id = ? ORDER BY created_at DESC
+	`), workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	return scanHistory(rows)
+}
+
+func scanHistory(rows *sql.Rows) ([]HistoryEntry, error) {
+	defer rows.Close()
+	var history []HistoryEntry
+	for rows.Next() {
+		var h HistoryEntry
+		var expiry sql.NullTime
+		if err := rows.Scan(&h.OriginalURL, &h.Domain, &h.ShortURL, &h.WorkspaceID, &expiry, &h.IsLoggedIn, &h.UserEmail); err != nil {
+			return nil, err
+		}
+		h.ExpiryDate = expiry.Time
+		history = append(history, h)
+	}
+	return history, rows.Err()
+}
+
+func (s *SQLStore) EachLink(ctx context.Context, sessionID, userEmail, workspaceID string, fn func(ExportedLink) error) error {
+	// Imported links have no session; never match them on an empty one.
+	where, args := `u.session_id = ? AND u.session_id <> '' AND u.workspace_id = ''`, []any{sessionID}
+	if workspaceID != "" {
+		where, args = `u.workspace_id = ?`, []any{workspaceID}
+	} else if userEmail != "" {
+		where, args = `(u.user_email = ? OR (u.session_id = ? AND u.session_id <> '')) AND u.workspace_id = ''`, []any{userEmail, sessionID}
+	}
+	rows, err := s.db.QueryContext(ctx, s.q(`
+		SELECT
+			u.domain, u.short_url, u.original_url, u.workspace_id, COALESCE(u.user_email, ''),
+			COALESCE(u.redirect_code, 302), COALESCE(u.destination_locked, FALSE), u.created_at, u.expiry_date, u.tags,
+			COALESCE(u.visits, 0), COALESCE(a.unique_visitors, 0), COALESCE(a.redirect_count, 0), COALESCE(a.preview_count, 0),
+			(SELECT COUNT(*) FROM url_access_logs l
+				WHERE l.domain = u.domain AND l.short_url = u.short_url AND l.visit_type = 'qr_scan' AND l.deleted_at IS NULL)
+		FROM url_mappings u
+		LEFT JOIN link_analytics a ON u.domain = a.domain AND u.short_url = a.short_url
+		WHERE `+where+` ORDER BY u.created_at, u.domain, u.short_url`), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l ExportedLink
+		var created, expiry sql.NullTime
+		var tags string
+		if err := rows.Scan(&l.Domain, &l.ShortURL, &l.OriginalURL, &l.WorkspaceID, &l.UserEmail,
+			&l.RedirectCode, &l.DestinationLocked, &created, &expiry, &tags,
+			&l.TotalVisits, &l.UniqueVisitors, &l.RedirectCount, &l.PreviewCount, &l.QRScans); err != nil {
+			return err
+		}
+		l.CreatedAt, l.ExpiryDate = created.Time, expiry.Time
+		if tags != "" {
+			l.Tags = strings.Split(tags, ",")
+		}
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SQLStore) MemberRole(ctx context.Context, workspaceID, email string) (workspace.Role, error) {
+	var role workspace.Role
+	err := s.db.QueryRowContext(ctx, s.q(`SELECT role FROM workspace_members WHERE workspace_id = ? AND user_email = ?`),
+		workspaceID, email).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return role, err
+}
+
+func (s *SQLStore) LogAccess(ctx context.Context, a AccessLog) error {
+	_, err := s.db.ExecContext(ctx, s.q(`
+		INSERT INTO url_access_logs (domain, short_url, session_id, ip_address, user_agent, referrer, visit_type, city, country, browser, device, operating_system)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`), a.Domain, a.ShortURL, a.SessionID, a.IPAddress, a.UserAgent, a.Referrer, a.VisitType, a.City, a.Country, a.Browser, a.Device, a.OperatingSystem)
+	return err
+}
+
+func (s *SQLStore) SetTotals(ctx context.Context, totals []LinkTotal) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, s.q(`
+		INSERT INTO link_analytics (domain, short_url, total_visits, last_updated)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (domain, short_url) DO UPDATE SET total_visits = excluded.total_visits, last_updated = excluded.last_updated
+	`))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	now := time.Now().UTC()
+	for _, t := range totals {
+		if _, err := stmt.ExecContext(ctx, t.Domain, t.ShortURL, t.TotalVisits, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
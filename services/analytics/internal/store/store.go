@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"usethislink/services/internal/storage"
+	"usethislink/services/internal/workspace"
+)
+
+// Re-exported so handlers only need to import this package.
+var (
+	ErrNotFound = storage.ErrNotFound
+	ErrConflict = storage.ErrConflict
+)
+
+// LinkStats joins a link with its cached aggregates. Clicks and Scans count
+// logged redirects through the short URL and through its QR code (/q/).
+// WorkspaceID, SessionID and UserEmail say who may see them.
+type LinkStats struct {
+	Domain         string
+	ShortURL       string
+	WorkspaceID    string
+	SessionID      string
+	UserEmail      string
+	OriginalURL    string
+	TotalVisits    int
+	UniqueVisitors int
+	RedirectCount  int
+	PreviewCount   int
+	Clicks         int
+	Scans          int
+	CreatedAt      time.Time
+	CountryStats   string
+	BrowserStats   string
+	DeviceStats    string
+}
+
+// HistoryEntry is one link in a caller's history.
+type HistoryEntry struct {
+	OriginalURL string
+	Domain      string
+	ShortURL    string
+	WorkspaceID string
+	ExpiryDate  time.Time
+	IsLoggedIn  bool
+	UserEmail   string
+}
+
+// ExportedLink is a link with its totals from link_analytics. TotalVisits is
+// the live redirect counter in url_mappings and QRScans counts logged scans,
+// as in LinkStats.
+type ExportedLink struct {
+	Domain            string
+	ShortURL          string
+	OriginalURL       string
+	WorkspaceID       string
+	UserEmail         string
+	RedirectCode      int
+	DestinationLocked bool
+	Tags              []string
+	CreatedAt         time.Time
+	ExpiryDate        time.Time
+	TotalVisits       int64
+	UniqueVisitors    int64
+	RedirectCount     int64
+	PreviewCount      int64
+	QRScans           int64
+}
+
+// Visit types in url_access_logs.
+const (
+	VisitRedirect = "redirect"
+	VisitPreview  = "preview"
+	VisitQRScan   = "qr_scan"
+)
+
+// AccessLog is one row of url_access_logs.
+type AccessLog struct {
+	Domain          string
+	ShortURL        string
+	SessionID       string
+	IPAddress       string
+	UserAgent       string
+	Referrer        string
+	VisitType       string
+	City            string
+	Country         string
+	Browser         string
+	Device          string
+	OperatingSystem string
+}
+
+// LinkTotal is a click total carried over from another shortener on import.
+type LinkTotal struct {
+	Domain      string `json:"domain"`
+	ShortURL    string `json:"short_url"`
+	TotalVisits int64  `json:"total_visits"`
+}
+
+// AnalyticsStore is everything the analytics service reads and writes.
+type AnalyticsStore interface {
+	// LinkStats reports on code within domain ("" for BASE_URL links).
+	LinkStats(ctx context.Context, domain, code string) (LinkStats, error)
+	// History lists personal links created by the session, or by the user when signed in.
+	History(ctx context.Context, sessionID, userEmail string) ([]HistoryEntry, error)
+	// WorkspaceHistory lists the links owned by a workspace.
+	WorkspaceHistory(ctx context.Context, workspaceID string) ([]HistoryEntry, error)
+	// MemberRole reads workspace_members; it returns ErrNotFound for non-members.
+	// EachLink streams, oldest first, the links History lists, or those of
+	// workspaceID when it is set, stopping at the first error fn returns.
+	EachLink(ctx context.Context, sessionID, userEmail, workspaceID string, fn func(ExportedLink) error) error
+	MemberRole(ctx context.Context, workspaceID, email string) (workspace.Role, error)
+	LogAccess(ctx context.Context, a AccessLog) error
+	// SetTotals writes imported totals into link_analytics, replacing any
+	// left behind by an earlier link with the same code.
+	SetTotals(ctx context.Context, totals []LinkTotal) error
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+
+	"github.com/gorilla/mux"
+)
+
+// bioPage is the public content of a link-in-bio page, as served by the link
+// service at GET /bio/{handle}.
+type bioPage struct {
+	Handle      string `json:"handle"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Theme       struct {
+		Background       string `json:"background"`
+		Foreground       string `json:"foreground"`
+		ButtonBackground string `json:"button_background"`
+		ButtonForeground string `json:"button_foreground"`
+	} `json:"theme"`
+	Links []struct {
+		ShortURL string `json:"short_url"`
+		Title    string `json:"title"`
+		Icon     string `json:"icon"`
+	} `json:"links"`
+}
+
+// bioPageHandler renders /@{handle} with templates/bio.html. Buttons link
+// straight to the short links, so each click is an ordinary redirect that
+// carries the page as its referrer.
+func bioPageHandler(linkService, templatesDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp, err := http.Get(linkService + "/bio/" + url.PathEscape(mux.Vars(r)["handle"]))
+		if err != nil {
+			log.Printf("Failed to fetch bio page: %v", err)
+			http.Error(w, "Service unavailable", http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			http.NotFound(w, r)
+			return
+		}
+		var page bioPage
+		if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&page) != nil {
+			log.Printf("Failed to fetch bio page: link service answered %d", resp.StatusCode)
+			http.Error(w, "Service unavailable", http.StatusBadGateway)
+			return
+		}
+		tmpl, err := template.ParseFiles(filepath.Join(templatesDir, "bio.html"))
+		if err != nil {
+			log.Printf("Failed to load bio template: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, page); err != nil {
+			log.Printf("Failed to render bio page: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Write(buf.Bytes())
+	}
+}
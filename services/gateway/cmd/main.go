@@ -10,14 +10,18 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"usethislink/services/internal/shorturl"
+
 	"github.com/gorilla/mux"
 )
 
 type sessionStatus struct {
-	LoggedIn bool   `json:"logged_in"`
-	Email    string `json:"email,omitempty"`
+	LoggedIn  bool   `json:"logged_in"`
+	Email     string `json:"email,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
 }
 
 func proxyTo(target string, passUser bool) http.HandlerFunc {
@@ -27,6 +31,9 @@ func proxyTo(target string, passUser bool) http.HandlerFunc {
 	}
 	proxy := httputil.NewSingleHostReverseProxy(targetURL)
 	return func(w http.ResponseWriter, r *http.Request) {
+		// Only the gateway may say who the caller is; drop whatever the client sent.
+		r.Header.Del("X-User-Email")
+		r.Header.Del("X-Session-ID")
 		if passUser {
 			if email := r.Context().Value("userEmail"); email != nil {
 				r.Header.Set("X-User-Email", email.(string))
@@ -43,7 +50,7 @@ func proxyTo(target string, passUser bool) http.HandlerFunc {
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
@@ -74,6 +81,44 @@ func (lrw *loggingResponseWriter) WriteHeader(code int) {
 	lrw.ResponseWriter.WriteHeader(code)
 }
 
+// Custom domain middleware: requests whose Host is a customer's short domain
+// only ever resolve links. GET /{shortcode} is proxied to the link service as
+// /s/{shortcode} and QR scans (/q/{shortcode}) as they are; the original Host
+// header is kept so the link service can tell which domain the code belongs to.
+func customDomainMiddleware(linkService string) mux.MiddlewareFunc {
+	redirect := proxyTo(linkService, false)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if shorturl.DomainForHost(r.Host) == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			code := strings.TrimPrefix(r.URL.Path, "/")
+			target := "/s/"
+			if strings.HasPrefix(code, shorturl.ScanPrefix) {
+				code, target = strings.TrimPrefix(code, shorturl.ScanPrefix), "/q/"
+			}
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if code == "" {
+				if baseURL := os.Getenv("BASE_URL"); baseURL != "" {
+					http.Redirect(w, r, baseURL, http.StatusFound)
+					return
+				}
+			}
+			if code == "" || strings.Contains(code, "/") {
+				http.NotFound(w, r)
+				return
+			}
+			r.URL.Path = target + code
+			r.URL.RawPath = ""
+			redirect(w, r)
+		})
+	}
+}
+
 // Auth middleware: checks session with user service
 func authMiddleware(userService string, required bool) mux.MiddlewareFunc {
 	return func(next http.Handler) http.Handler {
@@ -87,8 +132,8 @@ func authMiddleware(userService string, required bool) mux.MiddlewareFunc {
 				next.ServeHTTP(w, r)
 				return
 			}
-			// Call user service /session endpoint
-			req, _ := http.NewRequest("GET", userService+"/session", nil)
+			// Call user service /api/session endpoint
+			req, _ := http.NewRequest("GET", userService+"/api/session", nil)
 			req.AddCookie(cookie)
 			resp, err := http.DefaultClient.Do(req)
 			if err != nil || resp.StatusCode != 200 {
@@ -107,9 +152,13 @@ func authMiddleware(userService string, required bool) mux.MiddlewareFunc {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
+			// Services only ever see the session ID (the token's hash), never
+			// the cookie itself.
 			ctx := r.Context()
 			ctx = context.WithValue(ctx, "userEmail", status.Email)
-			ctx = context.WithValue(ctx, "sessionID", cookie.Value)
+			if status.SessionID != "" {
+				ctx = context.WithValue(ctx, "sessionID", status.SessionID)
+			}
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -173,16 +222,38 @@ func main() {
 	r.HandleFunc("/", serveHTML("index.html")).Methods("GET")
 	r.HandleFunc("/index.html", serveHTML("index.html")).Methods("GET")
 	r.HandleFunc("/login", serveHTML("login.html")).Methods("GET")
+	r.HandleFunc("/login/magic", serveHTML("login-magic.html")).Methods("GET")
+	r.HandleFunc("/login/oidc/callback", serveHTML("login-oidc-callback.html")).Methods("GET")
 	r.HandleFunc("/tos.html", serveHTML("tos.html")).Methods("GET")
 	r.HandleFunc("/privacy.html", serveHTML("privacy.html")).Methods("GET")
+	r.HandleFunc("/reset-password", serveHTML("reset-password.html")).Methods("GET")
+	r.HandleFunc("/@{handle}", bioPageHandler(linkService, templatesDir)).Methods("GET")
 
 	// Link Service (protected)
 	r.Handle("/shorten", authMiddleware(userService, true)(proxyTo(linkService, true))).Methods("POST")
 	r.Handle("/r/{shortcode}", proxyTo(linkService, false)).Methods("GET")
+	r.Handle("/q/{shortcode}", proxyTo(linkService, false)).Methods("GET")
+	r.Handle("/api/qrcode", authMiddleware(userService, false)(proxyTo(linkService, true))).Methods("GET")
+	r.Handle("/links/{shortcode}/qr-bundle", authMiddleware(userService, true)(proxyTo(linkService, true))).Methods("GET")
+	r.PathPrefix("/qr/presets").Handler(authMiddleware(userService, true)(proxyTo(linkService, true)))
+	r.Handle("/qr/payload", authMiddleware(userService, true)(proxyTo(linkService, true))).Methods("POST")
+	r.Handle("/vcards/{id}", proxyTo(linkService, false)).Methods("GET")
+	r.Handle("/bio", authMiddleware(userService, true)(proxyTo(linkService, true))).Methods("GET", "POST")
+	r.Handle("/bio/{handle}", proxyTo(linkService, false)).Methods("GET")
+	r.Handle("/bio/{handle}", authMiddleware(userService, true)(proxyTo(linkService, true))).Methods("PUT", "DELETE")
+	r.Handle("/links/{shortcode}", authMiddleware(userService, true)(proxyTo(linkService, true))).Methods("PATCH", "DELETE")
+	r.Handle("/links/{shortcode}/move", authMiddleware(userService, true)(proxyTo(linkService, true))).Methods("POST")
+	r.Handle("/import", authMiddleware(userService, true)(proxyTo(linkService, true))).Methods("POST")
+	r.PathPrefix("/workspaces").Handler(authMiddleware(userService, true)(proxyTo(linkService, true)))
+	r.PathPrefix("/invitations").Handler(authMiddleware(userService, true)(proxyTo(linkService, true)))
+	r.Handle("/domains", authMiddleware(userService, true)(proxyTo(linkService, true))).Methods("GET", "POST")
+	r.Handle("/domains/{domain}", authMiddleware(userService, true)(proxyTo(linkService, true))).Methods("DELETE")
+	r.Handle("/domains/{domain}/verify", authMiddleware(userService, true)(proxyTo(linkService, true))).Methods("POST")
 
 	// Analytics Service (protected)
 	r.Handle("/stats/{shortcode}", authMiddleware(userService, true)(proxyTo(analyticsService, true))).Methods("GET")
 	r.Handle("/history", authMiddleware(userService, true)(proxyTo(analyticsService, true))).Methods("GET")
+	r.Handle("/export/links", authMiddleware(userService, true)(proxyTo(analyticsService, true))).Methods("GET")
 
 	// User Service (public)
 	r.HandleFunc("/register", proxyTo(userService, false)).Methods("POST")
@@ -190,6 +261,25 @@ func main() {
 	r.HandleFunc("/login", proxyTo(userService, false)).Methods("POST")
 	r.HandleFunc("/logout", proxyTo(userService, false)).Methods("POST")
 	r.HandleFunc("/session", proxyTo(userService, false)).Methods("GET")
+	r.HandleFunc("/api/sessions", proxyTo(userService, false)).Methods("GET", "DELETE")
+	r.HandleFunc("/api/sessions/{id}", proxyTo(userService, false)).Methods("DELETE")
+	r.HandleFunc("/api/unlock", proxyTo(userService, false)).Methods("GET")
+	r.HandleFunc("/api/password/forgot", proxyTo(userService, false)).Methods("POST")
+	r.HandleFunc("/api/password/reset", proxyTo(userService, false)).Methods("POST")
+	r.HandleFunc("/api/login/2fa", proxyTo(userService, false)).Methods("POST")
+	r.HandleFunc("/api/login/magic", proxyTo(userService, false)).Methods("POST")
+	r.HandleFunc("/api/login/magic/verify", proxyTo(userService, false)).Methods("POST")
+	r.HandleFunc("/api/oidc/providers", proxyTo(userService, false)).Methods("GET")
+	r.HandleFunc("/api/oidc/{provider}/start", proxyTo(userService, false)).Methods("POST")
+	r.HandleFunc("/api/oidc/callback", proxyTo(userService, false)).Methods("POST")
+	r.HandleFunc("/api/me", proxyTo(userService, false)).Methods("GET", "PATCH")
+	r.HandleFunc("/api/me/password", proxyTo(userService, false)).Methods("POST")
+	r.HandleFunc("/api/admin/emails", proxyTo(userService, false)).Methods("GET")
+	r.HandleFunc("/api/admin/emails/{id}/retry", proxyTo(userService, false)).Methods("POST")
+	r.HandleFunc("/api/admin/email-suppressions", proxyTo(userService, false)).Methods("GET")
+	r.HandleFunc("/api/admin/email-suppressions/{email}", proxyTo(userService, false)).Methods("DELETE")
+	r.HandleFunc("/api/2fa", proxyTo(userService, false)).Methods("GET")
+	r.HandleFunc("/api/2fa/{action:setup|enable|disable|recovery-codes}", proxyTo(userService, false)).Methods("POST")
 
 	// Fallback: serve index.html for unknown routes (optional, SPA support)
 	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -198,8 +288,8 @@ func main() {
 		http.ServeFile(w, r, path)
 	})
 
-	// CORS and logging middleware
-	handler := corsMiddleware(loggingMiddleware(r))
+	// CORS, logging and custom domain middleware
+	handler := corsMiddleware(loggingMiddleware(customDomainMiddleware(linkService)(r)))
 
 	port := os.Getenv("PORT")
 	if port == "" {
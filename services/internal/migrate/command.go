@@ -0,0 +1,58 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Command implements the `migrate` subcommand shared by the service binaries:
+//
+//	migrate up           apply every pending migration (the default)
+//	migrate down [n]     roll back the latest n migrations (default 1)
+//	migrate status       list migrations and when they were applied
+func Command(ctx context.Context, m *Migrator, args []string, out io.Writer) error {
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	switch cmd {
+	case "up":
+		n, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s: applied %d migration(s)\n", m.scope, n)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			v, err := strconv.Atoi(args[1])
+			if err != nil || v < 1 {
+				return ErrUsage
+			}
+			steps = v
+		}
+		n, err := m.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s: rolled back %d migration(s)\n", m.scope, n)
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied {
+				applied = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(out, "%s %4d  %-40s %s\n", m.scope, s.Version, s.Name, applied)
+		}
+	default:
+		return ErrUsage
+	}
+	return nil
+}
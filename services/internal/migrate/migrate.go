@@ -0,0 +1,220 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	"usethislink/services/internal/storage"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Script is the SQL for one direction of a migration, per dialect.
+// An empty script is a no-op on that dialect.
+type Script struct {
+	Postgres string
+	SQLite   string
+}
+
+// Both uses the same SQL on every dialect.
+func Both(sql string) Script {
+	return Script{Postgres: sql, SQLite: sql}
+}
+
+func (s Script) For(d storage.Dialect) string {
+	if d == storage.SQLite {
+		return s.SQLite
+	}
+	return s.Postgres
+}
+
+// Migration is one numbered schema change. Versions must be unique and are
+// applied in ascending order; Down undoes exactly what Up did. Check, if set,
+// runs before Up in the same transaction and aborts the migration with its
+// error, for data that has to be fixed by hand first.
+type Migration struct {
+	Version int
+	Name    string
+	Up      Script
+	Down    Script
+	Check   func(ctx context.Context, tx *sql.Tx) error
+}
+
+// Status describes one known migration.
+type Status struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies one service's migrations. Every service records its
+// progress under its own scope in schema_migrations, so services sharing a
+// SQLite file (or a Postgres schema) do not collide.
+type Migrator struct {
+	db         *sql.DB
+	dialect    storage.Dialect
+	scope      string
+	migrations []Migration
+}
+
+func New(db *sql.DB, dialect storage.Dialect, scope string, migrations []Migration) *Migrator {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return &Migrator{db: db, dialect: dialect, scope: scope, migrations: sorted}
+}
+
+func (m *Migrator) q(query string) string { return m.dialect.Rebind(query) }
+
+// withLock runs fn on a single connection while holding the scope's lock.
+// On Postgres that is a session advisory lock, so replicas starting together
+// apply each migration once. SQLite serialises writers on its own.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if m.dialect == storage.Postgres {
+		h := fnv.New64a()
+		h.Write([]byte("usethislink/migrate/" + m.scope))
+		key := int64(h.Sum64())
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, key); err != nil {
+			return fmt.Errorf("acquire migration lock: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, key)
+	}
+	if _, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			scope TEXT NOT NULL,
+			version INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (scope, version)
+		)`); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, m.q(`SELECT version, applied_at FROM schema_migrations WHERE scope = ?`), m.scope)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	done := map[int]time.Time{}
+	for rows.Next() {
+		var v int
+		var at sql.NullTime
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		done[v] = at.Time
+	}
+	return done, rows.Err()
+}
+
+// run executes one script and records (or forgets) the version in the same transaction.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, mig Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	script := mig.Down.For(m.dialect)
+	if up {
+		script = mig.Up.For(m.dialect)
+		if mig.Check != nil {
+			if err := mig.Check(ctx, tx); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", mig.Version, mig.Name, err)
+			}
+		}
+	}
+	if script != "" {
+		if _, err := tx.ExecContext(ctx, script); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", mig.Version, mig.Name, err)
+		}
+	}
+	if up {
+		_, err = tx.ExecContext(ctx, m.q(`INSERT INTO schema_migrations (scope, version, name) VALUES (?, ?, ?)`), m.scope, mig.Version, mig.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, m.q(`DELETE FROM schema_migrations WHERE scope = ? AND version = ?`), m.scope, mig.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Up applies every pending migration and returns how many ran.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	n := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if _, ok := done[mig.Version]; ok {
+				continue
+			}
+			logrus.Infof("Applying %s migration %d: %s", m.scope, mig.Version, mig.Name)
+			if err := m.run(ctx, conn, mig, true); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Down rolls back the latest steps applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	n := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && n < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := done[mig.Version]; !ok {
+				continue
+			}
+			logrus.Infof("Rolling back %s migration %d: %s", m.scope, mig.Version, mig.Name)
+			if err := m.run(ctx, conn, mig, false); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Status lists every known migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var out []Status
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			at, ok := done[mig.Version]
+			out = append(out, Status{Version: mig.Version, Name: mig.Name, Applied: ok, AppliedAt: at})
+		}
+		return nil
+	})
+	return out, err
+}
+
+// ErrUsage is returned by Command for unknown subcommands.
+var ErrUsage = errors.New("usage: migrate [up | down [steps] | status]")
@@ -0,0 +1,67 @@
+// Package shorturl turns (domain, shortcode) pairs into public URLs and maps
+// request hosts back to link domains. The empty domain is the service's own
+// BASE_URL; any other domain is a customer's verified custom domain.
+package shorturl
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Build returns the public URL of a short code. Links on the default domain
+// live under BASE_URL; links on a custom domain use that host with
+// CUSTOM_DOMAIN_SCHEME (default https).
+func Build(domain, code string) string {
+	return build(domain, code)
+}
+
+// ScanPrefix is the path QR codes use instead of the plain short URL, so the
+// link service can tell scans from clicks.
+const ScanPrefix = "q/"
+
+// BuildScan returns the URL a QR code for the short code should encode.
+func BuildScan(domain, code string) string {
+	return build(domain, ScanPrefix+code)
+}
+
+func build(domain, path string) string {
+	if domain == "" {
+		return os.Getenv("BASE_URL") + "/" + path
+	}
+	scheme := os.Getenv("CUSTOM_DOMAIN_SCHEME")
+	if scheme == "" {
+		scheme = "https"
+	}
+	return scheme + "://" + domain + "/" + path
+}
+
+// PrimaryHost is the hostname of BASE_URL, without port.
+func PrimaryHost() string {
+	u, err := url.Parse(os.Getenv("BASE_URL"))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// Hostname lowercases host and strips any port and trailing dot.
+func Hostname(host string) string {
+	host = strings.TrimSpace(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// DomainForHost maps a request Host header to a link domain. The primary host,
+// IP addresses and single-label names (localhost, docker service names) all
+// mean the default domain.
+func DomainForHost(host string) string {
+	h := Hostname(host)
+	if h == "" || h == PrimaryHost() || !strings.Contains(h, ".") || net.ParseIP(h) != nil {
+		return ""
+	}
+	return h
+}
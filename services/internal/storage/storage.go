@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	// ErrNotFound is returned by stores when the requested row does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned by stores when a unique value is already taken.
+	ErrConflict = errors.New("already exists")
+)
+
+// Dialect captures the SQL differences between the databases we support.
+type Dialect int
+
+const (
+	Postgres Dialect = iota
+	SQLite
+)
+
+func (d Dialect) String() string {
+	if d == SQLite {
+		return "sqlite"
+	}
+	return "postgres"
+}
+
+// Rebind rewrites `?` placeholders into the dialect's own style.
+// Queries are written once with `?`; Postgres needs `$1, $2, ...`.
+// Question marks inside quoted literals are left alone.
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	var quote rune
+	for _, ch := range query {
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '?':
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(ch)
+	}
+	return b.String()
+}
+
+// PostgresDSNFromEnv builds the Postgres connection string from the PG* variables.
+// search_path is set per connection so every pooled connection sees the schema.
+func PostgresDSNFromEnv(schemas ...string) string {
+	host := os.Getenv("PGHOST")
+	port := os.Getenv("PGPORT")
+	user := os.Getenv("PGUSER")
+	password := os.Getenv("PGPASSWORD")
+	dbname := os.Getenv("PGDATABASE")
+	if port == "" {
+		port = "5432"
+	}
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
+	if len(schemas) > 0 {
+		quoted := make([]string, len(schemas))
+		for i, s := range schemas {
+			quoted[i] = `"` + s + `"`
+		}
+		dsn += " search_path='" + strings.Join(quoted, ",") + "'"
+	}
+	return dsn
+}
+
+// OpenFromEnv connects to the database selected by DB_DRIVER (postgres, the
+// default, or sqlite). On Postgres the service's own schema is created and put
+// first on the search_path, followed by any read-only schemas it queries.
+// On SQLite everything lives in the single file named by SQLITE_PATH; point
+// all services at the same file to self-host without Postgres.
+func OpenFromEnv(schema string, readSchemas ...string) (*sql.DB, Dialect, error) {
+	switch os.Getenv("DB_DRIVER") {
+	case "", "postgres":
+		if s := os.Getenv("PGSCHEMA"); s != "" {
+			schema = s
+		}
+		db, err := sql.Open("postgres", PostgresDSNFromEnv(append([]string{schema}, readSchemas...)...))
+		if err != nil {
+			return nil, Postgres, err
+		}
+		if _, err := db.Exec(fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS "%s"`, schema)); err != nil {
+			db.Close()
+			return nil, Postgres, err
+		}
+		return db, Postgres, nil
+	case "sqlite":
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = "usethislink.db"
+		}
+		q := url.Values{}
+		q.Set("_busy_timeout", "5000")
+		q.Set("_journal_mode", "WAL")
+		q.Set("_foreign_keys", "on")
+		db, err := sql.Open("sqlite3", "file:"+path+"?"+q.Encode())
+		if err != nil {
+			return nil, SQLite, err
+		}
+		return db, SQLite, nil
+	default:
+		return nil, Postgres, fmt.Errorf("unsupported DB_DRIVER %q", os.Getenv("DB_DRIVER"))
+	}
+}
@@ -0,0 +1,40 @@
+// Package workspace holds the roles shared by every service that checks
+// workspace permissions.
+package workspace
+
+// Role is a member's level in a workspace. Each role can do everything the
+// roles below it can:
+//
+//	viewer  sees the workspace's links, history and stats
+//	editor  creates, edits, deletes and moves links
+//	admin   invites members and changes roles below owner
+//	owner   manages owners and deletes the workspace
+type Role string
+
+const (
+	Viewer Role = "viewer"
+	Editor Role = "editor"
+	Admin  Role = "admin"
+	Owner  Role = "owner"
+)
+
+func (r Role) rank() int {
+	switch r {
+	case Viewer:
+		return 1
+	case Editor:
+		return 2
+	case Admin:
+		return 3
+	case Owner:
+		return 4
+	}
+	return 0
+}
+
+// Valid reports whether r is one of the four roles.
+func (r Role) Valid() bool { return r.rank() > 0 }
+
+// AtLeast reports whether r grants everything min does. The zero Role, used
+// for non-members, is below every role.
+func (r Role) AtLeast(min Role) bool { return r.rank() >= min.rank() && r.Valid() }
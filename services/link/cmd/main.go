@@ -1,31 +1,215 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
+	"usethislink/services/internal/migrate"
+	"usethislink/services/internal/shorturl"
+	"usethislink/services/internal/storage"
+	"usethislink/services/link/internal/cache"
 	"usethislink/services/link/internal/db"
+	"usethislink/services/link/internal/domains"
+	"usethislink/services/link/internal/guard"
 	"usethislink/services/link/internal/handler"
+	"usethislink/services/link/internal/importer"
+	"usethislink/services/link/internal/store"
+	"usethislink/services/link/internal/visits"
 
 	"github.com/gorilla/mux"
 )
 
 func main() {
-	dbConn, err := db.InitDBFromEnv()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		runImport(os.Args[2:])
+		return
+	}
+
+	// DB_DRIVER=memory runs without a database; nothing survives a restart.
+	var st store.LinkStore
+	shared := false
+	if os.Getenv("DB_DRIVER") == "memory" {
+		st = store.NewMemory()
+	} else {
+		dbConn, dialect, err := db.InitDBFromEnv()
+		if err != nil {
+			log.Fatalf("Failed to initialize DB: %v", err)
+		}
+		defer dbConn.Close()
+		st = store.New(dbConn, dialect)
+		shared = dialect == storage.Postgres
+	}
+
+	linkCache := cache.NewFromEnv()
+	linkGuard, err := guard.New(st)
 	if err != nil {
-		log.Fatalf("Failed to initialize DB: %v", err)
+		log.Fatalf("Failed to load shortcode filter: %v", err)
 	}
-	defer dbConn.Close()
+	// Only Postgres can have several replicas sharing the table.
+	if shared {
+		listener, err := cache.Listen(storage.PostgresDSNFromEnv(), linkCache, linkGuard)
+		if err != nil {
+			log.Fatalf("Failed to listen for link invalidations: %v", err)
+		}
+		defer listener.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	visitCounter := visits.NewCounter(st)
+	counterCtx, stopCounter := context.WithCancel(context.Background())
+	flushed := make(chan struct{})
+	go func() {
+		visitCounter.Run(counterCtx)
+		close(flushed)
+	}()
 
 	r := mux.NewRouter()
-	r.HandleFunc("/shorten", handler.ShortenHandler(dbConn)).Methods("POST")
-	r.HandleFunc("/s/{shortcode}", handler.RedirectHandler(dbConn)).Methods("GET")
+	r.HandleFunc("/shorten", handler.ShortenHandler(st, linkGuard)).Methods("POST")
+	r.HandleFunc("/s/{shortcode}", handler.RedirectHandler(st, linkCache, linkGuard, visitCounter)).Methods("GET")
+	r.HandleFunc("/q/{shortcode}", handler.QRScanHandler(st, linkCache, linkGuard, visitCounter)).Methods("GET")
+	r.HandleFunc("/links/{shortcode}", handler.EditHandler(st, linkCache)).Methods("PATCH")
+	r.HandleFunc("/links/{shortcode}", handler.DeleteHandler(st, linkCache)).Methods("DELETE")
+	r.HandleFunc("/import", handler.ImportHandler(st, linkGuard)).Methods("POST")
+	r.HandleFunc("/links/{shortcode}/move", handler.MoveHandler(st)).Methods("POST")
+	r.HandleFunc("/domains", handler.CreateDomainHandler(st)).Methods("POST")
+	r.HandleFunc("/domains", handler.ListDomainsHandler(st)).Methods("GET")
+	r.HandleFunc("/domains/{domain}/verify", handler.VerifyDomainHandler(st, domains.VerifierFromEnv())).Methods("POST")
+	r.HandleFunc("/domains/{domain}", handler.DeleteDomainHandler(st, linkCache)).Methods("DELETE")
+	r.HandleFunc("/workspaces", handler.CreateWorkspaceHandler(st)).Methods("POST")
+	r.HandleFunc("/workspaces", handler.ListWorkspacesHandler(st)).Methods("GET")
+	r.HandleFunc("/workspaces/{workspace}", handler.GetWorkspaceHandler(st)).Methods("GET")
+	r.HandleFunc("/workspaces/{workspace}", handler.DeleteWorkspaceHandler(st)).Methods("DELETE")
+	r.HandleFunc("/workspaces/{workspace}/invitations", handler.InviteHandler(st)).Methods("POST")
+	r.HandleFunc("/workspaces/{workspace}/invitations/{invitation}", handler.RevokeInvitationHandler(st)).Methods("DELETE")
+	r.HandleFunc("/workspaces/{workspace}/members/{email}", handler.UpdateMemberHandler(st)).Methods("PATCH")
+	r.HandleFunc("/workspaces/{workspace}/members/{email}", handler.RemoveMemberHandler(st)).Methods("DELETE")
+	r.HandleFunc("/invitations", handler.MyInvitationsHandler(st)).Methods("GET")
+	r.HandleFunc("/invitations/{invitation}/accept", handler.AcceptInvitationHandler(st)).Methods("POST")
+	r.HandleFunc("/api/qrcode", handler.QRCodeHandler(st)).Methods("GET")
+	r.HandleFunc("/qr/presets", handler.CreateQRPresetHandler(st)).Methods("POST")
+	r.HandleFunc("/qr/presets", handler.ListQRPresetsHandler(st)).Methods("GET")
+	r.HandleFunc("/qr/presets/{preset}", handler.DeleteQRPresetHandler(st)).Methods("DELETE")
+	r.HandleFunc("/links/{shortcode}/qr-bundle", handler.QRBundleHandler(st)).Methods("GET")
+	r.HandleFunc("/qr/payload", handler.QRPayloadHandler(st, linkGuard)).Methods("POST")
+	r.HandleFunc("/vcards/{id}", handler.VCardFileHandler(st)).Methods("GET")
+	r.HandleFunc("/bio", handler.CreateBioPageHandler(st)).Methods("POST")
+	r.HandleFunc("/bio", handler.ListBioPagesHandler(st)).Methods("GET")
+	r.HandleFunc("/bio/{handle}", handler.GetBioPageHandler(st)).Methods("GET")
+	r.HandleFunc("/bio/{handle}", handler.UpdateBioPageHandler(st)).Methods("PUT")
+	r.HandleFunc("/bio/{handle}", handler.DeleteBioPageHandler(st)).Methods("DELETE")
+	r.HandleFunc("/metrics", handler.MetricsHandler(linkCache)).Methods("GET")
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8081"
 	}
-	log.Printf("Link Service running on :%s", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+	go func() {
+		log.Printf("Link Service running on :%s", port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Link Service shutdown: %v", err)
+	}
+	// Stop the counter only after in-flight redirects finished, so its final
+	// flush sees every visit; wait for it before closing the DB.
+	stopCounter()
+	<-flushed
+}
+
+// runMigrate implements `linkservice migrate [up | down [steps] | status]`.
+func runMigrate(args []string) {
+	dbConn, dialect, err := db.Open()
+	if err != nil {
+		log.Fatalf("Failed to open DB: %v", err)
+	}
+	defer dbConn.Close()
+	if err := migrate.Command(context.Background(), db.Migrator(dbConn, dialect), args, os.Stdout); err != nil {
+		log.Fatalf("migrate: %v", err)
+	}
+}
+
+// runImport implements `linkservice import [flags] FILE`, loading a Bitly or
+// YOURLS export straight into the database. Running replicas pick the new
+// codes up through link_invalidate (Postgres) or their next filter rebuild.
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "", "csv or json (default: from the file extension)")
+	email := fs.String("email", "", "owner of the imported links")
+	domain := fs.String("domain", "", "verified custom domain to import onto")
+	workspaceID := fs.String("workspace", "", "workspace to import into")
+	onConflict := fs.String("on-conflict", importer.OnConflictGenerate, "generate or skip")
+	fs.Parse(args)
+	if fs.NArg() != 1 || (*email == "" && *workspaceID == "") {
+		log.Fatal("usage: import -email OWNER [-workspace ID] [-domain HOST] [-format csv|json] [-on-conflict generate|skip] FILE")
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(fs.Arg(0))), ".")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("import: %v", err)
+	}
+	defer f.Close()
+	records, err := importer.Parse(f, *format)
+	if err != nil {
+		log.Fatalf("import: %v", err)
+	}
+
+	dbConn, dialect, err := db.InitDBFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to initialize DB: %v", err)
+	}
+	defer dbConn.Close()
+	st := store.New(dbConn, dialect)
+	ctx := context.Background()
+	if *domain != "" {
+		d, err := st.GetDomain(ctx, shorturl.Hostname(*domain))
+		if err != nil || !d.Verified() {
+			log.Fatalf("import: %s is not a verified custom domain", *domain)
+		}
+		if d.WorkspaceID != "" && d.WorkspaceID != *workspaceID {
+			log.Fatalf("import: %s belongs to workspace %s; pass -workspace %s", *domain, d.WorkspaceID, d.WorkspaceID)
+		}
+		*domain = d.Host
+	}
+	if *workspaceID != "" {
+		if _, err := st.GetWorkspace(ctx, *workspaceID); err != nil {
+			log.Fatalf("import: workspace %s: %v", *workspaceID, err)
+		}
+	}
+
+	report, created := importer.Run(ctx, st, records, importer.Options{
+		Owner:       store.Owner{UserEmail: *email},
+		Domain:      *domain,
+		WorkspaceID: *workspaceID,
+		OnConflict:  *onConflict,
+	})
+	if err := importer.SendTotals(ctx, created); err != nil {
+		report.AnalyticsError = err.Error()
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
 }
@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"container/list"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Entry is what the redirect path needs to answer a shortcode without the database.
+// Missing marks a negative entry for a code that does not exist.
+type Entry struct {
+	OriginalURL       string
+	RedirectCode      int
+	DestinationLocked bool
+	ExpiresAt         time.Time
+	Missing           bool
+}
+
+type item struct {
+	code     string
+	entry    Entry
+	deadline time.Time
+}
+
+// LinkCache is a size-bounded LRU of link lookups with per-entry TTLs, keyed by
+// "domain/shortcode" as produced by store.LinkKey.
+type LinkCache struct {
+	mu          sync.Mutex
+	capacity    int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	ll          *list.List
+	items       map[string]*list.Element
+
+	// Invalidations bump a code's generation so a lookup that raced with
+	// one can't cache what it read. epoch is bumped instead of keeping
+	// generations forever, and by Purge.
+	epoch uint64
+	gens  map[string]uint64
+
+	hits          atomic.Uint64
+	negativeHits  atomic.Uint64
+	misses        atomic.Uint64
+	evictions     atomic.Uint64
+	invalidations atomic.Uint64
+}
+
+// Stats is a snapshot of the cache counters.
+type Stats struct {
+	Size          int
+	Hits          uint64
+	NegativeHits  uint64
+	Misses        uint64
+	Evictions     uint64
+	Invalidations uint64
+}
+
+func New(capacity int, ttl, negativeTTL time.Duration) *LinkCache {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &LinkCache{
+		capacity:    capacity,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		ll:          list.New(),
+		items:       make(map[string]*list.Element),
+		gens:        make(map[string]uint64),
+	}
+}
+
+// Generation identifies the state of a code's cache slot as seen by Get.
+type Generation struct {
+	epoch, gen uint64
+}
+
+func (c *LinkCache) generation(code string) Generation {
+	return Generation{epoch: c.epoch, gen: c.gens[code]}
+}
+
+// NewFromEnv reads LINK_CACHE_SIZE, LINK_CACHE_TTL and LINK_CACHE_NEGATIVE_TTL.
+func NewFromEnv() *LinkCache {
+	size := 10000
+	if v, err := strconv.Atoi(os.Getenv("LINK_CACHE_SIZE")); err == nil && v > 0 {
+		size = v
+	}
+	ttl := 5 * time.Minute
+	if v, err := time.ParseDuration(os.Getenv("LINK_CACHE_TTL")); err == nil && v > 0 {
+		ttl = v
+	}
+	negativeTTL := 30 * time.Second
+	if v, err := time.ParseDuration(os.Getenv("LINK_CACHE_NEGATIVE_TTL")); err == nil && v > 0 {
+		negativeTTL = v
+	}
+	return New(size, ttl, negativeTTL)
+}
+
+// Get returns a live entry for code. Expired entries, including links whose own
+// expiry date has passed, are dropped and reported as a miss. On a miss, pass
+// the Generation to Set or SetMissing with what the store returned.
+func (c *LinkCache) Get(code string) (Entry, Generation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	gen := c.generation(code)
+	el, ok := c.items[code]
+	if !ok {
+		c.misses.Add(1)
+		return Entry{}, gen, false
+	}
+	it := el.Value.(*item)
+	if time.Now().After(it.deadline) {
+		c.removeElement(el)
+		c.misses.Add(1)
+		return Entry{}, gen, false
+	}
+	c.ll.MoveToFront(el)
+	if it.entry.Missing {
+		c.negativeHits.Add(1)
+	} else {
+		c.hits.Add(1)
+	}
+	return it.entry, gen, true
+}
+
+// Set caches a found link, unless code was invalidated since the Get that
+// returned gen. The entry never outlives the link's own expiry date.
+func (c *LinkCache) Set(code string, gen Generation, e Entry) {
+	e.Missing = false
+	deadline := time.Now().Add(c.ttl)
+	if !e.ExpiresAt.IsZero() && e.ExpiresAt.Before(deadline) {
+		deadline = e.ExpiresAt
+	}
+	c.put(code, gen, e, deadline)
+}
+
+// SetMissing caches the fact that code does not exist, like Set.
+func (c *LinkCache) SetMissing(code string, gen Generation) {
+	c.put(code, gen, Entry{Missing: true}, time.Now().Add(c.negativeTTL))
+}
+
+func (c *LinkCache) put(code string, gen Generation, e Entry, deadline time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation(code) != gen {
+		return
+	}
+	if el, ok := c.items[code]; ok {
+		it := el.Value.(*item)
+		it.entry = e
+		it.deadline = deadline
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[code] = c.ll.PushFront(&item{code: code, entry: e, deadline: deadline})
+	for c.ll.Len() > c.capacity {
+		c.removeElement(c.ll.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// Invalidate drops code, positive or negative.
+func (c *LinkCache) Invalidate(code string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.gens) >= 4*c.capacity {
+		c.epoch++
+		c.gens = make(map[string]uint64)
+	}
+	c.gens[code]++
+	if el, ok := c.items[code]; ok {
+		c.removeElement(el)
+		c.invalidations.Add(1)
+	}
+}
+
+// Purge drops everything, used when we may have missed invalidations.
+func (c *LinkCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.epoch++
+	c.gens = make(map[string]uint64)
+}
+
+func (c *LinkCache) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*item).code)
+}
+
+func (c *LinkCache) Stats() Stats {
+	c.mu.Lock()
+	size := c.ll.Len()
+	c.mu.Unlock()
+	return Stats{
+		Size:          size,
+		Hits:          c.hits.Load(),
+		NegativeHits:  c.negativeHits.Load(),
+		Misses:        c.misses.Load(),
+		Evictions:     c.evictions.Load(),
+		Invalidations: c.invalidations.Load(),
+	}
+}
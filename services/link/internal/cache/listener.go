@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// InvalidationChannel is the Postgres NOTIFY channel carrying changed link keys
+// in "domain/shortcode" form.
+// The url_mappings trigger in the db package publishes on it for every insert,
+// update and delete, so edits made by any replica (or by hand) reach every cache.
+const InvalidationChannel = "link_invalidate"
+
+// Subscriber is anything that keeps per-link state derived from url_mappings.
+type Subscriber interface {
+	// Changed is called with each notified link key.
+	Changed(key string)
+	// Reset is called when notifications may have been lost.
+	Reset()
+}
+
+// Changed drops the cached lookup for key.
+func (c *LinkCache) Changed(key string) { c.Invalidate(key) }
+
+// Reset drops every cached lookup.
+func (c *LinkCache) Reset() { c.Purge() }
+
+// Listen subscribes to InvalidationChannel and fans each notification out to subs.
+// If the connection drops we cannot know what we missed, so every subscriber is
+// reset once the listener reconnects. Stop it with Close on the returned value.
+func Listen(dsn string, subs ...Subscriber) (*pq.Listener, error) {
+	listener := pq.NewListener(dsn, 100*time.Millisecond, 10*time.Second, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventConnectionAttemptFailed, pq.ListenerEventDisconnected:
+			logrus.Warnf("Link invalidation listener: %v", err)
+		case pq.ListenerEventReconnected:
+			logrus.Infof("Link invalidation listener reconnected, resetting subscribers")
+		}
+	})
+	if err := listener.Listen(InvalidationChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	go func() {
+		for {
+			select {
+			case n, ok := <-listener.Notify:
+				if !ok {
+					return
+				}
+				for _, s := range subs {
+					if n == nil {
+						// Sent after a reconnect; notifications may have been lost.
+						s.Reset()
+					} else {
+						s.Changed(n.Extra)
+					}
+				}
+			case <-time.After(time.Minute):
+				go listener.Ping()
+			}
+		}
+	}()
+	return listener, nil
+}
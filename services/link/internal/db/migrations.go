@@ -0,0 +1,506 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"usethislink/services/internal/migrate"
+)
+
+// Migrations is the link schema history. Never edit an applied migration;
+// add a new one instead. Version 1 uses IF NOT EXISTS so databases created
+// before migrations existed adopt it without changes.
+var Migrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "create url_mappings",
+		Up: migrate.Both(`
+			CREATE TABLE IF NOT EXISTS url_mappings (
+				short_url TEXT NOT NULL,
+				original_url TEXT NOT NULL,
+				session_id TEXT,
+				user_email TEXT,
+				visits INTEGER DEFAULT 0,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				expiry_date TIMESTAMP,
+				is_logged_in BOOLEAN DEFAULT FALSE,
+				PRIMARY KEY (short_url, session_id, user_email)
+			);`),
+		Down: migrate.Both(`DROP TABLE IF EXISTS url_mappings;`),
+	},
+	{
+		Version: 2,
+		Name:    "per-link redirect code",
+		Up: migrate.Script{
+			Postgres: `
+			ALTER TABLE url_mappings ADD COLUMN IF NOT EXISTS redirect_code INTEGER DEFAULT 302;
+			ALTER TABLE url_mappings ADD COLUMN IF NOT EXISTS destination_locked BOOLEAN DEFAULT FALSE;`,
+			SQLite: `
+			ALTER TABLE url_mappings ADD COLUMN redirect_code INTEGER DEFAULT 302;
+			ALTER TABLE url_mappings ADD COLUMN destination_locked BOOLEAN DEFAULT FALSE;`,
+		},
+		Down: migrate.Both(`
+			ALTER TABLE url_mappings DROP COLUMN destination_locked;
+			ALTER TABLE url_mappings DROP COLUMN redirect_code;`),
+	},
+	{
+		Version: 3,
+		Name:    "unique short codes",
+		Check:   checkDuplicateShortCodes,
+		Up:      migrate.Both(`CREATE UNIQUE INDEX IF NOT EXISTS url_mappings_short_url_idx ON url_mappings (short_url);`),
+		Down:    migrate.Both(`DROP INDEX IF EXISTS url_mappings_short_url_idx;`),
+	},
+	{
+		Version: 4,
+		Name:    "create scan_blocks",
+		Up: migrate.Script{
+			Postgres: `
+			CREATE TABLE IF NOT EXISTS scan_blocks (
+				id SERIAL PRIMARY KEY,
+				ip_address TEXT NOT NULL,
+				not_found_count INTEGER NOT NULL,
+				strikes INTEGER NOT NULL,
+				last_shortcode TEXT,
+				blocked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				blocked_until TIMESTAMP NOT NULL
+			);`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS scan_blocks (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				ip_address TEXT NOT NULL,
+				not_found_count INTEGER NOT NULL,
+				strikes INTEGER NOT NULL,
+				last_shortcode TEXT,
+				blocked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				blocked_until TIMESTAMP NOT NULL
+			);`,
+		},
+		Down: migrate.Both(`DROP TABLE IF EXISTS scan_blocks;`),
+	},
+	{
+		Version: 5,
+		Name:    "notify link changes",
+		// Tells every link-service replica to drop cached lookups for changed
+		// codes. SQLite runs in a single process and needs no trigger.
+		Up: migrate.Script{
+			Postgres: `
+			CREATE OR REPLACE FUNCTION notify_link_change() RETURNS trigger AS $$
+			BEGIN
+				IF TG_OP = 'DELETE' THEN
+					PERFORM pg_notify('link_invalidate', OLD.short_url);
+					RETURN OLD;
+				END IF;
+				IF TG_OP = 'UPDATE' AND OLD.short_url <> NEW.short_url THEN
+					PERFORM pg_notify('link_invalidate', OLD.short_url);
+				END IF;
+				PERFORM pg_notify('link_invalidate', NEW.short_url);
+				RETURN NEW;
+			END;
+			$$ LANGUAGE plpgsql;
+
+			DROP TRIGGER IF EXISTS url_mappings_notify ON url_mappings;
+			CREATE TRIGGER url_mappings_notify
+				AFTER INSERT OR DELETE OR UPDATE OF short_url, original_url, redirect_code, destination_locked, expiry_date
+				ON url_mappings
+				FOR EACH ROW EXECUTE FUNCTION notify_link_change();`,
+		},
+		Down: migrate.Script{
+			Postgres: `
+			DROP TRIGGER IF EXISTS url_mappings_notify ON url_mappings;
+			DROP FUNCTION IF EXISTS notify_link_change();`,
+		},
+	},
+	{
+		Version: 6,
+		Name:    "custom domains",
+		// Shortcodes become unique per domain: "" is BASE_URL, anything else a
+		// row in domains. SQLite cannot change a primary key in place, so the
+		// table is rebuilt there. Down drops every custom-domain link.
+		Up: migrate.Script{
+			Postgres: `
+			ALTER TABLE url_mappings ADD COLUMN IF NOT EXISTS domain TEXT NOT NULL DEFAULT '';
+			ALTER TABLE url_mappings DROP CONSTRAINT IF EXISTS url_mappings_pkey;
+			DROP INDEX IF EXISTS url_mappings_short_url_idx;
+			ALTER TABLE url_mappings ADD PRIMARY KEY (domain, short_url);
+
+			CREATE TABLE IF NOT EXISTS domains (
+				host TEXT PRIMARY KEY,
+				owner_email TEXT NOT NULL,
+				verification_token TEXT NOT NULL,
+				verified_at TIMESTAMP,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS domains_owner_idx ON domains (owner_email);`,
+			SQLite: `
+			CREATE TABLE url_mappings_v6 (
+				domain TEXT NOT NULL DEFAULT '',
+				short_url TEXT NOT NULL,
+				original_url TEXT NOT NULL,
+				session_id TEXT,
+				user_email TEXT,
+				visits INTEGER DEFAULT 0,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				expiry_date TIMESTAMP,
+				is_logged_in BOOLEAN DEFAULT FALSE,
+				redirect_code INTEGER DEFAULT 302,
+				destination_locked BOOLEAN DEFAULT FALSE,
+				PRIMARY KEY (domain, short_url)
+			);
+			INSERT INTO url_mappings_v6
+				(short_url, original_url, session_id, user_email, visits, created_at, expiry_date, is_logged_in, redirect_code, destination_locked)
+				SELECT short_url, original_url, session_id, user_email, visits, created_at, expiry_date, is_logged_in, redirect_code, destination_locked
+				FROM url_mappings;
+			DROP TABLE url_mappings;
+			ALTER TABLE url_mappings_v6 RENAME TO url_mappings;
+
+			CREATE TABLE IF NOT EXISTS domains (
+				host TEXT PRIMARY KEY,
+				owner_email TEXT NOT NULL,
+				verification_token TEXT NOT NULL,
+				verified_at TIMESTAMP,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS domains_owner_idx ON domains (owner_email);`,
+		},
+		Down: migrate.Script{
+			Postgres: `
+			DROP TABLE IF EXISTS domains;
+			DELETE FROM url_mappings WHERE domain <> '';
+			ALTER TABLE url_mappings DROP CONSTRAINT IF EXISTS url_mappings_pkey;
+			ALTER TABLE url_mappings DROP COLUMN domain;
+			ALTER TABLE url_mappings ADD PRIMARY KEY (short_url, session_id, user_email);
+			CREATE UNIQUE INDEX IF NOT EXISTS url_mappings_short_url_idx ON url_mappings (short_url);`,
+			SQLite: `
+			DROP TABLE IF EXISTS domains;
+			CREATE TABLE url_mappings_v5 (
+				short_url TEXT NOT NULL,
+				original_url TEXT NOT NULL,
+				session_id TEXT,
+				user_email TEXT,
+				visits INTEGER DEFAULT 0,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				expiry_date TIMESTAMP,
+				is_logged_in BOOLEAN DEFAULT FALSE,
+				redirect_code INTEGER DEFAULT 302,
+				destination_locked BOOLEAN DEFAULT FALSE,
+				PRIMARY KEY (short_url, session_id, user_email)
+			);
+			INSERT INTO url_mappings_v5
+				SELECT short_url, original_url, session_id, user_email, visits, created_at, expiry_date, is_logged_in, redirect_code, destination_locked
+				FROM url_mappings WHERE domain = '';
+			DROP TABLE url_mappings;
+			ALTER TABLE url_mappings_v5 RENAME TO url_mappings;
+			CREATE UNIQUE INDEX IF NOT EXISTS url_mappings_short_url_idx ON url_mappings (short_url);`,
+		},
+	},
+	{
+		Version: 7,
+		Name:    "notify link changes with domain",
+		// Notifications now carry "domain/shortcode" (store.LinkKey.String).
+		Up: migrate.Script{
+			Postgres: `
+			CREATE OR REPLACE FUNCTION notify_link_change() RETURNS trigger AS $$
+			BEGIN
+				IF TG_OP = 'DELETE' THEN
+					PERFORM pg_notify('link_invalidate', OLD.domain || '/' || OLD.short_url);
+					RETURN OLD;
+				END IF;
+				IF TG_OP = 'UPDATE' AND (OLD.domain <> NEW.domain OR OLD.short_url <> NEW.short_url) THEN
+					PERFORM pg_notify('link_invalidate', OLD.domain || '/' || OLD.short_url);
+				END IF;
+				PERFORM pg_notify('link_invalidate', NEW.domain || '/' || NEW.short_url);
+				RETURN NEW;
+			END;
+			$$ LANGUAGE plpgsql;
+
+			DROP TRIGGER IF EXISTS url_mappings_notify ON url_mappings;
+			CREATE TRIGGER url_mappings_notify
+				AFTER INSERT OR DELETE OR UPDATE OF domain, short_url, original_url, redirect_code, destination_locked, expiry_date
+				ON url_mappings
+				FOR EACH ROW EXECUTE FUNCTION notify_link_change();`,
+		},
+		Down: migrate.Script{
+			Postgres: `
+			CREATE OR REPLACE FUNCTION notify_link_change() RETURNS trigger AS $$
+			BEGIN
+				IF TG_OP = 'DELETE' THEN
+					PERFORM pg_notify('link_invalidate', OLD.short_url);
+					RETURN OLD;
+				END IF;
+				IF TG_OP = 'UPDATE' AND OLD.short_url <> NEW.short_url THEN
+					PERFORM pg_notify('link_invalidate', OLD.short_url);
+				END IF;
+				PERFORM pg_notify('link_invalidate', NEW.short_url);
+				RETURN NEW;
+			END;
+			$$ LANGUAGE plpgsql;
+
+			DROP TRIGGER IF EXISTS url_mappings_notify ON url_mappings;
+			CREATE TRIGGER url_mappings_notify
+				AFTER INSERT OR DELETE OR UPDATE OF short_url, original_url, redirect_code, destination_locked, expiry_date
+				ON url_mappings
+				FOR EACH ROW EXECUTE FUNCTION notify_link_change();`,
+		},
+	}, {
+		Version: 8,
+		Name:    "workspaces",
+		// Links with a workspace_id belong to the workspace; session_id and
+		// user_email then only record who created them.
+		Up: migrate.Script{
+			Postgres: `
+			ALTER TABLE url_mappings ADD COLUMN IF NOT EXISTS workspace_id TEXT NOT NULL DEFAULT '';
+			CREATE INDEX IF NOT EXISTS url_mappings_workspace_idx ON url_mappings (workspace_id) WHERE workspace_id <> '';
+
+			CREATE TABLE IF NOT EXISTS workspaces (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				created_by TEXT NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+
+			CREATE TABLE IF NOT EXISTS workspace_members (
+				workspace_id TEXT NOT NULL REFERENCES workspaces (id),
+				user_email TEXT NOT NULL,
+				role TEXT NOT NULL CHECK (role IN ('owner', 'admin', 'editor', 'viewer')),
+				added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (workspace_id, user_email)
+			);
+			CREATE INDEX IF NOT EXISTS workspace_members_email_idx ON workspace_members (user_email);
+
+			CREATE TABLE IF NOT EXISTS workspace_invitations (
+				id TEXT PRIMARY KEY,
+				workspace_id TEXT NOT NULL REFERENCES workspaces (id),
+				email TEXT NOT NULL,
+				role TEXT NOT NULL CHECK (role IN ('owner', 'admin', 'editor', 'viewer')),
+				invited_by TEXT NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				expires_at TIMESTAMP NOT NULL,
+				accepted_at TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS workspace_invitations_email_idx ON workspace_invitations (email);`,
+			SQLite: `
+			ALTER TABLE url_mappings ADD COLUMN workspace_id TEXT NOT NULL DEFAULT '';
+			CREATE INDEX IF NOT EXISTS url_mappings_workspace_idx ON url_mappings (workspace_id) WHERE workspace_id <> '';
+
+			CREATE TABLE IF NOT EXISTS workspaces (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				created_by TEXT NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+
+			CREATE TABLE IF NOT EXISTS workspace_members (
+				workspace_id TEXT NOT NULL REFERENCES workspaces (id),
+				user_email TEXT NOT NULL,
+				role TEXT NOT NULL CHECK (role IN ('owner', 'admin', 'editor', 'viewer')),
+				added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (workspace_id, user_email)
+			);
+			CREATE INDEX IF NOT EXISTS workspace_members_email_idx ON workspace_members (user_email);
+
+			CREATE TABLE IF NOT EXISTS workspace_invitations (
+				id TEXT PRIMARY KEY,
+				workspace_id TEXT NOT NULL REFERENCES workspaces (id),
+				email TEXT NOT NULL,
+				role TEXT NOT NULL CHECK (role IN ('owner', 'admin', 'editor', 'viewer')),
+				invited_by TEXT NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				expires_at TIMESTAMP NOT NULL,
+				accepted_at TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS workspace_invitations_email_idx ON workspace_invitations (email);`,
+		},
+		Down: migrate.Both(`
+			DROP TABLE IF EXISTS workspace_invitations;
+			DROP TABLE IF EXISTS workspace_members;
+			DROP TABLE IF EXISTS workspaces;
+			DROP INDEX IF EXISTS url_mappings_workspace_idx;
+			ALTER TABLE url_mappings DROP COLUMN workspace_id;`),
+	},
+	{
+		Version: 9,
+		Name:    "qr presets",
+		// A preset belongs to a user (owner_email) or a workspace (workspace_id).
+		Up: migrate.Script{
+			Postgres: `
+			CREATE TABLE IF NOT EXISTS qr_presets (
+				id TEXT PRIMARY KEY,
+				owner_email TEXT NOT NULL DEFAULT '',
+				workspace_id TEXT NOT NULL DEFAULT '',
+				name TEXT NOT NULL,
+				foreground TEXT NOT NULL,
+				background TEXT NOT NULL,
+				level TEXT NOT NULL CHECK (level IN ('L', 'M', 'Q', 'H')),
+				margin INTEGER NOT NULL,
+				rounded BOOLEAN NOT NULL DEFAULT FALSE,
+				logo BYTEA,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (owner_email, workspace_id, name)
+			);`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS qr_presets (
+				id TEXT PRIMARY KEY,
+				owner_email TEXT NOT NULL DEFAULT '',
+				workspace_id TEXT NOT NULL DEFAULT '',
+				name TEXT NOT NULL,
+				foreground TEXT NOT NULL,
+				background TEXT NOT NULL,
+				level TEXT NOT NULL CHECK (level IN ('L', 'M', 'Q', 'H')),
+				margin INTEGER NOT NULL,
+				rounded BOOLEAN NOT NULL DEFAULT FALSE,
+				logo BLOB,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE (owner_email, workspace_id, name)
+			);`,
+		},
+		Down: migrate.Both(`DROP TABLE IF EXISTS qr_presets;`),
+	},
+	{
+		Version: 10,
+		Name:    "hosted vcards",
+		Up: migrate.Both(`
+			CREATE TABLE IF NOT EXISTS vcards (
+				id TEXT PRIMARY KEY,
+				owner_email TEXT NOT NULL DEFAULT '',
+				session_id TEXT NOT NULL DEFAULT '',
+				workspace_id TEXT NOT NULL DEFAULT '',
+				data TEXT NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);`),
+		Down: migrate.Both(`DROP TABLE IF EXISTS vcards;`),
+	},
+	{
+		Version: 11,
+		Name:    "bio pages",
+		// bio_links rows are a page's buttons, ordered by position.
+		Up: migrate.Both(`
+			CREATE TABLE IF NOT EXISTS bio_pages (
+				handle TEXT PRIMARY KEY,
+				owner_email TEXT NOT NULL DEFAULT '',
+				workspace_id TEXT NOT NULL DEFAULT '',
+				title TEXT NOT NULL,
+				description TEXT NOT NULL DEFAULT '',
+				background TEXT NOT NULL,
+				foreground TEXT NOT NULL,
+				button_background TEXT NOT NULL,
+				button_foreground TEXT NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE TABLE IF NOT EXISTS bio_links (
+				handle TEXT NOT NULL REFERENCES bio_pages (handle) ON DELETE CASCADE,
+				position INTEGER NOT NULL,
+				domain TEXT NOT NULL DEFAULT '',
+				short_url TEXT NOT NULL,
+				title TEXT NOT NULL,
+				icon TEXT NOT NULL DEFAULT '',
+				PRIMARY KEY (handle, position)
+			);`),
+		Down: migrate.Both(`
+			DROP TABLE IF EXISTS bio_links;
+			DROP TABLE IF EXISTS bio_pages;`),
+	},
+	{
+		Version: 12,
+		Name:    "per-owner domain claims",
+		// Anyone may claim a host until one claim is verified, so an unverified
+		// claim can't lock the real owner out. Down keeps one row per host:
+		// the verified claim, or none if several were pending.
+		Up: migrate.Script{
+			Postgres: `
+			ALTER TABLE domains DROP CONSTRAINT IF EXISTS domains_pkey;
+			ALTER TABLE domains ADD PRIMARY KEY (host, owner_email);
+			CREATE UNIQUE INDEX IF NOT EXISTS domains_verified_host_idx ON domains (host) WHERE verified_at IS NOT NULL;`,
+			SQLite: `
+			CREATE TABLE domains_v12 (
+				host TEXT NOT NULL,
+				owner_email TEXT NOT NULL,
+				verification_token TEXT NOT NULL,
+				verified_at TIMESTAMP,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (host, owner_email)
+			);
+			INSERT INTO domains_v12 SELECT host, owner_email, verification_token, verified_at, created_at FROM domains;
+			DROP TABLE domains;
+			ALTER TABLE domains_v12 RENAME TO domains;
+			CREATE INDEX IF NOT EXISTS domains_owner_idx ON domains (owner_email);
+			CREATE UNIQUE INDEX IF NOT EXISTS domains_verified_host_idx ON domains (host) WHERE verified_at IS NOT NULL;`,
+		},
+		Down: migrate.Script{
+			Postgres: `
+			DELETE FROM domains WHERE verified_at IS NULL
+				AND host IN (SELECT host FROM domains GROUP BY host HAVING COUNT(*) > 1);
+			DROP INDEX IF EXISTS domains_verified_host_idx;
+			ALTER TABLE domains DROP CONSTRAINT IF EXISTS domains_pkey;
+			ALTER TABLE domains ADD PRIMARY KEY (host);`,
+			SQLite: `
+			DELETE FROM domains WHERE verified_at IS NULL
+				AND host IN (SELECT host FROM domains GROUP BY host HAVING COUNT(*) > 1);
+			CREATE TABLE domains_v11 (
+				host TEXT PRIMARY KEY,
+				owner_email TEXT NOT NULL,
+				verification_token TEXT NOT NULL,
+				verified_at TIMESTAMP,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+			INSERT INTO domains_v11 SELECT host, owner_email, verification_token, verified_at, created_at FROM domains;
+			DROP TABLE domains;
+			ALTER TABLE domains_v11 RENAME TO domains;
+			CREATE INDEX IF NOT EXISTS domains_owner_idx ON domains (owner_email);`,
+		},
+	},
+	{
+		Version: 13,
+		Name:    "workspace domains",
+		Up: migrate.Script{
+			Postgres: `
+			ALTER TABLE domains ADD COLUMN IF NOT EXISTS workspace_id TEXT NOT NULL DEFAULT '';
+			CREATE INDEX IF NOT EXISTS domains_workspace_idx ON domains (workspace_id);`,
+			SQLite: `
+			ALTER TABLE domains ADD COLUMN workspace_id TEXT NOT NULL DEFAULT '';
+			CREATE INDEX IF NOT EXISTS domains_workspace_idx ON domains (workspace_id);`,
+		},
+		Down: migrate.Both(`
+			DROP INDEX IF EXISTS domains_workspace_idx;
+			ALTER TABLE domains DROP COLUMN workspace_id;`),
+	},
+	{
+		Version: 14,
+		Name:    "link tags",
+		Up: migrate.Script{
+			Postgres: `ALTER TABLE url_mappings ADD COLUMN IF NOT EXISTS tags TEXT NOT NULL DEFAULT '';`,
+			SQLite:   `ALTER TABLE url_mappings ADD COLUMN tags TEXT NOT NULL DEFAULT '';`,
+		},
+		Down: migrate.Both(`ALTER TABLE url_mappings DROP COLUMN tags;`),
+	},
+}
+
+// checkDuplicateShortCodes refuses to add the unique index while a code was
+// issued more than once before it existed. Each duplicate is someone's link
+// with its own history, so which one keeps the code is left to an operator.
+func checkDuplicateShortCodes(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT short_url, COUNT(*) FROM url_mappings GROUP BY short_url HAVING COUNT(*) > 1 ORDER BY short_url`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var dups []string
+	for rows.Next() {
+		var code string
+		var n int
+		if err := rows.Scan(&code, &n); err != nil {
+			return err
+		}
+		dups = append(dups, fmt.Sprintf("%s (%d rows)", code, n))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(dups) > 0 {
+		return fmt.Errorf("short codes issued more than once: %s; give all but one row of each a new short_url, then migrate again", strings.Join(dups, ", "))
+	}
+	return nil
+}
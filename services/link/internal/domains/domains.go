@@ -0,0 +1,117 @@
+// Package domains validates custom short domains and checks that whoever
+// registers one controls its DNS.
+package domains
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"usethislink/services/internal/shorturl"
+)
+
+var (
+	ErrInvalidHost = errors.New("invalid domain name")
+	ErrPrimaryHost = errors.New("the service's own domain cannot be registered")
+	ErrNotVerified = errors.New("verification TXT record not found")
+)
+
+// Normalize lowercases host and checks it is a plausible registrable name:
+// at least two labels of letters, digits and hyphens, and not BASE_URL's host.
+func Normalize(host string) (string, error) {
+	h := shorturl.Hostname(host)
+	if h == "" || len(h) > 253 || net.ParseIP(h) != nil || !strings.Contains(h, ".") {
+		return "", ErrInvalidHost
+	}
+	for _, label := range strings.Split(h, ".") {
+		if err := checkLabel(label); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidHost, err)
+		}
+	}
+	if h == shorturl.PrimaryHost() {
+		return "", ErrPrimaryHost
+	}
+	return h, nil
+}
+
+func checkLabel(label string) error {
+	if label == "" || len(label) > 63 {
+		return fmt.Errorf("label %q must be 1 to 63 characters", label)
+	}
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return fmt.Errorf("label %q starts or ends with a hyphen", label)
+	}
+	for _, r := range label {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return fmt.Errorf("label %q contains %q", label, r)
+		}
+	}
+	return nil
+}
+
+// NewToken returns a random verification token.
+func NewToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// RecordName is the TXT record the owner of host must publish.
+func RecordName(host string) string { return "_usethislink." + host }
+
+// RecordValue is the content expected in RecordName.
+func RecordValue(token string) string { return "usethislink-verify=" + token }
+
+// Verifier proves control of a domain.
+type Verifier interface {
+	// Verify returns nil once host publishes RecordValue(token).
+	Verify(ctx context.Context, host, token string) error
+}
+
+// DNSVerifier looks the TXT record up with Resolver (net.DefaultResolver if nil).
+type DNSVerifier struct {
+	Resolver *net.Resolver
+}
+
+func (v DNSVerifier) Verify(ctx context.Context, host, token string) error {
+	resolver := v.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	records, err := resolver.LookupTXT(ctx, RecordName(host))
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return ErrNotVerified
+		}
+		return err
+	}
+	want := RecordValue(token)
+	for _, r := range records {
+		if strings.TrimSpace(r) == want {
+			return nil
+		}
+	}
+	return ErrNotVerified
+}
+
+// StubVerifier accepts every domain. Use it only where DNS is not reachable.
+type StubVerifier struct{}
+
+func (StubVerifier) Verify(ctx context.Context, host, token string) error { return nil }
+
+// VerifierFromEnv returns StubVerifier when DOMAIN_VERIFY=stub and a
+// DNSVerifier otherwise.
+func VerifierFromEnv() Verifier {
+	if os.Getenv("DOMAIN_VERIFY") == "stub" {
+		return StubVerifier{}
+	}
+	return DNSVerifier{}
+}
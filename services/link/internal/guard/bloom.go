@@ -0,0 +1,64 @@
+package guard
+
+import (
+	"math"
+	"sync"
+
+	"github.com/cespare/xxhash"
+)
+
+// Bloom is a fixed-size Bloom filter over shortcodes. It can say "definitely
+// not a code we issued" without touching the database; false positives simply
+// fall through to the normal lookup.
+type Bloom struct {
+	mu   sync.RWMutex
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+// NewBloom sizes a filter for n codes at false-positive rate p.
+func NewBloom(n int, p float64) *Bloom {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Bloom{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// positions uses double hashing (Kirsch-Mitzenmacher) to derive k bit indexes.
+func (b *Bloom) positions(code string, fn func(uint64)) {
+	h1 := xxhash.Sum64String(code)
+	h2 := xxhash.Sum64String(code+"\x00") | 1
+	for i := uint64(0); i < b.k; i++ {
+		fn((h1 + i*h2) % b.m)
+	}
+}
+
+func (b *Bloom) Add(code string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.positions(code, func(pos uint64) {
+		b.bits[pos/64] |= 1 << (pos % 64)
+	})
+}
+
+// MayContain is false only for codes that were never added.
+func (b *Bloom) MayContain(code string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	found := true
+	b.positions(code, func(pos uint64) {
+		if b.bits[pos/64]&(1<<(pos%64)) == 0 {
+			found = false
+		}
+	})
+	return found
+}
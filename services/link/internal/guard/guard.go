@@ -0,0 +1,151 @@
+package guard
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"usethislink/services/link/internal/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Guard keeps random shortcode probes away from the database: a Bloom filter
+// of issued domain/code keys answers most unknown codes, and a ScanDetector slows down or
+// blocks clients that collect too many 404s.
+type Guard struct {
+	st    store.LinkStore
+	bloom atomic.Pointer[Bloom]
+	Scans *ScanDetector
+
+	mu         sync.Mutex
+	rebuilding bool
+	pending    []string
+}
+
+// New loads every existing link key into the filter before returning, so the
+// filter never rejects a code that exists. It is rebuilt every
+// BLOOM_REBUILD_INTERVAL (default 1h) to resize as the table grows.
+func New(st store.LinkStore) (*Guard, error) {
+	g := &Guard{st: st}
+	g.Scans = NewScanDetector(ScanConfigFromEnv(), g.recordBlock)
+	if err := g.Rebuild(); err != nil {
+		return nil, err
+	}
+	interval := time.Hour
+	if v, err := time.ParseDuration(os.Getenv("BLOOM_REBUILD_INTERVAL")); err == nil && v > 0 {
+		interval = v
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if err := g.Rebuild(); err != nil {
+				logrus.Errorf("Failed to rebuild shortcode filter: %v", err)
+			}
+		}
+	}()
+	return g, nil
+}
+
+// Known is false only for codes that were never issued on key's domain.
+func (g *Guard) Known(key store.LinkKey) bool {
+	return g.bloom.Load().MayContain(key.String())
+}
+
+// Add records a newly issued code.
+func (g *Guard) Add(key store.LinkKey) {
+	g.add(key.String())
+}
+
+// add inserts a raw key. Keys added while a rebuild is running are replayed
+// onto the new filter so they are not lost in the swap.
+func (g *Guard) add(code string) {
+	g.mu.Lock()
+	if g.rebuilding {
+		g.pending = append(g.pending, code)
+	}
+	g.mu.Unlock()
+	if b := g.bloom.Load(); b != nil {
+		b.Add(code)
+	}
+}
+
+// Rebuild reloads the filter from the link store.
+func (g *Guard) Rebuild() error {
+	g.mu.Lock()
+	g.rebuilding = true
+	g.pending = nil
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		g.rebuilding = false
+		g.pending = nil
+		g.mu.Unlock()
+	}()
+
+	ctx := context.Background()
+	count, err := g.st.CountLinks(ctx)
+	if err != nil {
+		return err
+	}
+	// Leave headroom for codes issued before the next rebuild.
+	b := NewBloom(max(count*2, 100000), 0.01)
+	err = g.st.EachLinkKey(ctx, func(key store.LinkKey) error {
+		b.Add(key.String())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	for _, code := range g.pending {
+		b.Add(code)
+	}
+	g.bloom.Store(b)
+	g.mu.Unlock()
+	return nil
+}
+
+// Changed is called for every link key notified on the invalidation channel.
+// Inserts on other replicas reach our filter this way; deletes are harmless
+// extra bits until the next rebuild.
+func (g *Guard) Changed(key string) {
+	g.add(key)
+}
+
+// Reset is called when the notification stream may have gaps.
+func (g *Guard) Reset() {
+	go func() {
+		if err := g.Rebuild(); err != nil {
+			logrus.Errorf("Failed to rebuild shortcode filter: %v", err)
+		}
+	}()
+}
+
+func (g *Guard) recordBlock(ev BlockEvent) {
+	logrus.WithFields(logrus.Fields{
+		"ip":            ev.IP,
+		"not_found":     ev.Misses,
+		"strikes":       ev.Strikes,
+		"last_code":     ev.LastCode,
+		"blocked_until": ev.BlockedUntil.Format(time.RFC3339),
+	}).Warn("Blocking client for shortcode scanning")
+	err := g.st.RecordScanBlock(context.Background(), store.ScanBlock{
+		IPAddress:     ev.IP,
+		NotFoundCount: ev.Misses,
+		Strikes:       ev.Strikes,
+		LastShortcode: ev.LastCode,
+		BlockedUntil:  ev.BlockedUntil,
+	})
+	if err != nil {
+		logrus.Errorf("Failed to record scan block: %v", err)
+	}
+}
+
+// RetryAfter formats the seconds until t for a Retry-After header.
+func RetryAfter(t time.Time) string {
+	secs := int(time.Until(t).Seconds()) + 1
+	return strconv.Itoa(secs)
+}
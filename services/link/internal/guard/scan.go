@@ -0,0 +1,163 @@
+package guard
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ScanConfig controls when a client counts as scanning shortcodes.
+// Below SlowAfter misses per Window nothing happens; between SlowAfter and
+// BlockAfter each request is delayed, doubling per miss up to MaxDelay; at
+// BlockAfter the client is blocked for BlockFor, doubled for every repeat offence.
+type ScanConfig struct {
+	Window     time.Duration
+	SlowAfter  int
+	BlockAfter int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	BlockFor   time.Duration
+}
+
+// ScanConfigFromEnv reads SCAN_WINDOW, SCAN_SLOW_AFTER, SCAN_BLOCK_AFTER and SCAN_BLOCK_FOR.
+func ScanConfigFromEnv() ScanConfig {
+	cfg := ScanConfig{
+		Window:     time.Minute,
+		SlowAfter:  10,
+		BlockAfter: 30,
+		BaseDelay:  100 * time.Millisecond,
+		MaxDelay:   5 * time.Second,
+		BlockFor:   10 * time.Minute,
+	}
+	if v, err := time.ParseDuration(os.Getenv("SCAN_WINDOW")); err == nil && v > 0 {
+		cfg.Window = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("SCAN_SLOW_AFTER")); err == nil && v > 0 {
+		cfg.SlowAfter = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("SCAN_BLOCK_AFTER")); err == nil && v > 0 {
+		cfg.BlockAfter = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("SCAN_BLOCK_FOR")); err == nil && v > 0 {
+		cfg.BlockFor = v
+	}
+	return cfg
+}
+
+// BlockEvent describes a client that has just been blocked.
+type BlockEvent struct {
+	IP           string
+	Misses       int
+	Strikes      int
+	LastCode     string
+	BlockedUntil time.Time
+}
+
+type ipState struct {
+	windowStart  time.Time
+	misses       int
+	strikes      int
+	blockedUntil time.Time
+	lastSeen     time.Time
+}
+
+// ScanDetector counts 404s per client IP.
+type ScanDetector struct {
+	cfg     ScanConfig
+	onBlock func(BlockEvent)
+
+	mu      sync.Mutex
+	clients map[string]*ipState
+}
+
+func NewScanDetector(cfg ScanConfig, onBlock func(BlockEvent)) *ScanDetector {
+	d := &ScanDetector{cfg: cfg, onBlock: onBlock, clients: make(map[string]*ipState)}
+	go d.janitor()
+	return d
+}
+
+// Check returns how long to stall the request, or a non-zero blockedUntil if
+// the client must be refused outright.
+func (d *ScanDetector) Check(ip string) (delay time.Duration, blockedUntil time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	st, ok := d.clients[ip]
+	if !ok {
+		return 0, time.Time{}
+	}
+	now := time.Now()
+	if now.Before(st.blockedUntil) {
+		return 0, st.blockedUntil
+	}
+	if now.Sub(st.windowStart) > d.cfg.Window || st.misses < d.cfg.SlowAfter {
+		return 0, time.Time{}
+	}
+	delay = d.cfg.BaseDelay << uint(st.misses-d.cfg.SlowAfter)
+	if delay <= 0 || delay > d.cfg.MaxDelay {
+		delay = d.cfg.MaxDelay
+	}
+	return delay, time.Time{}
+}
+
+// RecordMiss notes a 404 for ip and blocks it once it crosses the threshold.
+func (d *ScanDetector) RecordMiss(ip, code string) {
+	d.mu.Lock()
+	now := time.Now()
+	st, ok := d.clients[ip]
+	if !ok {
+		st = &ipState{windowStart: now}
+		d.clients[ip] = st
+	}
+	st.lastSeen = now
+	if now.Sub(st.windowStart) > d.cfg.Window {
+		st.windowStart = now
+		st.misses = 0
+	}
+	st.misses++
+	var ev *BlockEvent
+	if st.misses >= d.cfg.BlockAfter && !now.Before(st.blockedUntil) {
+		st.strikes++
+		st.blockedUntil = now.Add(d.cfg.BlockFor << uint(st.strikes-1))
+		ev = &BlockEvent{IP: ip, Misses: st.misses, Strikes: st.strikes, LastCode: code, BlockedUntil: st.blockedUntil}
+		st.misses = 0
+		st.windowStart = now
+	}
+	d.mu.Unlock()
+	if ev != nil && d.onBlock != nil {
+		d.onBlock(*ev)
+	}
+}
+
+// janitor forgets clients that are neither blocked nor inside a window.
+// Strikes are kept for a day so repeat offenders keep escalating.
+func (d *ScanDetector) janitor() {
+	for range time.Tick(time.Minute) {
+		d.mu.Lock()
+		now := time.Now()
+		for ip, st := range d.clients {
+			if now.After(st.blockedUntil) && now.Sub(st.lastSeen) > 24*time.Hour {
+				delete(d.clients, ip)
+			}
+		}
+		d.mu.Unlock()
+	}
+}
+
+// ClientIP returns the caller's address. Behind the gateway the last
+// X-Forwarded-For hop is the one the gateway itself appended, so it is the
+// only entry a client cannot forge.
+func ClientIP(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		parts := strings.Split(xff, ",")
+		return strings.TrimSpace(parts[len(parts)-1])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"usethislink/services/internal/shorturl"
+	"usethislink/services/internal/workspace"
+	"usethislink/services/link/internal/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+// caller is who the gateway says is making the request.
+func caller(r *http.Request) store.Owner {
+	return store.Owner{SessionID: r.Header.Get("X-Session-ID"), UserEmail: r.Header.Get("X-User-Email")}
+}
+
+// linkRole is the caller's effective role on l: Owner for their own personal
+// links, their workspace role for workspace links, "" if they have no access.
+func linkRole(ctx context.Context, st store.LinkStore, l store.Link, owner store.Owner) (workspace.Role, error) {
+	if l.WorkspaceID == "" {
+		if owner.Owns(l) {
+			return workspace.Owner, nil
+		}
+		return "", nil
+	}
+	return memberRole(ctx, st, l.WorkspaceID, owner)
+}
+
+// authorizedLink loads the link addressed by the request and checks the caller
+// holds at least min on it. Otherwise it answers 404 if the caller cannot see
+// the link at all, or 403 if they can but their role is too low.
+func authorizedLink(w http.ResponseWriter, r *http.Request, st store.LinkStore, min workspace.Role) (store.Link, bool) {
+	link, err := st.GetLink(r.Context(), requestLinkKey(r))
+	if err == store.ErrNotFound {
+		http.NotFound(w, r)
+		return link, false
+	} else if err != nil {
+		logrus.Errorf("Failed to load link: %v", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return link, false
+	}
+	role, err := linkRole(r.Context(), st, link, caller(r))
+	if err != nil {
+		logrus.Errorf("Failed to load workspace role: %v", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return link, false
+	}
+	if !role.AtLeast(workspace.Viewer) {
+		http.NotFound(w, r)
+		return link, false
+	}
+	if !role.AtLeast(min) {
+		http.Error(w, "Your workspace role does not allow this", http.StatusForbidden)
+		return link, false
+	}
+	return link, true
+}
+
+// memberRole is the signed-in caller's role in workspaceID, or "" for
+// anonymous callers and non-members.
+func memberRole(ctx context.Context, st store.LinkStore, workspaceID string, owner store.Owner) (workspace.Role, error) {
+	if owner.UserEmail == "" || workspaceID == "" {
+		return "", nil
+	}
+	role, err := st.MemberRole(ctx, workspaceID, owner.UserEmail)
+	if err == store.ErrNotFound {
+		return "", nil
+	}
+	return role, err
+}
+
+// targetDomain resolves a requested custom domain for new links in
+// workspaceID: one of the caller's own verified domains, or the workspace's
+// own for its editors. "" stays on BASE_URL.
+func targetDomain(w http.ResponseWriter, r *http.Request, st store.LinkStore, requested, workspaceID string) (string, bool) {
+	if requested == "" {
+		return "", true
+	}
+	userEmail := r.Header.Get("X-User-Email")
+	if userEmail == "" {
+		http.Error(w, "Unknown domain", http.StatusBadRequest)
+		return "", false
+	}
+	host := shorturl.Hostname(requested)
+	d, err := st.GetDomain(r.Context(), host)
+	if err == store.ErrNotFound {
+		// Not verified by anyone; say so if the caller is waiting on it.
+		_, err = st.GetDomainClaim(r.Context(), host, userEmail)
+		if err == nil {
+			http.Error(w, "Domain is not verified", http.StatusForbidden)
+			return "", false
+		}
+	}
+	if err != nil && err != store.ErrNotFound {
+		logrus.Errorf("Failed to load domain: %v", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return "", false
+	}
+	if err == store.ErrNotFound {
+		http.Error(w, "Unknown domain", http.StatusBadRequest)
+		return "", false
+	}
+	if d.WorkspaceID == "" {
+		if d.OwnerEmail != userEmail {
+			http.Error(w, "Unknown domain", http.StatusBadRequest)
+			return "", false
+		}
+		return d.Host, true
+	}
+	role, err := memberRole(r.Context(), st, d.WorkspaceID, caller(r))
+	if err != nil {
+		logrus.Errorf("Failed to load workspace role: %v", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return "", false
+	}
+	if !role.AtLeast(workspace.Viewer) {
+		http.Error(w, "Unknown domain", http.StatusBadRequest)
+		return "", false
+	}
+	if workspaceID != d.WorkspaceID {
+		http.Error(w, "Links on this domain belong to its workspace; set workspace_id", http.StatusBadRequest)
+		return "", false
+	}
+	if !role.AtLeast(workspace.Editor) {
+		http.Error(w, "You cannot create links in this workspace", http.StatusForbidden)
+		return "", false
+	}
+	return d.Host, true
+}
+
+// canCreateIn checks the caller may add links to workspaceID ("" is always
+// allowed: the links become theirs).
+func canCreateIn(w http.ResponseWriter, r *http.Request, st store.LinkStore, workspaceID string) bool {
+	if workspaceID == "" {
+		return true
+	}
+	role, err := memberRole(r.Context(), st, workspaceID, caller(r))
+	if err != nil {
+		logrus.Errorf("Failed to load workspace role: %v", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return false
+	}
+	if !role.AtLeast(workspace.Editor) {
+		http.Error(w, "You cannot create links in this workspace", http.StatusForbidden)
+		return false
+	}
+	return true
+}
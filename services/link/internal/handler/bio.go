@@ -0,0 +1,411 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"usethislink/services/internal/shorturl"
+	"usethislink/services/internal/workspace"
+	"usethislink/services/link/internal/qr"
+	"usethislink/services/link/internal/store"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// Limits on bio page content.
+const (
+	maxBioLinks       = 50
+	maxBioTitle       = 100
+	maxBioDescription = 300
+)
+
+var (
+	bioHandle = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{2,29}$`)
+	bioIcon   = regexp.MustCompile(`^[a-z0-9-]{1,40}$`)
+)
+
+// defaultBioTheme is used for colours a page leaves out.
+var defaultBioTheme = store.BioTheme{
+	Background:       "#f5f5f5",
+	Foreground:       "#222222",
+	ButtonBackground: "#222222",
+	ButtonForeground: "#ffffff",
+}
+
+type bioTheme struct {
+	Background       string `json:"background,omitempty"`
+	Foreground       string `json:"foreground,omitempty"`
+	ButtonBackground string `json:"button_background,omitempty"`
+	ButtonForeground string `json:"button_foreground,omitempty"`
+}
+
+type bioLink struct {
+	ShortURL string `json:"short_url"`
+	Title    string `json:"title"`
+	Icon     string `json:"icon,omitempty"`
+}
+
+type bioPageRequest struct {
+	Handle      string    `json:"handle"`
+	WorkspaceID string    `json:"workspace_id,omitempty"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Theme       bioTheme  `json:"theme"`
+	Links       []bioLink `json:"links"`
+}
+
+type bioPageResponse struct {
+	Handle      string    `json:"handle"`
+	URL         string    `json:"url"`
+	OwnerEmail  string    `json:"owner_email,omitempty"`
+	WorkspaceID string    `json:"workspace_id,omitempty"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Theme       bioTheme  `json:"theme"`
+	Links       []bioLink `json:"links"`
+	CreatedAt   string    `json:"created_at"`
+	UpdatedAt   string    `json:"updated_at"`
+}
+
+// newBioPageResponse renders p showing the links in live (nil for listings).
+func newBioPageResponse(p store.BioPage, live []store.BioLink) bioPageResponse {
+	resp := bioPageResponse{
+		Handle:      p.Handle,
+		URL:         strings.TrimRight(os.Getenv("BASE_URL"), "/") + "/@" + p.Handle,
+		OwnerEmail:  p.OwnerEmail,
+		WorkspaceID: p.WorkspaceID,
+		Title:       p.Title,
+		Description: p.Description,
+		Theme:       bioTheme(p.Theme),
+		Links:       make([]bioLink, 0, len(live)),
+		CreatedAt:   p.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:   p.UpdatedAt.Format(time.RFC3339),
+	}
+	for _, l := range live {
+		resp.Links = append(resp.Links, bioLink{ShortURL: shorturl.Build(l.Domain, l.ShortCode), Title: l.Title, Icon: l.Icon})
+	}
+	return resp
+}
+
+// shortLinkKey maps a short URL on BASE_URL or a custom domain to its key.
+func shortLinkKey(raw string) (store.LinkKey, bool) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.RawQuery != "" || u.Fragment != "" {
+		return store.LinkKey{}, false
+	}
+	code := strings.TrimPrefix(u.Path, "/")
+	if code == "" || strings.Contains(code, "/") {
+		return store.LinkKey{}, false
+	}
+	return store.LinkKey{Domain: shorturl.DomainForHost(u.Host), Code: code}, true
+}
+
+// bioColour normalises a theme colour, falling back to def when empty.
+func bioColour(field, s, def string) (string, error) {
+	if s == "" {
+		return def, nil
+	}
+	c, err := qr.ParseColour(s)
+	if err != nil {
+		return "", fmt.Errorf("theme.%s: %v", field, err)
+	}
+	return qr.Hex(c), nil
+}
+
+// bioContent validates the editable part of req into p.
+func bioContent(req bioPageRequest, p *store.BioPage) error {
+	p.Title = strings.TrimSpace(req.Title)
+	p.Description = strings.TrimSpace(req.Description)
+	if p.Title == "" || len(p.Title) > maxBioTitle {
+		return fmt.Errorf("title must be 1 to %d characters", maxBioTitle)
+	}
+	if len(p.Description) > maxBioDescription {
+		return fmt.Errorf("description must be at most %d characters", maxBioDescription)
+	}
+	var err error
+	t := req.Theme
+	if p.Theme.Background, err = bioColour("background", t.Background, defaultBioTheme.Background); err != nil {
+		return err
+	}
+	if p.Theme.Foreground, err = bioColour("foreground", t.Foreground, defaultBioTheme.Foreground); err != nil {
+		return err
+	}
+	if p.Theme.ButtonBackground, err = bioColour("button_background", t.ButtonBackground, defaultBioTheme.ButtonBackground); err != nil {
+		return err
+	}
+	if p.Theme.ButtonForeground, err = bioColour("button_foreground", t.ButtonForeground, defaultBioTheme.ButtonForeground); err != nil {
+		return err
+	}
+	if len(req.Links) > maxBioLinks {
+		return fmt.Errorf("a page holds at most %d links", maxBioLinks)
+	}
+	p.Links = make([]store.BioLink, 0, len(req.Links))
+	for i, l := range req.Links {
+		key, ok := shortLinkKey(l.ShortURL)
+		if !ok {
+			return fmt.Errorf("links[%d].short_url must be one of our short links", i)
+		}
+		title := strings.TrimSpace(l.Title)
+		if title == "" || len(title) > maxBioTitle {
+			return fmt.Errorf("links[%d].title must be 1 to %d characters", i, maxBioTitle)
+		}
+		icon := strings.TrimPrefix(strings.ToLower(l.Icon), "fa-")
+		if icon != "" && !bioIcon.MatchString(icon) {
+			return fmt.Errorf("links[%d].icon must be a Font Awesome icon name", i)
+		}
+		p.Links = append(p.Links, store.BioLink{Domain: key.Domain, ShortCode: key.Code, Title: title, Icon: icon})
+	}
+	return nil
+}
+
+// belongsTo reports whether l may appear on p: a personal page lists its
+// owner's personal links, a workspace page the workspace's links.
+func belongsTo(l store.Link, p store.BioPage) bool {
+	if p.WorkspaceID != "" {
+		return l.WorkspaceID == p.WorkspaceID
+	}
+	return l.WorkspaceID == "" && l.UserEmail != "" && l.UserEmail == p.OwnerEmail
+}
+
+// checkBioLinks answers 400 unless every link on p exists and belongs to it.
+func checkBioLinks(w http.ResponseWriter, r *http.Request, st store.LinkStore, p store.BioPage) bool {
+	for i, bl := range p.Links {
+		l, err := st.GetLink(r.Context(), store.LinkKey{Domain: bl.Domain, Code: bl.ShortCode})
+		if err != nil && err != store.ErrNotFound {
+			logrus.Errorf("Failed to load link: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return false
+		}
+		if err == store.ErrNotFound || !belongsTo(l, p) {
+			http.Error(w, fmt.Sprintf("links[%d]: no such link on this page's account", i), http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+// bioPageAccess loads {handle} and checks the caller may manage it: its owner
+// for personal pages, at least min in the workspace for workspace pages.
+func bioPageAccess(w http.ResponseWriter, r *http.Request, st store.LinkStore, min workspace.Role) (store.BioPage, bool) {
+	email := r.Header.Get("X-User-Email")
+	if email == "" {
+		http.Error(w, "Sign in to manage bio pages", http.StatusUnauthorized)
+		return store.BioPage{}, false
+	}
+	p, err := st.GetBioPage(r.Context(), strings.ToLower(mux.Vars(r)["handle"]))
+	if err == store.ErrNotFound {
+		http.NotFound(w, r)
+		return p, false
+	} else if err != nil {
+		logrus.Errorf("Failed to load bio page: %v", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return p, false
+	}
+	if p.WorkspaceID == "" {
+		if p.OwnerEmail != email {
+			http.NotFound(w, r)
+			return p, false
+		}
+		return p, true
+	}
+	role, err := memberRole(r.Context(), st, p.WorkspaceID, caller(r))
+	if err != nil {
+		logrus.Errorf("Failed to load workspace role: %v", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return p, false
+	}
+	if !role.AtLeast(workspace.Viewer) {
+		http.NotFound(w, r)
+		return p, false
+	}
+	if !role.AtLeast(min) {
+		http.Error(w, "Your workspace role does not allow this", http.StatusForbidden)
+		return p, false
+	}
+	return p, true
+}
+
+// CreateBioPageHandler handles POST /bio: a signed-in user publishes a page
+// for themselves, or with "workspace_id" for a workspace they can edit.
+func CreateBioPageHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email := r.Header.Get("X-User-Email")
+		if email == "" {
+			http.Error(w, "Sign in to create bio pages", http.StatusUnauthorized)
+			return
+		}
+		var req bioPageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		p := store.BioPage{Handle: strings.ToLower(strings.TrimPrefix(req.Handle, "@")), WorkspaceID: req.WorkspaceID}
+		if !bioHandle.MatchString(p.Handle) {
+			http.Error(w, "handle must be 3 to 30 letters, digits, '.', '-' or '_', starting with a letter or digit", http.StatusBadRequest)
+			return
+		}
+		if p.WorkspaceID == "" {
+			p.OwnerEmail = email
+		} else if !canCreateIn(w, r, st, p.WorkspaceID) {
+			return
+		}
+		if err := bioContent(req, &p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !checkBioLinks(w, r, st, p) {
+			return
+		}
+		err := st.CreateBioPage(r.Context(), p)
+		if err == store.ErrConflict {
+			http.Error(w, "Handle is taken", http.StatusConflict)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to create bio page: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		p, err = st.GetBioPage(r.Context(), p.Handle)
+		if err != nil {
+			logrus.Errorf("Failed to load bio page: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(newBioPageResponse(p, p.Links))
+	}
+}
+
+// ListBioPagesHandler handles GET /bio: the caller's pages, or with
+// ?workspace_id= a workspace's pages. Listings leave out the links.
+func ListBioPagesHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email := r.Header.Get("X-User-Email")
+		if email == "" {
+			http.Error(w, "Sign in to manage bio pages", http.StatusUnauthorized)
+			return
+		}
+		workspaceID := r.URL.Query().Get("workspace_id")
+		if workspaceID != "" {
+			role, err := memberRole(r.Context(), st, workspaceID, caller(r))
+			if err != nil {
+				logrus.Errorf("Failed to load workspace role: %v", err)
+				http.Error(w, "DB error", http.StatusInternalServerError)
+				return
+			}
+			if !role.AtLeast(workspace.Viewer) {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		pages, err := st.ListBioPages(r.Context(), email, workspaceID)
+		if err != nil {
+			logrus.Errorf("Failed to list bio pages: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		resp := make([]bioPageResponse, 0, len(pages))
+		for _, p := range pages {
+			resp = append(resp, newBioPageResponse(p, nil))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// GetBioPageHandler handles GET /bio/{handle}, the public content of a page
+// that the gateway renders at /@{handle}. Links that were deleted, expired or
+// moved off the page's account since it was saved are left out.
+func GetBioPageHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := st.GetBioPage(r.Context(), strings.ToLower(mux.Vars(r)["handle"]))
+		if err == store.ErrNotFound {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to load bio page: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		live := make([]store.BioLink, 0, len(p.Links))
+		for _, bl := range p.Links {
+			l, err := st.GetActiveLink(r.Context(), store.LinkKey{Domain: bl.Domain, Code: bl.ShortCode})
+			if err == store.ErrNotFound {
+				continue
+			} else if err != nil {
+				logrus.Errorf("Failed to load link: %v", err)
+				http.Error(w, "DB error", http.StatusInternalServerError)
+				return
+			}
+			if belongsTo(l, p) {
+				live = append(live, bl)
+			}
+		}
+		resp := newBioPageResponse(p, live)
+		resp.OwnerEmail = ""
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// UpdateBioPageHandler handles PUT /bio/{handle}, replacing the title,
+// description, theme and links. The handle and owner never change.
+func UpdateBioPageHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := bioPageAccess(w, r, st, workspace.Editor)
+		if !ok {
+			return
+		}
+		var req bioPageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := bioContent(req, &p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !checkBioLinks(w, r, st, p) {
+			return
+		}
+		err := st.UpdateBioPage(r.Context(), p)
+		if err == nil {
+			p, err = st.GetBioPage(r.Context(), p.Handle)
+		}
+		if err == store.ErrNotFound {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to update bio page: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newBioPageResponse(p, p.Links))
+	}
+}
+
+// DeleteBioPageHandler handles DELETE /bio/{handle}; the handle becomes free.
+func DeleteBioPageHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := bioPageAccess(w, r, st, workspace.Editor)
+		if !ok {
+			return
+		}
+		if err := st.DeleteBioPage(r.Context(), p.Handle); err != nil && err != store.ErrNotFound {
+			logrus.Errorf("Failed to delete bio page: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,282 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"usethislink/services/internal/shorturl"
+	"usethislink/services/internal/workspace"
+	"usethislink/services/link/internal/cache"
+	"usethislink/services/link/internal/domains"
+	"usethislink/services/link/internal/store"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+type domainRequest struct {
+	Domain      string `json:"domain"`
+	WorkspaceID string `json:"workspace_id,omitempty"`
+}
+
+type domainResponse struct {
+	Domain      string `json:"domain"`
+	WorkspaceID string `json:"workspace_id,omitempty"`
+	Verified    bool   `json:"verified"`
+	VerifiedAt  string `json:"verified_at,omitempty"`
+	ExpiresAt   string `json:"expires_at,omitempty"`
+	TXTName     string `json:"txt_name"`
+	TXTValue    string `json:"txt_value"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// domainClaimTTL reads DOMAIN_CLAIM_TTL (default 3 days): how long a claim
+// can wait for its TXT record before anyone may drop it.
+func domainClaimTTL() time.Duration {
+	if v, err := time.ParseDuration(os.Getenv("DOMAIN_CLAIM_TTL")); err == nil && v > 0 {
+		return v
+	}
+	return 72 * time.Hour
+}
+
+func newDomainResponse(d store.Domain) domainResponse {
+	resp := domainResponse{
+		Domain:      d.Host,
+		WorkspaceID: d.WorkspaceID,
+		Verified:    d.Verified(),
+		TXTName:     domains.RecordName(d.Host),
+		TXTValue:    domains.RecordValue(d.VerificationToken),
+		CreatedAt:   d.CreatedAt.Format(time.RFC3339),
+	}
+	if d.Verified() {
+		resp.VerifiedAt = d.VerifiedAt.Format(time.RFC3339)
+	} else {
+		resp.ExpiresAt = d.CreatedAt.Add(domainClaimTTL()).Format(time.RFC3339)
+	}
+	return resp
+}
+
+// Custom domains belong to signed-in users only; anonymous sessions come and go.
+func domainOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	email := r.Header.Get("X-User-Email")
+	if email == "" {
+		http.Error(w, "Sign in to manage custom domains", http.StatusUnauthorized)
+		return "", false
+	}
+	return email, true
+}
+
+// ownedDomain loads {domain} from the route for a caller who may manage it:
+// their own claim, or a workspace domain verified by anyone for an admin of
+// the workspace. Otherwise it 404s.
+func ownedDomain(w http.ResponseWriter, r *http.Request, st store.LinkStore, email string) (store.Domain, bool) {
+	host := shorturl.Hostname(mux.Vars(r)["domain"])
+	d, err := st.GetDomainClaim(r.Context(), host, email)
+	if err == store.ErrNotFound {
+		d, err = st.GetDomain(r.Context(), host)
+	}
+	if err != nil && err != store.ErrNotFound {
+		logrus.Errorf("Failed to load domain: %v", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return d, false
+	}
+	if err == store.ErrNotFound {
+		http.NotFound(w, r)
+		return d, false
+	}
+	if d.WorkspaceID == "" {
+		if d.OwnerEmail != email {
+			http.NotFound(w, r)
+			return d, false
+		}
+		return d, true
+	}
+	role, err := memberRole(r.Context(), st, d.WorkspaceID, caller(r))
+	if err != nil {
+		logrus.Errorf("Failed to load workspace role: %v", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return d, false
+	}
+	if !role.AtLeast(workspace.Viewer) {
+		http.NotFound(w, r)
+		return d, false
+	}
+	if !role.AtLeast(workspace.Admin) {
+		http.Error(w, "Only workspace admins can manage its domains", http.StatusForbidden)
+		return d, false
+	}
+	return d, true
+}
+
+// CreateDomainHandler records the caller's claim on a domain, for themselves
+// or, as an admin, for a workspace, and returns the TXT record that proves
+// ownership. Anyone may claim a domain nobody has verified yet; the first to
+// publish their record gets it.
+func CreateDomainHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email, ok := domainOwner(w, r)
+		if !ok {
+			return
+		}
+		var req domainRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Domain == "" {
+			logrus.Errorf("Invalid request body: %v", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		host, err := domains.Normalize(req.Domain)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.WorkspaceID != "" {
+			role, err := memberRole(r.Context(), st, req.WorkspaceID, caller(r))
+			if err != nil {
+				logrus.Errorf("Failed to load workspace role: %v", err)
+				http.Error(w, "DB error", http.StatusInternalServerError)
+				return
+			}
+			if !role.AtLeast(workspace.Admin) {
+				http.Error(w, "Only workspace admins can add domains", http.StatusForbidden)
+				return
+			}
+		}
+		token, err := domains.NewToken()
+		if err != nil {
+			logrus.Errorf("Failed to generate verification token: %v", err)
+			http.Error(w, "Failed to register domain", http.StatusInternalServerError)
+			return
+		}
+		err = st.CreateDomain(r.Context(), store.Domain{Host: host, OwnerEmail: email, WorkspaceID: req.WorkspaceID, VerificationToken: token}, time.Now().Add(-domainClaimTTL()))
+		if err == store.ErrConflict {
+			http.Error(w, "Domain is already registered", http.StatusConflict)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to register domain: %v", err)
+			http.Error(w, "Failed to register domain", http.StatusInternalServerError)
+			return
+		}
+		d, err := st.GetDomainClaim(r.Context(), host, email)
+		if err != nil {
+			logrus.Errorf("Failed to load domain: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(newDomainResponse(d))
+	}
+}
+
+// ListDomainsHandler lists the caller's domains, or with ?workspace_id= a
+// workspace's.
+func ListDomainsHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email, ok := domainOwner(w, r)
+		if !ok {
+			return
+		}
+		workspaceID := r.URL.Query().Get("workspace_id")
+		if workspaceID != "" {
+			role, err := memberRole(r.Context(), st, workspaceID, caller(r))
+			if err != nil {
+				logrus.Errorf("Failed to load workspace role: %v", err)
+				http.Error(w, "DB error", http.StatusInternalServerError)
+				return
+			}
+			if !role.AtLeast(workspace.Viewer) {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		list, err := st.ListDomains(r.Context(), email, workspaceID)
+		if err != nil {
+			logrus.Errorf("Failed to list domains: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		resp := make([]domainResponse, 0, len(list))
+		for _, d := range list {
+			resp = append(resp, newDomainResponse(d))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// VerifyDomainHandler checks the domain's TXT record and, once it matches,
+// allows links to be created on the domain.
+func VerifyDomainHandler(st store.LinkStore, v domains.Verifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email, ok := domainOwner(w, r)
+		if !ok {
+			return
+		}
+		d, ok := ownedDomain(w, r, st, email)
+		if !ok {
+			return
+		}
+		if !d.Verified() {
+			if time.Since(d.CreatedAt) > domainClaimTTL() {
+				http.Error(w, "Claim expired, register the domain again", http.StatusGone)
+				return
+			}
+			if err := v.Verify(r.Context(), d.Host, d.VerificationToken); err != nil {
+				if errors.Is(err, domains.ErrNotVerified) {
+					http.Error(w, "TXT record "+domains.RecordName(d.Host)+" not found or does not match", http.StatusConflict)
+					return
+				}
+				logrus.Errorf("Failed to verify domain %s: %v", d.Host, err)
+				http.Error(w, "DNS lookup failed, try again later", http.StatusBadGateway)
+				return
+			}
+			d.VerifiedAt = time.Now().UTC()
+			err := st.MarkDomainVerified(r.Context(), d.Host, d.OwnerEmail, d.VerifiedAt)
+			if err == store.ErrConflict {
+				http.Error(w, "Domain is already registered", http.StatusConflict)
+				return
+			} else if err == store.ErrNotFound {
+				// Dropped as stale while we were checking DNS.
+				http.Error(w, "Claim expired, register the domain again", http.StatusGone)
+				return
+			} else if err != nil {
+				logrus.Errorf("Failed to mark domain verified: %v", err)
+				http.Error(w, "DB error", http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newDomainResponse(d))
+	}
+}
+
+// DeleteDomainHandler removes the caller's claim on a domain and, if it was
+// verified, every link on it.
+func DeleteDomainHandler(st store.LinkStore, c *cache.LinkCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email, ok := domainOwner(w, r)
+		if !ok {
+			return
+		}
+		d, ok := ownedDomain(w, r, st, email)
+		if !ok {
+			return
+		}
+		err := st.DeleteDomain(r.Context(), d.Host, d.OwnerEmail)
+		if err == store.ErrNotFound {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to delete domain: %v", err)
+			http.Error(w, "Failed to delete domain", http.StatusInternalServerError)
+			return
+		}
+		// Dropping the whole cache is simpler than finding every link on the
+		// domain, and domains are rarely deleted.
+		c.Purge()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
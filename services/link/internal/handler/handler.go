@@ -3,28 +3,39 @@ package handler
 import (
 	"bytes"
 	"context"
-	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
-	"net/url"
 	"os"
-	"strings"
+	"path"
+	"time"
 
+	"usethislink/services/internal/shorturl"
+	"usethislink/services/internal/workspace"
+	"usethislink/services/link/internal/cache"
+	"usethislink/services/link/internal/guard"
 	"usethislink/services/link/internal/shortner"
+	"usethislink/services/link/internal/store"
+	"usethislink/services/link/internal/visits"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 )
 
 type shortenRequest struct {
-	URL string `json:"original_url"`
+	URL               string   `json:"original_url"`
+	Domain            string   `json:"domain,omitempty"`
+	WorkspaceID       string   `json:"workspace_id,omitempty"`
+	RedirectCode      int      `json:"redirect_code,omitempty"`
+	DestinationLocked bool     `json:"destination_locked,omitempty"`
+	Tags              []string `json:"tags,omitempty"`
 }
 
 type shortenResponse struct {
 	ShortURL string `json:"short_url"`
 }
 
-func ShortenHandler(db *sql.DB) http.HandlerFunc {
+func ShortenHandler(st store.LinkStore, g *guard.Guard) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req shortenRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
@@ -32,33 +43,44 @@ func ShortenHandler(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
-
-		rawURL := req.URL
-		hasScheme := strings.Contains(rawURL, "://")
-		if !hasScheme {
-			rawURL = "http://" + rawURL
+		if req.RedirectCode != 0 && !shortner.ValidRedirectCode(req.RedirectCode) {
+			http.Error(w, "redirect_code must be one of 301, 302, 307, 308", http.StatusBadRequest)
+			return
 		}
-		parsed, err := url.Parse(rawURL)
-		if err != nil || parsed.Host == "" || !strings.Contains(parsed.Host, ".") {
-			logrus.Errorf("Invalid or incomplete domain: %v", err)
-			http.Error(w, "Invalid or incomplete domain", http.StatusBadRequest)
+
+		rawURL, err := shortner.NormalizeDestination(req.URL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		baseURL := os.Getenv("BASE_URL")
-		if baseURL != "" && (strings.Contains(rawURL, baseURL) || strings.Contains(parsed.Host, strings.TrimPrefix(strings.TrimPrefix(baseURL, "http://"), "https://"))) {
-			logrus.Warnf("Attempt to shorten a URL containing BASE_URL: %s", rawURL)
-			http.Error(w, "You cannot shorten URLs that point to this service.", http.StatusBadRequest)
+		tags, err := shortner.NormalizeTags(req.Tags)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		// TODO: session/user extraction for distributed context
 		sid := r.Header.Get("X-Session-ID")
 		userEmail := r.Header.Get("X-User-Email")
-		shortURL, err := shortner.StoreURL(db, sid, userEmail, rawURL)
+		domain, ok := targetDomain(w, r, st, req.Domain, req.WorkspaceID)
+		if !ok {
+			return
+		}
+		if !canCreateIn(w, r, st, req.WorkspaceID) {
+			return
+		}
+		shortURL, err := shortner.StoreURL(r.Context(), st, sid, userEmail, rawURL, shortner.LinkOptions{
+			Domain:            domain,
+			WorkspaceID:       req.WorkspaceID,
+			RedirectCode:      req.RedirectCode,
+			DestinationLocked: req.DestinationLocked,
+			Tags:              tags,
+		})
 		if err != nil {
 			logrus.Errorf("Failed to generate short URL: %v", err)
 			http.Error(w, "Could not generate short URL", http.StatusInternalServerError)
 			return
 		}
+		g.Add(store.LinkKey{Domain: domain, Code: path.Base(shortURL)})
 		resp := shortenResponse{
 			ShortURL: shortURL,
 		}
@@ -67,18 +89,290 @@ func ShortenHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-func RedirectHandler(db *sql.DB) http.HandlerFunc {
+type editRequest struct {
+	URL               *string   `json:"original_url,omitempty"`
+	RedirectCode      *int      `json:"redirect_code,omitempty"`
+	DestinationLocked *bool     `json:"destination_locked,omitempty"`
+	Tags              *[]string `json:"tags,omitempty"`
+}
+
+type linkResponse struct {
+	ShortCode         string   `json:"short_code"`
+	Domain            string   `json:"domain,omitempty"`
+	ShortURL          string   `json:"short_url"`
+	QRURL             string   `json:"qr_url"`
+	OriginalURL       string   `json:"original_url"`
+	RedirectCode      int      `json:"redirect_code"`
+	DestinationLocked bool     `json:"destination_locked"`
+	WorkspaceID       string   `json:"workspace_id,omitempty"`
+	Tags              []string `json:"tags"`
+}
+
+func newLinkResponse(l store.Link) linkResponse {
+	resp := linkResponse{
+		ShortCode:         l.ShortCode,
+		Domain:            l.Domain,
+		ShortURL:          shorturl.Build(l.Domain, l.ShortCode),
+		QRURL:             shorturl.BuildScan(l.Domain, l.ShortCode),
+		OriginalURL:       l.OriginalURL,
+		RedirectCode:      l.RedirectCode,
+		DestinationLocked: l.DestinationLocked,
+		WorkspaceID:       l.WorkspaceID,
+		Tags:              l.Tags,
+	}
+	if resp.Tags == nil {
+		resp.Tags = []string{}
+	}
+	return resp
+}
+
+// requestLinkKey identifies the link addressed by /links/{shortcode}; links on a
+// custom domain are addressed with ?domain=.
+func requestLinkKey(r *http.Request) store.LinkKey {
+	return store.LinkKey{
+		Domain: shorturl.Hostname(r.URL.Query().Get("domain")),
+		Code:   mux.Vars(r)["shortcode"],
+	}
+}
+
+// EditHandler changes the destination, redirect behaviour or tags of a link. Personal
+// links can be edited by their creator, workspace links by editors.
+// Locking is one-way: once a destination is locked it may have been cached as a
+// permanent redirect, so neither the destination nor the lock can change afterwards.
+func EditHandler(st store.LinkStore, c *cache.LinkCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req editRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logrus.Errorf("Invalid request body: %v", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		link, ok := authorizedLink(w, r, st, workspace.Editor)
+		if !ok {
+			return
+		}
+		if req.URL != nil {
+			if link.DestinationLocked {
+				http.Error(w, "Destination is locked and cannot be edited", http.StatusConflict)
+				return
+			}
+			dest, err := shortner.NormalizeDestination(*req.URL)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			link.OriginalURL = dest
+		}
+		if req.RedirectCode != nil {
+			if !shortner.ValidRedirectCode(*req.RedirectCode) {
+				http.Error(w, "redirect_code must be one of 301, 302, 307, 308", http.StatusBadRequest)
+				return
+			}
+			link.RedirectCode = *req.RedirectCode
+		}
+		if req.DestinationLocked != nil {
+			if link.DestinationLocked && !*req.DestinationLocked {
+				http.Error(w, "Destination is locked and cannot be unlocked", http.StatusConflict)
+				return
+			}
+			link.DestinationLocked = *req.DestinationLocked
+		}
+		if req.Tags != nil {
+			tags, err := shortner.NormalizeTags(*req.Tags)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			link.Tags = tags
+		}
+		if err := st.UpdateLink(r.Context(), link); err != nil {
+			logrus.Errorf("Failed to update link: %v", err)
+			http.Error(w, "Failed to update link", http.StatusInternalServerError)
+			return
+		}
+		// Other replicas hear about it through the url_mappings trigger.
+		c.Invalidate(link.Key().String())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newLinkResponse(link))
+	}
+}
+
+// DeleteHandler removes a link; the same people who may edit it may delete it.
+func DeleteHandler(st store.LinkStore, c *cache.LinkCache) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		shortcode := mux.Vars(r)["shortcode"]
-		var longURL string
-		err := db.QueryRowContext(context.Background(),
-			`SELECT original_url FROM url_mappings WHERE short_url = ?`,
-			shortcode).Scan(&longURL)
+		link, ok := authorizedLink(w, r, st, workspace.Editor)
+		if !ok {
+			return
+		}
+		err := st.DeleteLink(r.Context(), link.Key())
+		if err == store.ErrNotFound {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to delete link: %v", err)
+			http.Error(w, "Failed to delete link", http.StatusInternalServerError)
+			return
+		}
+		c.Invalidate(link.Key().String())
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type moveRequest struct {
+	WorkspaceID *string `json:"workspace_id"`
+}
+
+// MoveHandler hands a link to a workspace, between workspaces, or back to the
+// caller's personal links ("workspace_id": ""). The caller needs editor rights
+// on both sides; personal links can only be moved by a signed-in creator.
+// Links on a workspace's domain can't leave the workspace.
+func MoveHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req moveRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.WorkspaceID == nil {
+			logrus.Errorf("Invalid request body: %v", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		who := caller(r)
+		if who.UserEmail == "" {
+			http.Error(w, "Sign in to move links", http.StatusUnauthorized)
+			return
+		}
+		link, ok := authorizedLink(w, r, st, workspace.Editor)
+		if !ok {
+			return
+		}
+		target := *req.WorkspaceID
+		if link.Domain != "" {
+			d, err := st.GetDomain(r.Context(), link.Domain)
+			if err != nil && err != store.ErrNotFound {
+				logrus.Errorf("Failed to load domain: %v", err)
+				http.Error(w, "DB error", http.StatusInternalServerError)
+				return
+			}
+			if err == nil && d.WorkspaceID != "" && d.WorkspaceID != target {
+				http.Error(w, "Links on a workspace's domain stay in that workspace", http.StatusConflict)
+				return
+			}
+		}
+		if target != "" {
+			role, err := memberRole(r.Context(), st, target, who)
+			if err != nil {
+				logrus.Errorf("Failed to load workspace role: %v", err)
+				http.Error(w, "DB error", http.StatusInternalServerError)
+				return
+			}
+			if !role.AtLeast(workspace.Editor) {
+				http.Error(w, "You cannot add links to that workspace", http.StatusForbidden)
+				return
+			}
+		} else {
+			// Taking a link out of a workspace makes it the caller's own.
+			link.UserEmail = who.UserEmail
+		}
+		link.WorkspaceID = target
+		if err := st.UpdateLink(r.Context(), link); err != nil {
+			logrus.Errorf("Failed to move link: %v", err)
+			http.Error(w, "Failed to move link", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newLinkResponse(link))
+	}
+}
+
+// MetricsHandler exposes the redirect cache counters in Prometheus text format.
+func MetricsHandler(c *cache.LinkCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		st := c.Stats()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprintf(w, "# TYPE link_cache_hits_total counter\nlink_cache_hits_total %d\n", st.Hits)
+		fmt.Fprintf(w, "# TYPE link_cache_negative_hits_total counter\nlink_cache_negative_hits_total %d\n", st.NegativeHits)
+		fmt.Fprintf(w, "# TYPE link_cache_misses_total counter\nlink_cache_misses_total %d\n", st.Misses)
+		fmt.Fprintf(w, "# TYPE link_cache_evictions_total counter\nlink_cache_evictions_total %d\n", st.Evictions)
+		fmt.Fprintf(w, "# TYPE link_cache_invalidations_total counter\nlink_cache_invalidations_total %d\n", st.Invalidations)
+		fmt.Fprintf(w, "# TYPE link_cache_entries gauge\nlink_cache_entries %d\n", st.Size)
+	}
+}
+
+// lookupLink resolves key through the cache, falling back to the store.
+// Unknown and expired codes are cached negatively and reported as store.ErrNotFound.
+func lookupLink(ctx context.Context, st store.LinkStore, c *cache.LinkCache, key store.LinkKey) (cache.Entry, error) {
+	e, gen, ok := c.Get(key.String())
+	if ok {
+		if e.Missing {
+			return e, store.ErrNotFound
+		}
+		return e, nil
+	}
+	l, err := st.GetActiveLink(ctx, key)
+	if err == store.ErrNotFound {
+		c.SetMissing(key.String(), gen)
+		return cache.Entry{}, err
+	} else if err != nil {
+		return cache.Entry{}, err
+	}
+	e = cache.Entry{
+		OriginalURL:       l.OriginalURL,
+		RedirectCode:      l.RedirectCode,
+		DestinationLocked: l.DestinationLocked,
+		ExpiresAt:         l.ExpiresAt,
+	}
+	c.Set(key.String(), gen, e)
+	return e, nil
+}
+
+// RedirectHandler serves /s/{shortcode}. The domain comes from the Host header,
+// which the gateway preserves when proxying custom-domain requests.
+func RedirectHandler(st store.LinkStore, c *cache.LinkCache, g *guard.Guard, v *visits.Counter) http.HandlerFunc {
+	return redirectHandler(st, c, g, v, false)
+}
+
+// QRScanHandler serves /q/{shortcode}, the URL our QR codes encode. Scans are
+// logged as qr_scan and always get an uncached temporary redirect, so a
+// printed code follows later edits of the destination.
+func QRScanHandler(st store.LinkStore, c *cache.LinkCache, g *guard.Guard, v *visits.Counter) http.HandlerFunc {
+	return redirectHandler(st, c, g, v, true)
+}
+
+func redirectHandler(st store.LinkStore, c *cache.LinkCache, g *guard.Guard, v *visits.Counter, scan bool) http.HandlerFunc {
+	event := "redirect"
+	if scan {
+		event = "qr_scan"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := store.LinkKey{Domain: shorturl.DomainForHost(r.Host), Code: mux.Vars(r)["shortcode"]}
+		ip := guard.ClientIP(r)
+		delay, blockedUntil := g.Scans.Check(ip)
+		if !blockedUntil.IsZero() {
+			w.Header().Set("Retry-After", guard.RetryAfter(blockedUntil))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if !g.Known(key) {
+			g.Scans.RecordMiss(ip, key.String())
+			http.NotFound(w, r)
+			return
+		}
+		link, err := lookupLink(r.Context(), st, c, key)
 		if err != nil {
-			logrus.Errorf("Failed to fetch original URL: %v", err)
+			if err == store.ErrNotFound {
+				g.Scans.RecordMiss(ip, key.String())
+			} else {
+				logrus.Errorf("Failed to fetch original URL: %v", err)
+			}
 			http.NotFound(w, r)
 			return
 		}
+		v.Incr(key)
 		// Send analytics event to Analytics service (async)
 		go func() {
 			analyticsURL := os.Getenv("ANALYTICS_SERVICE_URL")
@@ -86,13 +380,14 @@ func RedirectHandler(db *sql.DB) http.HandlerFunc {
 				analyticsURL = "http://analytics:8082"
 			}
 			payload := map[string]interface{}{
-				"short_url":  shortcode,
+				"short_url":  key.Code,
+				"domain":     key.Domain,
 				"session_id": r.Header.Get("X-Session-ID"),
 				"user_email": r.Header.Get("X-User-Email"),
 				"ip_address": r.RemoteAddr,
 				"user_agent": r.UserAgent(),
 				"referrer":   r.Referer(),
-				"event":      "redirect",
+				"event":      event,
 			}
 			b, _ := json.Marshal(payload)
 			req, _ := http.NewRequest("POST", analyticsURL+"/log", bytes.NewBuffer(b))
@@ -102,6 +397,11 @@ func RedirectHandler(db *sql.DB) http.HandlerFunc {
 			req.Header.Set("X-User-Email", r.Header.Get("X-User-Email"))
 			_, _ = http.DefaultClient.Do(req)
 		}()
-		http.Redirect(w, r, longURL, http.StatusFound)
+		code := link.RedirectCode
+		if !shortner.ValidRedirectCode(code) || scan {
+			code = shortner.DefaultRedirectCode
+		}
+		w.Header().Set("Cache-Control", shortner.CacheControl(code, link.DestinationLocked))
+		http.Redirect(w, r, link.OriginalURL, code)
 	}
 }
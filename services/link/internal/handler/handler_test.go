@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"usethislink/services/internal/workspace"
+	"usethislink/services/link/internal/cache"
+	"usethislink/services/link/internal/guard"
+	"usethislink/services/link/internal/store"
+	"usethislink/services/link/internal/visits"
+
+	"github.com/gorilla/mux"
+)
+
+// linkTest serves the link routes from a memory store. Analytics events go to
+// a local server and arrive on events.
+type linkTest struct {
+	t      *testing.T
+	st     *store.MemoryStore
+	router *mux.Router
+	events chan map[string]any
+}
+
+func newLinkTest(t *testing.T) *linkTest {
+	t.Helper()
+	t.Setenv("BASE_URL", "http://ut.link")
+	events := make(chan map[string]any, 10)
+	analytics := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&e); err == nil {
+			events <- e
+		}
+	}))
+	t.Cleanup(analytics.Close)
+	t.Setenv("ANALYTICS_SERVICE_URL", analytics.URL)
+
+	st := store.NewMemory()
+	g, err := guard.New(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cache.New(100, time.Minute, time.Second)
+	v := visits.NewCounter(st)
+	r := mux.NewRouter()
+	r.HandleFunc("/shorten", ShortenHandler(st, g)).Methods("POST")
+	r.HandleFunc("/s/{shortcode}", RedirectHandler(st, c, g, v)).Methods("GET")
+	r.HandleFunc("/q/{shortcode}", QRScanHandler(st, c, g, v)).Methods("GET")
+	r.HandleFunc("/links/{shortcode}", EditHandler(st, c)).Methods("PATCH")
+	r.HandleFunc("/links/{shortcode}", DeleteHandler(st, c)).Methods("DELETE")
+	r.HandleFunc("/links/{shortcode}/move", MoveHandler(st)).Methods("POST")
+	return &linkTest{t: t, st: st, router: r, events: events}
+}
+
+// do sends a request as email ("" for an anonymous session).
+func (lt *linkTest) do(method, target, email, body string) *httptest.ResponseRecorder {
+	lt.t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Host = "ut.link"
+	req.Header.Set("X-Session-ID", "session-"+email)
+	if email != "" {
+		req.Header.Set("X-User-Email", email)
+	}
+	rec := httptest.NewRecorder()
+	lt.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// shorten creates a link and returns its code.
+func (lt *linkTest) shorten(email, body string) string {
+	lt.t.Helper()
+	rec := lt.do("POST", "/shorten", email, body)
+	if rec.Code != http.StatusOK {
+		lt.t.Fatalf("shorten %s: %d %s", body, rec.Code, rec.Body)
+	}
+	var resp shortenResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		lt.t.Fatal(err)
+	}
+	if !strings.HasPrefix(resp.ShortURL, "http://ut.link/") {
+		lt.t.Fatalf("short_url = %q", resp.ShortURL)
+	}
+	return path.Base(resp.ShortURL)
+}
+
+// workspace creates a workspace owned by owner with the given other members.
+func (lt *linkTest) workspace(owner string, members map[string]workspace.Role) string {
+	lt.t.Helper()
+	ctx := context.Background()
+	ws := store.Workspace{ID: "ws1", Name: "Team", CreatedBy: owner, CreatedAt: time.Now()}
+	if err := lt.st.CreateWorkspace(ctx, ws, owner); err != nil {
+		lt.t.Fatal(err)
+	}
+	for email, role := range members {
+		if err := lt.st.SetMember(ctx, store.Member{WorkspaceID: ws.ID, UserEmail: email, Role: role, AddedAt: time.Now()}); err != nil {
+			lt.t.Fatal(err)
+		}
+	}
+	return ws.ID
+}
+
+func TestShortenThenRedirect(t *testing.T) {
+	lt := newLinkTest(t)
+	code := lt.shorten("me@example.com", `{"original_url": "example.com/page", "redirect_code": 308, "tags": ["Launch"]}`)
+
+	rec := lt.do("GET", "/s/"+code, "", "")
+	if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != "http://example.com/page" {
+		t.Fatalf("redirect = %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+	select {
+	case e := <-lt.events:
+		if e["short_url"] != code || e["event"] != "redirect" {
+			t.Errorf("analytics event = %v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("no analytics event for the redirect")
+	}
+
+	// Scans always get a temporary redirect.
+	if rec := lt.do("GET", "/q/"+code, "", ""); rec.Code != http.StatusFound {
+		t.Errorf("scan = %d, want 302", rec.Code)
+	}
+
+	l, err := lt.st.GetLink(context.Background(), store.LinkKey{Code: code})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.UserEmail != "me@example.com" || len(l.Tags) != 1 || l.Tags[0] != "launch" || l.ExpiresAt.IsZero() {
+		t.Errorf("stored link = %+v", l)
+	}
+}
+
+func TestRedirectUnknownCode(t *testing.T) {
+	lt := newLinkTest(t)
+	if rec := lt.do("GET", "/s/nope", "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("redirect = %d, want 404", rec.Code)
+	}
+}
+
+func TestShortenRejectsBadInput(t *testing.T) {
+	lt := newLinkTest(t)
+	for _, body := range []string{
+		`{}`,
+		`not json`,
+		`{"original_url": "nowhere"}`,
+		`{"original_url": "http://ut.link/abc"}`,
+		`{"original_url": "example.com", "redirect_code": 303}`,
+		`{"original_url": "example.com", "tags": ["a,b"]}`,
+		`{"original_url": "example.com", "domain": "go.example.com"}`,
+	} {
+		if rec := lt.do("POST", "/shorten", "me@example.com", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("shorten %s = %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestEditFollowsNewDestination(t *testing.T) {
+	lt := newLinkTest(t)
+	code := lt.shorten("me@example.com", `{"original_url": "https://example.com/old"}`)
+	// Warm the cache so the edit has to invalidate it.
+	lt.do("GET", "/s/"+code, "", "")
+
+	rec := lt.do("PATCH", "/links/"+code, "me@example.com", `{"original_url": "https://example.com/new"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("edit = %d %s", rec.Code, rec.Body)
+	}
+	if loc := lt.do("GET", "/s/"+code, "", "").Header().Get("Location"); loc != "https://example.com/new" {
+		t.Errorf("redirect after edit goes to %q", loc)
+	}
+	if rec := lt.do("PATCH", "/links/"+code, "other@example.com", `{"original_url": "https://evil.example.com"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("edit by someone else = %d, want 404", rec.Code)
+	}
+}
+
+func TestWorkspaceLinkAccess(t *testing.T) {
+	lt := newLinkTest(t)
+	ws := lt.workspace("owner@example.com", map[string]workspace.Role{
+		"editor@example.com": workspace.Editor,
+		"viewer@example.com": workspace.Viewer,
+	})
+	body := `{"original_url": "https://example.com", "workspace_id": "` + ws + `"}`
+	code := lt.shorten("editor@example.com", body)
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		target string
+		email  string
+		body   string
+		want   int
+	}{
+		{"viewer creates", "POST", "/shorten", "viewer@example.com", body, http.StatusForbidden},
+		{"outsider creates", "POST", "/shorten", "outsider@example.com", body, http.StatusForbidden},
+		{"anonymous creates", "POST", "/shorten", "", body, http.StatusForbidden},
+		{"outsider edits", "PATCH", "/links/" + code, "outsider@example.com", `{"redirect_code": 307}`, http.StatusNotFound},
+		{"viewer edits", "PATCH", "/links/" + code, "viewer@example.com", `{"redirect_code": 307}`, http.StatusForbidden},
+		{"outsider deletes", "DELETE", "/links/" + code, "outsider@example.com", "", http.StatusNotFound},
+		{"viewer deletes", "DELETE", "/links/" + code, "viewer@example.com", "", http.StatusForbidden},
+		{"viewer moves it out", "POST", "/links/" + code + "/move", "viewer@example.com", `{"workspace_id": ""}`, http.StatusForbidden},
+		{"editor moves it to a workspace they are not in", "POST", "/links/" + code + "/move", "editor@example.com", `{"workspace_id": "ws2"}`, http.StatusForbidden},
+		{"editor edits", "PATCH", "/links/" + code, "editor@example.com", `{"redirect_code": 307}`, http.StatusOK},
+		{"owner deletes", "DELETE", "/links/" + code, "owner@example.com", "", http.StatusNoContent},
+		{"deleted link", "PATCH", "/links/" + code, "owner@example.com", `{"redirect_code": 307}`, http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if rec := lt.do(tc.method, tc.target, tc.email, tc.body); rec.Code != tc.want {
+				t.Errorf("%s %s = %d %s, want %d", tc.method, tc.target, rec.Code, strings.TrimSpace(rec.Body.String()), tc.want)
+			}
+		})
+	}
+}
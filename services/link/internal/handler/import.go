@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"usethislink/services/link/internal/guard"
+	"usethislink/services/link/internal/importer"
+	"usethislink/services/link/internal/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxImportSize caps uploaded export files.
+const maxImportSize = 10 << 20
+
+// ImportHandler handles POST /import. The body is a Bitly or YOURLS export;
+// ?format= is csv or json (default: from Content-Type), ?on_conflict= is
+// generate or skip, and ?domain= / ?workspace_id= place the links like
+// /shorten does. It answers with the import report.
+func ImportHandler(st store.LinkStore, g *guard.Guard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-User-Email") == "" {
+			http.Error(w, "Sign in to import links", http.StatusUnauthorized)
+			return
+		}
+		q := r.URL.Query()
+		format := q.Get("format")
+		if format == "" {
+			format = importer.CSV
+			if strings.Contains(r.Header.Get("Content-Type"), "json") {
+				format = importer.JSON
+			}
+		}
+		onConflict := q.Get("on_conflict")
+		if onConflict != "" && onConflict != importer.OnConflictGenerate && onConflict != importer.OnConflictSkip {
+			http.Error(w, "on_conflict must be generate or skip", http.StatusBadRequest)
+			return
+		}
+		workspaceID := q.Get("workspace_id")
+		domain, ok := targetDomain(w, r, st, q.Get("domain"), workspaceID)
+		if !ok {
+			return
+		}
+		if !canCreateIn(w, r, st, workspaceID) {
+			return
+		}
+
+		records, err := importer.Parse(http.MaxBytesReader(w, r.Body, maxImportSize), format)
+		if err != nil {
+			http.Error(w, "Could not read export: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		report, created := importer.Run(r.Context(), st, records, importer.Options{
+			Owner:       caller(r),
+			Domain:      domain,
+			WorkspaceID: workspaceID,
+			OnConflict:  onConflict,
+			OnCreate:    func(l store.Link) { g.Add(l.Key()) },
+		})
+		if err := importer.SendTotals(r.Context(), created); err != nil {
+			logrus.Errorf("Failed to send imported click totals: %v", err)
+			report.AnalyticsError = err.Error()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"usethislink/services/internal/shorturl"
+	"usethislink/services/internal/workspace"
+	"usethislink/services/link/internal/qr"
+	"usethislink/services/link/internal/store"
+
+	"github.com/cespare/xxhash"
+	"github.com/sirupsen/logrus"
+)
+
+// Bounds for ?size= and ?margin= on /api/qrcode.
+const (
+	minQRSize   = 64
+	maxQRSize   = 2048
+	maxQRMargin = 16
+)
+
+// qrOptions applies size, level, margin, fg, bg and rounded from the query
+// on top of opts.
+func qrOptions(q url.Values, opts qr.Options) (qr.Options, error) {
+	var err error
+	if s := q.Get("size"); s != "" {
+		if opts.Size, err = strconv.Atoi(s); err != nil || opts.Size < minQRSize || opts.Size > maxQRSize {
+			return opts, fmt.Errorf("size must be between %d and %d", minQRSize, maxQRSize)
+		}
+	}
+	if s := q.Get("margin"); s != "" {
+		if opts.Margin, err = strconv.Atoi(s); err != nil || opts.Margin < 0 || opts.Margin > maxQRMargin {
+			return opts, fmt.Errorf("margin must be between 0 and %d", maxQRMargin)
+		}
+	}
+	if s := q.Get("level"); s != "" {
+		if opts.Level, err = qr.ParseLevel(s); err != nil {
+			return opts, err
+		}
+	}
+	if s := q.Get("fg"); s != "" {
+		if opts.Foreground, err = qr.ParseColour(s); err != nil {
+			return opts, err
+		}
+	}
+	if s := q.Get("bg"); s != "" {
+		if opts.Background, err = qr.ParseColour(s); err != nil {
+			return opts, err
+		}
+	}
+	if s := q.Get("rounded"); s != "" {
+		opts.Rounded = s == "1" || s == "true"
+	}
+	if opts.Foreground == opts.Background {
+		return opts, errors.New("fg and bg must differ")
+	}
+	return opts, nil
+}
+
+// styledOptions starts from ?preset= (if the caller may use it) and applies
+// the other query options on top.
+func styledOptions(w http.ResponseWriter, r *http.Request, st store.LinkStore, q url.Values) (qr.Options, bool) {
+	opts := qr.DefaultOptions()
+	if id := q.Get("preset"); id != "" {
+		p, ok := usablePreset(w, r, st, id, workspace.Viewer)
+		if !ok {
+			return opts, false
+		}
+		var err error
+		if opts, err = presetOptions(p); err != nil {
+			logrus.Errorf("Invalid QR preset %s: %v", p.ID, err)
+			http.Error(w, "Invalid preset", http.StatusInternalServerError)
+			return opts, false
+		}
+	}
+	opts, err := qrOptions(q, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return opts, false
+	}
+	return opts, true
+}
+
+// scanURL checks raw is a short link on BASE_URL or a verified custom domain
+// (http(s), our host, /{code} or /q/{code}) and returns the /q/ variant to
+// encode, so scans are counted apart from clicks. It returns "" otherwise.
+func scanURL(r *http.Request, st store.LinkStore, raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return "", nil
+	}
+	code := strings.TrimPrefix(strings.TrimPrefix(u.EscapedPath(), "/"), shorturl.ScanPrefix)
+	if code == "" || strings.Contains(code, "/") {
+		return "", nil
+	}
+	host := shorturl.Hostname(u.Host)
+	if host != shorturl.PrimaryHost() {
+		d, err := st.GetDomain(r.Context(), host)
+		if err == store.ErrNotFound || (err == nil && !d.Verified()) {
+			return "", nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	u.Path, u.RawPath = "/"+shorturl.ScanPrefix+code, ""
+	return u.String(), nil
+}
+
+// QRCodeHandler serves /api/qrcode?data=<short link>&format=png|svg, encoding
+// the link's /q/ scan URL, with
+// optional size (px), level (L/M/Q/H), margin (modules), fg and bg (hex) and
+// rounded. ?preset= starts from a saved preset the caller may use. Output
+// depends only on the query, so it is cached publicly for a day (privately
+// with a preset, which can change) and revalidated by ETag.
+func QRCodeHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		data, err := scanURL(r, st, q.Get("data"))
+		if err != nil {
+			logrus.Errorf("Failed to load domain: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if data == "" {
+			http.Error(w, "data must be a short link on one of our domains", http.StatusBadRequest)
+			return
+		}
+		format := strings.ToLower(q.Get("format"))
+		if format == "" {
+			format = qr.PNG
+		}
+		contentType := map[string]string{qr.PNG: "image/png", qr.SVG: "image/svg+xml"}[format]
+		if contentType == "" {
+			http.Error(w, "format must be png or svg", http.StatusBadRequest)
+			return
+		}
+		opts, ok := styledOptions(w, r, st, q)
+		if !ok {
+			return
+		}
+		cacheControl := "public, max-age=86400"
+		if q.Get("preset") != "" {
+			cacheControl = "private, max-age=300"
+		}
+
+		var buf bytes.Buffer
+		if err := qr.Write(&buf, data, format, opts); err != nil {
+			http.Error(w, "Could not render QR code: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		etag := `"` + strconv.FormatUint(xxhash.Sum64(buf.Bytes()), 16) + `"`
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Vary", "Accept-Encoding")
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.Write(buf.Bytes())
+	}
+}
+
+// Bundle sizes: PNG in pixels, SVG default size, PDF page edge in points (4in).
+const (
+	bundlePNGSize = 2048
+	bundleSVGSize = 1024
+	bundlePDFSize = 288
+)
+
+// QRBundleHandler serves GET /links/{shortcode}/qr-bundle: a zip with a
+// high-resolution PNG, an SVG and a PDF of the link's QR code (its /q/ scan
+// URL), styled by ?preset= and the same query options as /api/qrcode.
+func QRBundleHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		link, ok := authorizedLink(w, r, st, workspace.Viewer)
+		if !ok {
+			return
+		}
+		q := r.URL.Query()
+		q.Del("size")
+		opts, ok := styledOptions(w, r, st, q)
+		if !ok {
+			return
+		}
+
+		data := shorturl.BuildScan(link.Domain, link.ShortCode)
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, f := range []struct {
+			format string
+			size   int
+		}{{qr.PNG, bundlePNGSize}, {qr.SVG, bundleSVGSize}, {qr.PDF, bundlePDFSize}} {
+			opts.Size = f.size
+			fw, err := zw.Create(link.ShortCode + "." + f.format)
+			if err == nil {
+				err = qr.Write(fw, data, f.format, opts)
+			}
+			if err != nil {
+				logrus.Errorf("Failed to render QR bundle: %v", err)
+				http.Error(w, "Could not render QR code", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := zw.Close(); err != nil {
+			logrus.Errorf("Failed to render QR bundle: %v", err)
+			http.Error(w, "Could not render QR code", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+link.ShortCode+`-qr.zip"`)
+		w.Header().Set("Cache-Control", "private, no-cache")
+		w.Write(buf.Bytes())
+	}
+}
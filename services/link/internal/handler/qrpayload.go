@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"usethislink/services/internal/shorturl"
+	"usethislink/services/link/internal/guard"
+	"usethislink/services/link/internal/qr"
+	"usethislink/services/link/internal/shortner"
+	"usethislink/services/link/internal/store"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+type qrPayloadRequest struct {
+	Type  string    `json:"type"` // wifi, vcard, event or geo
+	WiFi  *qr.WiFi  `json:"wifi,omitempty"`
+	VCard *qr.VCard `json:"vcard,omitempty"`
+	Event *qr.Event `json:"event,omitempty"`
+	Geo   *qr.Geo   `json:"geo,omitempty"`
+	// Host stores a vCard behind a short link and encodes that instead, so
+	// scans are counted; Domain and WorkspaceID place the link as on /shorten.
+	Host        bool   `json:"host,omitempty"`
+	Domain      string `json:"domain,omitempty"`
+	WorkspaceID string `json:"workspace_id,omitempty"`
+}
+
+type hostedVCardResponse struct {
+	ShortURL string `json:"short_url"`
+	QRURL    string `json:"qr_url"`
+	VCardURL string `json:"vcard_url"`
+}
+
+func (req qrPayloadRequest) payload() (qr.Payload, error) {
+	var p qr.Payload
+	switch req.Type {
+	case "wifi":
+		if req.WiFi != nil {
+			p = req.WiFi
+		}
+	case "vcard":
+		if req.VCard != nil {
+			p = req.VCard
+		}
+	case "event":
+		if req.Event != nil {
+			p = req.Event
+		}
+	case "geo":
+		if req.Geo != nil {
+			p = req.Geo
+		}
+	default:
+		return nil, errors.New("type must be wifi, vcard, event or geo")
+	}
+	if p == nil {
+		return nil, errors.New(req.Type + " fields are missing")
+	}
+	return p, nil
+}
+
+var payloadContentTypes = map[string]string{
+	qr.PNG: "image/png",
+	qr.SVG: "image/svg+xml",
+	qr.PDF: "application/pdf",
+	"text": "text/plain; charset=utf-8",
+}
+
+// maxPayloadBody caps POST /qr/payload bodies.
+const maxPayloadBody = 64 << 10
+
+// QRPayloadHandler serves POST /qr/payload: it validates a typed payload
+// (Wi-Fi, vCard, calendar event or location) and answers with its QR code in
+// ?format=png|svg|pdf, styled like /api/qrcode, or with ?format=text the
+// encoded payload itself. With "host": true a signed-in caller's vCard is
+// stored instead and a short link to it created, so scans are counted; the
+// response then gives the link and its qr_url to render.
+func QRPayloadHandler(st store.LinkStore, g *guard.Guard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req qrPayloadRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPayloadBody)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		p, err := req.payload()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := p.Encode()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Host {
+			if req.Type != "vcard" {
+				http.Error(w, "Only vCards can be hosted", http.StatusBadRequest)
+				return
+			}
+			hostVCard(w, r, st, g, req, data)
+			return
+		}
+
+		q := r.URL.Query()
+		format := strings.ToLower(q.Get("format"))
+		if format == "" {
+			format = qr.PNG
+		}
+		contentType := payloadContentTypes[format]
+		if contentType == "" {
+			http.Error(w, "format must be png, svg, pdf or text", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Cache-Control", "private, no-store")
+		if format == "text" {
+			w.Header().Set("Content-Type", contentType)
+			w.Write([]byte(data))
+			return
+		}
+		opts, ok := styledOptions(w, r, st, q)
+		if !ok {
+			return
+		}
+		var buf bytes.Buffer
+		if err := qr.Write(&buf, data, format, opts); err != nil {
+			http.Error(w, "Could not render QR code: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(buf.Bytes())
+	}
+}
+
+// hostVCard stores the encoded card and a never-expiring short link to its
+// /vcards/{id}.vcf URL, placed on req.Domain and req.WorkspaceID.
+func hostVCard(w http.ResponseWriter, r *http.Request, st store.LinkStore, g *guard.Guard, req qrPayloadRequest, data string) {
+	userEmail := r.Header.Get("X-User-Email")
+	if userEmail == "" {
+		http.Error(w, "Sign in to host vCards", http.StatusUnauthorized)
+		return
+	}
+	domain, ok := targetDomain(w, r, st, req.Domain, req.WorkspaceID)
+	if !ok {
+		return
+	}
+	if !canCreateIn(w, r, st, req.WorkspaceID) {
+		return
+	}
+	baseURL := strings.TrimRight(os.Getenv("BASE_URL"), "/")
+	if baseURL == "" {
+		logrus.Errorf("BASE_URL not set")
+		http.Error(w, "Could not host vCard", http.StatusInternalServerError)
+		return
+	}
+	id, err := newID()
+	if err != nil {
+		logrus.Errorf("Failed to generate vCard ID: %v", err)
+		http.Error(w, "Could not host vCard", http.StatusInternalServerError)
+		return
+	}
+	owner := caller(r)
+	err = st.CreateVCard(r.Context(), store.HostedVCard{
+		ID:          id,
+		OwnerEmail:  userEmail,
+		SessionID:   owner.SessionID,
+		WorkspaceID: req.WorkspaceID,
+		Data:        data,
+	})
+	if err != nil {
+		logrus.Errorf("Failed to store vCard: %v", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	vcardURL := baseURL + "/vcards/" + id + ".vcf"
+	code, err := shortner.StoreGenerated(r.Context(), st, store.Link{
+		Domain:       domain,
+		OriginalURL:  vcardURL,
+		SessionID:    owner.SessionID,
+		UserEmail:    userEmail,
+		IsLoggedIn:   true,
+		RedirectCode: shortner.DefaultRedirectCode,
+		WorkspaceID:  req.WorkspaceID,
+	})
+	if err != nil {
+		logrus.Errorf("Failed to create vCard link: %v", err)
+		http.Error(w, "Could not generate short URL", http.StatusInternalServerError)
+		return
+	}
+	g.Add(store.LinkKey{Domain: domain, Code: code})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hostedVCardResponse{
+		ShortURL: shorturl.Build(domain, code),
+		QRURL:    shorturl.BuildScan(domain, code),
+		VCardURL: vcardURL,
+	})
+}
+
+// VCardFileHandler serves GET /vcards/{id}.vcf, the target of hosted vCard
+// links. Cards never change, so they are cached publicly.
+func VCardFileHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSuffix(mux.Vars(r)["id"], ".vcf")
+		v, err := st.GetVCard(r.Context(), id)
+		if err == store.ErrNotFound {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to load vCard: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/vcard; charset=utf-8")
+		w.Header().Set("Content-Disposition", `inline; filename="contact.vcf"`)
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.Write([]byte(v.Data))
+	}
+}
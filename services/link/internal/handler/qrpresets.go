@@ -0,0 +1,267 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"usethislink/services/internal/workspace"
+	"usethislink/services/link/internal/qr"
+	"usethislink/services/link/internal/store"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+type qrPresetRequest struct {
+	Name        string `json:"name"`
+	WorkspaceID string `json:"workspace_id"`
+	Foreground  string `json:"fg"`
+	Background  string `json:"bg"`
+	Level       string `json:"level"`
+	Margin      *int   `json:"margin"`
+	Rounded     bool   `json:"rounded"`
+	Logo        string `json:"logo"` // base64 PNG or JPEG
+}
+
+type qrPresetResponse struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	WorkspaceID string `json:"workspace_id,omitempty"`
+	Foreground  string `json:"fg"`
+	Background  string `json:"bg"`
+	Level       string `json:"level"`
+	Margin      int    `json:"margin"`
+	Rounded     bool   `json:"rounded"`
+	HasLogo     bool   `json:"has_logo"`
+	CreatedAt   string `json:"created_at"`
+}
+
+func newQRPresetResponse(p store.QRPreset) qrPresetResponse {
+	return qrPresetResponse{
+		ID:          p.ID,
+		Name:        p.Name,
+		WorkspaceID: p.WorkspaceID,
+		Foreground:  p.Foreground,
+		Background:  p.Background,
+		Level:       p.Level,
+		Margin:      p.Margin,
+		Rounded:     p.Rounded,
+		HasLogo:     len(p.Logo) > 0,
+		CreatedAt:   p.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// presetOptions turns a saved preset into rendering options.
+func presetOptions(p store.QRPreset) (qr.Options, error) {
+	opts := qr.DefaultOptions()
+	var err error
+	if opts.Foreground, err = qr.ParseColour(p.Foreground); err != nil {
+		return opts, err
+	}
+	if opts.Background, err = qr.ParseColour(p.Background); err != nil {
+		return opts, err
+	}
+	if opts.Level, err = qr.ParseLevel(p.Level); err != nil {
+		return opts, err
+	}
+	opts.Margin = p.Margin
+	opts.Rounded = p.Rounded
+	if len(p.Logo) > 0 {
+		if opts.Logo, err = qr.DecodeLogo(p.Logo); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// usablePreset loads a preset the caller may apply: their own, or one of a
+// workspace they belong to. Others are reported as unknown.
+func usablePreset(w http.ResponseWriter, r *http.Request, st store.LinkStore, id string, min workspace.Role) (store.QRPreset, bool) {
+	p, err := st.GetQRPreset(r.Context(), id)
+	if err != nil && err != store.ErrNotFound {
+		logrus.Errorf("Failed to load QR preset: %v", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return p, false
+	}
+	var role workspace.Role
+	if err == nil {
+		if p.WorkspaceID == "" {
+			if email := r.Header.Get("X-User-Email"); email != "" && email == p.OwnerEmail {
+				role = workspace.Owner
+			}
+		} else if role, err = memberRole(r.Context(), st, p.WorkspaceID, caller(r)); err != nil {
+			logrus.Errorf("Failed to load workspace role: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return p, false
+		}
+	}
+	if !role.AtLeast(workspace.Viewer) {
+		http.Error(w, "Unknown preset", http.StatusNotFound)
+		return p, false
+	}
+	if !role.AtLeast(min) {
+		http.Error(w, "Your workspace role does not allow this", http.StatusForbidden)
+		return p, false
+	}
+	return p, true
+}
+
+// CreateQRPresetHandler saves a QR style for the caller, or with workspace_id
+// for a workspace they can edit. A logo forces level H.
+func CreateQRPresetHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email := r.Header.Get("X-User-Email")
+		if email == "" {
+			http.Error(w, "Sign in to save QR presets", http.StatusUnauthorized)
+			return
+		}
+		var req qrPresetRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*qr.MaxLogoBytes)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || len(req.Name) > 100 {
+			http.Error(w, "name must be 1 to 100 characters", http.StatusBadRequest)
+			return
+		}
+		if !canCreateIn(w, r, st, req.WorkspaceID) {
+			return
+		}
+
+		defaults := qr.DefaultOptions()
+		p := store.QRPreset{
+			Name:        req.Name,
+			WorkspaceID: req.WorkspaceID,
+			Foreground:  qr.Hex(defaults.Foreground),
+			Background:  qr.Hex(defaults.Background),
+			Level:       strings.ToUpper(req.Level),
+			Margin:      defaults.Margin,
+			Rounded:     req.Rounded,
+		}
+		if req.WorkspaceID == "" {
+			p.OwnerEmail = email
+		}
+		for _, c := range []struct {
+			in  string
+			out *string
+		}{{req.Foreground, &p.Foreground}, {req.Background, &p.Background}} {
+			if c.in == "" {
+				continue
+			}
+			colour, err := qr.ParseColour(c.in)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			*c.out = qr.Hex(colour)
+		}
+		if p.Foreground == p.Background {
+			http.Error(w, "fg and bg must differ", http.StatusBadRequest)
+			return
+		}
+		if p.Level == "" {
+			p.Level = "M"
+		}
+		if _, err := qr.ParseLevel(p.Level); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Margin != nil {
+			if *req.Margin < 0 || *req.Margin > maxQRMargin {
+				http.Error(w, "margin out of range", http.StatusBadRequest)
+				return
+			}
+			p.Margin = *req.Margin
+		}
+		if req.Logo != "" {
+			logo, err := base64.StdEncoding.DecodeString(req.Logo)
+			if err == nil {
+				_, err = qr.DecodeLogo(logo)
+			}
+			if err != nil {
+				http.Error(w, qr.ErrInvalidLogo.Error(), http.StatusBadRequest)
+				return
+			}
+			p.Logo = logo
+			p.Level = "H"
+		}
+
+		id, err := newID()
+		if err != nil {
+			logrus.Errorf("Failed to generate preset ID: %v", err)
+			http.Error(w, "Could not create preset", http.StatusInternalServerError)
+			return
+		}
+		p.ID = id
+		if err := st.CreateQRPreset(r.Context(), p); err == store.ErrConflict {
+			http.Error(w, "A preset with this name already exists", http.StatusConflict)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to create QR preset: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		p.CreatedAt = time.Now().UTC()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(newQRPresetResponse(p))
+	}
+}
+
+// ListQRPresetsHandler lists the caller's presets, or with ?workspace_id= a
+// workspace's.
+func ListQRPresetsHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email := r.Header.Get("X-User-Email")
+		if email == "" {
+			http.Error(w, "Sign in to use QR presets", http.StatusUnauthorized)
+			return
+		}
+		workspaceID := r.URL.Query().Get("workspace_id")
+		if workspaceID != "" {
+			role, err := memberRole(r.Context(), st, workspaceID, caller(r))
+			if err != nil {
+				logrus.Errorf("Failed to load workspace role: %v", err)
+				http.Error(w, "DB error", http.StatusInternalServerError)
+				return
+			}
+			if !role.AtLeast(workspace.Viewer) {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		presets, err := st.ListQRPresets(r.Context(), email, workspaceID)
+		if err != nil {
+			logrus.Errorf("Failed to list QR presets: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		resp := make([]qrPresetResponse, 0, len(presets))
+		for _, p := range presets {
+			resp = append(resp, newQRPresetResponse(p))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// DeleteQRPresetHandler removes a personal preset, or a workspace preset for
+// editors and above.
+func DeleteQRPresetHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := usablePreset(w, r, st, mux.Vars(r)["preset"], workspace.Editor)
+		if !ok {
+			return
+		}
+		if err := st.DeleteQRPreset(r.Context(), p.ID); err != nil && err != store.ErrNotFound {
+			logrus.Errorf("Failed to delete QR preset: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,494 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"usethislink/services/internal/workspace"
+	"usethislink/services/link/internal/store"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+type workspaceResponse struct {
+	ID          string               `json:"id"`
+	Name        string               `json:"name"`
+	Role        workspace.Role       `json:"role"`
+	CreatedBy   string               `json:"created_by"`
+	CreatedAt   string               `json:"created_at"`
+	Members     []memberResponse     `json:"members,omitempty"`
+	Invitations []invitationResponse `json:"invitations,omitempty"`
+}
+
+type memberResponse struct {
+	Email   string         `json:"email"`
+	Role    workspace.Role `json:"role"`
+	AddedAt string         `json:"added_at"`
+}
+
+type invitationResponse struct {
+	ID          string         `json:"id"`
+	WorkspaceID string         `json:"workspace_id"`
+	Email       string         `json:"email"`
+	Role        workspace.Role `json:"role"`
+	InvitedBy   string         `json:"invited_by"`
+	ExpiresAt   string         `json:"expires_at"`
+}
+
+func newWorkspaceResponse(ws store.Workspace, role workspace.Role) workspaceResponse {
+	return workspaceResponse{
+		ID:        ws.ID,
+		Name:      ws.Name,
+		Role:      role,
+		CreatedBy: ws.CreatedBy,
+		CreatedAt: ws.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func newInvitationResponse(inv store.Invitation) invitationResponse {
+	return invitationResponse{
+		ID:          inv.ID,
+		WorkspaceID: inv.WorkspaceID,
+		Email:       inv.Email,
+		Role:        inv.Role,
+		InvitedBy:   inv.InvitedBy,
+		ExpiresAt:   inv.ExpiresAt.Format(time.RFC3339),
+	}
+}
+
+func newID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// inviteTTL reads WORKSPACE_INVITE_TTL (default 7 days).
+func inviteTTL() time.Duration {
+	if v, err := time.ParseDuration(os.Getenv("WORKSPACE_INVITE_TTL")); err == nil && v > 0 {
+		return v
+	}
+	return 7 * 24 * time.Hour
+}
+
+// signedIn returns the caller's email, answering 401 for anonymous sessions.
+func signedIn(w http.ResponseWriter, r *http.Request) (string, bool) {
+	email := r.Header.Get("X-User-Email")
+	if email == "" {
+		http.Error(w, "Sign in to use workspaces", http.StatusUnauthorized)
+		return "", false
+	}
+	return email, true
+}
+
+// workspaceAccess loads {workspace} and checks the caller holds at least min
+// in it: 404 for non-members, 403 for members with a lower role.
+func workspaceAccess(w http.ResponseWriter, r *http.Request, st store.LinkStore, min workspace.Role) (store.Workspace, workspace.Role, bool) {
+	email, ok := signedIn(w, r)
+	if !ok {
+		return store.Workspace{}, "", false
+	}
+	ws, err := st.GetWorkspace(r.Context(), mux.Vars(r)["workspace"])
+	if err == store.ErrNotFound {
+		http.NotFound(w, r)
+		return ws, "", false
+	} else if err != nil {
+		logrus.Errorf("Failed to load workspace: %v", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return ws, "", false
+	}
+	role, err := st.MemberRole(r.Context(), ws.ID, email)
+	if err == store.ErrNotFound {
+		http.NotFound(w, r)
+		return ws, "", false
+	} else if err != nil {
+		logrus.Errorf("Failed to load workspace role: %v", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return ws, "", false
+	}
+	if !role.AtLeast(min) {
+		http.Error(w, "Your workspace role does not allow this", http.StatusForbidden)
+		return ws, role, false
+	}
+	return ws, role, true
+}
+
+// CreateWorkspaceHandler creates a workspace with the caller as its owner.
+func CreateWorkspaceHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email, ok := signedIn(w, r)
+		if !ok {
+			return
+		}
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+			logrus.Errorf("Invalid request body: %v", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		id, err := newID()
+		if err != nil {
+			logrus.Errorf("Failed to generate workspace ID: %v", err)
+			http.Error(w, "Failed to create workspace", http.StatusInternalServerError)
+			return
+		}
+		ws := store.Workspace{ID: id, Name: strings.TrimSpace(req.Name), CreatedBy: email}
+		if err := st.CreateWorkspace(r.Context(), ws, email); err != nil {
+			logrus.Errorf("Failed to create workspace: %v", err)
+			http.Error(w, "Failed to create workspace", http.StatusInternalServerError)
+			return
+		}
+		ws.CreatedAt = time.Now().UTC()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(newWorkspaceResponse(ws, workspace.Owner))
+	}
+}
+
+// ListWorkspacesHandler lists the caller's workspaces with their role in each.
+func ListWorkspacesHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email, ok := signedIn(w, r)
+		if !ok {
+			return
+		}
+		list, err := st.ListWorkspaces(r.Context(), email)
+		if err != nil {
+			logrus.Errorf("Failed to list workspaces: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		resp := make([]workspaceResponse, 0, len(list))
+		for _, m := range list {
+			resp = append(resp, newWorkspaceResponse(m.Workspace, m.Role))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// GetWorkspaceHandler shows a workspace and its members to any member.
+// Admins also see pending invitations.
+func GetWorkspaceHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ws, role, ok := workspaceAccess(w, r, st, workspace.Viewer)
+		if !ok {
+			return
+		}
+		resp := newWorkspaceResponse(ws, role)
+		members, err := st.ListMembers(r.Context(), ws.ID)
+		if err != nil {
+			logrus.Errorf("Failed to list members: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		for _, m := range members {
+			resp.Members = append(resp.Members, memberResponse{Email: m.UserEmail, Role: m.Role, AddedAt: m.AddedAt.Format(time.RFC3339)})
+		}
+		if role.AtLeast(workspace.Admin) {
+			invs, err := st.PendingInvitations(r.Context(), ws.ID, "")
+			if err != nil {
+				logrus.Errorf("Failed to list invitations: %v", err)
+				http.Error(w, "DB error", http.StatusInternalServerError)
+				return
+			}
+			for _, inv := range invs {
+				resp.Invitations = append(resp.Invitations, newInvitationResponse(inv))
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// DeleteWorkspaceHandler deletes an empty workspace. Owners must move or
+// delete its links first so nothing is lost by accident.
+func DeleteWorkspaceHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ws, _, ok := workspaceAccess(w, r, st, workspace.Owner)
+		if !ok {
+			return
+		}
+		err := st.DeleteWorkspace(r.Context(), ws.ID)
+		if err == store.ErrConflict {
+			http.Error(w, "Move or delete the workspace's links first", http.StatusConflict)
+			return
+		} else if err == store.ErrNotFound {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to delete workspace: %v", err)
+			http.Error(w, "Failed to delete workspace", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// InviteHandler invites an email address to the workspace. Admins can invite
+// up to admin; only owners can invite owners. The invitee accepts by signing
+// in with that address.
+func InviteHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ws, role, ok := workspaceAccess(w, r, st, workspace.Admin)
+		if !ok {
+			return
+		}
+		var req struct {
+			Email string         `json:"email"`
+			Role  workspace.Role `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !strings.Contains(req.Email, "@") || !req.Role.Valid() {
+			logrus.Errorf("Invalid request body: %v", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Role == workspace.Owner && role != workspace.Owner {
+			http.Error(w, "Only owners can invite owners", http.StatusForbidden)
+			return
+		}
+		id, err := newID()
+		if err != nil {
+			logrus.Errorf("Failed to generate invitation ID: %v", err)
+			http.Error(w, "Failed to create invitation", http.StatusInternalServerError)
+			return
+		}
+		inv := store.Invitation{
+			ID:          id,
+			WorkspaceID: ws.ID,
+			Email:       strings.ToLower(strings.TrimSpace(req.Email)),
+			Role:        req.Role,
+			InvitedBy:   r.Header.Get("X-User-Email"),
+			ExpiresAt:   time.Now().Add(inviteTTL()).UTC(),
+		}
+		if err := st.CreateInvitation(r.Context(), inv); err != nil {
+			logrus.Errorf("Failed to create invitation: %v", err)
+			http.Error(w, "Failed to create invitation", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(newInvitationResponse(inv))
+	}
+}
+
+// RevokeInvitationHandler withdraws a pending invitation.
+func RevokeInvitationHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ws, _, ok := workspaceAccess(w, r, st, workspace.Admin)
+		if !ok {
+			return
+		}
+		err := st.DeleteInvitation(r.Context(), ws.ID, mux.Vars(r)["invitation"])
+		if err == store.ErrNotFound {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to revoke invitation: %v", err)
+			http.Error(w, "Failed to revoke invitation", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// MyInvitationsHandler lists pending invitations addressed to the caller.
+func MyInvitationsHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email, ok := signedIn(w, r)
+		if !ok {
+			return
+		}
+		invs, err := st.PendingInvitations(r.Context(), "", strings.ToLower(email))
+		if err != nil {
+			logrus.Errorf("Failed to list invitations: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		resp := make([]invitationResponse, 0, len(invs))
+		for _, inv := range invs {
+			resp = append(resp, newInvitationResponse(inv))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// AcceptInvitationHandler joins the workspace if the invitation was sent to
+// the caller's email.
+func AcceptInvitationHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email, ok := signedIn(w, r)
+		if !ok {
+			return
+		}
+		inv, err := st.GetInvitation(r.Context(), mux.Vars(r)["invitation"])
+		if err == store.ErrNotFound || (err == nil && !strings.EqualFold(inv.Email, email)) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to load invitation: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if !inv.AcceptedAt.IsZero() {
+			http.Error(w, "Invitation was already accepted", http.StatusConflict)
+			return
+		}
+		if time.Now().After(inv.ExpiresAt) {
+			http.Error(w, "Invitation has expired", http.StatusGone)
+			return
+		}
+		// Membership is keyed by the signed-in address as the gateway sends it.
+		inv.Email = email
+		err = st.AcceptInvitation(r.Context(), inv, time.Now())
+		if err == store.ErrNotFound {
+			http.Error(w, "Invitation was already accepted", http.StatusConflict)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to accept invitation: %v", err)
+			http.Error(w, "Failed to accept invitation", http.StatusInternalServerError)
+			return
+		}
+		ws, err := st.GetWorkspace(r.Context(), inv.WorkspaceID)
+		if err != nil {
+			logrus.Errorf("Failed to load workspace: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		role, err := st.MemberRole(r.Context(), ws.ID, email)
+		if err != nil {
+			logrus.Errorf("Failed to load workspace role: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newWorkspaceResponse(ws, role))
+	}
+}
+
+// lastOwner reports whether email is the workspace's only owner.
+func lastOwner(r *http.Request, st store.LinkStore, workspaceID, email string) (bool, error) {
+	members, err := st.ListMembers(r.Context(), workspaceID)
+	if err != nil {
+		return false, err
+	}
+	owners := 0
+	isOwner := false
+	for _, m := range members {
+		if m.Role == workspace.Owner {
+			owners++
+			isOwner = isOwner || m.UserEmail == email
+		}
+	}
+	return isOwner && owners == 1, nil
+}
+
+// UpdateMemberHandler changes a member's role. Admins manage roles below
+// owner; only owners can promote to or demote from owner, and the last owner
+// cannot be demoted.
+func UpdateMemberHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ws, role, ok := workspaceAccess(w, r, st, workspace.Admin)
+		if !ok {
+			return
+		}
+		var req struct {
+			Role workspace.Role `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.Role.Valid() {
+			logrus.Errorf("Invalid request body: %v", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		email := mux.Vars(r)["email"]
+		current, err := st.MemberRole(r.Context(), ws.ID, email)
+		if err == store.ErrNotFound {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to load workspace role: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if (current == workspace.Owner || req.Role == workspace.Owner) && role != workspace.Owner {
+			http.Error(w, "Only owners can change owners", http.StatusForbidden)
+			return
+		}
+		if current == workspace.Owner && req.Role != workspace.Owner {
+			last, err := lastOwner(r, st, ws.ID, email)
+			if err != nil {
+				logrus.Errorf("Failed to list members: %v", err)
+				http.Error(w, "DB error", http.StatusInternalServerError)
+				return
+			}
+			if last {
+				http.Error(w, "A workspace needs at least one owner", http.StatusConflict)
+				return
+			}
+		}
+		if err := st.SetMember(r.Context(), store.Member{WorkspaceID: ws.ID, UserEmail: email, Role: req.Role}); err != nil {
+			logrus.Errorf("Failed to update member: %v", err)
+			http.Error(w, "Failed to update member", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(memberResponse{Email: email, Role: req.Role})
+	}
+}
+
+// RemoveMemberHandler removes a member. Anyone may leave; admins may remove
+// members below owner. The last owner can neither leave nor be removed.
+func RemoveMemberHandler(st store.LinkStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email := mux.Vars(r)["email"]
+		min := workspace.Admin
+		if email == r.Header.Get("X-User-Email") {
+			min = workspace.Viewer
+		}
+		ws, role, ok := workspaceAccess(w, r, st, min)
+		if !ok {
+			return
+		}
+		current, err := st.MemberRole(r.Context(), ws.ID, email)
+		if err == store.ErrNotFound {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to load workspace role: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if current == workspace.Owner {
+			if role != workspace.Owner {
+				http.Error(w, "Only owners can remove owners", http.StatusForbidden)
+				return
+			}
+			last, err := lastOwner(r, st, ws.ID, email)
+			if err != nil {
+				logrus.Errorf("Failed to list members: %v", err)
+				http.Error(w, "DB error", http.StatusInternalServerError)
+				return
+			}
+			if last {
+				http.Error(w, "A workspace needs at least one owner", http.StatusConflict)
+				return
+			}
+		}
+		if err := st.RemoveMember(r.Context(), ws.ID, email); err != nil && err != store.ErrNotFound {
+			logrus.Errorf("Failed to remove member: %v", err)
+			http.Error(w, "Failed to remove member", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
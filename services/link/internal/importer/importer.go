@@ -0,0 +1,194 @@
+// Package importer loads links exported from other shorteners (Bitly, YOURLS)
+// into url_mappings, keeping their short codes where our rules allow.
+package importer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"usethislink/services/internal/shorturl"
+	"usethislink/services/link/internal/shortner"
+	"usethislink/services/link/internal/store"
+)
+
+// What to do with a row whose code is taken or fails our alias rules.
+const (
+	OnConflictGenerate = "generate" // store it under a newly generated code
+	OnConflictSkip     = "skip"     // leave it out
+)
+
+// Options applies to every imported link.
+type Options struct {
+	Owner       store.Owner
+	Domain      string // verified custom domain, "" for BASE_URL
+	WorkspaceID string
+	OnConflict  string
+	// OnCreate is called for every link stored.
+	OnCreate func(store.Link)
+}
+
+// Row statuses in a Report.
+const (
+	StatusImported = "imported"
+	StatusRenamed  = "renamed"
+	StatusSkipped  = "skipped"
+)
+
+// RowResult describes a row that was not imported as-is.
+type RowResult struct {
+	Line         int    `json:"line"`
+	OriginalCode string `json:"original_code,omitempty"`
+	URL          string `json:"url,omitempty"`
+	Status       string `json:"status"`
+	ShortCode    string `json:"short_code,omitempty"`
+	ShortURL     string `json:"short_url,omitempty"`
+	Reason       string `json:"reason"`
+}
+
+// Report summarizes an import. Issues lists renamed and skipped rows only.
+type Report struct {
+	Total          int         `json:"total"`
+	Imported       int         `json:"imported"`
+	Renamed        int         `json:"renamed"`
+	Skipped        int         `json:"skipped"`
+	Issues         []RowResult `json:"issues"`
+	AnalyticsError string      `json:"analytics_error,omitempty"`
+}
+
+// Run stores records one by one; a failing row never aborts the rest. Imported
+// links do not expire, since they were already public on the old shortener.
+// It returns the links it created so callers can forward their click totals.
+func Run(ctx context.Context, st store.LinkStore, records []Record, opts Options) (Report, []store.Link) {
+	if opts.OnConflict == "" {
+		opts.OnConflict = OnConflictGenerate
+	}
+	report := Report{Total: len(records), Issues: []RowResult{}}
+	var created []store.Link
+	for _, rec := range records {
+		res := RowResult{Line: rec.Line, OriginalCode: rec.Code, URL: rec.URL}
+		dest, err := shortner.NormalizeDestination(rec.URL)
+		if err != nil {
+			res.Status, res.Reason = StatusSkipped, err.Error()
+			report.add(res)
+			continue
+		}
+		tags, err := shortner.NormalizeTags(rec.Tags)
+		if err != nil {
+			res.Status, res.Reason = StatusSkipped, err.Error()
+			report.add(res)
+			continue
+		}
+		l := store.Link{
+			Domain:       opts.Domain,
+			ShortCode:    rec.Code,
+			OriginalURL:  dest,
+			SessionID:    opts.Owner.SessionID,
+			UserEmail:    opts.Owner.UserEmail,
+			IsLoggedIn:   opts.Owner.UserEmail != "",
+			CreatedAt:    rec.CreatedAt,
+			Visits:       rec.Clicks,
+			RedirectCode: shortner.DefaultRedirectCode,
+			WorkspaceID:  opts.WorkspaceID,
+			Tags:         tags,
+		}
+		reason := ""
+		if rec.Code == "" {
+			reason = "row has no short code"
+		} else if err := shortner.ValidAlias(rec.Code); err != nil {
+			reason = err.Error()
+		} else {
+			err := st.CreateLink(ctx, l)
+			if err == nil {
+				res.Status = StatusImported
+			} else if err == store.ErrConflict {
+				reason = "short code already taken"
+			} else {
+				res.Status, res.Reason = StatusSkipped, "database error: "+err.Error()
+				report.add(res)
+				continue
+			}
+		}
+		if reason != "" {
+			if opts.OnConflict != OnConflictGenerate {
+				res.Status, res.Reason = StatusSkipped, reason
+				report.add(res)
+				continue
+			}
+			code, err := shortner.StoreGenerated(ctx, st, l)
+			if err != nil {
+				res.Status, res.Reason = StatusSkipped, fmt.Sprintf("%s; generating a new code failed: %v", reason, err)
+				report.add(res)
+				continue
+			}
+			l.ShortCode = code
+			res.Status, res.Reason = StatusRenamed, reason
+		}
+		res.ShortCode = l.ShortCode
+		res.ShortURL = shorturl.Build(l.Domain, l.ShortCode)
+		report.add(res)
+		created = append(created, l)
+		if opts.OnCreate != nil {
+			opts.OnCreate(l)
+		}
+	}
+	return report, created
+}
+
+func (r *Report) add(res RowResult) {
+	switch res.Status {
+	case StatusImported:
+		r.Imported++
+		return
+	case StatusRenamed:
+		r.Renamed++
+	case StatusSkipped:
+		r.Skipped++
+	}
+	r.Issues = append(r.Issues, res)
+}
+
+type linkTotal struct {
+	Domain      string `json:"domain"`
+	ShortURL    string `json:"short_url"`
+	TotalVisits int64  `json:"total_visits"`
+}
+
+// SendTotals hands the imported click totals to the analytics service, which
+// owns link_analytics.
+func SendTotals(ctx context.Context, links []store.Link) error {
+	totals := make([]linkTotal, 0, len(links))
+	for _, l := range links {
+		if l.Visits > 0 {
+			totals = append(totals, linkTotal{Domain: l.Domain, ShortURL: l.ShortCode, TotalVisits: l.Visits})
+		}
+	}
+	if len(totals) == 0 {
+		return nil
+	}
+	analyticsURL := os.Getenv("ANALYTICS_SERVICE_URL")
+	if analyticsURL == "" {
+		analyticsURL = "http://analytics:8082"
+	}
+	b, err := json.Marshal(totals)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", analyticsURL+"/link-analytics", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("analytics service returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"usethislink/services/link/internal/store"
+)
+
+var testOwner = store.Owner{SessionID: "s1", UserEmail: "me@example.com"}
+
+func TestRun(t *testing.T) {
+	t.Setenv("BASE_URL", "https://ut.link")
+	created := time.Date(2021, 5, 6, 7, 8, 9, 0, time.UTC)
+	for _, tc := range []struct {
+		name       string
+		rec        Record
+		onConflict string
+		wantStatus string
+		wantReason string
+		keepsCode  bool
+	}{
+		{"kept code", Record{Code: "spring", URL: "https://example.com/spring"}, "", StatusImported, "", true},
+		{"code breaking the alias rules", Record{Code: "no!pe", URL: "https://example.com/a"}, "", StatusRenamed, "alias may only contain", false},
+		{"reserved code", Record{Code: "login", URL: "https://example.com/login"}, "", StatusRenamed, "alias is reserved", false},
+		{"row without a code", Record{URL: "https://example.com/none"}, "", StatusRenamed, "row has no short code", false},
+		{"collision with generate", Record{Code: "taken", URL: "https://example.com/t"}, OnConflictGenerate, StatusRenamed, "short code already taken", false},
+		{"collision with skip", Record{Code: "taken", URL: "https://example.com/t"}, OnConflictSkip, StatusSkipped, "short code already taken", false},
+		{"bad alias with skip", Record{Code: "no!pe", URL: "https://example.com/a"}, OnConflictSkip, StatusSkipped, "alias may only contain", false},
+		{"bad URL", Record{Code: "broken", URL: "not a url"}, "", StatusSkipped, "Invalid or incomplete domain", false},
+		{"bad tags", Record{Code: "tagged", URL: "https://example.com", Tags: []string{"a,b"}}, "", StatusSkipped, "tags may not contain", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			st := store.NewMemory()
+			if err := st.CreateLink(context.Background(), store.Link{ShortCode: "taken", OriginalURL: "https://example.org"}); err != nil {
+				t.Fatal(err)
+			}
+			rec := tc.rec
+			rec.Line, rec.CreatedAt, rec.Clicks = 2, created, 42
+			var hooked []store.Link
+			report, links := Run(context.Background(), st, []Record{rec}, Options{
+				Owner:      testOwner,
+				OnConflict: tc.onConflict,
+				OnCreate:   func(l store.Link) { hooked = append(hooked, l) },
+			})
+			if report.Total != 1 {
+				t.Errorf("Total = %d, want 1", report.Total)
+			}
+			if tc.wantStatus == StatusImported {
+				if report.Imported != 1 || len(report.Issues) != 0 {
+					t.Fatalf("report = %+v, want one clean import", report)
+				}
+			} else {
+				if len(report.Issues) != 1 {
+					t.Fatalf("report = %+v, want one issue", report)
+				}
+				issue := report.Issues[0]
+				if issue.Status != tc.wantStatus || !strings.Contains(issue.Reason, tc.wantReason) || issue.Line != 2 {
+					t.Errorf("issue = %+v, want %s with reason %q", issue, tc.wantStatus, tc.wantReason)
+				}
+			}
+			if tc.wantStatus == StatusSkipped {
+				if report.Skipped != 1 || len(links) != 0 || len(hooked) != 0 {
+					t.Errorf("skipped row was stored: report %+v, links %v", report, links)
+				}
+				return
+			}
+			if len(links) != 1 || !reflect.DeepEqual(hooked, links) {
+				t.Fatalf("links = %v, OnCreate saw %v", links, hooked)
+			}
+			l := links[0]
+			if tc.keepsCode != (l.ShortCode == tc.rec.Code) {
+				t.Errorf("code = %q, original %q", l.ShortCode, tc.rec.Code)
+			}
+			if tc.wantStatus == StatusRenamed && report.Issues[0].ShortURL != "https://ut.link/"+l.ShortCode {
+				t.Errorf("issue short_url = %q", report.Issues[0].ShortURL)
+			}
+			got, err := st.GetLink(context.Background(), l.Key())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.CreatedAt.Equal(created) || got.Visits != 42 || !got.ExpiresAt.IsZero() {
+				t.Errorf("stored created %v, visits %d, expiry %v; want %v, 42, never", got.CreatedAt, got.Visits, got.ExpiresAt, created)
+			}
+			if got.UserEmail != testOwner.UserEmail || got.SessionID != testOwner.SessionID || !got.IsLoggedIn {
+				t.Errorf("stored owner %q/%q, logged in %v", got.UserEmail, got.SessionID, got.IsLoggedIn)
+			}
+		})
+	}
+}
+
+func TestRunCounts(t *testing.T) {
+	t.Setenv("BASE_URL", "https://ut.link")
+	st := store.NewMemory()
+	records := []Record{
+		{Line: 2, Code: "one", URL: "example.com/1", Tags: []string{"News", "news "}},
+		{Line: 3, Code: "one", URL: "https://example.com/dup"},
+		{Line: 4, Code: "two", URL: ""},
+	}
+	report, links := Run(context.Background(), st, records, Options{Owner: testOwner, WorkspaceID: "ws1"})
+	if report.Total != 3 || report.Imported != 1 || report.Renamed != 1 || report.Skipped != 1 {
+		t.Errorf("report = %+v", report)
+	}
+	if len(links) != 2 {
+		t.Fatalf("links = %v", links)
+	}
+	first := links[0]
+	if first.OriginalURL != "http://example.com/1" || first.WorkspaceID != "ws1" || !reflect.DeepEqual(first.Tags, []string{"news"}) {
+		t.Errorf("first link = %+v", first)
+	}
+}
+
+func TestSendTotals(t *testing.T) {
+	var got []linkTotal
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/link-analytics" {
+			t.Errorf("request %s %s", r.Method, r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("ANALYTICS_SERVICE_URL", srv.URL)
+
+	links := []store.Link{
+		{Domain: "go.acme.com", ShortCode: "busy", Visits: 9},
+		{ShortCode: "quiet"},
+	}
+	if err := SendTotals(context.Background(), links); err != nil {
+		t.Fatal(err)
+	}
+	want := []linkTotal{{Domain: "go.acme.com", ShortURL: "busy", TotalVisits: 9}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,275 @@
+package importer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record is one link read from another shortener's export.
+type Record struct {
+	Line      int // CSV line or 1-based position in the JSON list
+	Code      string
+	URL       string
+	Title     string
+	Tags      []string  // Bitly only
+	CreatedAt time.Time // zero if the export had none
+	Clicks    int64
+}
+
+// Format names accepted by Parse.
+const (
+	CSV  = "csv"
+	JSON = "json"
+)
+
+var ErrUnknownFormat = errors.New("format must be csv or json")
+
+// Parse reads a Bitly or YOURLS export. Both shorteners' CSV exports are
+// matched by header name, so column order and extra columns do not matter.
+// JSON may be Bitly's {"links": [...]} list or YOURLS' {"links": {"link_1": ...}}
+// map, or a bare array of either kind of object.
+func Parse(r io.Reader, format string) ([]Record, error) {
+	switch format {
+	case CSV:
+		return parseCSV(r)
+	case JSON:
+		return parseJSON(r)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// Header aliases, lowercased with spaces and dashes turned into underscores.
+var (
+	codeColumns    = []string{"keyword", "custom_bitlinks", "bitlink", "short_url", "shorturl", "link", "short_link", "code"}
+	urlColumns     = []string{"long_url", "url", "original_url", "destination", "target"}
+	titleColumns   = []string{"title"}
+	tagsColumns    = []string{"tags"}
+	createdColumns = []string{"created", "created_at", "timestamp", "date", "creation_date"}
+	clicksColumns  = []string{"clicks", "total_clicks", "click_count", "visits"}
+)
+
+func normalizeHeader(h string) string {
+	h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(h)
+}
+
+func parseCSV(r io.Reader) ([]Record, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+	index := map[string]int{}
+	for i, h := range header {
+		index[normalizeHeader(h)] = i
+	}
+	find := func(names []string) int {
+		for _, n := range names {
+			if i, ok := index[n]; ok {
+				return i
+			}
+		}
+		return -1
+	}
+	// A row may leave its custom back-half empty, so try every code column in turn.
+	var codeCols []int
+	for _, n := range codeColumns {
+		if i, ok := index[n]; ok {
+			codeCols = append(codeCols, i)
+		}
+	}
+	urlCol := find(urlColumns)
+	titleCol, tagsCol := find(titleColumns), find(tagsColumns)
+	createdCol, clicksCol := find(createdColumns), find(clicksColumns)
+	if urlCol < 0 {
+		return nil, errors.New("CSV has no long URL column (long_url, url)")
+	}
+	field := func(row []string, i int) string {
+		if i < 0 || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+	var records []Record
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := cr.FieldPos(0)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		code := ""
+		for _, i := range codeCols {
+			// Custom back-halves may be listed several to a cell.
+			if code = codeFromShortLink(firstOf(strings.Fields(strings.ReplaceAll(field(row, i), ",", " ")))); code != "" {
+				break
+			}
+		}
+		records = append(records, Record{
+			Line:      line,
+			Code:      code,
+			URL:       field(row, urlCol),
+			Title:     field(row, titleCol),
+			Tags:      splitList(field(row, tagsCol)),
+			CreatedAt: parseTime(field(row, createdCol)),
+			Clicks:    parseClicks(field(row, clicksCol)),
+		})
+	}
+	return records, nil
+}
+
+// jsonLink accepts the field names of both Bitly's API and YOURLS' stats API.
+type jsonLink struct {
+	Link           string          `json:"link"`
+	ID             string          `json:"id"`
+	CustomBitlinks []string        `json:"custom_bitlinks"`
+	LongURL        string          `json:"long_url"`
+	CreatedAt      string          `json:"created_at"`
+	Keyword        string          `json:"keyword"`
+	ShortURL       string          `json:"shorturl"`
+	URL            string          `json:"url"`
+	Timestamp      string          `json:"timestamp"`
+	Title          string          `json:"title"`
+	Tags           []string        `json:"tags"`
+	Clicks         json.RawMessage `json:"clicks"`
+}
+
+func (j jsonLink) record(line int) Record {
+	rec := Record{Line: line, Title: j.Title, Tags: j.Tags, URL: j.LongURL}
+	if rec.URL == "" {
+		rec.URL = j.URL
+	}
+	// Prefer a custom back-half over the random Bitly code.
+	for _, c := range []string{firstOf(j.CustomBitlinks), j.Keyword, j.ShortURL, j.Link, j.ID} {
+		if code := codeFromShortLink(c); code != "" {
+			rec.Code = code
+			break
+		}
+	}
+	rec.CreatedAt = parseTime(j.CreatedAt)
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = parseTime(j.Timestamp)
+	}
+	rec.Clicks = parseClicks(strings.Trim(string(j.Clicks), `"`))
+	return rec
+}
+
+// splitList splits a cell listing several values, e.g. "news, promo".
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func firstOf(s []string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	return s[0]
+}
+
+func parseJSON(r io.Reader) ([]Record, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("reading JSON: %w", err)
+	}
+	var wrapped struct {
+		Links json.RawMessage `json:"links"`
+	}
+	if err := json.Unmarshal(raw, &wrapped); err == nil && len(wrapped.Links) > 0 {
+		raw = wrapped.Links
+	}
+	var list []jsonLink
+	if err := json.Unmarshal(raw, &list); err != nil {
+		// YOURLS keys links as link_1, link_2, ...; keep that order.
+		var byKey map[string]jsonLink
+		if err := json.Unmarshal(raw, &byKey); err != nil {
+			return nil, errors.New("JSON must be a list of links or an object of links")
+		}
+		keys := make([]string, 0, len(byKey))
+		for k := range byKey {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return linkKeyLess(keys[i], keys[j]) })
+		for _, k := range keys {
+			list = append(list, byKey[k])
+		}
+	}
+	records := make([]Record, 0, len(list))
+	for i, j := range list {
+		records = append(records, j.record(i+1))
+	}
+	return records, nil
+}
+
+// linkKeyLess orders "link_2" before "link_10".
+func linkKeyLess(a, b string) bool {
+	na, errA := strconv.Atoi(strings.TrimPrefix(a, "link_"))
+	nb, errB := strconv.Atoi(strings.TrimPrefix(b, "link_"))
+	if errA == nil && errB == nil {
+		return na < nb
+	}
+	return a < b
+}
+
+// codeFromShortLink turns "https://bit.ly/abc", "bit.ly/abc" or "abc" into "abc".
+func codeFromShortLink(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+	if !strings.Contains(s, "/") {
+		return s
+	}
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return ""
+	}
+	return strings.Trim(u.Path, "/")
+}
+
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05-0700", // Bitly API
+	"2006-01-02 15:04:05",      // YOURLS
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"01/02/2006 15:04",
+	"01/02/2006",
+}
+
+func parseTime(s string) time.Time {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil && secs > 0 {
+		return time.Unix(secs, 0).UTC()
+	}
+	return time.Time{}
+}
+
+func parseClicks(s string) int64 {
+	n, err := strconv.ParseInt(strings.ReplaceAll(s, ",", ""), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
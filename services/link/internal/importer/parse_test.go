@@ -0,0 +1,106 @@
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	jan2 := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		name   string
+		format string
+		input  string
+		want   []Record
+	}{
+		{
+			name:   "Bitly CSV",
+			format: CSV,
+			input: "\ufeffTitle,Bitlink,Custom bitlinks,Long URL,Created,Clicks,Tags\n" +
+				"Spring,bit.ly/3xYz,\"bit.ly/spring, bit.ly/spring2\",https://example.com/spring,2024-01-02T15:04:05+0000,\"1,204\",\"News, promo\"\n" +
+				"Plain,https://bit.ly/4aBc,,https://example.com/plain,,,\n",
+			want: []Record{
+				{Line: 2, Code: "spring", URL: "https://example.com/spring", Title: "Spring", Tags: []string{"News", " promo"}, CreatedAt: jan2, Clicks: 1204},
+				{Line: 3, Code: "4aBc", URL: "https://example.com/plain", Title: "Plain"},
+			},
+		},
+		{
+			name:   "YOURLS CSV with its own column order",
+			format: CSV,
+			input: "keyword,url,title,timestamp,ip,clicks\n" +
+				"docs,https://example.com/docs,Docs,2024-01-02 15:04:05,127.0.0.1,7\n",
+			want: []Record{
+				{Line: 2, Code: "docs", URL: "https://example.com/docs", Title: "Docs", CreatedAt: jan2, Clicks: 7},
+			},
+		},
+		{
+			name:   "Bitly JSON",
+			format: JSON,
+			input: `{"links": [{"link": "https://bit.ly/3xYz", "custom_bitlinks": ["https://bit.ly/spring"],
+				"long_url": "https://example.com/spring", "title": "Spring", "created_at": "2024-01-02T15:04:05+0000", "tags": ["news"]}]}`,
+			want: []Record{
+				{Line: 1, Code: "spring", URL: "https://example.com/spring", Title: "Spring", Tags: []string{"news"}, CreatedAt: jan2},
+			},
+		},
+		{
+			name:   "YOURLS JSON keeps link_N order",
+			format: JSON,
+			input: `{"links": {
+				"link_10": {"shorturl": "https://sho.rt/ten", "url": "https://example.com/10", "timestamp": "2024-01-02 15:04:05", "clicks": "3"},
+				"link_2": {"shorturl": "https://sho.rt/two", "url": "https://example.com/2", "clicks": "12"}}}`,
+			want: []Record{
+				{Line: 1, Code: "two", URL: "https://example.com/2", Clicks: 12},
+				{Line: 2, Code: "ten", URL: "https://example.com/10", CreatedAt: jan2, Clicks: 3},
+			},
+		},
+		{
+			name:   "bare JSON array",
+			format: JSON,
+			input:  `[{"keyword": "abc", "url": "https://example.com", "clicks": 5}]`,
+			want:   []Record{{Line: 1, Code: "abc", URL: "https://example.com", Clicks: 5}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tc.input), tc.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Parse =\n%+v\nwant\n%+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		format string
+		input  string
+	}{
+		{"unknown format", "xml", "<links/>"},
+		{"CSV without a URL column", CSV, "keyword,title\nabc,Abc\n"},
+		{"empty CSV", CSV, ""},
+		{"JSON that is not a list of links", JSON, `{"links": 42}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(tc.input), tc.format); err == nil {
+				t.Error("Parse succeeded")
+			}
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	want := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	for _, s := range []string{"2024-01-02T00:00:00Z", "2024-01-02", "01/02/2024", "1704153600"} {
+		if got := parseTime(s); !got.Equal(want) {
+			t.Errorf("parseTime(%q) = %v, want %v", s, got, want)
+		}
+	}
+	if got := parseTime("last tuesday"); !got.IsZero() {
+		t.Errorf("parseTime(last tuesday) = %v, want zero", got)
+	}
+}
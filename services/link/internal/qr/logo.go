@@ -0,0 +1,89 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/jpeg" // logos may be JPEG
+	"image/png"
+)
+
+// MaxLogoBytes and MaxLogoSide cap uploaded logos. The side limit matters
+// because a small compressed file can decode to a huge bitmap.
+const (
+	MaxLogoBytes = 512 << 10
+	MaxLogoSide  = 2048
+)
+
+var ErrInvalidLogo = errors.New("logo must be a PNG or JPEG image of at most 512KB and 2048x2048 pixels")
+
+// DecodeLogo checks an uploaded logo and decodes it.
+func DecodeLogo(b []byte) (image.Image, error) {
+	if len(b) == 0 || len(b) > MaxLogoBytes {
+		return nil, ErrInvalidLogo
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > MaxLogoSide || cfg.Height > MaxLogoSide {
+		return nil, ErrInvalidLogo
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, ErrInvalidLogo
+	}
+	return img, nil
+}
+
+// fit scales src to fit within w x h, keeping its aspect ratio. Each target
+// pixel averages the source pixels it covers, which is plenty for logos.
+func fit(src image.Image, w, h int) *image.RGBA {
+	sb := src.Bounds()
+	if sb.Dx() == 0 || sb.Dy() == 0 || w <= 0 || h <= 0 {
+		return image.NewRGBA(image.Rect(0, 0, 0, 0))
+	}
+	if sb.Dx()*h > sb.Dy()*w {
+		h = max(1, sb.Dy()*w/sb.Dx())
+	} else {
+		w = max(1, sb.Dx()*h/sb.Dy())
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := sb.Min.Y+y*sb.Dy()/h, sb.Min.Y+(y+1)*sb.Dy()/h
+		y1 = max(y1, y0+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := sb.Min.X+x*sb.Dx()/w, sb.Min.X+(x+1)*sb.Dx()/w
+			x1 = max(x1, x0+1)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// flatten composites img onto bg, for formats without transparency.
+func flatten(img image.Image, bg color.RGBA) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			r, g, bl, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			blend := func(c uint32, under uint8) uint8 {
+				return uint8((c + uint32(under)*0x101*(0xffff-a)/0xffff) >> 8)
+			}
+			out.Set(x, y, color.RGBA{R: blend(r, bg.R), G: blend(g, bg.G), B: blend(bl, bg.B), A: 0xff})
+		}
+	}
+	return out
+}
+
+func encodePNG(img image.Image) []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
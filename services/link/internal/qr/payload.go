@@ -0,0 +1,313 @@
+package qr
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Payload is structured content encoded in a standard format that phones
+// understand, instead of a URL.
+type Payload interface {
+	// Encode validates the fields and returns the text to put in the code.
+	Encode() (string, error)
+}
+
+// fieldError reports an invalid field of a payload.
+type fieldError struct{ field, msg string }
+
+func (e fieldError) Error() string { return e.field + ": " + e.msg }
+
+func invalid(field, msg string) error { return fieldError{field, msg} }
+
+// WiFi joins a network: WIFI:T:<auth>;S:<ssid>;P:<password>;H:<hidden>;;
+type WiFi struct {
+	SSID     string `json:"ssid"`
+	Auth     string `json:"auth"` // WPA (default), WEP or nopass
+	Password string `json:"password"`
+	Hidden   bool   `json:"hidden"`
+}
+
+var hexOnly = regexp.MustCompile(`^[0-9A-Fa-f]+$`)
+
+func (w WiFi) Encode() (string, error) {
+	if w.SSID == "" || len(w.SSID) > 32 {
+		return "", invalid("ssid", "must be 1 to 32 bytes")
+	}
+	auth := strings.ToUpper(w.Auth)
+	switch auth {
+	case "", "WPA", "WPA2":
+		auth = "WPA"
+		if n := len(w.Password); (n < 8 || n > 63) && !(n == 64 && hexOnly.MatchString(w.Password)) {
+			return "", invalid("password", "WPA passwords are 8 to 63 characters or 64 hex digits")
+		}
+	case "WEP":
+		n := len(w.Password)
+		if !(n == 5 || n == 13 || ((n == 10 || n == 26) && hexOnly.MatchString(w.Password))) {
+			return "", invalid("password", "WEP keys are 5 or 13 characters or 10 or 26 hex digits")
+		}
+	case "NOPASS":
+		auth = "nopass"
+		if w.Password != "" {
+			return "", invalid("password", "must be empty for open networks")
+		}
+	default:
+		return "", invalid("auth", "must be WPA, WEP or nopass")
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "WIFI:T:%s;S:%s;", auth, wifiEscape(w.SSID))
+	if w.Password != "" {
+		fmt.Fprintf(&b, "P:%s;", wifiEscape(w.Password))
+	}
+	if w.Hidden {
+		b.WriteString("H:true;")
+	}
+	b.WriteString(";")
+	return b.String(), nil
+}
+
+var wifiEscaper = strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, `:`, `\:`, `"`, `\"`)
+
+func wifiEscape(s string) string { return wifiEscaper.Replace(s) }
+
+// Phone is a vCard telephone number; Type is e.g. cell, work or home.
+type Phone struct {
+	Type   string `json:"type"`
+	Number string `json:"number"`
+}
+
+// Address is a vCard postal address.
+type Address struct {
+	Street     string `json:"street"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+}
+
+// VCard is a contact card in vCard 3.0 (default) or 4.0.
+type VCard struct {
+	Version      string   `json:"version"`
+	FirstName    string   `json:"first_name"`
+	LastName     string   `json:"last_name"`
+	Organization string   `json:"organization"`
+	Title        string   `json:"title"`
+	Phones       []Phone  `json:"phones"`
+	Emails       []string `json:"emails"`
+	URL          string   `json:"url"`
+	Address      *Address `json:"address"`
+	Note         string   `json:"note"`
+}
+
+var (
+	phonePattern = regexp.MustCompile(`^\+?[0-9 ().-]{3,32}$`)
+	typePattern  = regexp.MustCompile(`^[A-Za-z-]{1,20}$`)
+)
+
+// maxVCardLength keeps inline vCards scannable; longer ones should be hosted.
+const maxVCardLength = 2000
+
+func (v VCard) Encode() (string, error) {
+	version := v.Version
+	if version == "" {
+		version = "3.0"
+	}
+	if version != "3.0" && version != "4.0" {
+		return "", invalid("version", "must be 3.0 or 4.0")
+	}
+	fn := strings.TrimSpace(strings.TrimSpace(v.FirstName) + " " + strings.TrimSpace(v.LastName))
+	if fn == "" {
+		fn = strings.TrimSpace(v.Organization)
+	}
+	if fn == "" {
+		return "", invalid("first_name", "a name or organization is required")
+	}
+	lines := []string{"BEGIN:VCARD", "VERSION:" + version}
+	lines = append(lines,
+		"N:"+vcardEscape(v.LastName)+";"+vcardEscape(v.FirstName)+";;;",
+		"FN:"+vcardEscape(fn))
+	if v.Organization != "" {
+		lines = append(lines, "ORG:"+vcardEscape(v.Organization))
+	}
+	if v.Title != "" {
+		lines = append(lines, "TITLE:"+vcardEscape(v.Title))
+	}
+	for i, p := range v.Phones {
+		if !phonePattern.MatchString(p.Number) {
+			return "", invalid(fmt.Sprintf("phones[%d].number", i), "is not a phone number")
+		}
+		line := "TEL"
+		if p.Type != "" {
+			if !typePattern.MatchString(p.Type) {
+				return "", invalid(fmt.Sprintf("phones[%d].type", i), "must be a word like cell, work or home")
+			}
+			t := strings.ToUpper(p.Type)
+			if version == "4.0" {
+				t = strings.ToLower(p.Type)
+			}
+			line += ";TYPE=" + t
+		}
+		lines = append(lines, line+":"+p.Number)
+	}
+	for i, e := range v.Emails {
+		if addr, err := mail.ParseAddress(e); err != nil || addr.Address != e {
+			return "", invalid(fmt.Sprintf("emails[%d]", i), "is not an email address")
+		}
+		lines = append(lines, "EMAIL:"+e)
+	}
+	if v.URL != "" {
+		if !isWebURL(v.URL) {
+			return "", invalid("url", "must be an http or https URL")
+		}
+		lines = append(lines, "URL:"+v.URL)
+	}
+	if a := v.Address; a != nil {
+		lines = append(lines, "ADR:;;"+strings.Join([]string{
+			vcardEscape(a.Street), vcardEscape(a.City), vcardEscape(a.Region), vcardEscape(a.PostalCode), vcardEscape(a.Country),
+		}, ";"))
+	}
+	if v.Note != "" {
+		lines = append(lines, "NOTE:"+vcardEscape(v.Note))
+	}
+	lines = append(lines, "END:VCARD")
+	out := foldLines(lines)
+	if len(out) > maxVCardLength {
+		return "", invalid("vcard", "is too long to fit in a QR code; host it instead")
+	}
+	return out, nil
+}
+
+var vcardEscaper = strings.NewReplacer(`\`, `\\`, `,`, `\,`, `;`, `\;`, "\r\n", `\n`, "\n", `\n`)
+
+func vcardEscape(s string) string { return vcardEscaper.Replace(strings.TrimSpace(s)) }
+
+// foldLines joins content lines with CRLF, folding each at 75 octets as vCard
+// and iCalendar require, without splitting UTF-8 sequences.
+func foldLines(lines []string) string {
+	var b strings.Builder
+	for _, line := range lines {
+		for len(line) > 75 {
+			cut := 75
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			b.WriteString(line[:cut] + "\r\n ")
+			line = line[cut:]
+		}
+		b.WriteString(line + "\r\n")
+	}
+	return b.String()
+}
+
+// Event is a calendar invitation, encoded as an iCalendar VEVENT. Start and
+// End are RFC 3339 times, or dates (2006-01-02) for all-day events.
+type Event struct {
+	Summary     string `json:"summary"`
+	Start       string `json:"start"`
+	End         string `json:"end"`
+	Location    string `json:"location"`
+	Description string `json:"description"`
+	URL         string `json:"url"`
+}
+
+func (e Event) Encode() (string, error) {
+	if strings.TrimSpace(e.Summary) == "" {
+		return "", invalid("summary", "is required")
+	}
+	start, allDay, err := eventTime(e.Start)
+	if err != nil {
+		return "", invalid("start", err.Error())
+	}
+	end, endAllDay := start, allDay
+	if e.End != "" {
+		if end, endAllDay, err = eventTime(e.End); err != nil {
+			return "", invalid("end", err.Error())
+		}
+		if endAllDay != allDay {
+			return "", invalid("end", "must be a date when start is a date, and a time otherwise")
+		}
+		if !end.After(start) {
+			return "", invalid("end", "must be after start")
+		}
+	} else if allDay {
+		end = start.AddDate(0, 0, 1)
+	} else {
+		end = start.Add(time.Hour)
+	}
+	if e.URL != "" && !isWebURL(e.URL) {
+		return "", invalid("url", "must be an http or https URL")
+	}
+	uid := make([]byte, 12)
+	if _, err := rand.Read(uid); err != nil {
+		return "", err
+	}
+	lines := []string{
+		"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//UseThisLink//QR//EN",
+		"BEGIN:VEVENT",
+		"UID:" + hex.EncodeToString(uid) + "@usethislink",
+		"DTSTAMP:" + time.Now().UTC().Format("20060102T150405Z"),
+	}
+	if allDay {
+		lines = append(lines, "DTSTART;VALUE=DATE:"+start.Format("20060102"), "DTEND;VALUE=DATE:"+end.Format("20060102"))
+	} else {
+		lines = append(lines, "DTSTART:"+start.UTC().Format("20060102T150405Z"), "DTEND:"+end.UTC().Format("20060102T150405Z"))
+	}
+	lines = append(lines, "SUMMARY:"+vcardEscape(e.Summary))
+	if e.Location != "" {
+		lines = append(lines, "LOCATION:"+vcardEscape(e.Location))
+	}
+	if e.Description != "" {
+		lines = append(lines, "DESCRIPTION:"+vcardEscape(e.Description))
+	}
+	if e.URL != "" {
+		lines = append(lines, "URL:"+e.URL)
+	}
+	lines = append(lines, "END:VEVENT", "END:VCALENDAR")
+	return foldLines(lines), nil
+}
+
+func eventTime(s string) (t time.Time, allDay bool, err error) {
+	if t, err = time.Parse(time.RFC3339, s); err == nil {
+		return t, false, nil
+	}
+	if t, err = time.Parse("2006-01-02", s); err == nil {
+		return t, true, nil
+	}
+	return t, false, errors.New("must be an RFC 3339 time or a 2006-01-02 date")
+}
+
+// Geo is a location, encoded as an RFC 5870 geo: URI.
+type Geo struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude"`
+}
+
+func (g Geo) Encode() (string, error) {
+	if g.Latitude < -90 || g.Latitude > 90 {
+		return "", invalid("latitude", "must be between -90 and 90")
+	}
+	if g.Longitude < -180 || g.Longitude > 180 {
+		return "", invalid("longitude", "must be between -180 and 180")
+	}
+	uri := "geo:" + formatCoord(g.Latitude) + "," + formatCoord(g.Longitude)
+	if g.Altitude != nil {
+		uri += "," + formatCoord(*g.Altitude)
+	}
+	return uri, nil
+}
+
+func formatCoord(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+
+func isWebURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,113 @@
+package qr
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image/color"
+	"io"
+)
+
+// pdfLogoPx is the resolution the logo is embedded at in PDFs.
+const pdfLogoPx = 512
+
+// writePDF writes a one-page PDF whose page is the code, opts.Size points
+// square. Modules are vector paths, so it prints sharp at any scale.
+func writePDF(w io.Writer, g grid, opts Options) error {
+	m := float64(opts.Margin)
+	unit := float64(opts.Size) / (float64(g.size()) + 2*m)
+	page := float64(opts.Size)
+
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "%s rg 0 0 %g %g re f\n", pdfColour(opts.Background), page, page)
+	fmt.Fprintf(&content, "%s rg\n", pdfColour(opts.Foreground))
+	for y := 0; y < g.size(); y++ {
+		for x := 0; x < g.size(); x++ {
+			if !g.dark(x, y) {
+				continue
+			}
+			// PDF's origin is the bottom-left corner.
+			px, py := (m+float64(x))*unit, page-(m+float64(y)+1)*unit
+			if opts.Rounded {
+				roundedRect(&content, px, py, unit, dotRadius*unit)
+			} else {
+				fmt.Fprintf(&content, "%.3f %.3f %.3f %.3f re\n", px, py, unit, unit)
+			}
+		}
+	}
+	content.WriteString("f\n")
+
+	var logo []byte
+	var logoW, logoH int
+	if opts.Logo != nil {
+		img := flatten(fit(opts.Logo, pdfLogoPx, pdfLogoPx), opts.Background)
+		logoW, logoH = img.Bounds().Dx(), img.Bounds().Dy()
+		rgb := make([]byte, 0, logoW*logoH*3)
+		for i := 0; i < len(img.Pix); i += 4 {
+			rgb = append(rgb, img.Pix[i], img.Pix[i+1], img.Pix[i+2])
+		}
+		logo = deflate(rgb)
+		// Fit the logo inside its box, half a module in from each side.
+		boxX, boxY := (m+float64(g.logo.Min.X)+0.5)*unit, page-(m+float64(g.logo.Max.Y)-0.5)*unit
+		boxW := (float64(g.logo.Dx()) - 1) * unit
+		scale := boxW / float64(max(logoW, logoH))
+		dw, dh := float64(logoW)*scale, float64(logoH)*scale
+		fmt.Fprintf(&content, "q %.3f 0 0 %.3f %.3f %.3f cm /Logo Do Q\n", dw, dh, boxX+(boxW-dw)/2, boxY+(boxW-dh)/2)
+	}
+
+	var objects []string
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+	)
+	resources := "<< >>"
+	if logo != nil {
+		resources = "<< /XObject << /Logo 5 0 R >> >>"
+	}
+	objects = append(objects, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %g %g] /Contents 4 0 R /Resources %s >>", page, page, resources))
+	stream := deflate(content.Bytes())
+	objects = append(objects, fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", len(stream), stream))
+	if logo != nil {
+		objects = append(objects, fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream",
+			logoW, logoH, len(logo), logo))
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+func pdfColour(c color.RGBA) string {
+	return fmt.Sprintf("%.3f %.3f %.3f", float64(c.R)/255, float64(c.G)/255, float64(c.B)/255)
+}
+
+// roundedRect adds a square of edge s with corner radius r at (x, y) to the
+// current path, approximating the corners with Bézier curves.
+func roundedRect(b *bytes.Buffer, x, y, s, r float64) {
+	k := 0.5523 * r
+	fmt.Fprintf(b, "%.3f %.3f m ", x+r, y)
+	fmt.Fprintf(b, "%.3f %.3f l %.3f %.3f %.3f %.3f %.3f %.3f c ", x+s-r, y, x+s-r+k, y, x+s, y+r-k, x+s, y+r)
+	fmt.Fprintf(b, "%.3f %.3f l %.3f %.3f %.3f %.3f %.3f %.3f c ", x+s, y+s-r, x+s, y+s-r+k, x+s-r+k, y+s, x+s-r, y+s)
+	fmt.Fprintf(b, "%.3f %.3f l %.3f %.3f %.3f %.3f %.3f %.3f c ", x+r, y+s, x+r-k, y+s, x, y+s-r+k, x, y+s-r)
+	fmt.Fprintf(b, "%.3f %.3f l %.3f %.3f %.3f %.3f %.3f %.3f c h\n", x, y+r, x, y+r-k, x+r-k, y, x+r, y)
+}
+
+func deflate(b []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
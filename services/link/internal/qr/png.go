@@ -0,0 +1,71 @@
+package qr
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+)
+
+func writePNG(w io.Writer, g grid, opts Options) error {
+	modulePx, offset, err := layout(g.size(), opts)
+	if err != nil {
+		return err
+	}
+	bounds := image.Rect(0, 0, opts.Size, opts.Size)
+	var img draw.Image
+	if opts.Logo != nil {
+		rgba := image.NewRGBA(bounds)
+		draw.Draw(rgba, bounds, image.NewUniform(opts.Background), image.Point{}, draw.Src)
+		img = rgba
+	} else {
+		img = image.NewPaletted(bounds, color.Palette{opts.Background, opts.Foreground})
+	}
+	dot := moduleMask(modulePx, opts.Rounded)
+	for y := 0; y < g.size(); y++ {
+		for x := 0; x < g.size(); x++ {
+			if !g.dark(x, y) {
+				continue
+			}
+			for py := 0; py < modulePx; py++ {
+				for px := 0; px < modulePx; px++ {
+					if dot[py*modulePx+px] {
+						img.Set(offset+x*modulePx+px, offset+y*modulePx+py, opts.Foreground)
+					}
+				}
+			}
+		}
+	}
+	if opts.Logo != nil {
+		box := image.Rect(offset+g.logo.Min.X*modulePx, offset+g.logo.Min.Y*modulePx,
+			offset+g.logo.Max.X*modulePx, offset+g.logo.Max.Y*modulePx)
+		// Half a module of padding keeps the logo off the surrounding modules.
+		inner := box.Inset(modulePx / 2)
+		logo := fit(opts.Logo, inner.Dx(), inner.Dy())
+		at := inner.Min.Add(image.Pt((inner.Dx()-logo.Bounds().Dx())/2, (inner.Dy()-logo.Bounds().Dy())/2))
+		draw.Draw(img, logo.Bounds().Add(at), logo, image.Point{}, draw.Over)
+	}
+	return png.Encode(w, img)
+}
+
+// moduleMask is the set of pixels a dark module covers: all of them, or a
+// square with rounded corners.
+func moduleMask(modulePx int, rounded bool) []bool {
+	mask := make([]bool, modulePx*modulePx)
+	half := float64(modulePx) / 2
+	r := dotRadius * float64(modulePx)
+	for py := 0; py < modulePx; py++ {
+		for px := 0; px < modulePx; px++ {
+			if !rounded {
+				mask[py*modulePx+px] = true
+				continue
+			}
+			qx := math.Max(math.Abs(float64(px)+0.5-half)-(half-r), 0)
+			qy := math.Max(math.Abs(float64(py)+0.5-half)-(half-r), 0)
+			mask[py*modulePx+px] = qx*qx+qy*qy <= r*r
+		}
+	}
+	return mask
+}
@@ -0,0 +1,155 @@
+// Package qr renders QR codes for short links as PNG or SVG.
+package qr
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Output formats.
+const (
+	PNG = "png"
+	SVG = "svg"
+	PDF = "pdf"
+)
+
+var (
+	ErrTooSmall      = errors.New("size is too small for this content and margin")
+	ErrInvalidColour = errors.New("colours must be hex RGB like 1a2b3c")
+	ErrInvalidLevel  = errors.New("level must be L, M, Q or H")
+)
+
+// Options controls how a code is drawn. Size is the image edge in pixels (in
+// points for PDF) and Margin the quiet zone in modules. Rounded draws modules
+// as dots; Logo is placed in the centre, which forces the highest error
+// correction so the covered modules can be recovered.
+type Options struct {
+	Size       int
+	Level      qrcode.RecoveryLevel
+	Margin     int
+	Foreground color.RGBA
+	Background color.RGBA
+	Rounded    bool
+	Logo       image.Image
+}
+
+// DefaultOptions is a 256px black-on-white code with medium error correction
+// and the standard four-module quiet zone.
+func DefaultOptions() Options {
+	return Options{
+		Size:       256,
+		Level:      qrcode.Medium,
+		Margin:     4,
+		Foreground: color.RGBA{A: 0xff},
+		Background: color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+	}
+}
+
+// ParseLevel maps the usual L/M/Q/H letters to recovery levels.
+func ParseLevel(s string) (qrcode.RecoveryLevel, error) {
+	switch strings.ToUpper(s) {
+	case "L":
+		return qrcode.Low, nil
+	case "M":
+		return qrcode.Medium, nil
+	case "Q":
+		return qrcode.High, nil
+	case "H":
+		return qrcode.Highest, nil
+	}
+	return 0, ErrInvalidLevel
+}
+
+// ParseColour reads "1a2b3c" or "#1a2b3c".
+func ParseColour(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	var c color.RGBA
+	if len(s) != 6 {
+		return c, ErrInvalidColour
+	}
+	if _, err := fmt.Sscanf(s, "%02x%02x%02x", &c.R, &c.G, &c.B); err != nil {
+		return c, ErrInvalidColour
+	}
+	c.A = 0xff
+	return c, nil
+}
+
+// Hex formats c as "#rrggbb".
+func Hex(c color.RGBA) string { return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B) }
+
+// grid is the module matrix without quiet zone. Modules inside logo (in module
+// coordinates, empty without a logo) are left blank for the logo.
+type grid struct {
+	bitmap [][]bool
+	logo   image.Rectangle
+}
+
+// logoShare is the logo box edge as a share of the code; its area stays well
+// under the 30% that level H can recover.
+const logoShare = 0.22
+
+func newGrid(data string, opts Options) (grid, error) {
+	level := opts.Level
+	if opts.Logo != nil {
+		level = qrcode.Highest
+	}
+	code, err := qrcode.New(data, level)
+	if err != nil {
+		return grid{}, err
+	}
+	code.DisableBorder = true
+	g := grid{bitmap: code.Bitmap()}
+	if opts.Logo != nil {
+		n := len(g.bitmap)
+		box := int(float64(n) * logoShare)
+		if (n-box)%2 != 0 {
+			box++
+		}
+		start := (n - box) / 2
+		g.logo = image.Rect(start, start, start+box, start+box)
+	}
+	return g, nil
+}
+
+func (g grid) size() int { return len(g.bitmap) }
+
+func (g grid) dark(x, y int) bool {
+	return g.bitmap[y][x] && !image.Pt(x, y).In(g.logo)
+}
+
+// layout fits the grid plus margin into opts.Size using whole pixels per
+// module, centring the leftover.
+func layout(n int, opts Options) (modulePx, offset int, err error) {
+	total := n + 2*opts.Margin
+	modulePx = opts.Size / total
+	if modulePx < 1 {
+		return 0, 0, ErrTooSmall
+	}
+	return modulePx, (opts.Size - modulePx*n) / 2, nil
+}
+
+// dotRadius is the corner radius of rounded modules, in modules.
+const dotRadius = 0.4
+
+// Write renders data in format to w.
+func Write(w io.Writer, data, format string, opts Options) error {
+	g, err := newGrid(data, opts)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case PNG:
+		return writePNG(w, g, opts)
+	case SVG:
+		return writeSVG(w, g, opts)
+	case PDF:
+		return writePDF(w, g, opts)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
@@ -0,0 +1,59 @@
+package qr
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// svgLogoPx is the resolution the logo is embedded at; the SVG itself scales.
+const svgLogoPx = 256
+
+// writeSVG draws in module units and lets the viewer scale, so the code stays
+// sharp at any size; Size only sets the default width and height.
+func writeSVG(w io.Writer, g grid, opts Options) error {
+	if _, _, err := layout(g.size(), opts); err != nil {
+		return err
+	}
+	m := opts.Margin
+	total := g.size() + 2*m
+	var body strings.Builder
+	if opts.Rounded {
+		fmt.Fprintf(&body, `<g fill="%s">`, Hex(opts.Foreground))
+		for y := 0; y < g.size(); y++ {
+			for x := 0; x < g.size(); x++ {
+				if g.dark(x, y) {
+					fmt.Fprintf(&body, `<rect x="%d" y="%d" width="1" height="1" rx="%g"/>`, x+m, y+m, dotRadius)
+				}
+			}
+		}
+		body.WriteString(`</g>`)
+	} else {
+		var path strings.Builder
+		for y := 0; y < g.size(); y++ {
+			for x := 0; x < g.size(); {
+				if !g.dark(x, y) {
+					x++
+					continue
+				}
+				start := x
+				for x < g.size() && g.dark(x, y) {
+					x++
+				}
+				fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", start+m, y+m, x-start, x-start)
+			}
+		}
+		fmt.Fprintf(&body, `<path d="%s" fill="%s"/>`, path.String(), Hex(opts.Foreground))
+	}
+	if opts.Logo != nil {
+		logo := fit(opts.Logo, svgLogoPx, svgLogoPx)
+		fmt.Fprintf(&body, `<image x="%g" y="%g" width="%g" height="%g" preserveAspectRatio="xMidYMid meet" href="data:image/png;base64,%s"/>`,
+			float64(g.logo.Min.X+m)+0.5, float64(g.logo.Min.Y+m)+0.5, float64(g.logo.Dx())-1, float64(g.logo.Dy())-1,
+			base64.StdEncoding.EncodeToString(encodePNG(logo)))
+	}
+	_, err := fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="%d" height="%d" fill="%s"/>%s</svg>`+"\n",
+		opts.Size, opts.Size, total, total, total, total, Hex(opts.Background), body.String())
+	return err
+}
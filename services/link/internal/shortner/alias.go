@@ -0,0 +1,38 @@
+package shortner
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrAliasLength   = errors.New("alias must be 3 to 50 characters")
+	ErrAliasChars    = errors.New("alias may only contain letters, digits, '-' and '_', and must start with a letter or digit")
+	ErrAliasReserved = errors.New("alias is reserved")
+)
+
+// reservedAliases are paths the gateway or link service serve themselves.
+var reservedAliases = map[string]bool{
+	"api": true, "assets": true, "bio": true, "domains": true, "export": true, "health": true, "history": true, "import": true,
+	"index.html": true, "invitations": true, "links": true, "login": true, "logout": true,
+	"metrics": true, "privacy.html": true, "qr": true, "ready": true, "register": true, "session": true, "shorten": true, "static": true, "stats": true,
+	"tos.html": true, "vcards": true, "verify-otp": true, "workspaces": true,
+}
+
+// ValidAlias checks a caller-chosen short code (e.g. one carried over from
+// another shortener). Generated codes always pass.
+func ValidAlias(code string) error {
+	if len(code) < 3 || len(code) > 50 {
+		return ErrAliasLength
+	}
+	for i, r := range code {
+		alnum := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+		if !alnum && (i == 0 || (r != '-' && r != '_')) {
+			return ErrAliasChars
+		}
+	}
+	if reservedAliases[strings.ToLower(code)] {
+		return ErrAliasReserved
+	}
+	return nil
+}
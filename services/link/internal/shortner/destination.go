@@ -0,0 +1,35 @@
+package shortner
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrInvalidDomain = errors.New("Invalid or incomplete domain")
+	ErrSelfReference = errors.New("You cannot shorten URLs that point to this service.")
+)
+
+// NormalizeDestination adds a missing scheme and rejects URLs we refuse to shorten.
+func NormalizeDestination(raw string) (string, error) {
+	rawURL := raw
+	hasScheme := strings.Contains(rawURL, "://")
+	if !hasScheme {
+		rawURL = "http://" + rawURL
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" || !strings.Contains(parsed.Host, ".") {
+		logrus.Errorf("Invalid or incomplete domain: %v", err)
+		return "", ErrInvalidDomain
+	}
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL != "" && (strings.Contains(rawURL, baseURL) || strings.Contains(parsed.Host, strings.TrimPrefix(strings.TrimPrefix(baseURL, "http://"), "https://"))) {
+		logrus.Warnf("Attempt to shorten a URL containing BASE_URL: %s", rawURL)
+		return "", ErrSelfReference
+	}
+	return rawURL, nil
+}
@@ -0,0 +1,42 @@
+package shortner
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// DefaultRedirectCode is used when a link is created without an explicit status.
+const DefaultRedirectCode = http.StatusFound
+
+// ValidRedirectCode reports whether code is one of the redirect statuses a link may use.
+func ValidRedirectCode(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// IsPermanent reports whether clients are allowed to remember the redirect.
+func IsPermanent(code int) bool {
+	return code == http.StatusMovedPermanently || code == http.StatusPermanentRedirect
+}
+
+// CacheControl picks the Cache-Control header for a redirect.
+// Temporary redirects are never cached so every hit reaches us (and analytics).
+// Permanent redirects are only cacheable when the destination is locked;
+// an editable destination would otherwise stay stuck in browsers and proxies.
+func CacheControl(code int, locked bool) string {
+	if !IsPermanent(code) {
+		return "private, no-cache"
+	}
+	if !locked {
+		return "no-store"
+	}
+	maxAge := 86400
+	if v, err := strconv.Atoi(os.Getenv("REDIRECT_CACHE_MAX_AGE")); err == nil && v > 0 {
+		maxAge = v
+	}
+	return "public, max-age=" + strconv.Itoa(maxAge)
+}
@@ -1,12 +1,15 @@
 package shortner
 
 import (
-	"database/sql"
+	"context"
 	"errors"
 	"os"
 	"strconv"
 	"time"
 
+	"usethislink/services/internal/shorturl"
+	"usethislink/services/link/internal/store"
+
 	"github.com/cespare/xxhash"
 )
 
@@ -44,27 +47,61 @@ func toBase62(num uint64) string {
 	return string(result)
 }
 
+// LinkOptions carries the per-link settings chosen at creation time.
+// Domain is a verified custom domain, or "" for BASE_URL. WorkspaceID is ""
+// for a personal link.
+type LinkOptions struct {
+	Domain            string
+	WorkspaceID       string
+	RedirectCode      int
+	DestinationLocked bool
+	Tags              []string
+}
+
 // manage collisions
-func StoreURL(db *sql.DB, sessionID, userEmail, originalURL string) (string, error) {
+func StoreURL(ctx context.Context, st store.LinkStore, sessionID, userEmail, originalURL string, opts LinkOptions) (string, error) {
 
-	baseURL := os.Getenv("BASE_URL")
-	if baseURL == "" {
+	if opts.Domain == "" && os.Getenv("BASE_URL") == "" {
 		return "", errors.New("BASE_URL not set")
 	}
+	if opts.RedirectCode == 0 {
+		opts.RedirectCode = DefaultRedirectCode
+	}
+	if !ValidRedirectCode(opts.RedirectCode) {
+		return "", errors.New("unsupported redirect code")
+	}
 
+	l := store.Link{
+		Domain:            opts.Domain,
+		OriginalURL:       originalURL,
+		SessionID:         sessionID,
+		UserEmail:         userEmail,
+		ExpiresAt:         time.Now().Add(48 * time.Hour),
+		RedirectCode:      opts.RedirectCode,
+		DestinationLocked: opts.DestinationLocked,
+		WorkspaceID:       opts.WorkspaceID,
+		Tags:              opts.Tags,
+	}
+	code, err := StoreGenerated(ctx, st, l)
+	if err != nil {
+		return "", err
+	}
+	// return the full short URL, on the link's own domain
+	return shorturl.Build(opts.Domain, code), nil
+}
+
+// StoreGenerated inserts l under a code derived from its destination,
+// retrying on collisions, and returns the code. l.ShortCode is ignored.
+func StoreGenerated(ctx context.Context, st store.LinkStore, l store.Link) (string, error) {
 	//TODO: need a way to test and make robust
 	for i := 0; i < 5; i++ {
-		shortcode := generateShortURL(originalURL + strconv.Itoa(i))
-		expiry := time.Now().Add(48 * time.Hour).Format("2006-01-02 15:04:05")
-		_, err := db.Exec(
-			`INSERT OR IGNORE INTO url_mappings 
-			(short_url, original_url, session_id, user_email, expiry_date, is_logged_in) 
-			VALUES (?, ?, ?, ?, ?, ?)`,
-			shortcode, originalURL, sessionID, userEmail, expiry, false)
-
+		l.ShortCode = generateShortURL(l.OriginalURL + strconv.Itoa(i))
+		err := st.CreateLink(ctx, l)
 		if err == nil {
-			// return the full short URL to the user
-			return baseURL + "/" + shortcode, nil
+			return l.ShortCode, nil
+		}
+		if err != store.ErrConflict {
+			return "", err
 		}
 	}
 	return "", errors.New("failed to generate unique short URL")
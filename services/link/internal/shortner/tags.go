@@ -0,0 +1,44 @@
+package shortner
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+const (
+	maxTags   = 10
+	maxTagLen = 32
+)
+
+var (
+	ErrTooManyTags = errors.New("a link may have at most 10 tags")
+	ErrTagLength   = errors.New("tags must be 1 to 32 characters")
+	ErrTagChars    = errors.New("tags may not contain commas or control characters")
+)
+
+// NormalizeTags trims and lowercases tags and drops empty and repeated ones,
+// keeping the caller's order. Commas are refused since tags are stored
+// comma-separated.
+func NormalizeTags(raw []string) ([]string, error) {
+	var tags []string
+	seen := map[string]bool{}
+	for _, t := range raw {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		if len([]rune(t)) > maxTagLen {
+			return nil, ErrTagLength
+		}
+		if strings.ContainsFunc(t, func(r rune) bool { return r == ',' || unicode.IsControl(r) }) {
+			return nil, ErrTagChars
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	if len(tags) > maxTags {
+		return nil, ErrTooManyTags
+	}
+	return tags, nil
+}
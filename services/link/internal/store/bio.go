@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// BioPage is a link-in-bio page published at /@{handle}. Personal pages have
+// OwnerEmail set; workspace pages have WorkspaceID set and OwnerEmail "".
+type BioPage struct {
+	Handle      string
+	OwnerEmail  string
+	WorkspaceID string
+	Title       string
+	Description string
+	Theme       BioTheme
+	Links       []BioLink // in display order
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// BioTheme holds a page's hex RGB colours.
+type BioTheme struct {
+	Background       string
+	Foreground       string
+	ButtonBackground string
+	ButtonForeground string
+}
+
+// BioLink is one button on a bio page, pointing at one of the owner's links.
+type BioLink struct {
+	Domain    string
+	ShortCode string
+	Title     string
+	Icon      string // Font Awesome icon name, "" for none
+}
+
+// BioPageStore persists bio pages. Handles are unique and stored lowercase.
+type BioPageStore interface {
+	// CreateBioPage returns ErrConflict if the handle is taken.
+	CreateBioPage(ctx context.Context, p BioPage) error
+	GetBioPage(ctx context.Context, handle string) (BioPage, error)
+	// ListBioPages lists a workspace's pages, or with workspaceID "" the
+	// personal pages of ownerEmail.
+	ListBioPages(ctx context.Context, ownerEmail, workspaceID string) ([]BioPage, error)
+	// UpdateBioPage replaces everything but the handle and owner, links included.
+	UpdateBioPage(ctx context.Context, p BioPage) error
+	DeleteBioPage(ctx context.Context, handle string) error
+}
@@ -0,0 +1,568 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"usethislink/services/internal/workspace"
+)
+
+// MemoryStore is an in-process LinkStore for tests and throwaway instances.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	links   map[LinkKey]Link
+	blocks  []ScanBlock
+	domains map[domainClaim]Domain
+
+	workspaces  map[string]Workspace
+	members     map[string]map[string]Member // workspace ID -> email
+	invitations map[string]Invitation
+
+	qrPresets map[string]QRPreset
+	vcards    map[string]HostedVCard
+	bioPages  map[string]BioPage
+}
+
+type domainClaim struct{ host, owner string }
+
+func NewMemory() *MemoryStore {
+	return &MemoryStore{
+		links:       make(map[LinkKey]Link),
+		domains:     make(map[domainClaim]Domain),
+		workspaces:  make(map[string]Workspace),
+		members:     make(map[string]map[string]Member),
+		invitations: make(map[string]Invitation),
+		qrPresets:   make(map[string]QRPreset),
+		vcards:      make(map[string]HostedVCard),
+		bioPages:    make(map[string]BioPage),
+	}
+}
+
+func (m *MemoryStore) CreateLink(ctx context.Context, l Link) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.links[l.Key()]; ok {
+		return ErrConflict
+	}
+	if l.CreatedAt.IsZero() {
+		l.CreatedAt = time.Now().UTC()
+	}
+	l.Tags = slices.Clone(l.Tags)
+	m.links[l.Key()] = l
+	return nil
+}
+
+func (m *MemoryStore) GetActiveLink(ctx context.Context, key LinkKey) (Link, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	l, ok := m.links[key]
+	if !ok || (!l.ExpiresAt.IsZero() && !l.ExpiresAt.After(time.Now())) {
+		return Link{}, ErrNotFound
+	}
+	return l, nil
+}
+
+func (m *MemoryStore) GetLink(ctx context.Context, key LinkKey) (Link, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	l, ok := m.links[key]
+	if !ok {
+		return Link{}, ErrNotFound
+	}
+	return l, nil
+}
+
+func (m *MemoryStore) UpdateLink(ctx context.Context, l Link) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.links[l.Key()]
+	if !ok {
+		return ErrNotFound
+	}
+	cur.OriginalURL = l.OriginalURL
+	cur.RedirectCode = l.RedirectCode
+	cur.DestinationLocked = l.DestinationLocked
+	cur.WorkspaceID = l.WorkspaceID
+	cur.UserEmail = l.UserEmail
+	cur.Tags = slices.Clone(l.Tags)
+	m.links[l.Key()] = cur
+	return nil
+}
+
+func (m *MemoryStore) DeleteLink(ctx context.Context, key LinkKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.links[key]; !ok {
+		return ErrNotFound
+	}
+	delete(m.links, key)
+	return nil
+}
+
+func (m *MemoryStore) CountLinks(ctx context.Context) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.links), nil
+}
+
+func (m *MemoryStore) EachLinkKey(ctx context.Context, fn func(key LinkKey) error) error {
+	m.mu.RLock()
+	keys := make([]LinkKey, 0, len(m.links))
+	for key := range m.links {
+		keys = append(keys, key)
+	}
+	m.mu.RUnlock()
+	for _, key := range keys {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) AddVisits(ctx context.Context, counts map[LinkKey]int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, n := range counts {
+		if l, ok := m.links[key]; ok {
+			l.Visits += n
+			m.links[key] = l
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) RecordScanBlock(ctx context.Context, b ScanBlock) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blocks = append(m.blocks, b)
+	return nil
+}
+
+func (m *MemoryStore) CreateDomain(ctx context.Context, d Domain, staleBefore time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, other := range m.domains {
+		if !other.Verified() && other.CreatedAt.Before(staleBefore) {
+			delete(m.domains, k)
+		}
+	}
+	for k, other := range m.domains {
+		if k.host == d.Host && (other.Verified() || k.owner == d.OwnerEmail) {
+			return ErrConflict
+		}
+	}
+	d.CreatedAt = time.Now().UTC()
+	d.VerifiedAt = time.Time{}
+	m.domains[domainClaim{d.Host, d.OwnerEmail}] = d
+	return nil
+}
+
+func (m *MemoryStore) GetDomain(ctx context.Context, host string) (Domain, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for k, d := range m.domains {
+		if k.host == host && d.Verified() {
+			return d, nil
+		}
+	}
+	return Domain{}, ErrNotFound
+}
+
+func (m *MemoryStore) GetDomainClaim(ctx context.Context, host, ownerEmail string) (Domain, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d, ok := m.domains[domainClaim{host, ownerEmail}]
+	if !ok {
+		return Domain{}, ErrNotFound
+	}
+	return d, nil
+}
+
+func (m *MemoryStore) ListDomains(ctx context.Context, ownerEmail, workspaceID string) ([]Domain, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var domains []Domain
+	for _, d := range m.domains {
+		if (workspaceID != "" && d.WorkspaceID == workspaceID) || (workspaceID == "" && d.WorkspaceID == "" && d.OwnerEmail == ownerEmail) {
+			domains = append(domains, d)
+		}
+	}
+	sort.Slice(domains, func(i, j int) bool { return domains[i].Host < domains[j].Host })
+	return domains, nil
+}
+
+func (m *MemoryStore) MarkDomainVerified(ctx context.Context, host, ownerEmail string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, other := range m.domains {
+		if k.host == host && other.Verified() && k.owner != ownerEmail {
+			return ErrConflict
+		}
+	}
+	key := domainClaim{host, ownerEmail}
+	d, ok := m.domains[key]
+	if !ok {
+		return ErrNotFound
+	}
+	d.VerifiedAt = at.UTC()
+	m.domains[key] = d
+	for k, other := range m.domains {
+		if k.host == host && !other.Verified() {
+			delete(m.domains, k)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteDomain(ctx context.Context, host, ownerEmail string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := domainClaim{host, ownerEmail}
+	d, ok := m.domains[key]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(m.domains, key)
+	if d.Verified() {
+		for k := range m.links {
+			if k.Domain == host {
+				delete(m.links, k)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreateWorkspace(ctx context.Context, ws Workspace, ownerEmail string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.workspaces[ws.ID]; ok {
+		return ErrConflict
+	}
+	now := time.Now().UTC()
+	ws.CreatedAt = now
+	m.workspaces[ws.ID] = ws
+	m.members[ws.ID] = map[string]Member{
+		ownerEmail: {WorkspaceID: ws.ID, UserEmail: ownerEmail, Role: workspace.Owner, AddedAt: now},
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetWorkspace(ctx context.Context, id string) (Workspace, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ws, ok := m.workspaces[id]
+	if !ok {
+		return Workspace{}, ErrNotFound
+	}
+	return ws, nil
+}
+
+func (m *MemoryStore) ListWorkspaces(ctx context.Context, email string) ([]Membership, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var list []Membership
+	for id, members := range m.members {
+		if mem, ok := members[email]; ok {
+			list = append(list, Membership{Workspace: m.workspaces[id], Role: mem.Role})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Workspace.Name < list[j].Workspace.Name })
+	return list, nil
+}
+
+func (m *MemoryStore) DeleteWorkspace(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.workspaces[id]; !ok {
+		return ErrNotFound
+	}
+	for _, l := range m.links {
+		if l.WorkspaceID == id {
+			return ErrConflict
+		}
+	}
+	delete(m.workspaces, id)
+	delete(m.members, id)
+	for invID, inv := range m.invitations {
+		if inv.WorkspaceID == id {
+			delete(m.invitations, invID)
+		}
+	}
+	for presetID, p := range m.qrPresets {
+		if p.WorkspaceID == id {
+			delete(m.qrPresets, presetID)
+		}
+	}
+	for handle, p := range m.bioPages {
+		if p.WorkspaceID == id {
+			delete(m.bioPages, handle)
+		}
+	}
+	for k, d := range m.domains {
+		if d.WorkspaceID == id {
+			delete(m.domains, k)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) ListMembers(ctx context.Context, workspaceID string) ([]Member, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var members []Member
+	for _, mem := range m.members[workspaceID] {
+		members = append(members, mem)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].UserEmail < members[j].UserEmail })
+	return members, nil
+}
+
+func (m *MemoryStore) MemberRole(ctx context.Context, workspaceID, email string) (workspace.Role, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	mem, ok := m.members[workspaceID][email]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return mem.Role, nil
+}
+
+func (m *MemoryStore) SetMember(ctx context.Context, mem Member) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setMember(mem)
+	return nil
+}
+
+func (m *MemoryStore) setMember(mem Member) {
+	members, ok := m.members[mem.WorkspaceID]
+	if !ok {
+		members = make(map[string]Member)
+		m.members[mem.WorkspaceID] = members
+	}
+	if cur, ok := members[mem.UserEmail]; ok {
+		cur.Role = mem.Role
+		members[mem.UserEmail] = cur
+		return
+	}
+	mem.AddedAt = time.Now().UTC()
+	members[mem.UserEmail] = mem
+}
+
+func (m *MemoryStore) RemoveMember(ctx context.Context, workspaceID, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.members[workspaceID][email]; !ok {
+		return ErrNotFound
+	}
+	delete(m.members[workspaceID], email)
+	return nil
+}
+
+func (m *MemoryStore) CreateInvitation(ctx context.Context, inv Invitation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.invitations[inv.ID]; ok {
+		return ErrConflict
+	}
+	inv.CreatedAt = time.Now().UTC()
+	m.invitations[inv.ID] = inv
+	return nil
+}
+
+func (m *MemoryStore) GetInvitation(ctx context.Context, id string) (Invitation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	inv, ok := m.invitations[id]
+	if !ok {
+		return Invitation{}, ErrNotFound
+	}
+	return inv, nil
+}
+
+func (m *MemoryStore) PendingInvitations(ctx context.Context, workspaceID, email string) ([]Invitation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	var list []Invitation
+	for _, inv := range m.invitations {
+		if !inv.AcceptedAt.IsZero() || !inv.ExpiresAt.After(now) {
+			continue
+		}
+		if (workspaceID != "" && inv.WorkspaceID == workspaceID) || (workspaceID == "" && inv.Email == email) {
+			list = append(list, inv)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
+
+func (m *MemoryStore) AcceptInvitation(ctx context.Context, inv Invitation, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.invitations[inv.ID]
+	if !ok || !cur.AcceptedAt.IsZero() {
+		return ErrNotFound
+	}
+	cur.AcceptedAt = at.UTC()
+	m.invitations[inv.ID] = cur
+	// An existing member keeps the higher of the two roles.
+	if mem, ok := m.members[inv.WorkspaceID][inv.Email]; ok && mem.Role.AtLeast(inv.Role) {
+		return nil
+	}
+	m.setMember(Member{WorkspaceID: inv.WorkspaceID, UserEmail: inv.Email, Role: inv.Role})
+	return nil
+}
+
+func (m *MemoryStore) DeleteInvitation(ctx context.Context, workspaceID, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inv, ok := m.invitations[id]
+	if !ok || inv.WorkspaceID != workspaceID {
+		return ErrNotFound
+	}
+	delete(m.invitations, id)
+	return nil
+}
+
+func (m *MemoryStore) CreateQRPreset(ctx context.Context, p QRPreset) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, other := range m.qrPresets {
+		if other.ID == p.ID || (other.OwnerEmail == p.OwnerEmail && other.WorkspaceID == p.WorkspaceID && other.Name == p.Name) {
+			return ErrConflict
+		}
+	}
+	p.CreatedAt = time.Now().UTC()
+	m.qrPresets[p.ID] = p
+	return nil
+}
+
+func (m *MemoryStore) GetQRPreset(ctx context.Context, id string) (QRPreset, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.qrPresets[id]
+	if !ok {
+		return QRPreset{}, ErrNotFound
+	}
+	return p, nil
+}
+
+func (m *MemoryStore) ListQRPresets(ctx context.Context, ownerEmail, workspaceID string) ([]QRPreset, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if workspaceID != "" {
+		ownerEmail = ""
+	}
+	var list []QRPreset
+	for _, p := range m.qrPresets {
+		if p.OwnerEmail == ownerEmail && p.WorkspaceID == workspaceID {
+			list = append(list, p)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func (m *MemoryStore) DeleteQRPreset(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.qrPresets[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.qrPresets, id)
+	return nil
+}
+
+func (m *MemoryStore) CreateVCard(ctx context.Context, v HostedVCard) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.vcards[v.ID]; ok {
+		return ErrConflict
+	}
+	v.CreatedAt = time.Now().UTC()
+	m.vcards[v.ID] = v
+	return nil
+}
+
+func (m *MemoryStore) GetVCard(ctx context.Context, id string) (HostedVCard, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	v, ok := m.vcards[id]
+	if !ok {
+		return HostedVCard{}, ErrNotFound
+	}
+	return v, nil
+}
+
+func (m *MemoryStore) CreateBioPage(ctx context.Context, p BioPage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.bioPages[p.Handle]; ok {
+		return ErrConflict
+	}
+	p.CreatedAt = time.Now().UTC()
+	p.UpdatedAt = p.CreatedAt
+	p.Links = append([]BioLink(nil), p.Links...)
+	m.bioPages[p.Handle] = p
+	return nil
+}
+
+func (m *MemoryStore) GetBioPage(ctx context.Context, handle string) (BioPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.bioPages[handle]
+	if !ok {
+		return BioPage{}, ErrNotFound
+	}
+	p.Links = append([]BioLink(nil), p.Links...)
+	return p, nil
+}
+
+func (m *MemoryStore) ListBioPages(ctx context.Context, ownerEmail, workspaceID string) ([]BioPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if workspaceID != "" {
+		ownerEmail = ""
+	}
+	var list []BioPage
+	for _, p := range m.bioPages {
+		if p.OwnerEmail == ownerEmail && p.WorkspaceID == workspaceID {
+			p.Links = nil
+			list = append(list, p)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Handle < list[j].Handle })
+	return list, nil
+}
+
+func (m *MemoryStore) UpdateBioPage(ctx context.Context, p BioPage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.bioPages[p.Handle]
+	if !ok {
+		return ErrNotFound
+	}
+	old.Title = p.Title
+	old.Description = p.Description
+	old.Theme = p.Theme
+	old.Links = append([]BioLink(nil), p.Links...)
+	old.UpdatedAt = time.Now().UTC()
+	m.bioPages[p.Handle] = old
+	return nil
+}
+
+func (m *MemoryStore) DeleteBioPage(ctx context.Context, handle string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.bioPages[handle]; !ok {
+		return ErrNotFound
+	}
+	delete(m.bioPages, handle)
+	return nil
+}
@@ -0,0 +1,33 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// QRPreset is a saved QR code style. Personal presets have OwnerEmail set;
+// workspace presets have WorkspaceID set and OwnerEmail "".
+type QRPreset struct {
+	ID          string
+	OwnerEmail  string
+	WorkspaceID string
+	Name        string
+	Foreground  string // hex RGB
+	Background  string
+	Level       string // L, M, Q or H
+	Margin      int
+	Rounded     bool
+	Logo        []byte // PNG or JPEG, nil for none
+	CreatedAt   time.Time
+}
+
+// QRPresetStore persists QR presets.
+type QRPresetStore interface {
+	// CreateQRPreset returns ErrConflict if the name is taken in its scope.
+	CreateQRPreset(ctx context.Context, p QRPreset) error
+	GetQRPreset(ctx context.Context, id string) (QRPreset, error)
+	// ListQRPresets lists a workspace's presets, or with workspaceID "" the
+	// personal presets of ownerEmail.
+	ListQRPresets(ctx context.Context, ownerEmail, workspaceID string) ([]QRPreset, error)
+	DeleteQRPreset(ctx context.Context, id string) error
+}
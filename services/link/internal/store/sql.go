@@ -0,0 +1,776 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"usethislink/services/internal/storage"
+	"usethislink/services/internal/workspace"
+)
+
+// SQLStore implements LinkStore on Postgres or SQLite. Queries are written
+// once with `?` placeholders and rebound for the dialect.
+type SQLStore struct {
+	db      *sql.DB
+	dialect storage.Dialect
+}
+
+func NewPostgres(db *sql.DB) *SQLStore { return &SQLStore{db: db, dialect: storage.Postgres} }
+
+func NewSQLite(db *sql.DB) *SQLStore { return &SQLStore{db: db, dialect: storage.SQLite} }
+
+// New picks the implementation matching dialect.
+func New(db *sql.DB, dialect storage.Dialect) *SQLStore {
+	return &SQLStore{db: db, dialect: dialect}
+}
+
+func (s *SQLStore) q(query string) string { return s.dialect.Rebind(query) }
+
+const linkColumns = `domain, short_url, original_url, COALESCE(session_id, ''), COALESCE(user_email, ''), COALESCE(visits, 0),
+	created_at, expiry_date, COALESCE(is_logged_in, FALSE), COALESCE(redirect_code, 302), COALESCE(destination_locked, FALSE), workspace_id, tags`
+
+func scanLink(row interface{ Scan(...any) error }) (Link, error) {
+	var l Link
+	var created, expiry sql.NullTime
+	var tags string
+	err := row.Scan(&l.Domain, &l.ShortCode, &l.OriginalURL, &l.SessionID, &l.UserEmail, &l.Visits,
+		&created, &expiry, &l.IsLoggedIn, &l.RedirectCode, &l.DestinationLocked, &l.WorkspaceID, &tags)
+	if err == sql.ErrNoRows {
+		return l, ErrNotFound
+	}
+	l.CreatedAt = created.Time
+	l.ExpiresAt = expiry.Time
+	l.Tags = splitTags(tags)
+	return l, err
+}
+
+// splitTags reverses the comma-joined form tags are stored in.
+func splitTags(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func (s *SQLStore) CreateLink(ctx context.Context, l Link) error {
+	var expiry any
+	if !l.ExpiresAt.IsZero() {
+		expiry = l.ExpiresAt.UTC()
+	}
+	// Imported links keep their original creation date and click count.
+	created := l.CreatedAt
+	if created.IsZero() {
+		created = time.Now()
+	}
+	// Codes are unique per domain through the (domain, short_url) primary key;
+	// both dialects accept a bare ON CONFLICT DO NOTHING.
+	res, err := s.db.ExecContext(ctx, s.q(`
+		INSERT INTO url_mappings
+		(domain, short_url, original_url, session_id, user_email, expiry_date, is_logged_in, redirect_code, destination_locked, workspace_id, tags, created_at, visits)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`),
+		l.Domain, l.ShortCode, l.OriginalURL, l.SessionID, l.UserEmail, expiry, l.IsLoggedIn, l.RedirectCode, l.DestinationLocked, l.WorkspaceID,
+		strings.Join(l.Tags, ","), created.UTC(), l.Visits)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (s *SQLStore) GetActiveLink(ctx context.Context, key LinkKey) (Link, error) {
+	return scanLink(s.db.QueryRowContext(ctx, s.q(`
+		SELECT `+linkColumns+` FROM url_mappings
+		WHERE domain = ? AND short_url = ? AND (expiry_date IS NULL OR expiry_date > ?)`),
+		key.Domain, key.Code, time.Now().UTC()))
+}
+
+func (s *SQLStore) GetLink(ctx context.Context, key LinkKey) (Link, error) {
+	return scanLink(s.db.QueryRowContext(ctx, s.q(`
+		SELECT `+linkColumns+` FROM url_mappings WHERE domain = ? AND short_url = ?`),
+		key.Domain, key.Code))
+}
+
+func (s *SQLStore) UpdateLink(ctx context.Context, l Link) error {
+	res, err := s.db.ExecContext(ctx, s.q(`
+		UPDATE url_mappings SET original_url = ?, redirect_code = ?, destination_locked = ?, workspace_id = ?, user_email = ?, tags = ?
+		WHERE domain = ? AND short_url = ?`),
+		l.OriginalURL, l.RedirectCode, l.DestinationLocked, l.WorkspaceID, l.UserEmail, strings.Join(l.Tags, ","), l.Domain, l.ShortCode)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) DeleteLink(ctx context.Context, key LinkKey) error {
+	res, err := s.db.ExecContext(ctx, s.q(`DELETE FROM url_mappings WHERE domain = ? AND short_url = ?`),
+		key.Domain, key.Code)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) CountLinks(ctx context.Context) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM url_mappings`).Scan(&n)
+	return n, err
+}
+
+func (s *SQLStore) EachLinkKey(ctx context.Context, fn func(key LinkKey) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT domain, short_url FROM url_mappings`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key LinkKey
+		if err := rows.Scan(&key.Domain, &key.Code); err != nil {
+			return err
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SQLStore) AddVisits(ctx context.Context, counts map[LinkKey]int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, s.q(`UPDATE url_mappings SET visits = COALESCE(visits, 0) + ? WHERE domain = ? AND short_url = ?`))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for key, n := range counts {
+		if _, err := stmt.ExecContext(ctx, n, key.Domain, key.Code); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) RecordScanBlock(ctx context.Context, b ScanBlock) error {
+	_, err := s.db.ExecContext(ctx, s.q(`
+		INSERT INTO scan_blocks (ip_address, not_found_count, strikes, last_shortcode, blocked_until)
+		VALUES (?, ?, ?, ?, ?)`),
+		b.IPAddress, b.NotFoundCount, b.Strikes, b.LastShortcode, b.BlockedUntil.UTC())
+	return err
+}
+
+const domainColumns = `host, owner_email, workspace_id, verification_token, verified_at, created_at`
+
+func scanDomain(row interface{ Scan(...any) error }) (Domain, error) {
+	var d Domain
+	var verified, created sql.NullTime
+	err := row.Scan(&d.Host, &d.OwnerEmail, &d.WorkspaceID, &d.VerificationToken, &verified, &created)
+	if err == sql.ErrNoRows {
+		return d, ErrNotFound
+	}
+	d.VerifiedAt = verified.Time
+	d.CreatedAt = created.Time
+	return d, err
+}
+
+func (s *SQLStore) CreateDomain(ctx context.Context, d Domain, staleBefore time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, s.q(`DELETE FROM domains WHERE verified_at IS NULL AND created_at < ?`), staleBefore.UTC()); err != nil {
+		return err
+	}
+	var n int
+	err = tx.QueryRowContext(ctx, s.q(`
+		SELECT COUNT(*) FROM domains WHERE host = ? AND (verified_at IS NOT NULL OR owner_email = ?)`),
+		d.Host, d.OwnerEmail).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrConflict
+	}
+	_, err = tx.ExecContext(ctx, s.q(`
+		INSERT INTO domains (host, owner_email, workspace_id, verification_token, created_at)
+		VALUES (?, ?, ?, ?, ?)`),
+		d.Host, d.OwnerEmail, d.WorkspaceID, d.VerificationToken, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) GetDomain(ctx context.Context, host string) (Domain, error) {
+	return scanDomain(s.db.QueryRowContext(ctx, s.q(`
+		SELECT `+domainColumns+` FROM domains WHERE host = ? AND verified_at IS NOT NULL`), host))
+}
+
+func (s *SQLStore) GetDomainClaim(ctx context.Context, host, ownerEmail string) (Domain, error) {
+	return scanDomain(s.db.QueryRowContext(ctx, s.q(`
+		SELECT `+domainColumns+` FROM domains WHERE host = ? AND owner_email = ?`), host, ownerEmail))
+}
+
+func (s *SQLStore) ListDomains(ctx context.Context, ownerEmail, workspaceID string) ([]Domain, error) {
+	query, arg := `SELECT `+domainColumns+` FROM domains WHERE owner_email = ? AND workspace_id = '' ORDER BY host`, ownerEmail
+	if workspaceID != "" {
+		query, arg = `SELECT `+domainColumns+` FROM domains WHERE workspace_id = ? ORDER BY host`, workspaceID
+	}
+	rows, err := s.db.QueryContext(ctx, s.q(query), arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var domains []Domain
+	for rows.Next() {
+		d, err := scanDomain(rows)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, d)
+	}
+	return domains, rows.Err()
+}
+
+func (s *SQLStore) MarkDomainVerified(ctx context.Context, host, ownerEmail string, at time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var verifiedBy string
+	err = tx.QueryRowContext(ctx, s.q(`
+		SELECT owner_email FROM domains WHERE host = ? AND verified_at IS NOT NULL`), host).Scan(&verifiedBy)
+	if err == nil && verifiedBy != ownerEmail {
+		return ErrConflict
+	} else if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, s.q(`
+		UPDATE domains SET verified_at = ? WHERE host = ? AND owner_email = ?`), at.UTC(), host, ownerEmail)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, s.q(`DELETE FROM domains WHERE host = ? AND verified_at IS NULL`), host); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) DeleteDomain(ctx context.Context, host, ownerEmail string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	d, err := scanDomain(tx.QueryRowContext(ctx, s.q(`
+		SELECT `+domainColumns+` FROM domains WHERE host = ? AND owner_email = ?`), host, ownerEmail))
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.q(`DELETE FROM domains WHERE host = ? AND owner_email = ?`), host, ownerEmail); err != nil {
+		return err
+	}
+	// Only the verified claim can have links; an abandoned claim must not
+	// take the real owner's links with it.
+	if d.Verified() {
+		if _, err := tx.ExecContext(ctx, s.q(`DELETE FROM url_mappings WHERE domain = ?`), host); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) CreateWorkspace(ctx context.Context, ws Workspace, ownerEmail string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	res, err := tx.ExecContext(ctx, s.q(`
+		INSERT INTO workspaces (id, name, created_by, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`), ws.ID, ws.Name, ws.CreatedBy, now)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrConflict
+	}
+	_, err = tx.ExecContext(ctx, s.q(`
+		INSERT INTO workspace_members (workspace_id, user_email, role, added_at) VALUES (?, ?, ?, ?)`),
+		ws.ID, ownerEmail, workspace.Owner, now)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) GetWorkspace(ctx context.Context, id string) (Workspace, error) {
+	var ws Workspace
+	var created sql.NullTime
+	err := s.db.QueryRowContext(ctx, s.q(`SELECT id, name, created_by, created_at FROM workspaces WHERE id = ?`), id).
+		Scan(&ws.ID, &ws.Name, &ws.CreatedBy, &created)
+	if err == sql.ErrNoRows {
+		return ws, ErrNotFound
+	}
+	ws.CreatedAt = created.Time
+	return ws, err
+}
+
+func (s *SQLStore) ListWorkspaces(ctx context.Context, email string) ([]Membership, error) {
+	rows, err := s.db.QueryContext(ctx, s.q(`
+		SELECT w.id, w.name, w.created_by, w.created_at, m.role
+		FROM workspace_members m JOIN workspaces w ON w.id = m.workspace_id
+		WHERE m.user_email = ? ORDER BY w.name`), email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []Membership
+	for rows.Next() {
+		var m Membership
+		var created sql.NullTime
+		if err := rows.Scan(&m.Workspace.ID, &m.Workspace.Name, &m.Workspace.CreatedBy, &created, &m.Role); err != nil {
+			return nil, err
+		}
+		m.Workspace.CreatedAt = created.Time
+		list = append(list, m)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLStore) DeleteWorkspace(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var links int
+	if err := tx.QueryRowContext(ctx, s.q(`SELECT COUNT(*) FROM url_mappings WHERE workspace_id = ?`), id).Scan(&links); err != nil {
+		return err
+	}
+	if links > 0 {
+		return ErrConflict
+	}
+	for _, query := range []string{
+		`DELETE FROM workspace_invitations WHERE workspace_id = ?`,
+		`DELETE FROM workspace_members WHERE workspace_id = ?`,
+		`DELETE FROM qr_presets WHERE workspace_id = ?`,
+		`DELETE FROM bio_links WHERE handle IN (SELECT handle FROM bio_pages WHERE workspace_id = ?)`,
+		`DELETE FROM bio_pages WHERE workspace_id = ?`,
+		`DELETE FROM domains WHERE workspace_id = ?`,
+	} {
+		if _, err := tx.ExecContext(ctx, s.q(query), id); err != nil {
+			return err
+		}
+	}
+	res, err := tx.ExecContext(ctx, s.q(`DELETE FROM workspaces WHERE id = ?`), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) ListMembers(ctx context.Context, workspaceID string) ([]Member, error) {
+	rows, err := s.db.QueryContext(ctx, s.q(`
+		SELECT workspace_id, user_email, role, added_at FROM workspace_members
+		WHERE workspace_id = ? ORDER BY user_email`), workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var members []Member
+	for rows.Next() {
+		var m Member
+		var added sql.NullTime
+		if err := rows.Scan(&m.WorkspaceID, &m.UserEmail, &m.Role, &added); err != nil {
+			return nil, err
+		}
+		m.AddedAt = added.Time
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+func (s *SQLStore) MemberRole(ctx context.Context, workspaceID, email string) (workspace.Role, error) {
+	var role workspace.Role
+	err := s.db.QueryRowContext(ctx, s.q(`SELECT role FROM workspace_members WHERE workspace_id = ? AND user_email = ?`),
+		workspaceID, email).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return role, err
+}
+
+func (s *SQLStore) SetMember(ctx context.Context, m Member) error {
+	_, err := s.db.ExecContext(ctx, s.q(`
+		INSERT INTO workspace_members (workspace_id, user_email, role, added_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (workspace_id, user_email) DO UPDATE SET role = EXCLUDED.role`),
+		m.WorkspaceID, m.UserEmail, m.Role, time.Now().UTC())
+	return err
+}
+
+func (s *SQLStore) RemoveMember(ctx context.Context, workspaceID, email string) error {
+	res, err := s.db.ExecContext(ctx, s.q(`DELETE FROM workspace_members WHERE workspace_id = ? AND user_email = ?`),
+		workspaceID, email)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const invitationColumns = `id, workspace_id, email, role, invited_by, created_at, expires_at, accepted_at`
+
+func scanInvitation(row interface{ Scan(...any) error }) (Invitation, error) {
+	var inv Invitation
+	var created, expires, accepted sql.NullTime
+	err := row.Scan(&inv.ID, &inv.WorkspaceID, &inv.Email, &inv.Role, &inv.InvitedBy, &created, &expires, &accepted)
+	if err == sql.ErrNoRows {
+		return inv, ErrNotFound
+	}
+	inv.CreatedAt = created.Time
+	inv.ExpiresAt = expires.Time
+	inv.AcceptedAt = accepted.Time
+	return inv, err
+}
+
+func (s *SQLStore) CreateInvitation(ctx context.Context, inv Invitation) error {
+	_, err := s.db.ExecContext(ctx, s.q(`
+		INSERT INTO workspace_invitations (id, workspace_id, email, role, invited_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`),
+		inv.ID, inv.WorkspaceID, inv.Email, inv.Role, inv.InvitedBy, time.Now().UTC(), inv.ExpiresAt.UTC())
+	return err
+}
+
+func (s *SQLStore) GetInvitation(ctx context.Context, id string) (Invitation, error) {
+	return scanInvitation(s.db.QueryRowContext(ctx, s.q(`SELECT `+invitationColumns+` FROM workspace_invitations WHERE id = ?`), id))
+}
+
+func (s *SQLStore) PendingInvitations(ctx context.Context, workspaceID, email string) ([]Invitation, error) {
+	where, arg := `email = ?`, email
+	if workspaceID != "" {
+		where, arg = `workspace_id = ?`, workspaceID
+	}
+	rows, err := s.db.QueryContext(ctx, s.q(`
+		SELECT `+invitationColumns+` FROM workspace_invitations
+		WHERE `+where+` AND accepted_at IS NULL AND expires_at > ?
+		ORDER BY created_at`), arg, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []Invitation
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, inv)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLStore) AcceptInvitation(ctx context.Context, inv Invitation, at time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, s.q(`
+		UPDATE workspace_invitations SET accepted_at = ? WHERE id = ? AND accepted_at IS NULL`), at.UTC(), inv.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	// An existing member keeps the higher of the two roles.
+	role, err := s.memberRoleTx(ctx, tx, inv.WorkspaceID, inv.Email)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if !role.AtLeast(inv.Role) {
+		_, err = tx.ExecContext(ctx, s.q(`
+			INSERT INTO workspace_members (workspace_id, user_email, role, added_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT (workspace_id, user_email) DO UPDATE SET role = EXCLUDED.role`),
+			inv.WorkspaceID, inv.Email, inv.Role, at.UTC())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) memberRoleTx(ctx context.Context, tx *sql.Tx, workspaceID, email string) (workspace.Role, error) {
+	var role workspace.Role
+	err := tx.QueryRowContext(ctx, s.q(`SELECT role FROM workspace_members WHERE workspace_id = ? AND user_email = ?`),
+		workspaceID, email).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return role, err
+}
+
+func (s *SQLStore) DeleteInvitation(ctx context.Context, workspaceID, id string) error {
+	res, err := s.db.ExecContext(ctx, s.q(`DELETE FROM workspace_invitations WHERE workspace_id = ? AND id = ?`), workspaceID, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const qrPresetColumns = `id, owner_email, workspace_id, name, foreground, background, level, margin, rounded, logo, created_at`
+
+func scanQRPreset(row interface{ Scan(...any) error }) (QRPreset, error) {
+	var p QRPreset
+	var created sql.NullTime
+	err := row.Scan(&p.ID, &p.OwnerEmail, &p.WorkspaceID, &p.Name, &p.Foreground, &p.Background, &p.Level, &p.Margin, &p.Rounded, &p.Logo, &created)
+	if err == sql.ErrNoRows {
+		return p, ErrNotFound
+	}
+	p.CreatedAt = created.Time
+	return p, err
+}
+
+func (s *SQLStore) CreateQRPreset(ctx context.Context, p QRPreset) error {
+	res, err := s.db.ExecContext(ctx, s.q(`
+		INSERT INTO qr_presets (`+qrPresetColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`),
+		p.ID, p.OwnerEmail, p.WorkspaceID, p.Name, p.Foreground, p.Background, p.Level, p.Margin, p.Rounded, p.Logo, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (s *SQLStore) GetQRPreset(ctx context.Context, id string) (QRPreset, error) {
+	return scanQRPreset(s.db.QueryRowContext(ctx, s.q(`SELECT `+qrPresetColumns+` FROM qr_presets WHERE id = ?`), id))
+}
+
+func (s *SQLStore) ListQRPresets(ctx context.Context, ownerEmail, workspaceID string) ([]QRPreset, error) {
+	if workspaceID != "" {
+		ownerEmail = ""
+	}
+	rows, err := s.db.QueryContext(ctx, s.q(`
+		SELECT `+qrPresetColumns+` FROM qr_presets
+		WHERE owner_email = ? AND workspace_id = ? ORDER BY name`), ownerEmail, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []QRPreset
+	for rows.Next() {
+		p, err := scanQRPreset(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLStore) DeleteQRPreset(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, s.q(`DELETE FROM qr_presets WHERE id = ?`), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) CreateVCard(ctx context.Context, v HostedVCard) error {
+	_, err := s.db.ExecContext(ctx, s.q(`
+		INSERT INTO vcards (id, owner_email, session_id, workspace_id, data, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`),
+		v.ID, v.OwnerEmail, v.SessionID, v.WorkspaceID, v.Data, time.Now().UTC())
+	return err
+}
+
+func (s *SQLStore) GetVCard(ctx context.Context, id string) (HostedVCard, error) {
+	var v HostedVCard
+	var created sql.NullTime
+	err := s.db.QueryRowContext(ctx, s.q(`
+		SELECT id, owner_email, session_id, workspace_id, data, created_at FROM vcards WHERE id = ?`), id).
+		Scan(&v.ID, &v.OwnerEmail, &v.SessionID, &v.WorkspaceID, &v.Data, &created)
+	if err == sql.ErrNoRows {
+		return v, ErrNotFound
+	}
+	v.CreatedAt = created.Time
+	return v, err
+}
+
+const bioPageColumns = `handle, owner_email, workspace_id, title, description, background, foreground, button_background, button_foreground, created_at, updated_at`
+
+func scanBioPage(row interface{ Scan(...any) error }) (BioPage, error) {
+	var p BioPage
+	var created, updated sql.NullTime
+	err := row.Scan(&p.Handle, &p.OwnerEmail, &p.WorkspaceID, &p.Title, &p.Description,
+		&p.Theme.Background, &p.Theme.Foreground, &p.Theme.ButtonBackground, &p.Theme.ButtonForeground, &created, &updated)
+	if err == sql.ErrNoRows {
+		return p, ErrNotFound
+	}
+	p.CreatedAt = created.Time
+	p.UpdatedAt = updated.Time
+	return p, err
+}
+
+// insertBioLinks writes p's links in order.
+func (s *SQLStore) insertBioLinks(ctx context.Context, tx *sql.Tx, p BioPage) error {
+	for i, l := range p.Links {
+		_, err := tx.ExecContext(ctx, s.q(`
+			INSERT INTO bio_links (handle, position, domain, short_url, title, icon) VALUES (?, ?, ?, ?, ?, ?)`),
+			p.Handle, i, l.Domain, l.ShortCode, l.Title, l.Icon)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) CreateBioPage(ctx context.Context, p BioPage) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	res, err := tx.ExecContext(ctx, s.q(`
+		INSERT INTO bio_pages (`+bioPageColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`),
+		p.Handle, p.OwnerEmail, p.WorkspaceID, p.Title, p.Description,
+		p.Theme.Background, p.Theme.Foreground, p.Theme.ButtonBackground, p.Theme.ButtonForeground, now, now)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrConflict
+	}
+	if err := s.insertBioLinks(ctx, tx, p); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) GetBioPage(ctx context.Context, handle string) (BioPage, error) {
+	p, err := scanBioPage(s.db.QueryRowContext(ctx, s.q(`SELECT `+bioPageColumns+` FROM bio_pages WHERE handle = ?`), handle))
+	if err != nil {
+		return p, err
+	}
+	rows, err := s.db.QueryContext(ctx, s.q(`
+		SELECT domain, short_url, title, icon FROM bio_links WHERE handle = ? ORDER BY position`), handle)
+	if err != nil {
+		return p, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l BioLink
+		if err := rows.Scan(&l.Domain, &l.ShortCode, &l.Title, &l.Icon); err != nil {
+			return p, err
+		}
+		p.Links = append(p.Links, l)
+	}
+	return p, rows.Err()
+}
+
+// ListBioPages returns the pages without their links.
+func (s *SQLStore) ListBioPages(ctx context.Context, ownerEmail, workspaceID string) ([]BioPage, error) {
+	if workspaceID != "" {
+		ownerEmail = ""
+	}
+	rows, err := s.db.QueryContext(ctx, s.q(`
+		SELECT `+bioPageColumns+` FROM bio_pages
+		WHERE owner_email = ? AND workspace_id = ? ORDER BY handle`), ownerEmail, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []BioPage
+	for rows.Next() {
+		p, err := scanBioPage(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLStore) UpdateBioPage(ctx context.Context, p BioPage) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, s.q(`
+		UPDATE bio_pages SET title = ?, description = ?, background = ?, foreground = ?,
+			button_background = ?, button_foreground = ?, updated_at = ?
+		WHERE handle = ?`),
+		p.Title, p.Description, p.Theme.Background, p.Theme.Foreground,
+		p.Theme.ButtonBackground, p.Theme.ButtonForeground, time.Now().UTC(), p.Handle)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, s.q(`DELETE FROM bio_links WHERE handle = ?`), p.Handle); err != nil {
+		return err
+	}
+	if err := s.insertBioLinks(ctx, tx, p); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) DeleteBioPage(ctx context.Context, handle string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, s.q(`DELETE FROM bio_links WHERE handle = ?`), handle); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, s.q(`DELETE FROM bio_pages WHERE handle = ?`), handle)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"usethislink/services/internal/storage"
+)
+
+// Re-exported so handlers only need to import this package.
+var (
+	ErrNotFound = storage.ErrNotFound
+	ErrConflict = storage.ErrConflict
+)
+
+// Link is one row of url_mappings. Domain is "" for links on BASE_URL.
+// WorkspaceID is "" for personal links, which belong to their creator's
+// session or email.
+type Link struct {
+	Domain            string
+	ShortCode         string
+	OriginalURL       string
+	SessionID         string
+	UserEmail         string
+	Visits            int64
+	CreatedAt         time.Time
+	ExpiresAt         time.Time // zero means never
+	IsLoggedIn        bool
+	RedirectCode      int
+	DestinationLocked bool
+	WorkspaceID       string
+	Tags              []string // normalized by shortner.NormalizeTags
+}
+
+// Key returns the identity of l; shortcodes are unique per domain.
+func (l Link) Key() LinkKey { return LinkKey{Domain: l.Domain, Code: l.ShortCode} }
+
+// LinkKey identifies a link across domains.
+type LinkKey struct {
+	Domain string
+	Code   string
+}
+
+// String is the form used as cache key and in link_invalidate notifications.
+func (k LinkKey) String() string { return k.Domain + "/" + k.Code }
+
+// ParseLinkKey reverses String. A bare code is taken as a default-domain link.
+func ParseLinkKey(s string) LinkKey {
+	if domain, code, ok := strings.Cut(s, "/"); ok {
+		return LinkKey{Domain: domain, Code: code}
+	}
+	return LinkKey{Code: s}
+}
+
+// Domain is a user's claim on a custom short domain, for themselves or a
+// workspace. Links can only be
+// created on it once VerifiedAt is set, which at most one claim per host is.
+type Domain struct {
+	Host       string
+	OwnerEmail string
+	// WorkspaceID is "" for a personal domain. A workspace's domain is
+	// managed by its admins and used by its editors.
+	WorkspaceID       string
+	VerificationToken string
+	VerifiedAt        time.Time
+	CreatedAt         time.Time
+}
+
+func (d Domain) Verified() bool { return !d.VerifiedAt.IsZero() }
+
+// Owner identifies the caller as the gateway forwards it: an anonymous browser
+// session, a signed-in user, or both.
+type Owner struct {
+	SessionID string
+	UserEmail string
+}
+
+// Owns reports whether l is a personal link created by o.
+func (o Owner) Owns(l Link) bool {
+	if l.WorkspaceID != "" {
+		return false
+	}
+	return (l.UserEmail != "" && l.UserEmail == o.UserEmail) || (l.SessionID != "" && l.SessionID == o.SessionID)
+}
+
+// ScanBlock is a client blocked for probing shortcodes.
+type ScanBlock struct {
+	IPAddress     string
+	NotFoundCount int
+	Strikes       int
+	LastShortcode string
+	BlockedUntil  time.Time
+}
+
+// LinkStore is everything the link service persists.
+type LinkStore interface {
+	WorkspaceStore
+	QRPresetStore
+	VCardStore
+	BioPageStore
+
+	// CreateLink inserts l, returning ErrConflict if the shortcode is taken on its domain.
+	CreateLink(ctx context.Context, l Link) error
+	// GetActiveLink returns a link that exists and has not expired.
+	GetActiveLink(ctx context.Context, key LinkKey) (Link, error)
+	// GetLink returns a link whether or not it has expired. Callers check
+	// permissions themselves.
+	GetLink(ctx context.Context, key LinkKey) (Link, error)
+	// UpdateLink saves the destination, redirect settings and ownership of l.
+	UpdateLink(ctx context.Context, l Link) error
+	DeleteLink(ctx context.Context, key LinkKey) error
+	// CountLinks and EachLinkKey walk every issued code on every domain.
+	CountLinks(ctx context.Context) (int, error)
+	EachLinkKey(ctx context.Context, fn func(key LinkKey) error) error
+	// AddVisits adds the buffered redirect counts in one transaction.
+	AddVisits(ctx context.Context, counts map[LinkKey]int64) error
+	RecordScanBlock(ctx context.Context, b ScanBlock) error
+
+	// CreateDomain records d.OwnerEmail's unverified claim on d.Host after
+	// dropping every unverified claim created before staleBefore. Several
+	// users may claim a host; it returns ErrConflict if the host is already
+	// verified or the owner already claimed it.
+	CreateDomain(ctx context.Context, d Domain, staleBefore time.Time) error
+	// GetDomain returns the verified domain serving host.
+	GetDomain(ctx context.Context, host string) (Domain, error)
+	// GetDomainClaim returns ownerEmail's claim on host, verified or not.
+	GetDomainClaim(ctx context.Context, host, ownerEmail string) (Domain, error)
+	// ListDomains lists workspaceID's domains, or ownerEmail's personal ones
+	// if workspaceID is "".
+	ListDomains(ctx context.Context, ownerEmail, workspaceID string) ([]Domain, error)
+	// MarkDomainVerified verifies ownerEmail's claim and drops everyone
+	// else's, returning ErrConflict if another owner verified host first.
+	MarkDomainVerified(ctx context.Context, host, ownerEmail string, at time.Time) error
+	// DeleteDomain removes a claim, and the domain's links if it was verified.
+	DeleteDomain(ctx context.Context, host, ownerEmail string) error
+}
@@ -0,0 +1,23 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// HostedVCard is a vCard served from /vcards/{id}.vcf, so a QR code can point
+// at a short link to it instead of carrying the whole card.
+type HostedVCard struct {
+	ID          string
+	OwnerEmail  string
+	SessionID   string
+	WorkspaceID string
+	Data        string
+	CreatedAt   time.Time
+}
+
+// VCardStore persists hosted vCards. They are immutable once created.
+type VCardStore interface {
+	CreateVCard(ctx context.Context, v HostedVCard) error
+	GetVCard(ctx context.Context, id string) (HostedVCard, error)
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"usethislink/services/internal/workspace"
+)
+
+// Workspace is a team that owns links together.
+type Workspace struct {
+	ID        string
+	Name      string
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+// Member is a user's role in a workspace.
+type Member struct {
+	WorkspaceID string
+	UserEmail   string
+	Role        workspace.Role
+	AddedAt     time.Time
+}
+
+// Membership is a workspace as seen by one of its members.
+type Membership struct {
+	Workspace Workspace
+	Role      workspace.Role
+}
+
+// Invitation offers a role to whoever signs in with Email.
+type Invitation struct {
+	ID          string
+	WorkspaceID string
+	Email       string
+	Role        workspace.Role
+	InvitedBy   string
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	AcceptedAt  time.Time // zero while pending
+}
+
+// WorkspaceStore persists workspaces, their members and pending invitations.
+type WorkspaceStore interface {
+	// CreateWorkspace inserts ws with ownerEmail as its first owner.
+	CreateWorkspace(ctx context.Context, ws Workspace, ownerEmail string) error
+	GetWorkspace(ctx context.Context, id string) (Workspace, error)
+	// ListWorkspaces returns every workspace email belongs to.
+	ListWorkspaces(ctx context.Context, email string) ([]Membership, error)
+	// DeleteWorkspace returns ErrConflict while the workspace still owns links.
+	DeleteWorkspace(ctx context.Context, id string) error
+
+	ListMembers(ctx context.Context, workspaceID string) ([]Member, error)
+	// MemberRole returns ErrNotFound for non-members.
+	MemberRole(ctx context.Context, workspaceID, email string) (workspace.Role, error)
+	// SetMember adds m or changes the role of an existing member.
+	SetMember(ctx context.Context, m Member) error
+	RemoveMember(ctx context.Context, workspaceID, email string) error
+
+	CreateInvitation(ctx context.Context, inv Invitation) error
+	GetInvitation(ctx context.Context, id string) (Invitation, error)
+	// PendingInvitations lists unaccepted, unexpired invitations, either for
+	// one workspace or, with workspaceID "", addressed to email.
+	PendingInvitations(ctx context.Context, workspaceID, email string) ([]Invitation, error)
+	// AcceptInvitation marks inv accepted and adds its member in one transaction.
+	AcceptInvitation(ctx context.Context, inv Invitation, at time.Time) error
+	DeleteInvitation(ctx context.Context, workspaceID, id string) error
+}
@@ -0,0 +1,78 @@
+package visits
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"usethislink/services/link/internal/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Counter buffers redirect counts in memory and adds them to url_mappings.visits
+// in batches, so a redirect never waits on a write. At most one flush interval
+// of visits is lost if the process dies without a clean shutdown.
+type Counter struct {
+	st       store.LinkStore
+	interval time.Duration
+
+	mu     sync.Mutex
+	counts map[store.LinkKey]int64
+}
+
+// NewCounter reads VISIT_FLUSH_INTERVAL (default 5s).
+func NewCounter(st store.LinkStore) *Counter {
+	interval := 5 * time.Second
+	if v, err := time.ParseDuration(os.Getenv("VISIT_FLUSH_INTERVAL")); err == nil && v > 0 {
+		interval = v
+	}
+	return &Counter{st: st, interval: interval, counts: make(map[store.LinkKey]int64)}
+}
+
+func (c *Counter) Incr(key store.LinkKey) {
+	c.mu.Lock()
+	c.counts[key]++
+	c.mu.Unlock()
+}
+
+// Run flushes every interval until ctx is done, then flushes once more.
+func (c *Counter) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Flush(context.Background()); err != nil {
+				logrus.Errorf("Failed to flush visit counts: %v", err)
+			}
+		case <-ctx.Done():
+			if err := c.Flush(context.Background()); err != nil {
+				logrus.Errorf("Failed to flush visit counts on shutdown: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// Flush writes the pending counts in one transaction. On failure the counts
+// are put back so the next flush retries them.
+func (c *Counter) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	pending := c.counts
+	c.counts = make(map[store.LinkKey]int64, len(pending))
+	c.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	err := c.st.AddVisits(ctx, pending)
+	if err != nil {
+		c.mu.Lock()
+		for key, n := range pending {
+			c.counts[key] += n
+		}
+		c.mu.Unlock()
+	}
+	return err
+}
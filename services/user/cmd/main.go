@@ -1,35 +1,123 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"usethislink/services/internal/migrate"
 	"usethislink/services/user/internal/db"
 	"usethislink/services/user/internal/handler"
+	"usethislink/services/user/internal/mail"
+	"usethislink/services/user/internal/oidc"
+	"usethislink/services/user/internal/otp"
+	"usethislink/services/user/internal/outbox"
+	"usethislink/services/user/internal/store"
 
 	"github.com/gorilla/mux"
 )
 
 func main() {
-	dbConn, err := db.InitDBFromEnv()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
+	// DB_DRIVER=memory runs without a database; nothing survives a restart.
+	var st store.UserStore
+	if os.Getenv("DB_DRIVER") == "memory" {
+		st = store.NewMemory()
+	} else {
+		dbConn, dialect, err := db.InitDBFromEnv()
+		if err != nil {
+			log.Fatalf("Failed to initialize DB: %v", err)
+		}
+		defer dbConn.Close()
+		st = store.New(dbConn, dialect)
+	}
+
+	sender, err := mail.FromEnv()
 	if err != nil {
-		log.Fatalf("Failed to initialize DB: %v", err)
+		log.Fatalf("Failed to configure mail: %v", err)
 	}
-	defer dbConn.Close()
+	providers, err := oidc.ProvidersFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure OIDC: %v", err)
+	}
+	otpKey, err := otp.KeyFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure OTPs: %v", err)
+	}
+	// Handlers only enqueue; the outbox worker does the sending.
+	mailer := outbox.New(st, sender)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go mailer.Run(ctx)
 
 	r := mux.NewRouter()
-	r.HandleFunc("/api/register", handler.RegisterHandler(dbConn)).Methods("POST")
-	r.HandleFunc("/api/verify-otp", handler.VerifyOTPHandler(dbConn)).Methods("POST")
-	r.HandleFunc("/api/login", handler.LoginHandler(dbConn)).Methods("POST")
-	r.HandleFunc("/api/logout", handler.LogoutHandler(dbConn)).Methods("POST")
-	r.HandleFunc("/api/session", handler.SessionStatusHandler(dbConn)).Methods("GET")
-	r.HandleFunc("/api/userinfo", handler.UserInfoHandler(dbConn)).Methods("GET")
+	r.HandleFunc("/api/register", handler.RegisterHandler(st, mailer, otpKey)).Methods("POST")
+	r.HandleFunc("/api/verify-otp", handler.VerifyOTPHandler(st, otpKey)).Methods("POST")
+	r.HandleFunc("/api/login", handler.LoginHandler(st, mailer)).Methods("POST")
+	r.HandleFunc("/api/login/2fa", handler.LoginTwoFactorHandler(st, mailer)).Methods("POST")
+	r.HandleFunc("/api/login/magic", handler.MagicLinkHandler(st, mailer)).Methods("POST")
+	r.HandleFunc("/api/login/magic/verify", handler.MagicLinkVerifyHandler(st)).Methods("POST")
+	r.HandleFunc("/api/oidc/providers", handler.OIDCProvidersHandler(providers)).Methods("GET")
+	r.HandleFunc("/api/oidc/{provider}/start", handler.OIDCStartHandler(st, providers)).Methods("POST")
+	r.HandleFunc("/api/oidc/callback", handler.OIDCCallbackHandler(st, providers)).Methods("POST")
+	r.HandleFunc("/api/logout", handler.LogoutHandler(st)).Methods("POST")
+	r.HandleFunc("/api/session", handler.SessionStatusHandler(st)).Methods("GET")
+	r.HandleFunc("/api/sessions", handler.ListSessionsHandler(st)).Methods("GET")
+	r.HandleFunc("/api/sessions", handler.RevokeAllSessionsHandler(st)).Methods("DELETE")
+	r.HandleFunc("/api/sessions/{id}", handler.RevokeSessionHandler(st)).Methods("DELETE")
+	r.HandleFunc("/api/unlock", handler.UnlockHandler(st)).Methods("GET")
+	r.HandleFunc("/api/password/forgot", handler.ForgotPasswordHandler(st, mailer)).Methods("POST")
+	r.HandleFunc("/api/password/reset", handler.ResetPasswordHandler(st)).Methods("POST")
+	r.HandleFunc("/api/me", handler.MeHandler(st)).Methods("GET")
+	r.HandleFunc("/api/me", handler.UpdateMeHandler(st)).Methods("PATCH")
+	r.HandleFunc("/api/me/password", handler.ChangePasswordHandler(st, mailer)).Methods("POST")
+	r.HandleFunc("/api/2fa", handler.TwoFactorStatusHandler(st)).Methods("GET")
+	r.HandleFunc("/api/2fa/setup", handler.TwoFactorSetupHandler(st)).Methods("POST")
+	r.HandleFunc("/api/2fa/enable", handler.TwoFactorEnableHandler(st)).Methods("POST")
+	r.HandleFunc("/api/2fa/disable", handler.TwoFactorDisableHandler(st)).Methods("POST")
+	r.HandleFunc("/api/2fa/recovery-codes", handler.RecoveryCodesHandler(st)).Methods("POST")
+	r.HandleFunc("/api/userinfo", handler.UserInfoHandler(st)).Methods("GET")
+	r.HandleFunc("/api/admin/emails", handler.ListEmailsHandler(st)).Methods("GET")
+	r.HandleFunc("/api/admin/emails/{id}/retry", handler.RetryEmailHandler(st)).Methods("POST")
+	r.HandleFunc("/api/admin/email-suppressions", handler.ListEmailSuppressionsHandler(st)).Methods("GET")
+	r.HandleFunc("/api/admin/email-suppressions/{email}", handler.DeleteEmailSuppressionHandler(st)).Methods("DELETE")
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8083"
 	}
-	log.Printf("User Service running on :%s", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+	go func() {
+		log.Printf("User Service running on :%s", port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("User Service shutdown: %v", err)
+	}
+}
+
+// runMigrate implements `userservice migrate [up | down [steps] | status]`.
+func runMigrate(args []string) {
+	dbConn, dialect, err := db.Open()
+	if err != nil {
+		log.Fatalf("Failed to open DB: %v", err)
+	}
+	defer dbConn.Close()
+	if err := migrate.Command(context.Background(), db.Migrator(dbConn, dialect), args, os.Stdout); err != nil {
+		log.Fatalf("migrate: %v", err)
+	}
 }
@@ -0,0 +1,39 @@
+// Command mockidp runs a local OpenID Connect provider for trying OIDC
+// sign-in without network access. It signs in MOCK_IDP_EMAIL at once.
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"usethislink/services/user/internal/oidc/oidctest"
+)
+
+func env(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func main() {
+	addr := env("MOCK_IDP_ADDR", "127.0.0.1:9090")
+	redirect := os.Getenv("OIDC_REDIRECT_URL")
+	if redirect == "" {
+		redirect = strings.TrimRight(env("BASE_URL", "http://localhost:8080"), "/") + "/login/oidc/callback"
+	}
+	email := env("MOCK_IDP_EMAIL", "dev@example.com")
+	s, err := oidctest.New("http://"+addr, env("MOCK_IDP_CLIENT_ID", "usethislink"), env("MOCK_IDP_CLIENT_SECRET", "secret"), redirect, oidctest.Identity{
+		Subject:       env("MOCK_IDP_SUBJECT", email),
+		Email:         email,
+		EmailVerified: os.Getenv("MOCK_IDP_EMAIL_VERIFIED") != "false",
+		Name:          env("MOCK_IDP_NAME", "Dev User"),
+	})
+	if err != nil {
+		log.Fatalf("Failed to start mock IdP: %v", err)
+	}
+	log.Printf("Mock IdP running on http://%s for client %s, redirecting to %s", addr, s.ClientID, redirect)
+	log.Fatal(http.ListenAndServe(addr, s))
+}
@@ -1,78 +1,43 @@
 package db
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
 	"os"
 
-	_ "github.com/lib/pq"
+	"usethislink/services/internal/migrate"
+	"usethislink/services/internal/storage"
 )
 
-// USERDEFN, sessions, pending_registrations
-func InitDBFromEnv() (*sql.DB, error) {
-	host := os.Getenv("PGHOST")
-	port := os.Getenv("PGPORT")
-	user := os.Getenv("PGUSER")
-	password := os.Getenv("PGPASSWORD")
-	dbname := os.Getenv("PGDATABASE")
-	schema := os.Getenv("PGSCHEMA")
-	if schema == "" {
-		schema = "user"
-	}
-	if port == "" {
-		port = "5432"
-	}
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
-	db, err := sql.Open("postgres", dsn)
-	if err != nil {
-		return nil, err
-	}
-	_, err = db.Exec(fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS "%s"`, schema))
-	if err != nil {
-		return nil, err
+// Open connects without touching the schema; the migrate subcommand uses it.
+//
+// url_mappings belongs to the link service; on Postgres its schema is added
+// to our search_path (LINK_PGSCHEMA, default "link") so a login can claim the
+// links created anonymously in that browser session.
+func Open() (*sql.DB, storage.Dialect, error) {
+	linkSchema := os.Getenv("LINK_PGSCHEMA")
+	if linkSchema == "" {
+		linkSchema = "link"
 	}
-	_, err = db.Exec(fmt.Sprintf(`SET search_path TO "%s"`, schema))
-	if err != nil {
-		return nil, err
-	}
-	_, err = db.Exec(`
-	CREATE TABLE IF NOT EXISTS USERDEFN (
-		EMAILID TEXT PRIMARY KEY NOT NULL,
-		UNIQUEID TEXT NOT NULL,
-		FULLNAMEDESC TEXT DEFAULT '',
-		USERPSWD TEXT NOT NULL,
-		LANGUAGE_CODE TEXT DEFAULT 'ENG',
-		CURRENCY_CODE TEXT DEFAULT 'INR',
-		LASTPSWDCHANGE TIMESTAMP,
-		ACCTLOCK INTEGER DEFAULT 0,
-		ISSIGNEDIN INTEGER DEFAULT 0,
-		DEFAULTHOME TEXT DEFAULT '',
-		FAILEDLOGINS INTEGER DEFAULT 0,
-		CREATEDETTM TIMESTAMP,
-		LASTSIGNONDTTM TIMESTAMP,
-		LASTSIGNOFFDTTM TIMESTAMP,
-		LASTUPDDTTM TIMESTAMP
-	);
+	return storage.OpenFromEnv("user", linkSchema)
+}
 
-	CREATE TABLE IF NOT EXISTS pending_registrations (
-		EMAILID TEXT PRIMARY KEY,
-		OTP TEXT NOT NULL,
-		OTP_EXPIRES_AT TIMESTAMP NOT NULL,
-		USERPSWD TEXT NOT NULL,
-		UNIQUEID TEXT NOT NULL,
-		CREATED_AT TIMESTAMP NOT NULL
-	);
+// Migrator manages the user schema (see Migrations).
+func Migrator(db *sql.DB, dialect storage.Dialect) *migrate.Migrator {
+	return migrate.New(db, dialect, "user", Migrations)
+}
 
-	CREATE TABLE IF NOT EXISTS sessions (
-		session_id  TEXT PRIMARY KEY,
-		user_agent  TEXT,
-		ip_address  TEXT,
-		user_email  TEXT,
-		created_at  TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);
-	`)
+// USERDEFN, sessions, pending_registrations
+//
+// InitDBFromEnv connects and applies any pending migrations.
+func InitDBFromEnv() (*sql.DB, storage.Dialect, error) {
+	db, dialect, err := Open()
 	if err != nil {
-		return nil, err
+		return nil, dialect, err
+	}
+	if _, err := Migrator(db, dialect).Up(context.Background()); err != nil {
+		db.Close()
+		return nil, dialect, err
 	}
-	return db, nil
+	return db, dialect, nil
 }
@@ -0,0 +1,307 @@
+package db
+
+import "usethislink/services/internal/migrate"
+
+// Migrations is the user schema history. Never edit an applied migration;
+// add a new one instead. Version 1 uses IF NOT EXISTS so databases created
+// before migrations existed adopt it without changes.
+var Migrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "create USERDEFN, pending_registrations and sessions",
+		Up: migrate.Both(`
+			CREATE TABLE IF NOT EXISTS USERDEFN (
+				EMAILID TEXT PRIMARY KEY NOT NULL,
+				UNIQUEID TEXT NOT NULL,
+				FULLNAMEDESC TEXT DEFAULT '',
+				USERPSWD TEXT NOT NULL,
+				LANGUAGE_CODE TEXT DEFAULT 'ENG',
+				CURRENCY_CODE TEXT DEFAULT 'INR',
+				LASTPSWDCHANGE TIMESTAMP,
+				ACCTLOCK INTEGER DEFAULT 0,
+				ISSIGNEDIN INTEGER DEFAULT 0,
+				DEFAULTHOME TEXT DEFAULT '',
+				FAILEDLOGINS INTEGER DEFAULT 0,
+				CREATEDETTM TIMESTAMP,
+				LASTSIGNONDTTM TIMESTAMP,
+				LASTSIGNOFFDTTM TIMESTAMP,
+				LASTUPDDTTM TIMESTAMP
+			);
+
+			CREATE TABLE IF NOT EXISTS pending_registrations (
+				EMAILID TEXT PRIMARY KEY,
+				OTP TEXT NOT NULL,
+				OTP_EXPIRES_AT TIMESTAMP NOT NULL,
+				USERPSWD TEXT NOT NULL,
+				UNIQUEID TEXT NOT NULL,
+				CREATED_AT TIMESTAMP NOT NULL
+			);
+
+			CREATE TABLE IF NOT EXISTS sessions (
+				session_id  TEXT PRIMARY KEY,
+				user_agent  TEXT,
+				ip_address  TEXT,
+				user_email  TEXT,
+				created_at  TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);`),
+		Down: migrate.Both(`
+			DROP TABLE IF EXISTS sessions;
+			DROP TABLE IF EXISTS pending_registrations;
+			DROP TABLE IF EXISTS USERDEFN;`),
+	},
+	{
+		Version: 2,
+		Name:    "hashed session tokens",
+		// Sessions were keyed by guessable cookie values. They are dropped, so
+		// everyone signs in again and gets a random token stored as its hash.
+		Up: migrate.Script{
+			Postgres: `
+			DELETE FROM sessions;
+			ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP;
+			CREATE INDEX IF NOT EXISTS sessions_user_idx ON sessions (user_email);`,
+			SQLite: `
+			DELETE FROM sessions;
+			ALTER TABLE sessions ADD COLUMN last_seen_at TIMESTAMP;
+			CREATE INDEX IF NOT EXISTS sessions_user_idx ON sessions (user_email);`,
+		},
+		Down: migrate.Both(`
+			DROP INDEX IF EXISTS sessions_user_idx;
+			ALTER TABLE sessions DROP COLUMN last_seen_at;`),
+	},
+	{
+		Version: 3,
+		Name:    "account lockout",
+		Up: migrate.Script{
+			Postgres: `
+			ALTER TABLE USERDEFN ADD COLUMN IF NOT EXISTS FAILEDLOGINDTTM TIMESTAMP;
+			ALTER TABLE USERDEFN ADD COLUMN IF NOT EXISTS LOCKEDUNTILDTTM TIMESTAMP;
+			ALTER TABLE USERDEFN ADD COLUMN IF NOT EXISTS LOCKCOUNT INTEGER DEFAULT 0;
+
+			CREATE TABLE IF NOT EXISTS unlock_tokens (
+				token_hash TEXT PRIMARY KEY,
+				EMAILID TEXT NOT NULL,
+				expires_at TIMESTAMP NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+
+			CREATE TABLE IF NOT EXISTS account_audit (
+				id BIGSERIAL PRIMARY KEY,
+				EMAILID TEXT NOT NULL,
+				event TEXT NOT NULL,
+				reason TEXT NOT NULL DEFAULT '',
+				ip_address TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS account_audit_email_idx ON account_audit (EMAILID, created_at);`,
+			SQLite: `
+			ALTER TABLE USERDEFN ADD COLUMN FAILEDLOGINDTTM TIMESTAMP;
+			ALTER TABLE USERDEFN ADD COLUMN LOCKEDUNTILDTTM TIMESTAMP;
+			ALTER TABLE USERDEFN ADD COLUMN LOCKCOUNT INTEGER DEFAULT 0;
+
+			CREATE TABLE IF NOT EXISTS unlock_tokens (
+				token_hash TEXT PRIMARY KEY,
+				EMAILID TEXT NOT NULL,
+				expires_at TIMESTAMP NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+
+			CREATE TABLE IF NOT EXISTS account_audit (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				EMAILID TEXT NOT NULL,
+				event TEXT NOT NULL,
+				reason TEXT NOT NULL DEFAULT '',
+				ip_address TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS account_audit_email_idx ON account_audit (EMAILID, created_at);`,
+		},
+		Down: migrate.Both(`
+			DROP TABLE IF EXISTS account_audit;
+			DROP TABLE IF EXISTS unlock_tokens;
+			ALTER TABLE USERDEFN DROP COLUMN LOCKCOUNT;
+			ALTER TABLE USERDEFN DROP COLUMN LOCKEDUNTILDTTM;
+			ALTER TABLE USERDEFN DROP COLUMN FAILEDLOGINDTTM;`),
+	},
+	{
+		Version: 4,
+		Name:    "password resets",
+		Up: migrate.Both(`
+			CREATE TABLE IF NOT EXISTS password_resets (
+				token_hash TEXT PRIMARY KEY,
+				EMAILID TEXT NOT NULL,
+				expires_at TIMESTAMP NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS password_resets_email_idx ON password_resets (EMAILID);`),
+		Down: migrate.Both(`DROP TABLE IF EXISTS password_resets;`),
+	},
+	{
+		Version: 5,
+		Name:    "two-factor authentication",
+		Up: migrate.Script{
+			Postgres: `
+			ALTER TABLE USERDEFN ADD COLUMN IF NOT EXISTS TOTPSECRET TEXT DEFAULT '';
+			ALTER TABLE USERDEFN ADD COLUMN IF NOT EXISTS TOTPENABLED INTEGER DEFAULT 0;
+			ALTER TABLE USERDEFN ADD COLUMN IF NOT EXISTS TOTPLASTSTEP BIGINT DEFAULT 0;
+
+			CREATE TABLE IF NOT EXISTS recovery_codes (
+				EMAILID TEXT NOT NULL,
+				code_hash TEXT NOT NULL,
+				PRIMARY KEY (EMAILID, code_hash)
+			);
+
+			CREATE TABLE IF NOT EXISTS login_challenges (
+				token_hash TEXT PRIMARY KEY,
+				EMAILID TEXT NOT NULL,
+				expires_at TIMESTAMP NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);`,
+			SQLite: `
+			ALTER TABLE USERDEFN ADD COLUMN TOTPSECRET TEXT DEFAULT '';
+			ALTER TABLE USERDEFN ADD COLUMN TOTPENABLED INTEGER DEFAULT 0;
+			ALTER TABLE USERDEFN ADD COLUMN TOTPLASTSTEP INTEGER DEFAULT 0;
+
+			CREATE TABLE IF NOT EXISTS recovery_codes (
+				EMAILID TEXT NOT NULL,
+				code_hash TEXT NOT NULL,
+				PRIMARY KEY (EMAILID, code_hash)
+			);
+
+			CREATE TABLE IF NOT EXISTS login_challenges (
+				token_hash TEXT PRIMARY KEY,
+				EMAILID TEXT NOT NULL,
+				expires_at TIMESTAMP NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);`,
+		},
+		Down: migrate.Both(`
+			DROP TABLE IF EXISTS login_challenges;
+			DROP TABLE IF EXISTS recovery_codes;
+			ALTER TABLE USERDEFN DROP COLUMN TOTPLASTSTEP;
+			ALTER TABLE USERDEFN DROP COLUMN TOTPENABLED;
+			ALTER TABLE USERDEFN DROP COLUMN TOTPSECRET;`),
+	},
+	{
+		Version: 6,
+		Name:    "outbound email queue",
+		Up: migrate.Script{
+			Postgres: `
+			CREATE TABLE IF NOT EXISTS email_queue (
+				id BIGSERIAL PRIMARY KEY,
+				recipient TEXT NOT NULL,
+				subject TEXT NOT NULL,
+				text_body TEXT NOT NULL DEFAULT '',
+				html_body TEXT NOT NULL DEFAULT '',
+				status TEXT NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				next_attempt_at TIMESTAMP NOT NULL,
+				last_error TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL,
+				sent_at TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS email_queue_due_idx ON email_queue (status, next_attempt_at);
+
+			CREATE TABLE IF NOT EXISTS email_suppressions (
+				EMAILID TEXT PRIMARY KEY,
+				reason TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL
+			);`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS email_queue (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				recipient TEXT NOT NULL,
+				subject TEXT NOT NULL,
+				text_body TEXT NOT NULL DEFAULT '',
+				html_body TEXT NOT NULL DEFAULT '',
+				status TEXT NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				next_attempt_at TIMESTAMP NOT NULL,
+				last_error TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL,
+				sent_at TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS email_queue_due_idx ON email_queue (status, next_attempt_at);
+
+			CREATE TABLE IF NOT EXISTS email_suppressions (
+				EMAILID TEXT PRIMARY KEY,
+				reason TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL
+			);`,
+		},
+		Down: migrate.Both(`
+			DROP TABLE IF EXISTS email_suppressions;
+			DROP TABLE IF EXISTS email_queue;`),
+	},
+	{
+		Version: 7,
+		Name:    "otp attempts and send log",
+		Up: migrate.Script{
+			Postgres: `
+			DELETE FROM pending_registrations;
+			ALTER TABLE pending_registrations ADD COLUMN IF NOT EXISTS OTP_ATTEMPTS INTEGER NOT NULL DEFAULT 0;
+
+			CREATE TABLE IF NOT EXISTS otp_sends (
+				EMAILID TEXT NOT NULL,
+				ip_address TEXT NOT NULL DEFAULT '',
+				sent_at TIMESTAMP NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS otp_sends_email_idx ON otp_sends (EMAILID, sent_at);
+			CREATE INDEX IF NOT EXISTS otp_sends_ip_idx ON otp_sends (ip_address, sent_at);`,
+			SQLite: `
+			DELETE FROM pending_registrations;
+			ALTER TABLE pending_registrations ADD COLUMN OTP_ATTEMPTS INTEGER NOT NULL DEFAULT 0;
+
+			CREATE TABLE IF NOT EXISTS otp_sends (
+				EMAILID TEXT NOT NULL,
+				ip_address TEXT NOT NULL DEFAULT '',
+				sent_at TIMESTAMP NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS otp_sends_email_idx ON otp_sends (EMAILID, sent_at);
+			CREATE INDEX IF NOT EXISTS otp_sends_ip_idx ON otp_sends (ip_address, sent_at);`,
+		},
+		Down: migrate.Both(`
+			DROP TABLE IF EXISTS otp_sends;
+			DELETE FROM pending_registrations;
+			ALTER TABLE pending_registrations DROP COLUMN OTP_ATTEMPTS;`),
+	},
+	{
+		Version: 8,
+		Name:    "magic links",
+		Up: migrate.Both(`
+			CREATE TABLE IF NOT EXISTS magic_links (
+				token_hash TEXT PRIMARY KEY,
+				EMAILID TEXT NOT NULL,
+				browser_hash TEXT NOT NULL DEFAULT '',
+				expires_at TIMESTAMP NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE INDEX IF NOT EXISTS magic_links_email_idx ON magic_links (EMAILID);`),
+		Down: migrate.Both(`DROP TABLE IF EXISTS magic_links;`),
+	},
+	{
+		Version: 9,
+		Name:    "openid connect sign-in",
+		Up: migrate.Both(`
+			CREATE TABLE IF NOT EXISTS user_identities (
+				provider TEXT NOT NULL,
+				subject TEXT NOT NULL,
+				EMAILID TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (provider, subject)
+			);
+			CREATE INDEX IF NOT EXISTS user_identities_email_idx ON user_identities (EMAILID);
+
+			CREATE TABLE IF NOT EXISTS oidc_logins (
+				state_hash TEXT PRIMARY KEY,
+				provider TEXT NOT NULL,
+				nonce TEXT NOT NULL,
+				code_verifier TEXT NOT NULL,
+				expires_at TIMESTAMP NOT NULL
+			);`),
+		Down: migrate.Both(`
+			DROP TABLE IF EXISTS oidc_logins;
+			DROP TABLE IF EXISTS user_identities;`),
+	},
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"usethislink/services/user/internal/store"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// isAdmin reports whether email is listed in ADMIN_EMAILS (comma-separated).
+func isAdmin(email string) bool {
+	for _, a := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if a = strings.TrimSpace(a); a != "" && strings.EqualFold(a, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// adminOnly answers 401 or 403 unless the caller is signed in as an admin.
+func adminOnly(w http.ResponseWriter, r *http.Request, st store.UserStore) bool {
+	sess, ok := signedIn(w, r, st)
+	if !ok {
+		return false
+	}
+	if !isAdmin(sess.UserEmail) {
+		http.Error(w, "Admins only", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+type emailResponse struct {
+	ID            int64  `json:"id"`
+	To            string `json:"to"`
+	Subject       string `json:"subject"`
+	Status        string `json:"status"`
+	Attempts      int    `json:"attempts"`
+	LastError     string `json:"last_error,omitempty"`
+	CreatedAt     string `json:"created_at"`
+	NextAttemptAt string `json:"next_attempt_at,omitempty"`
+	SentAt        string `json:"sent_at,omitempty"`
+}
+
+// ListEmailsHandler handles GET /api/admin/emails?status= (default dead,
+// the dead letters) &limit= (default 100). Bodies are never returned; they
+// may hold one-time codes.
+func ListEmailsHandler(st store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminOnly(w, r, st) {
+			return
+		}
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "":
+			status = store.EmailDead
+		case store.EmailPending, store.EmailSending, store.EmailSent, store.EmailDead:
+		default:
+			http.Error(w, "status must be pending, sending, sent or dead", http.StatusBadRequest)
+			return
+		}
+		limit := 100
+		if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v <= 1000 {
+			limit = v
+		}
+		emails, err := st.ListEmails(r.Context(), status, limit)
+		if err != nil {
+			logrus.Errorf("Failed to list emails: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		resp := make([]emailResponse, 0, len(emails))
+		for _, e := range emails {
+			item := emailResponse{
+				ID:        e.ID,
+				To:        e.To,
+				Subject:   e.Subject,
+				Status:    e.Status,
+				Attempts:  e.Attempts,
+				LastError: e.LastError,
+				CreatedAt: formatTime(e.CreatedAt),
+				SentAt:    formatTime(e.SentAt),
+			}
+			if e.Status == store.EmailPending {
+				item.NextAttemptAt = formatTime(e.NextAttemptAt)
+			}
+			resp = append(resp, item)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// RetryEmailHandler handles POST /api/admin/emails/{id}/retry: a dead letter
+// goes back in the queue with fresh attempts.
+func RetryEmailHandler(st store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminOnly(w, r, st) {
+			return
+		}
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid email ID", http.StatusBadRequest)
+			return
+		}
+		if err := st.RequeueEmail(r.Context(), id, time.Now()); err == store.ErrNotFound {
+			http.Error(w, "No dead email with that ID", http.StatusNotFound)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to requeue email: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"queued"}`))
+	}
+}
+
+// ListEmailSuppressionsHandler handles GET /api/admin/email-suppressions: the
+// addresses no email is sent to, because they bounced.
+func ListEmailSuppressionsHandler(st store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminOnly(w, r, st) {
+			return
+		}
+		list, err := st.ListEmailSuppressions(r.Context())
+		if err != nil {
+			logrus.Errorf("Failed to list email suppressions: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		type item struct {
+			Email     string `json:"email"`
+			Reason    string `json:"reason"`
+			CreatedAt string `json:"created_at"`
+		}
+		resp := make([]item, 0, len(list))
+		for _, s := range list {
+			resp = append(resp, item{Email: s.Email, Reason: s.Reason, CreatedAt: formatTime(s.CreatedAt)})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// DeleteEmailSuppressionHandler handles DELETE
+// /api/admin/email-suppressions/{email}, e.g. once a mailbox is fixed.
+func DeleteEmailSuppressionHandler(st store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminOnly(w, r, st) {
+			return
+		}
+		if err := st.DeleteEmailSuppression(r.Context(), mux.Vars(r)["email"]); err == store.ErrNotFound {
+			http.Error(w, "Address is not suppressed", http.StatusNotFound)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to delete email suppression: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
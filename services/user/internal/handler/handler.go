@@ -1,35 +1,24 @@
 package handler
 
 import (
-	"crypto/rand"
-	"database/sql"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"net/smtp"
-	"os"
+	"strings"
 	"time"
 
+	"usethislink/services/user/internal/mail"
+	"usethislink/services/user/internal/otp"
+	"usethislink/services/user/internal/profile"
+	"usethislink/services/user/internal/session"
+	"usethislink/services/user/internal/store"
+
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func generateOTP() (string, error) {
-	b := make([]byte, 4)
-	_, err := rand.Read(b)
-	if err != nil {
-		logrus.Errorf("Failed to generate OTP: %v", err)
-		return "", err
-	}
-	otp := int(b[0])<<24 | int(b[1])<<16 | int(b[2])<<8 | int(b[3])
-	otp = otp % 1000000
-	if otp < 0 {
-		otp = -otp
-	}
-	return fmt.Sprintf("%06d", otp), nil
-}
-
 func hashPassword(password string) (string, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -39,44 +28,41 @@ func hashPassword(password string) (string, error) {
 	return string(hash), nil
 }
 
-func setSessionCookie(w http.ResponseWriter, sessionID string) {
+func setSessionCookie(w http.ResponseWriter, token string, maxAge time.Duration) {
 	http.SetCookie(w, &http.Cookie{
-		Name:     "UTL_SESSION",
-		Value:    sessionID,
+		Name:     session.CookieName,
+		Value:    token,
 		Path:     "/",
 		HttpOnly: true,
 		Secure:   true,
 		SameSite: http.SameSiteStrictMode,
-		MaxAge:   60 * 60 * 24 * 7, // 1 week
+		MaxAge:   int(maxAge / time.Second),
 	})
 }
 
-func sendOTPEmail(to, otp string) error {
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPort := os.Getenv("SMTP_PORT")
-	smtpUser := os.Getenv("SMTP_USER")
-	smtpPass := os.Getenv("SMTP_PASS")
-	from := smtpUser
-	msg := []byte("Subject: Your OTP for UseThisLink Registration\r\n" +
-		"To: " + to + "\r\n" +
-		"Content-Type: text/plain; charset=UTF-8\r\n" +
-		"\r\n" +
-		fmt.Sprintf("Your OTP for UseThisLink registration is: %s\nThis OTP is valid for 10 minutes.", otp))
-	var auth smtp.Auth
-	if smtpUser != "" {
-		auth = smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
-	} else {
-		auth = nil
-	}
-	err := smtp.SendMail(smtpHost+":"+smtpPort, auth, from, []string{to}, msg)
+// sendMail renders the named template in lang and sends it to to.
+func sendMail(ctx context.Context, m mail.Mailer, name, lang, to string, data any) error {
+	msg, err := mail.Render(name, lang, to, data)
 	if err != nil {
-		logrus.Errorf("Failed to send OTP email via smtp.SendMail: %v", err)
 		return err
 	}
-	return nil
+	return m.Send(ctx, msg)
+}
+
+// requestLanguage is the LANGUAGE_CODE for the browser's preferred language,
+// for emails to people who have no account yet.
+func requestLanguage(r *http.Request) string {
+	for _, tag := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, _, _ = strings.Cut(strings.TrimSpace(tag), ";")
+		tag, _, _ = strings.Cut(tag, "-")
+		if lang, ok := profile.Language(tag); ok {
+			return lang
+		}
+	}
+	return mail.DefaultLanguage
 }
 
-func RegisterHandler(db *sql.DB) http.HandlerFunc {
+func RegisterHandler(st store.UserStore, m mail.Mailer, key otp.Key) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		type reqBody struct {
 			Email    string `json:"email"`
@@ -88,21 +74,25 @@ func RegisterHandler(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
-		var exists int
-		err := db.QueryRow("SELECT COUNT(1) FROM USERDEFN WHERE EMAILID = ?", req.Email).Scan(&exists)
+		exists, err := st.UserExists(r.Context(), req.Email)
 		if err != nil {
 			logrus.Errorf("DB error: %v", err)
 			http.Error(w, "DB error", http.StatusInternalServerError)
 			return
 		}
-		if exists > 0 {
+		if exists {
 			logrus.Errorf("User already exists")
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusConflict)
 			json.NewEncoder(w).Encode(map[string]string{"error": "An account with this email already exists. Please log in or use a different email."})
 			return
 		}
-		otp, err := generateOTP()
+		now := time.Now()
+		policy := otp.PolicyFromEnv()
+		if !allowOTPSend(w, r, st, policy, req.Email, now) {
+			return
+		}
+		code, err := otp.Generate()
 		if err != nil {
 			logrus.Errorf("Failed to generate OTP: %v", err)
 			http.Error(w, "Failed to generate OTP", http.StatusInternalServerError)
@@ -114,15 +104,25 @@ func RegisterHandler(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 			return
 		}
-		uuid := base64.URLEncoding.EncodeToString([]byte(fmt.Sprintf("%s-%d", req.Email, time.Now().UnixNano())))
-		expiry := time.Now().Add(10 * time.Minute)
-		_, err = db.Exec(`INSERT OR REPLACE INTO pending_registrations (EMAILID, OTP, OTP_EXPIRES_AT, USERPSWD, UNIQUEID, CREATED_AT) VALUES (?, ?, ?, ?, ?, ?)`, req.Email, otp, expiry, phash, uuid, time.Now())
+		uuid := base64.URLEncoding.EncodeToString([]byte(fmt.Sprintf("%s-%d", req.Email, now.UnixNano())))
+		err = st.SavePendingRegistration(r.Context(), store.PendingRegistration{
+			Email:        req.Email,
+			OTPHash:      key.Hash(req.Email, code),
+			OTPExpiresAt: now.Add(policy.TTL),
+			PasswordHash: phash,
+			UniqueID:     uuid,
+			CreatedAt:    now,
+		})
 		if err != nil {
 			logrus.Errorf("Failed to store registration: %v", err)
 			http.Error(w, "Failed to store registration", http.StatusInternalServerError)
 			return
 		}
-		if err := sendOTPEmail(req.Email, otp); err != nil {
+		if err := st.RecordOTPSend(r.Context(), req.Email, clientIP(r), now); err != nil {
+			logrus.Errorf("Failed to record OTP send: %v", err)
+		}
+		err = sendMail(r.Context(), m, "otp", requestLanguage(r), req.Email, map[string]any{"OTP": code, "Minutes": int(policy.TTL / time.Minute)})
+		if err != nil {
 			logrus.Errorf("Failed to send OTP email: %v", err)
 			http.Error(w, "Failed to send OTP email", http.StatusInternalServerError)
 			return
@@ -132,7 +132,7 @@ func RegisterHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-func VerifyOTPHandler(db *sql.DB) http.HandlerFunc {
+func VerifyOTPHandler(st store.UserStore, key otp.Key) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		type reqBody struct {
 			Email string `json:"email"`
@@ -144,10 +144,8 @@ func VerifyOTPHandler(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
-		var dbOTP, phash, uuid string
-		var otpExpiry, createdAt time.Time
-		err := db.QueryRow(`SELECT OTP, OTP_EXPIRES_AT, USERPSWD, UNIQUEID, CREATED_AT FROM pending_registrations WHERE EMAILID = ?`, req.Email).Scan(&dbOTP, &otpExpiry, &phash, &uuid, &createdAt)
-		if err == sql.ErrNoRows {
+		pending, err := st.GetPendingRegistration(r.Context(), req.Email)
+		if err == store.ErrNotFound {
 			logrus.Errorf("No pending registration")
 			http.Error(w, "No pending registration", http.StatusNotFound)
 			return
@@ -156,29 +154,52 @@ func VerifyOTPHandler(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "DB error", http.StatusInternalServerError)
 			return
 		}
-		if time.Now().After(otpExpiry) {
+		if time.Now().After(pending.OTPExpiresAt) {
 			logrus.Errorf("OTP expired")
 			http.Error(w, "OTP expired", http.StatusUnauthorized)
 			return
 		}
-		if req.OTP != dbOTP {
+		maxAttempts := otp.PolicyFromEnv().MaxAttempts
+		if pending.OTPAttempts >= maxAttempts {
+			logrus.Errorf("OTP invalidated after too many attempts")
+			http.Error(w, "Too many attempts, request a new OTP", http.StatusUnauthorized)
+			return
+		}
+		if !key.Matches(pending.OTPHash, req.Email, req.OTP) {
 			logrus.Errorf("Invalid OTP")
+			if n, err := st.FailOTPAttempt(r.Context(), req.Email); err != nil {
+				logrus.Errorf("Failed to count OTP attempt: %v", err)
+				http.Error(w, "DB error", http.StatusInternalServerError)
+				return
+			} else if n >= maxAttempts {
+				http.Error(w, "Too many attempts, request a new OTP", http.StatusUnauthorized)
+				return
+			}
 			http.Error(w, "Invalid OTP", http.StatusUnauthorized)
 			return
 		}
-		_, err = db.Exec(`INSERT INTO USERDEFN (EMAILID, UNIQUEID, USERPSWD, CREATEDETTM, LASTUPDDTTM, LASTPSWDCHANGE, ACCTLOCK, ISSIGNEDIN, DEFAULTHOME, FAILEDLOGINS, LANGUAGE_CODE, CURRENCY_CODE) VALUES (?, ?, ?, ?, ?, ?, 0, 0, '', 0, 'ENG', 'INR')`, req.Email, uuid, phash, createdAt, time.Now(), time.Now())
+		err = st.CreateUser(r.Context(), store.User{
+			Email:          req.Email,
+			UniqueID:       pending.UniqueID,
+			PasswordHash:   pending.PasswordHash,
+			CreatedAt:      pending.CreatedAt,
+			LastUpdated:    time.Now(),
+			LastPswdChange: time.Now(),
+			LanguageCode:   "ENG",
+			CurrencyCode:   "INR",
+		})
 		if err != nil {
 			logrus.Errorf("Failed to create user: %v", err)
 			http.Error(w, "Failed to create user", http.StatusInternalServerError)
 			return
 		}
-		_, _ = db.Exec(`DELETE FROM pending_registrations WHERE EMAILID = ?`, req.Email)
+		_ = st.DeletePendingRegistration(r.Context(), req.Email)
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"status":"registered"}`))
 	}
 }
 
-func LoginHandler(db *sql.DB) http.HandlerFunc {
+func LoginHandler(st store.UserStore, m mail.Mailer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		type reqBody struct {
 			Email    string `json:"email"`
@@ -190,12 +211,8 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
-		var hash string
-		var acctLock, failedLogins int
-		var uniqueID string
-		var lastSignOn, lastSignOff, lastPwdChange, createDetTm, lastUpdTm sql.NullTime
-		err := db.QueryRow(`SELECT USERPSWD, ACCTLOCK, FAILEDLOGINS, UNIQUEID, LASTSIGNONDTTM, LASTSIGNOFFDTTM, LASTPSWDCHANGE, CREATEDETTM, LASTUPDDTTM FROM USERDEFN WHERE EMAILID = ?`, req.Email).Scan(&hash, &acctLock, &failedLogins, &uniqueID, &lastSignOn, &lastSignOff, &lastPwdChange, &createDetTm, &lastUpdTm)
-		if err == sql.ErrNoRows {
+		user, err := st.GetUser(r.Context(), req.Email)
+		if err == store.ErrNotFound {
 			logrus.Errorf("Invalid email or password")
 			http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 			return
@@ -204,92 +221,117 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "DB error", http.StatusInternalServerError)
 			return
 		}
-		if acctLock != 0 {
-			logrus.Errorf("Account is locked")
-			http.Error(w, "Account is locked", http.StatusForbidden)
+		now := time.Now()
+		if !checkLock(w, r, st, user, now) {
 			return
 		}
-		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil {
-			db.Exec(`UPDATE USERDEFN SET FAILEDLOGINS = FAILEDLOGINS + 1 WHERE EMAILID = ?`, req.Email)
+		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+			if locked := recordFailedLogin(r, st, m, user, now); locked {
+				http.Error(w, "Account is locked", http.StatusForbidden)
+				return
+			}
 			logrus.Errorf("Invalid email or password")
 			http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 			return
 		}
-		db.Exec(`UPDATE USERDEFN SET FAILEDLOGINS = 0, ISSIGNEDIN = 1, LASTSIGNONDTTM = ? WHERE EMAILID = ?`, time.Now(), req.Email)
-		sid, err := r.Cookie("UTL_SESSION")
-		var sessionID string
-		if err == nil && sid.Value != "" {
-			sessionID = sid.Value
-		} else {
-			sessionID = base64.URLEncoding.EncodeToString([]byte(req.Email + time.Now().String()))
-			_, _ = db.Exec(`INSERT OR IGNORE INTO sessions (session_id, user_agent, ip_address, user_email) VALUES (?, ?, ?, ?)`, sessionID, r.UserAgent(), r.RemoteAddr, req.Email)
+		if user.TOTPEnabled {
+			startLoginChallenge(w, r, st, user.Email, now)
+			return
 		}
-		_, _ = db.Exec(`UPDATE sessions SET user_email = ?, created_at = ? WHERE session_id = ?`, req.Email, time.Now(), sessionID)
-		_, _ = db.Exec(`UPDATE url_mappings SET user_email = ? WHERE session_id = ? AND (user_email IS NULL OR user_email = '')`, req.Email, sessionID)
-		setSessionCookie(w, sessionID)
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status":"logged_in"}`))
+		startSession(w, r, st, user.Email, now)
+	}
+}
+
+// startSession signs email in: it records the login, issues a new session
+// cookie and answers {"status":"logged_in"}.
+func startSession(w http.ResponseWriter, r *http.Request, st store.UserStore, email string, now time.Time) {
+	st.RecordLogin(r.Context(), email, now)
+	// Always start a new session so a planted cookie is never promoted.
+	token, id, err := session.NewToken()
+	if err != nil {
+		logrus.Errorf("Failed to generate session token: %v", err)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	err = st.CreateSession(r.Context(), store.Session{
+		ID:         id,
+		UserAgent:  r.UserAgent(),
+		IPAddress:  clientIP(r),
+		UserEmail:  email,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	})
+	if err != nil {
+		logrus.Errorf("Failed to create session: %v", err)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	if old, err := r.Cookie(session.CookieName); err == nil && old.Value != "" {
+		oldID := session.ID(old.Value)
+		_ = st.ClaimSessionLinks(r.Context(), oldID, email)
+		_ = st.DeleteSession(r.Context(), oldID)
 	}
+	timeouts := session.TimeoutsFromEnv()
+	if err := st.DeleteExpiredSessions(r.Context(), now.Add(-timeouts.Idle), now.Add(-timeouts.Absolute)); err != nil {
+		logrus.Errorf("Failed to prune expired sessions: %v", err)
+	}
+	setSessionCookie(w, token, timeouts.Absolute)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"logged_in"}`))
 }
 
-func LogoutHandler(db *sql.DB) http.HandlerFunc {
+func LogoutHandler(st store.UserStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		sid, err := r.Cookie("UTL_SESSION")
+		sid, err := r.Cookie(session.CookieName)
 		if err != nil || sid.Value == "" {
 			logrus.Errorf("No session")
 			http.Error(w, "No session", http.StatusUnauthorized)
 			return
 		}
-		var email string
-		db.QueryRow(`SELECT user_email FROM sessions WHERE session_id = ?`, sid.Value).Scan(&email)
-		if email != "" {
-			_, _ = db.Exec(`UPDATE USERDEFN SET ISSIGNEDIN = 0, LASTSIGNOFFDTTM = ? WHERE EMAILID = ?`, time.Now(), email)
+		id := session.ID(sid.Value)
+		if sess, err := st.GetSession(r.Context(), id); err == nil {
+			_ = st.RecordLogout(r.Context(), sess.UserEmail, time.Now())
+			_ = st.DeleteSession(r.Context(), id)
 		}
-		_, _ = db.Exec(`UPDATE sessions SET user_email = NULL WHERE session_id = ?`, sid.Value)
-		http.SetCookie(w, &http.Cookie{
-			Name:     "UTL_SESSION",
-			Value:    "",
-			Path:     "/",
-			HttpOnly: true,
-			Secure:   true,
-			SameSite: http.SameSiteStrictMode,
-			MaxAge:   -1,
-		})
+		setSessionCookie(w, "", -time.Second)
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"status":"logged_out"}`))
 	}
 }
 
-func SessionStatusHandler(db *sql.DB) http.HandlerFunc {
+type sessionStatus struct {
+	LoggedIn  bool   `json:"logged_in"`
+	Email     string `json:"email,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+}
+
+// SessionStatusHandler tells the gateway who is behind the cookie. The
+// session ID it returns is the token hash, safe to hand to other services.
+func SessionStatusHandler(st store.UserStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		sid, err := r.Cookie("UTL_SESSION")
-		if err != nil || sid.Value == "" {
-			w.Header().Set("Content-Type", "application/json")
-			w.Write([]byte(`{"logged_in": false}`))
-			return
-		}
-		var email string
-		_ = db.QueryRow(`SELECT user_email FROM sessions WHERE session_id = ?`, sid.Value).Scan(&email)
-		if email != "" {
-			w.Header().Set("Content-Type", "application/json")
-			w.Write([]byte(`{"logged_in": true, "email": "` + email + `"}`))
+		var status sessionStatus
+		sess, err := currentSession(r, st)
+		if err == nil {
+			status = sessionStatus{LoggedIn: true, Email: sess.UserEmail, SessionID: sess.ID}
+		} else if err != store.ErrNotFound {
+			logrus.Errorf("Failed to load session: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(`{"logged_in": false}`))
+		json.NewEncoder(w).Encode(status)
 	}
 }
 
-func UserInfoHandler(db *sql.DB) http.HandlerFunc {
+func UserInfoHandler(st store.UserStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		email := r.URL.Query().Get("email")
 		if email == "" {
 			http.Error(w, "Missing email", http.StatusBadRequest)
 			return
 		}
-		var uniqueid, fullname string
-		err := db.QueryRow(`SELECT UNIQUEID, FULLNAMEDESC FROM USERDEFN WHERE EMAILID = ?`, email).Scan(&uniqueid, &fullname)
-		if err == sql.ErrNoRows {
+		user, err := st.GetUser(r.Context(), email)
+		if err == store.ErrNotFound {
 			http.Error(w, "User not found", http.StatusNotFound)
 			return
 		} else if err != nil {
@@ -298,8 +340,8 @@ func UserInfoHandler(db *sql.DB) http.HandlerFunc {
 		}
 		resp := map[string]interface{}{
 			"email":    email,
-			"uniqueid": uniqueid,
-			"fullname": fullname,
+			"uniqueid": user.UniqueID,
+			"fullname": user.FullName,
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(resp)
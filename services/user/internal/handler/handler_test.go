@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	netmail "net/mail"
+	"regexp"
+	"strings"
+	"testing"
+
+	"usethislink/services/user/internal/mail"
+	"usethislink/services/user/internal/otp"
+	"usethislink/services/user/internal/session"
+	"usethislink/services/user/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+// accountTest serves sign-up and password sign-in from a memory store and
+// keeps the emails it sends.
+type accountTest struct {
+	t      *testing.T
+	st     *store.MemoryStore
+	mailer *mail.MemoryMailer
+	router *mux.Router
+}
+
+func newAccountTest(t *testing.T) *accountTest {
+	t.Helper()
+	st := store.NewMemory()
+	m := mail.NewMemory(&netmail.Address{Address: "noreply@usethislink.test"})
+	key := otp.Key("test-key")
+	r := mux.NewRouter()
+	r.HandleFunc("/api/register", RegisterHandler(st, m, key)).Methods("POST")
+	r.HandleFunc("/api/verify-otp", VerifyOTPHandler(st, key)).Methods("POST")
+	r.HandleFunc("/api/login", LoginHandler(st, m)).Methods("POST")
+	r.HandleFunc("/api/logout", LogoutHandler(st)).Methods("POST")
+	r.HandleFunc("/api/session", SessionStatusHandler(st)).Methods("GET")
+	return &accountTest{t: t, st: st, mailer: m, router: r}
+}
+
+func (a *accountTest) do(method, target, body string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	a.t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	a.router.ServeHTTP(rec, req)
+	return rec
+}
+
+var otpPattern = regexp.MustCompile(`\b\d{6}\b`)
+
+// lastOTP is the code in the most recent email.
+func (a *accountTest) lastOTP() string {
+	a.t.Helper()
+	sent := a.mailer.Sent()
+	if len(sent) == 0 {
+		a.t.Fatal("no email sent")
+	}
+	code := otpPattern.FindString(sent[len(sent)-1].Text)
+	if code == "" {
+		a.t.Fatalf("no code in %q", sent[len(sent)-1].Text)
+	}
+	return code
+}
+
+func sessionCookie(rec *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == session.CookieName {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestRegisterVerifyLogin(t *testing.T) {
+	a := newAccountTest(t)
+	const creds = `{"email": "new@example.com", "password": "correct horse"}`
+	if rec := a.do("POST", "/api/register", creds, nil); rec.Code != http.StatusOK {
+		t.Fatalf("register = %d %s", rec.Code, rec.Body)
+	}
+	code := a.lastOTP()
+	if sent := a.mailer.Sent(); sent[0].To != "new@example.com" {
+		t.Errorf("OTP sent to %q", sent[0].To)
+	}
+	pending, err := a.st.GetPendingRegistration(context.Background(), "new@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(pending.OTPHash, code) {
+		t.Error("OTP stored in the clear")
+	}
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	if rec := a.do("POST", "/api/verify-otp", `{"email": "new@example.com", "otp": "`+wrong+`"}`, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("verify with a wrong code = %d, want 401", rec.Code)
+	}
+	if rec := a.do("POST", "/api/verify-otp", `{"email": "new@example.com", "otp": "`+code+`"}`, nil); rec.Code != http.StatusOK {
+		t.Fatalf("verify = %d %s", rec.Code, rec.Body)
+	}
+	if rec := a.do("POST", "/api/register", creds, nil); rec.Code != http.StatusConflict {
+		t.Errorf("registering again = %d, want 409", rec.Code)
+	}
+
+	if rec := a.do("POST", "/api/login", `{"email": "new@example.com", "password": "wrong"}`, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("login with a wrong password = %d, want 401", rec.Code)
+	}
+	rec := a.do("POST", "/api/login", creds, nil)
+	cookie := sessionCookie(rec)
+	if rec.Code != http.StatusOK || cookie == nil {
+		t.Fatalf("login = %d, cookie %v", rec.Code, cookie)
+	}
+
+	var status sessionStatus
+	if err := json.NewDecoder(a.do("GET", "/api/session", "", cookie).Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.LoggedIn || status.Email != "new@example.com" || status.SessionID == "" || status.SessionID == cookie.Value {
+		t.Errorf("session = %+v", status)
+	}
+
+	if rec := a.do("POST", "/api/logout", "", cookie); rec.Code != http.StatusOK {
+		t.Fatalf("logout = %d", rec.Code)
+	}
+	status = sessionStatus{}
+	if err := json.NewDecoder(a.do("GET", "/api/session", "", cookie).Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.LoggedIn {
+		t.Error("still logged in after logout")
+	}
+}
+
+func TestVerifyOTPWithoutRegistration(t *testing.T) {
+	a := newAccountTest(t)
+	if rec := a.do("POST", "/api/verify-otp", `{"email": "nobody@example.com", "otp": "123456"}`, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("verify = %d, want 404", rec.Code)
+	}
+	if rec := a.do("POST", "/api/login", `{"email": "nobody@example.com", "password": "x"}`, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("login = %d, want 401", rec.Code)
+	}
+}
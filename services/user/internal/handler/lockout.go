@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"usethislink/services/user/internal/lockout"
+	"usethislink/services/user/internal/mail"
+	"usethislink/services/user/internal/session"
+	"usethislink/services/user/internal/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+// audit writes an entry to the account's audit trail; failures are logged.
+func audit(r *http.Request, st store.UserStore, email, event, reason string) {
+	err := st.RecordAudit(r.Context(), store.AuditEvent{
+		Email:     email,
+		Event:     event,
+		Reason:    reason,
+		IPAddress: clientIP(r),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to write audit event %s for %s: %v", event, email, err)
+	}
+}
+
+// checkLock answers 403 (with Retry-After for timed locks) if user is locked
+// at now. A lock whose cooldown has passed is lifted instead.
+func checkLock(w http.ResponseWriter, r *http.Request, st store.UserStore, user store.User, now time.Time) bool {
+	if user.AcctLock == 0 {
+		return true
+	}
+	if !user.LockedUntil.IsZero() && !now.Before(user.LockedUntil) {
+		if err := st.UnlockUser(r.Context(), user.Email); err != nil {
+			logrus.Errorf("Failed to unlock account: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return false
+		}
+		audit(r, st, user.Email, store.AuditUnlock, "cooldown expired")
+		return true
+	}
+	if !user.LockedUntil.IsZero() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(user.LockedUntil.Sub(now).Seconds())+1))
+	}
+	logrus.Errorf("Account is locked")
+	http.Error(w, "Account is locked", http.StatusForbidden)
+	return false
+}
+
+// recordFailedLogin counts a wrong password for user and, once the policy's
+// threshold is reached, locks the account and emails its owner an unlock
+// link. It reports whether the account is now locked.
+func recordFailedLogin(r *http.Request, st store.UserStore, m mail.Mailer, user store.User, now time.Time) bool {
+	policy := lockout.PolicyFromEnv()
+	failures, err := st.RecordFailedLogin(r.Context(), user.Email, now, policy.Window)
+	if err != nil {
+		logrus.Errorf("Failed to record failed login: %v", err)
+		return false
+	}
+	if !policy.ShouldLock(failures) {
+		return false
+	}
+	until := now.Add(policy.LockFor(user.LockCount))
+	if err := st.LockUser(r.Context(), user.Email, until); err != nil {
+		logrus.Errorf("Failed to lock account: %v", err)
+		return false
+	}
+	audit(r, st, user.Email, store.AuditLock, fmt.Sprintf("%d failed logins within %s", failures, policy.Window))
+
+	token, hash, err := session.NewToken()
+	if err == nil {
+		err = st.CreateUnlockToken(r.Context(), store.EmailToken{Hash: hash, Email: user.Email, ExpiresAt: until})
+	}
+	if err != nil {
+		logrus.Errorf("Failed to create unlock token: %v", err)
+		return true
+	}
+	link := strings.TrimRight(os.Getenv("BASE_URL"), "/") + "/api/unlock?token=" + url.QueryEscape(token)
+	err = sendMail(r.Context(), m, "account_locked", user.LanguageCode, user.Email, map[string]any{
+		"Failures": failures,
+		"Until":    until.UTC().Format("2006-01-02 15:04 MST"),
+		"Link":     link,
+	})
+	if err != nil {
+		logrus.Errorf("Failed to send lockout email: %v", err)
+	}
+	return true
+}
+
+// UnlockHandler handles GET /api/unlock?token=, the link in the lockout email.
+// Tokens work once and only while the lock they were issued for lasts.
+func UnlockHandler(st store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			http.Error(w, "Missing token", http.StatusBadRequest)
+			return
+		}
+		email, err := st.ConsumeUnlockToken(r.Context(), session.ID(token), time.Now())
+		if err == store.ErrNotFound {
+			http.Error(w, "Invalid or expired unlock link", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to load unlock token: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if err := st.UnlockUser(r.Context(), email); err != nil {
+			logrus.Errorf("Failed to unlock account: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		audit(r, st, email, store.AuditUnlock, "email link")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"unlocked"}`))
+	}
+}
@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"usethislink/services/user/internal/mail"
+	"usethislink/services/user/internal/otp"
+	"usethislink/services/user/internal/session"
+	"usethislink/services/user/internal/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+// magicCookie holds the nonce that binds sign-in links to the browser that
+// asked for them.
+const magicCookie = "UTL_MAGIC"
+
+// magicLinkTTL is how long a sign-in link works, from MAGIC_LINK_TTL
+// (default 15m).
+func magicLinkTTL() time.Duration {
+	if v, err := time.ParseDuration(os.Getenv("MAGIC_LINK_TTL")); err == nil && v > 0 {
+		return v
+	}
+	return 15 * time.Minute
+}
+
+func setMagicCookie(w http.ResponseWriter, nonce string, maxAge time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicCookie,
+		Value:    nonce,
+		Path:     "/api/login/magic",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(maxAge / time.Second),
+	})
+}
+
+// MagicLinkHandler handles POST /api/login/magic with {"email"}. Like the
+// password reset it answers the same way whether or not the account exists.
+// Unless MAGIC_LINK_BIND_BROWSER is false, the link only works in the browser
+// that asked for it, which keeps a nonce cookie for the link's lifetime.
+// Links count towards the OTP send limits.
+func MagicLinkHandler(st store.UserStore, m mail.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Email string `json:"email"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+			logrus.Errorf("Invalid request: %v", err)
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		if !allowOTPSend(w, r, st, otp.PolicyFromEnv(), req.Email, now) {
+			return
+		}
+		if err := st.RecordOTPSend(r.Context(), req.Email, clientIP(r), now); err != nil {
+			logrus.Errorf("Failed to record magic link send: %v", err)
+		}
+		ttl := magicLinkTTL()
+		var browserHash string
+		if os.Getenv("MAGIC_LINK_BIND_BROWSER") != "false" {
+			// Reuse the browser's nonce so earlier links it asked for still work.
+			nonce := ""
+			if c, err := r.Cookie(magicCookie); err == nil {
+				nonce = c.Value
+			}
+			if nonce == "" {
+				var err error
+				if nonce, _, err = session.NewToken(); err != nil {
+					logrus.Errorf("Failed to generate magic link nonce: %v", err)
+					http.Error(w, "Failed to create sign-in link", http.StatusInternalServerError)
+					return
+				}
+			}
+			browserHash = session.ID(nonce)
+			setMagicCookie(w, nonce, ttl)
+		}
+		go sendMagicLink(st, m, req.Email, browserHash, now.Add(ttl))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"magic_link_sent"}`))
+	}
+}
+
+// sendMagicLink emails a sign-in link to email if it has an account.
+func sendMagicLink(st store.UserStore, m mail.Mailer, email, browserHash string, expires time.Time) {
+	ctx := context.Background()
+	user, err := st.GetUser(ctx, email)
+	if err == store.ErrNotFound {
+		return
+	} else if err != nil {
+		logrus.Errorf("Failed to load user for magic link: %v", err)
+		return
+	}
+	token, hash, err := session.NewToken()
+	if err == nil {
+		err = st.CreateMagicLink(ctx, store.MagicLink{Hash: hash, Email: user.Email, BrowserHash: browserHash, ExpiresAt: expires})
+	}
+	if err != nil {
+		logrus.Errorf("Failed to create magic link: %v", err)
+		return
+	}
+	link := strings.TrimRight(os.Getenv("BASE_URL"), "/") + "/login/magic?token=" + url.QueryEscape(token)
+	err = sendMail(ctx, m, "magic_link", user.LanguageCode, user.Email, map[string]any{
+		"Link":    link,
+		"Minutes": int(time.Until(expires) / time.Minute),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to send magic link email: %v", err)
+	}
+}
+
+// MagicLinkVerifyHandler handles POST /api/login/magic/verify with the token
+// from the link. The page the link opens posts it, so mail scanners that
+// follow links don't use it up. It signs in like a password would: locked
+// accounts stay locked and 2FA accounts still need their second factor.
+func MagicLinkVerifyHandler(st store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+			logrus.Errorf("Invalid request: %v", err)
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		link, err := st.ConsumeMagicLink(r.Context(), session.ID(req.Token), now)
+		if err == store.ErrNotFound {
+			http.Error(w, "Invalid or expired sign-in link", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to load magic link: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if link.BrowserHash != "" {
+			c, err := r.Cookie(magicCookie)
+			if err != nil || session.ID(c.Value) != link.BrowserHash {
+				logrus.Errorf("Magic link opened in another browser")
+				http.Error(w, "Open the sign-in link in the browser you requested it from", http.StatusForbidden)
+				return
+			}
+			setMagicCookie(w, "", -time.Second)
+		}
+		user, err := st.GetUser(r.Context(), link.Email)
+		if err == store.ErrNotFound {
+			http.Error(w, "Invalid or expired sign-in link", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			logrus.Errorf("DB error: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if !checkLock(w, r, st, user, now) {
+			return
+		}
+		if user.TOTPEnabled {
+			startLoginChallenge(w, r, st, user.Email, now)
+			return
+		}
+		startSession(w, r, st, user.Email, now)
+	}
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"usethislink/services/user/internal/mail"
+	"usethislink/services/user/internal/profile"
+	"usethislink/services/user/internal/store"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type meResponse struct {
+	Email              string `json:"email"`
+	UniqueID           string `json:"uniqueid"`
+	FullName           string `json:"fullname"`
+	LanguageCode       string `json:"language_code"`
+	CurrencyCode       string `json:"currency_code"`
+	DefaultHome        string `json:"default_home"`
+	TwoFactorEnabled   bool   `json:"two_factor_enabled"`
+	CreatedAt          string `json:"created_at,omitempty"`
+	LastPasswordChange string `json:"last_password_change,omitempty"`
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func writeMe(w http.ResponseWriter, u store.User) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(meResponse{
+		Email:              u.Email,
+		UniqueID:           u.UniqueID,
+		FullName:           u.FullName,
+		LanguageCode:       u.LanguageCode,
+		CurrencyCode:       u.CurrencyCode,
+		DefaultHome:        u.DefaultHome,
+		TwoFactorEnabled:   u.TOTPEnabled,
+		CreatedAt:          formatTime(u.CreatedAt),
+		LastPasswordChange: formatTime(u.LastPswdChange),
+	})
+}
+
+// reloadMe answers with the caller's account as stored after a change.
+func reloadMe(w http.ResponseWriter, r *http.Request, st store.UserStore, email string) {
+	user, err := st.GetUser(r.Context(), email)
+	if err != nil {
+		logrus.Errorf("Failed to load user: %v", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	writeMe(w, user)
+}
+
+// MeHandler handles GET /api/me: the signed-in user's profile and preferences.
+func MeHandler(st store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, user, ok := signedInUser(w, r, st)
+		if !ok {
+			return
+		}
+		writeMe(w, user)
+	}
+}
+
+// UpdateMeHandler handles PATCH /api/me. Only the fields present are changed:
+// fullname, language_code (ISO 639, stored as the 639-2 code), currency_code
+// (ISO 4217) and default_home (one of the app's pages, or "" for the default).
+func UpdateMeHandler(st store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, user, ok := signedInUser(w, r, st)
+		if !ok {
+			return
+		}
+		var req struct {
+			FullName     *string `json:"fullname"`
+			LanguageCode *string `json:"language_code"`
+			CurrencyCode *string `json:"currency_code"`
+			DefaultHome  *string `json:"default_home"`
+		}
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		p := store.Profile{
+			FullName:     user.FullName,
+			LanguageCode: user.LanguageCode,
+			CurrencyCode: user.CurrencyCode,
+			DefaultHome:  user.DefaultHome,
+		}
+		if req.FullName != nil {
+			if p.FullName, ok = profile.FullName(*req.FullName); !ok {
+				http.Error(w, "fullname must be at most 100 characters without control characters", http.StatusBadRequest)
+				return
+			}
+		}
+		if req.LanguageCode != nil {
+			if p.LanguageCode, ok = profile.Language(*req.LanguageCode); !ok {
+				http.Error(w, "language_code must be a supported ISO 639 language code", http.StatusBadRequest)
+				return
+			}
+		}
+		if req.CurrencyCode != nil {
+			if p.CurrencyCode, ok = profile.Currency(*req.CurrencyCode); !ok {
+				http.Error(w, "currency_code must be an ISO 4217 currency code", http.StatusBadRequest)
+				return
+			}
+		}
+		if req.DefaultHome != nil {
+			if !profile.Home(*req.DefaultHome) {
+				http.Error(w, "default_home is not an allowed page", http.StatusBadRequest)
+				return
+			}
+			p.DefaultHome = *req.DefaultHome
+		}
+		if err := st.UpdateProfile(r.Context(), user.Email, p, time.Now()); err != nil {
+			logrus.Errorf("Failed to update profile: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		reloadMe(w, r, st, user.Email)
+	}
+}
+
+// ChangePasswordHandler handles POST /api/me/password. The current password
+// must be given, and wrong ones count towards the lockout. The caller stays
+// signed in; their other sessions are revoked.
+func ChangePasswordHandler(st store.UserStore, m mail.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sess, user, ok := signedInUser(w, r, st)
+		if !ok {
+			return
+		}
+		var req struct {
+			CurrentPassword string `json:"current_password"`
+			NewPassword     string `json:"new_password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CurrentPassword == "" || req.NewPassword == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		if !checkLock(w, r, st, user, now) {
+			return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)) != nil {
+			if locked := recordFailedLogin(r, st, m, user, now); locked {
+				http.Error(w, "Account is locked", http.StatusForbidden)
+				return
+			}
+			http.Error(w, "Current password is incorrect", http.StatusUnauthorized)
+			return
+		}
+		phash, err := hashPassword(req.NewPassword)
+		if err != nil {
+			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+			return
+		}
+		if err := st.SetPassword(r.Context(), user.Email, phash, now); err != nil {
+			logrus.Errorf("Failed to set password: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := st.DeleteUserSessions(r.Context(), user.Email, sess.ID); err != nil {
+			logrus.Errorf("Failed to revoke sessions after password change: %v", err)
+		}
+		audit(r, st, user.Email, store.AuditPasswordChange, "")
+		reloadMe(w, r, st, user.Email)
+	}
+}
@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"usethislink/services/user/internal/oidc"
+	"usethislink/services/user/internal/profile"
+	"usethislink/services/user/internal/session"
+	"usethislink/services/user/internal/store"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// oidcCookie holds the state of the browser's sign-in in progress.
+	oidcCookie = "UTL_OIDC"
+	// oidcLoginTTL is how long the user has at the provider.
+	oidcLoginTTL = 10 * time.Minute
+)
+
+func setOIDCCookie(w http.ResponseWriter, state string, maxAge time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    state,
+		Path:     "/api/oidc",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(maxAge / time.Second),
+	})
+}
+
+// OIDCProvidersHandler handles GET /api/oidc/providers: the names of the
+// configured providers, for sign-in buttons.
+func OIDCProvidersHandler(providers oidc.Providers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]string{"providers": providers.Names()})
+	}
+}
+
+// OIDCStartHandler handles POST /api/oidc/{provider}/start. It answers
+// {"authorization_url"} for the browser to go to, and ties the sign-in to the
+// browser with a state cookie.
+func OIDCStartHandler(st store.UserStore, providers oidc.Providers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := providers[mux.Vars(r)["provider"]]
+		if !ok {
+			http.Error(w, "Unknown provider", http.StatusNotFound)
+			return
+		}
+		state, stateHash, err := session.NewToken()
+		var nonce, verifier string
+		if err == nil {
+			nonce, _, err = session.NewToken()
+		}
+		if err == nil {
+			verifier, err = oidc.NewVerifier()
+		}
+		if err != nil {
+			logrus.Errorf("Failed to generate OIDC state: %v", err)
+			http.Error(w, "Failed to start sign-in", http.StatusInternalServerError)
+			return
+		}
+		authURL, err := p.AuthCodeURL(r.Context(), state, nonce, verifier)
+		if err != nil {
+			logrus.Errorf("OIDC provider %s unavailable: %v", p.Name, err)
+			http.Error(w, "Provider unavailable", http.StatusBadGateway)
+			return
+		}
+		err = st.CreateOIDCLogin(r.Context(), store.OIDCLogin{
+			StateHash:    stateHash,
+			Provider:     p.Name,
+			Nonce:        nonce,
+			CodeVerifier: verifier,
+			ExpiresAt:    time.Now().Add(oidcLoginTTL),
+		})
+		if err != nil {
+			logrus.Errorf("Failed to store OIDC sign-in: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		setOIDCCookie(w, state, oidcLoginTTL)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"authorization_url": authURL})
+	}
+}
+
+// OIDCCallbackHandler handles POST /api/oidc/callback with the state and code
+// (or error) the provider redirected back with. The first sign-in links the
+// provider account to the user with its verified email, creating the user if
+// there is none. Then it signs in like a password would: locked accounts stay
+// locked and 2FA accounts still need their second factor.
+func OIDCCallbackHandler(st store.UserStore, providers oidc.Providers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			State string `json:"state"`
+			Code  string `json:"code"`
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.State == "" || (req.Code == "" && req.Error == "") {
+			logrus.Errorf("Invalid request: %v", err)
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		c, err := r.Cookie(oidcCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(c.Value), []byte(req.State)) != 1 {
+			logrus.Errorf("OIDC state does not match this browser")
+			http.Error(w, "Sign-in was started in another browser", http.StatusForbidden)
+			return
+		}
+		setOIDCCookie(w, "", -time.Second)
+		now := time.Now()
+		login, err := st.ConsumeOIDCLogin(r.Context(), session.ID(req.State), now)
+		if err == store.ErrNotFound {
+			http.Error(w, "Invalid or expired sign-in", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to load OIDC sign-in: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if req.Error != "" {
+			logrus.Errorf("OIDC provider %s refused sign-in: %s", login.Provider, req.Error)
+			http.Error(w, "Sign-in was cancelled or refused by the provider", http.StatusUnauthorized)
+			return
+		}
+		p, ok := providers[login.Provider]
+		if !ok {
+			http.Error(w, "Unknown provider", http.StatusBadRequest)
+			return
+		}
+		claims, err := p.Exchange(r.Context(), req.Code, login.CodeVerifier, login.Nonce)
+		if err != nil {
+			logrus.Errorf("OIDC sign-in with %s failed: %v", p.Name, err)
+			http.Error(w, "Sign-in with the provider failed", http.StatusUnauthorized)
+			return
+		}
+		email, ok := oidcAccount(w, r, st, p.Name, claims, now)
+		if !ok {
+			return
+		}
+		user, err := st.GetUser(r.Context(), email)
+		if err != nil {
+			logrus.Errorf("Failed to load user: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if !checkLock(w, r, st, user, now) {
+			return
+		}
+		if user.TOTPEnabled {
+			startLoginChallenge(w, r, st, user.Email, now)
+			return
+		}
+		startSession(w, r, st, user.Email, now)
+	}
+}
+
+// oidcAccount returns the email of the user linked to the provider account,
+// linking or creating one by the verified email on first sign-in.
+func oidcAccount(w http.ResponseWriter, r *http.Request, st store.UserStore, provider string, claims oidc.Claims, now time.Time) (string, bool) {
+	id, err := st.GetIdentity(r.Context(), provider, claims.Subject)
+	if err == nil {
+		return id.Email, true
+	} else if err != store.ErrNotFound {
+		logrus.Errorf("Failed to load identity: %v", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return "", false
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		http.Error(w, "The provider did not confirm your email address", http.StatusForbidden)
+		return "", false
+	}
+	reason := "linked by verified email"
+	name, _ := profile.FullName(claims.Name)
+	err = st.CreateUser(r.Context(), store.User{
+		Email:        claims.Email,
+		UniqueID:     base64.URLEncoding.EncodeToString([]byte(fmt.Sprintf("%s-%d", claims.Email, now.UnixNano()))),
+		FullName:     name,
+		CreatedAt:    now,
+		LastUpdated:  now,
+		LanguageCode: "ENG",
+		CurrencyCode: "INR",
+	})
+	if err == nil {
+		reason = "account created"
+	} else if err != store.ErrConflict {
+		logrus.Errorf("Failed to create user: %v", err)
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return "", false
+	}
+	err = st.LinkIdentity(r.Context(), store.Identity{Provider: provider, Subject: claims.Subject, Email: claims.Email, CreatedAt: now})
+	if err == store.ErrConflict {
+		// Linked concurrently; use whatever won.
+		return oidcAccount(w, r, st, provider, claims, now)
+	} else if err != nil {
+		logrus.Errorf("Failed to link identity: %v", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return "", false
+	}
+	audit(r, st, claims.Email, store.AuditIdentityLinked, provider+": "+reason)
+	return claims.Email, true
+}
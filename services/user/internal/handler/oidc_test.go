@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"usethislink/services/user/internal/oidc"
+	"usethislink/services/user/internal/oidc/oidctest"
+	"usethislink/services/user/internal/session"
+	"usethislink/services/user/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+const oidcTestRedirect = "https://usethislink.test/login/oidc/callback"
+
+// oidcTest is the user service's OIDC routes in front of a mock provider.
+type oidcTest struct {
+	t      *testing.T
+	st     *store.MemoryStore
+	idp    *oidctest.Server
+	router *mux.Router
+}
+
+func newOIDCTest(t *testing.T, id oidctest.Identity) *oidcTest {
+	t.Helper()
+	idp, ts, err := oidctest.Start("usethislink", "secret", oidcTestRedirect, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ts.Close)
+	providers := oidc.Providers{
+		"mock": oidc.NewProvider("mock", idp.Issuer(), "usethislink", "secret", oidcTestRedirect, []string{"openid", "email"}, ts.Client()),
+	}
+	st := store.NewMemory()
+	r := mux.NewRouter()
+	r.HandleFunc("/api/oidc/{provider}/start", OIDCStartHandler(st, providers)).Methods("POST")
+	r.HandleFunc("/api/oidc/callback", OIDCCallbackHandler(st, providers)).Methods("POST")
+	return &oidcTest{t: t, st: st, idp: idp, router: r}
+}
+
+// start begins a sign-in and returns the browser's state cookie and the
+// provider's redirect back (state and code).
+func (o *oidcTest) start() (*http.Cookie, url.Values) {
+	o.t.Helper()
+	rec := httptest.NewRecorder()
+	o.router.ServeHTTP(rec, httptest.NewRequest("POST", "/api/oidc/mock/start", nil))
+	if rec.Code != http.StatusOK {
+		o.t.Fatalf("start: %d %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		o.t.Fatal(err)
+	}
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == oidcCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		o.t.Fatal("start set no state cookie")
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	authResp, err := client.Get(resp.AuthorizationURL)
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	authResp.Body.Close()
+	back, err := url.Parse(authResp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(back.String(), oidcTestRedirect) {
+		o.t.Fatalf("authorize redirected to %q", authResp.Header.Get("Location"))
+	}
+	return cookie, back.Query()
+}
+
+// callback posts the redirect's parameters as the callback page does.
+func (o *oidcTest) callback(cookie *http.Cookie, q url.Values) *httptest.ResponseRecorder {
+	o.t.Helper()
+	body, _ := json.Marshal(map[string]string{"state": q.Get("state"), "code": q.Get("code"), "error": q.Get("error")})
+	req := httptest.NewRequest("POST", "/api/oidc/callback", strings.NewReader(string(body)))
+	if cookie != nil {
+		req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	rec := httptest.NewRecorder()
+	o.router.ServeHTTP(rec, req)
+	return rec
+}
+
+var oidcTestIdentity = oidctest.Identity{Subject: "sub-1", Email: "new@example.com", EmailVerified: true, Name: "New User"}
+
+func TestOIDCSignInCreatesAndLinksUser(t *testing.T) {
+	o := newOIDCTest(t, oidcTestIdentity)
+	cookie, q := o.start()
+	rec := o.callback(cookie, q)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"logged_in"`) {
+		t.Fatalf("callback: %d %s", rec.Code, rec.Body)
+	}
+	var sessionCookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == session.CookieName {
+			sessionCookie = c
+		}
+	}
+	if sessionCookie == nil {
+		t.Fatal("no session cookie")
+	}
+	sess, err := o.st.GetSession(context.Background(), session.ID(sessionCookie.Value))
+	if err != nil || sess.UserEmail != oidcTestIdentity.Email {
+		t.Fatalf("session = %+v, %v", sess, err)
+	}
+	if _, err := o.st.GetUser(context.Background(), oidcTestIdentity.Email); err != nil {
+		t.Fatalf("user not created: %v", err)
+	}
+	id, err := o.st.GetIdentity(context.Background(), "mock", oidcTestIdentity.Subject)
+	if err != nil || id.Email != oidcTestIdentity.Email {
+		t.Fatalf("identity = %+v, %v", id, err)
+	}
+
+	// The state is single-use.
+	if rec := o.callback(cookie, q); rec.Code != http.StatusBadRequest {
+		t.Errorf("replayed callback: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOIDCCallbackFromAnotherBrowser(t *testing.T) {
+	o := newOIDCTest(t, oidcTestIdentity)
+	_, q := o.start()
+	if rec := o.callback(nil, q); rec.Code != http.StatusForbidden {
+		t.Fatalf("callback without the state cookie: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOIDCCallbackPKCEMismatch(t *testing.T) {
+	o := newOIDCTest(t, oidcTestIdentity)
+	// A code issued to one sign-in, injected into another, is redeemed with
+	// the wrong verifier.
+	_, first := o.start()
+	cookie, second := o.start()
+	second.Set("code", first.Get("code"))
+	if rec := o.callback(cookie, second); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("callback with another sign-in's code: %d %s", rec.Code, rec.Body)
+	}
+	if _, err := o.st.GetUser(context.Background(), oidcTestIdentity.Email); err != store.ErrNotFound {
+		t.Errorf("user created after a failed sign-in: %v", err)
+	}
+}
+
+func TestOIDCCallbackRejectsBadTokens(t *testing.T) {
+	for name, claims := range map[string]map[string]any{
+		"nonce mismatch": {"nonce": "replayed"},
+		"wrong issuer":   {"iss": "https://evil.test"},
+		"wrong audience": {"aud": "someone-else"},
+		"expired":        {"exp": 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			o := newOIDCTest(t, oidcTestIdentity)
+			o.idp.SetClaims(claims)
+			cookie, q := o.start()
+			if rec := o.callback(cookie, q); rec.Code != http.StatusUnauthorized {
+				t.Fatalf("callback: %d %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackUnverifiedEmail(t *testing.T) {
+	id := oidcTestIdentity
+	id.EmailVerified = false
+	o := newOIDCTest(t, id)
+	cookie, q := o.start()
+	if rec := o.callback(cookie, q); rec.Code != http.StatusForbidden {
+		t.Fatalf("callback: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOIDCCallbackProviderError(t *testing.T) {
+	o := newOIDCTest(t, oidcTestIdentity)
+	cookie, q := o.start()
+	q.Del("code")
+	q.Set("error", "access_denied")
+	if rec := o.callback(cookie, q); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("callback: %d %s", rec.Code, rec.Body)
+	}
+}
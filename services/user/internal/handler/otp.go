@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"usethislink/services/user/internal/otp"
+	"usethislink/services/user/internal/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+// allowOTPSend answers 429 with Retry-After if another OTP can't be sent to
+// email yet: the resend cooldown hasn't passed, or the address or the
+// caller's IP has hit its daily cap.
+func allowOTPSend(w http.ResponseWriter, r *http.Request, st store.UserStore, policy otp.Policy, email string, now time.Time) bool {
+	sends, err := st.OTPSends(r.Context(), email, clientIP(r), now.Add(-otp.Day))
+	if err != nil {
+		logrus.Errorf("Failed to load OTP sends: %v", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return false
+	}
+	var until time.Time
+	later := func(t time.Time) {
+		if t.After(until) {
+			until = t
+		}
+	}
+	if sends.ByEmail > 0 {
+		if t := sends.LastToEmail.Add(policy.ResendCooldown); now.Before(t) {
+			later(t)
+		}
+	}
+	if sends.ByEmail >= policy.DailyPerEmail {
+		later(sends.FirstToEmail.Add(otp.Day))
+	}
+	if sends.ByIP >= policy.DailyPerIP {
+		later(sends.FirstFromIP.Add(otp.Day))
+	}
+	if until.IsZero() {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(until.Sub(now).Seconds())+1))
+	logrus.Errorf("OTP send limited for %s from %s", email, clientIP(r))
+	http.Error(w, "Too many codes requested, try again later", http.StatusTooManyRequests)
+	return false
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"usethislink/services/user/internal/mail"
+	"usethislink/services/user/internal/session"
+	"usethislink/services/user/internal/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+// passwordResetTTL is how long a reset link works, from PASSWORD_RESET_TTL
+// (default 1h).
+func passwordResetTTL() time.Duration {
+	if v, err := time.ParseDuration(os.Getenv("PASSWORD_RESET_TTL")); err == nil && v > 0 {
+		return v
+	}
+	return time.Hour
+}
+
+// ForgotPasswordHandler handles POST /api/password/forgot. It answers the same
+// way whether or not the account exists; the email is sent in the background
+// so response times don't tell them apart either.
+func ForgotPasswordHandler(st store.UserStore, m mail.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Email string `json:"email"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+			logrus.Errorf("Invalid request: %v", err)
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		go sendPasswordReset(st, m, req.Email)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"reset_sent"}`))
+	}
+}
+
+// sendPasswordReset emails a reset link to email if it has an account.
+func sendPasswordReset(st store.UserStore, m mail.Mailer, email string) {
+	ctx := context.Background()
+	user, err := st.GetUser(ctx, email)
+	if err == store.ErrNotFound {
+		return
+	} else if err != nil {
+		logrus.Errorf("Failed to load user for password reset: %v", err)
+		return
+	}
+	ttl := passwordResetTTL()
+	token, hash, err := session.NewToken()
+	if err == nil {
+		err = st.CreatePasswordReset(ctx, store.EmailToken{Hash: hash, Email: email, ExpiresAt: time.Now().Add(ttl)})
+	}
+	if err != nil {
+		logrus.Errorf("Failed to create password reset token: %v", err)
+		return
+	}
+	link := strings.TrimRight(os.Getenv("BASE_URL"), "/") + "/reset-password?token=" + url.QueryEscape(token)
+	err = sendMail(ctx, m, "password_reset", user.LanguageCode, email, map[string]any{
+		"Link":    link,
+		"Minutes": int(ttl / time.Minute),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to send password reset email: %v", err)
+	}
+}
+
+// ResetPasswordHandler handles POST /api/password/reset with the token from
+// the email and a new password. Every session of the account is revoked, and
+// a lockout is lifted since the owner has proven access to the mailbox.
+func ResetPasswordHandler(st store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Token    string `json:"token"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" || req.Password == "" {
+			logrus.Errorf("Invalid request: %v", err)
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		email, err := st.ConsumePasswordReset(r.Context(), session.ID(req.Token), now)
+		if err == store.ErrNotFound {
+			http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to load password reset token: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		phash, err := hashPassword(req.Password)
+		if err != nil {
+			logrus.Errorf("Failed to hash password: %v", err)
+			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+			return
+		}
+		if err := st.SetPassword(r.Context(), email, phash, now); err == store.ErrNotFound {
+			http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to set password: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := st.DeleteUserSessions(r.Context(), email, ""); err != nil {
+			logrus.Errorf("Failed to revoke sessions after password reset: %v", err)
+		}
+		audit(r, st, email, store.AuditPasswordReset, "email link")
+		if user, err := st.GetUser(r.Context(), email); err == nil && user.AcctLock != 0 {
+			if err := st.UnlockUser(r.Context(), email); err != nil {
+				logrus.Errorf("Failed to unlock account: %v", err)
+			} else {
+				audit(r, st, email, store.AuditUnlock, "password reset")
+			}
+		}
+		setSessionCookie(w, "", -time.Second)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"password_reset"}`))
+	}
+}
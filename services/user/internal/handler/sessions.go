@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"usethislink/services/user/internal/session"
+	"usethislink/services/user/internal/store"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// clientIP is the browser's address: the last X-Forwarded-For hop, which the
+// gateway appends, or the peer address. Earlier hops are client-supplied.
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		hops := strings.Split(fwd, ",")
+		return strings.TrimSpace(hops[len(hops)-1])
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// currentSession returns the live session behind the request's cookie and
+// slides its idle timeout. Missing, unknown and expired sessions (which are
+// deleted on the way) all give store.ErrNotFound.
+func currentSession(r *http.Request, st store.UserStore) (store.Session, error) {
+	c, err := r.Cookie(session.CookieName)
+	if err != nil || c.Value == "" {
+		return store.Session{}, store.ErrNotFound
+	}
+	sess, err := st.GetSession(r.Context(), session.ID(c.Value))
+	if err != nil {
+		return sess, err
+	}
+	now := time.Now()
+	if session.TimeoutsFromEnv().Expired(sess.CreatedAt, sess.LastSeenAt, now) {
+		if err := st.DeleteSession(r.Context(), sess.ID); err != nil && err != store.ErrNotFound {
+			logrus.Errorf("Failed to delete expired session: %v", err)
+		}
+		return store.Session{}, store.ErrNotFound
+	}
+	if now.Sub(sess.LastSeenAt) >= session.TouchInterval {
+		if err := st.TouchSession(r.Context(), sess.ID, now); err != nil {
+			logrus.Errorf("Failed to update session: %v", err)
+		}
+		sess.LastSeenAt = now
+	}
+	return sess, nil
+}
+
+// signedIn answers 401 unless the request carries a live session.
+func signedIn(w http.ResponseWriter, r *http.Request, st store.UserStore) (store.Session, bool) {
+	sess, err := currentSession(r, st)
+	if err == store.ErrNotFound {
+		http.Error(w, "Not signed in", http.StatusUnauthorized)
+		return sess, false
+	} else if err != nil {
+		logrus.Errorf("Failed to load session: %v", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return sess, false
+	}
+	return sess, true
+}
+
+type sessionResponse struct {
+	ID         string `json:"id"`
+	Device     string `json:"device"`
+	UserAgent  string `json:"user_agent"`
+	IPAddress  string `json:"ip_address"`
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	Current    bool   `json:"current"`
+}
+
+// ListSessionsHandler handles GET /api/sessions: the caller's live sessions,
+// most recently used first.
+func ListSessionsHandler(st store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current, ok := signedIn(w, r, st)
+		if !ok {
+			return
+		}
+		sessions, err := st.ListSessions(r.Context(), current.UserEmail)
+		if err != nil {
+			logrus.Errorf("Failed to list sessions: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		timeouts := session.TimeoutsFromEnv()
+		now := time.Now()
+		resp := make([]sessionResponse, 0, len(sessions))
+		for _, s := range sessions {
+			if timeouts.Expired(s.CreatedAt, s.LastSeenAt, now) {
+				continue
+			}
+			resp = append(resp, sessionResponse{
+				ID:         s.ID,
+				Device:     session.Device(s.UserAgent),
+				UserAgent:  s.UserAgent,
+				IPAddress:  s.IPAddress,
+				CreatedAt:  s.CreatedAt.Format(time.RFC3339),
+				LastSeenAt: s.LastSeenAt.Format(time.RFC3339),
+				Current:    s.ID == current.ID,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// RevokeSessionHandler handles DELETE /api/sessions/{id}. Revoking the
+// current session signs the caller out.
+func RevokeSessionHandler(st store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current, ok := signedIn(w, r, st)
+		if !ok {
+			return
+		}
+		id := mux.Vars(r)["id"]
+		target, err := st.GetSession(r.Context(), id)
+		if err == store.ErrNotFound || (err == nil && target.UserEmail != current.UserEmail) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to load session: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if err := st.DeleteSession(r.Context(), id); err != nil && err != store.ErrNotFound {
+			logrus.Errorf("Failed to revoke session: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if id == current.ID {
+			_ = st.RecordLogout(r.Context(), current.UserEmail, time.Now())
+			setSessionCookie(w, "", -time.Second)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RevokeAllSessionsHandler handles DELETE /api/sessions: every session of the
+// caller ends, or with ?keep_current=true every other one.
+func RevokeAllSessionsHandler(st store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current, ok := signedIn(w, r, st)
+		if !ok {
+			return
+		}
+		keep := ""
+		if v := r.URL.Query().Get("keep_current"); v == "1" || v == "true" {
+			keep = current.ID
+		}
+		n, err := st.DeleteUserSessions(r.Context(), current.UserEmail, keep)
+		if err != nil {
+			logrus.Errorf("Failed to revoke sessions: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if keep == "" {
+			_ = st.RecordLogout(r.Context(), current.UserEmail, time.Now())
+			setSessionCookie(w, "", -time.Second)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"revoked": n})
+	}
+}
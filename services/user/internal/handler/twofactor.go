@@ -0,0 +1,371 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"usethislink/services/user/internal/mail"
+	"usethislink/services/user/internal/session"
+	"usethislink/services/user/internal/store"
+	"usethislink/services/user/internal/totp"
+
+	"github.com/sirupsen/logrus"
+	qrcode "github.com/skip2/go-qrcode"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// challengeTTL is how long the pre-auth token between the password and
+	// code steps of signing in lasts.
+	challengeTTL = 5 * time.Minute
+	// maxChallengeAttempts wrong codes void a pre-auth token.
+	maxChallengeAttempts = 5
+)
+
+// totpIssuer names the account in authenticator apps, from TOTP_ISSUER.
+func totpIssuer() string {
+	if v := os.Getenv("TOTP_ISSUER"); v != "" {
+		return v
+	}
+	return "UseThisLink"
+}
+
+// secondFactor is the code part of a request: an authenticator code or,
+// instead, one of the account's recovery codes.
+type secondFactor struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// checkSecondFactor reports whether f is a valid code for user's enabled
+// authenticator or an unused recovery code, using it up either way.
+func checkSecondFactor(r *http.Request, st store.UserStore, user store.User, f secondFactor, now time.Time) (bool, error) {
+	if f.RecoveryCode != "" {
+		err := st.UseRecoveryCode(r.Context(), user.Email, totp.HashRecoveryCode(f.RecoveryCode))
+		if err == store.ErrNotFound {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		audit(r, st, user.Email, store.AuditRecoveryCodeUsed, "")
+		return true, nil
+	}
+	step, ok := totp.Verify(user.TOTPSecret, f.Code, now, user.TOTPLastStep)
+	if !ok {
+		return false, nil
+	}
+	return st.UseTOTPStep(r.Context(), user.Email, step)
+}
+
+// startLoginChallenge answers a correct password on a 2FA account with a
+// pre-auth token for POST /api/login/2fa instead of a session.
+func startLoginChallenge(w http.ResponseWriter, r *http.Request, st store.UserStore, email string, now time.Time) {
+	token, hash, err := session.NewToken()
+	if err == nil {
+		err = st.CreateLoginChallenge(r.Context(), store.LoginChallenge{Hash: hash, Email: email, ExpiresAt: now.Add(challengeTTL)})
+	}
+	if err != nil {
+		logrus.Errorf("Failed to create login challenge: %v", err)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "2fa_required", "pre_auth_token": token})
+}
+
+// LoginTwoFactorHandler handles POST /api/login/2fa, the second step of
+// signing in to a 2FA account: the pre-auth token from /api/login plus a code
+// or recovery code. Wrong codes count towards the account lockout.
+func LoginTwoFactorHandler(st store.UserStore, m mail.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			PreAuthToken string `json:"pre_auth_token"`
+			secondFactor
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PreAuthToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+			logrus.Errorf("Invalid request: %v", err)
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		hash := session.ID(req.PreAuthToken)
+		challenge, err := st.GetLoginChallenge(r.Context(), hash)
+		if err == nil && now.After(challenge.ExpiresAt) {
+			_ = st.DeleteLoginChallenge(r.Context(), hash)
+			err = store.ErrNotFound
+		}
+		if err == store.ErrNotFound {
+			http.Error(w, "Sign-in expired, please log in again", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			logrus.Errorf("Failed to load login challenge: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		user, err := st.GetUser(r.Context(), challenge.Email)
+		if err != nil {
+			logrus.Errorf("Failed to load user: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if !checkLock(w, r, st, user, now) {
+			_ = st.DeleteLoginChallenge(r.Context(), hash)
+			return
+		}
+		ok, err := checkSecondFactor(r, st, user, req.secondFactor, now)
+		if err != nil {
+			logrus.Errorf("Failed to check code: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			attempts, err := st.FailLoginChallenge(r.Context(), hash)
+			locked := recordFailedLogin(r, st, m, user, now)
+			if locked || err != nil || attempts >= maxChallengeAttempts {
+				_ = st.DeleteLoginChallenge(r.Context(), hash)
+			}
+			if locked {
+				http.Error(w, "Account is locked", http.StatusForbidden)
+				return
+			}
+			logrus.Errorf("Invalid authentication code")
+			http.Error(w, "Invalid authentication code", http.StatusUnauthorized)
+			return
+		}
+		_ = st.DeleteLoginChallenge(r.Context(), hash)
+		startSession(w, r, st, user.Email, now)
+	}
+}
+
+// signedInUser is signedIn plus the account behind the session.
+func signedInUser(w http.ResponseWriter, r *http.Request, st store.UserStore) (store.Session, store.User, bool) {
+	sess, ok := signedIn(w, r, st)
+	if !ok {
+		return sess, store.User{}, false
+	}
+	user, err := st.GetUser(r.Context(), sess.UserEmail)
+	if err != nil {
+		logrus.Errorf("Failed to load user: %v", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return sess, user, false
+	}
+	return sess, user, true
+}
+
+// TwoFactorStatusHandler handles GET /api/2fa.
+func TwoFactorStatusHandler(st store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, user, ok := signedInUser(w, r, st)
+		if !ok {
+			return
+		}
+		remaining, err := st.CountRecoveryCodes(r.Context(), user.Email)
+		if err != nil {
+			logrus.Errorf("Failed to count recovery codes: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"enabled":                  user.TOTPEnabled,
+			"recovery_codes_remaining": remaining,
+		})
+	}
+}
+
+// TwoFactorSetupHandler handles POST /api/2fa/setup. It stores a new secret,
+// not yet enabled, and returns it as an otpauth:// URI and a PNG QR code
+// (data URI) for the authenticator app. Calling it again restarts enrollment.
+func TwoFactorSetupHandler(st store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, user, ok := signedInUser(w, r, st)
+		if !ok {
+			return
+		}
+		if user.TOTPEnabled {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+		secret, err := totp.NewSecret()
+		if err != nil {
+			logrus.Errorf("Failed to generate TOTP secret: %v", err)
+			http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+			return
+		}
+		uri := totp.URI(totpIssuer(), user.Email, secret)
+		png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+		if err != nil {
+			logrus.Errorf("Failed to render TOTP QR code: %v", err)
+			http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
+			return
+		}
+		if err := st.SetTOTPSecret(r.Context(), user.Email, secret); err != nil {
+			logrus.Errorf("Failed to store TOTP secret: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]string{
+			"secret":      secret,
+			"otpauth_uri": uri,
+			"qr_code":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+		})
+	}
+}
+
+// newRecoveryCodes returns fresh recovery codes and their hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := totp.NewRecoveryCodes()
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = totp.HashRecoveryCode(c)
+	}
+	return codes, hashes, nil
+}
+
+func writeRecoveryCodes(w http.ResponseWriter, status string, codes []string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "recovery_codes": codes})
+}
+
+// TwoFactorEnableHandler handles POST /api/2fa/enable with a code from the
+// newly enrolled app. It answers with the recovery codes, shown only this once.
+func TwoFactorEnableHandler(st store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, user, ok := signedInUser(w, r, st)
+		if !ok {
+			return
+		}
+		var req struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if user.TOTPEnabled {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+		if user.TOTPSecret == "" {
+			http.Error(w, "Start with POST /api/2fa/setup", http.StatusConflict)
+			return
+		}
+		step, ok := totp.Verify(user.TOTPSecret, req.Code, time.Now(), 0)
+		if !ok {
+			http.Error(w, "Invalid authentication code", http.StatusBadRequest)
+			return
+		}
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			logrus.Errorf("Failed to generate recovery codes: %v", err)
+			http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+			return
+		}
+		if err := st.EnableTOTP(r.Context(), user.Email, step, hashes); err != nil {
+			logrus.Errorf("Failed to enable TOTP: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		audit(r, st, user.Email, store.AuditTOTPEnabled, "")
+		writeRecoveryCodes(w, "enabled", codes)
+	}
+}
+
+// TwoFactorDisableHandler handles POST /api/2fa/disable. It takes the password
+// and a code or recovery code, so a hijacked session alone can't turn 2FA off.
+func TwoFactorDisableHandler(st store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, user, ok := signedInUser(w, r, st)
+		if !ok {
+			return
+		}
+		var req struct {
+			Password string `json:"password"`
+			secondFactor
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Password == "" || (req.Code == "" && req.RecoveryCode == "") {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if !user.TOTPEnabled {
+			http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+			return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+			http.Error(w, "Invalid password", http.StatusUnauthorized)
+			return
+		}
+		ok, err := checkSecondFactor(r, st, user, req.secondFactor, time.Now())
+		if err != nil {
+			logrus.Errorf("Failed to check code: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "Invalid authentication code", http.StatusUnauthorized)
+			return
+		}
+		if err := st.DisableTOTP(r.Context(), user.Email); err != nil {
+			logrus.Errorf("Failed to disable TOTP: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		audit(r, st, user.Email, store.AuditTOTPDisabled, "")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"disabled"}`))
+	}
+}
+
+// RecoveryCodesHandler handles POST /api/2fa/recovery-codes with a current
+// code: it replaces all recovery codes and returns the new ones.
+func RecoveryCodesHandler(st store.UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, user, ok := signedInUser(w, r, st)
+		if !ok {
+			return
+		}
+		var req struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if !user.TOTPEnabled {
+			http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+			return
+		}
+		ok, err := checkSecondFactor(r, st, user, secondFactor{Code: req.Code}, time.Now())
+		if err != nil {
+			logrus.Errorf("Failed to check code: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "Invalid authentication code", http.StatusUnauthorized)
+			return
+		}
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			logrus.Errorf("Failed to generate recovery codes: %v", err)
+			http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+			return
+		}
+		if err := st.ReplaceRecoveryCodes(r.Context(), user.Email, hashes); err != nil {
+			logrus.Errorf("Failed to store recovery codes: %v", err)
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		audit(r, st, user.Email, store.AuditRecoveryCodes, "")
+		writeRecoveryCodes(w, "regenerated", codes)
+	}
+}
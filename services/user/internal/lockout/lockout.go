@@ -0,0 +1,58 @@
+// Package lockout decides when repeated failed sign-ins lock an account and
+// for how long.
+package lockout
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// Policy locks an account after Threshold failed logins within Window. The
+// first lock lasts Cooldown and each further one twice the previous, up to
+// MaxCooldown, until a successful sign-in resets the count.
+type Policy struct {
+	Threshold   int
+	Window      time.Duration
+	Cooldown    time.Duration
+	MaxCooldown time.Duration
+}
+
+// PolicyFromEnv reads LOCKOUT_THRESHOLD (default 5), LOCKOUT_WINDOW (15m),
+// LOCKOUT_COOLDOWN (15m) and LOCKOUT_MAX_COOLDOWN (24h). A threshold of 0
+// disables locking.
+func PolicyFromEnv() Policy {
+	p := Policy{Threshold: 5, Window: 15 * time.Minute, Cooldown: 15 * time.Minute, MaxCooldown: 24 * time.Hour}
+	if v, err := strconv.Atoi(os.Getenv("LOCKOUT_THRESHOLD")); err == nil && v >= 0 {
+		p.Threshold = v
+	}
+	for env, d := range map[string]*time.Duration{
+		"LOCKOUT_WINDOW":       &p.Window,
+		"LOCKOUT_COOLDOWN":     &p.Cooldown,
+		"LOCKOUT_MAX_COOLDOWN": &p.MaxCooldown,
+	} {
+		if v, err := time.ParseDuration(os.Getenv(env)); err == nil && v > 0 {
+			*d = v
+		}
+	}
+	return p
+}
+
+// ShouldLock reports whether failures failed logins within the window lock
+// the account.
+func (p Policy) ShouldLock(failures int) bool {
+	return p.Threshold > 0 && failures >= p.Threshold
+}
+
+// LockFor is how long a lock lasts when the account was already locked
+// previous times since its last successful sign-in.
+func (p Policy) LockFor(previous int) time.Duration {
+	d := p.Cooldown
+	for i := 0; i < previous && d < p.MaxCooldown; i++ {
+		d *= 2
+	}
+	if d > p.MaxCooldown {
+		d = p.MaxCooldown
+	}
+	return d
+}
@@ -0,0 +1,47 @@
+package mail
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MaildirMailer delivers into a local Maildir instead of sending, so emails
+// can be read offline during development (any mail client or plain cat).
+type MaildirMailer struct {
+	Dir  string
+	From *mail.Address
+}
+
+// NewMaildir creates dir's tmp, new and cur folders if needed.
+func NewMaildir(dir string, from *mail.Address) (*MaildirMailer, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, err
+		}
+	}
+	return &MaildirMailer{Dir: dir, From: from}, nil
+}
+
+func (m *MaildirMailer) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	raw, err := compose(m.From, msg, now)
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%s.%s", now.UnixNano(), hex.EncodeToString(b), host)
+	// Written to tmp and renamed, so readers never see a partial message.
+	tmp := filepath.Join(m.Dir, "tmp", name)
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(m.Dir, "new", name))
+}
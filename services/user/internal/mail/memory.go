@@ -0,0 +1,44 @@
+package mail
+
+import (
+	"context"
+	"net/mail"
+	"sync"
+	"time"
+)
+
+// Sent is a message a MemoryMailer accepted, with its encoded form.
+type Sent struct {
+	Message
+	Raw []byte
+}
+
+// MemoryMailer keeps messages instead of sending them, for tests.
+type MemoryMailer struct {
+	From *mail.Address
+
+	mu   sync.Mutex
+	sent []Sent
+}
+
+func NewMemory(from *mail.Address) *MemoryMailer {
+	return &MemoryMailer{From: from}
+}
+
+func (m *MemoryMailer) Send(ctx context.Context, msg Message) error {
+	raw, err := compose(m.From, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, Sent{Message: msg, Raw: raw})
+	return nil
+}
+
+// Sent returns the messages sent so far, oldest first.
+func (m *MemoryMailer) Sent() []Sent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Sent(nil), m.sent...)
+}
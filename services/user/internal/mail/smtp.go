@@ -0,0 +1,125 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"time"
+)
+
+// TLS modes for SMTP_TLS.
+const (
+	TLSStartTLS = "starttls" // plain connection upgraded with STARTTLS, which is required
+	TLSImplicit = "implicit" // TLS from the first byte, usually port 465
+	TLSNone     = "none"     // no encryption; only for local relays such as MailHog
+)
+
+// SMTPMailer sends through an SMTP relay.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	TLS      string
+	From     *mail.Address
+}
+
+// SMTPFromEnv reads SMTP_HOST, SMTP_PORT (default 587), SMTP_USER, SMTP_PASS and
+// SMTP_TLS (default implicit on port 465, starttls otherwise).
+func SMTPFromEnv(from *mail.Address) (*SMTPMailer, error) {
+	m := &SMTPMailer{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USER"),
+		Password: os.Getenv("SMTP_PASS"),
+		TLS:      os.Getenv("SMTP_TLS"),
+		From:     from,
+	}
+	if m.Port == "" {
+		m.Port = "587"
+	}
+	if m.TLS == "" {
+		m.TLS = TLSStartTLS
+		if m.Port == "465" {
+			m.TLS = TLSImplicit
+		}
+	}
+	switch m.TLS {
+	case TLSStartTLS, TLSImplicit, TLSNone:
+	default:
+		return nil, fmt.Errorf("unknown SMTP_TLS %q", m.TLS)
+	}
+	return m, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	raw, err := compose(m.From, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(m.Host, m.Port)
+	tlsConfig := &tls.Config{ServerName: m.Host}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	if m.TLS == TLSImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Minute)
+	}
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if m.TLS == TLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not offer STARTTLS", addr)
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if m.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.From.Address); err != nil {
+		return err
+	}
+	to, _ := mail.ParseAddress(msg.To)
+	if err := c.Rcpt(to.Address); err != nil {
+		var reply *textproto.Error
+		if errors.As(err, &reply) && reply.Code >= 500 {
+			return fmt.Errorf("%w: %v", ErrBounced, err)
+		}
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,61 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"io/fs"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Templates live in templates/<language>/<name>.txt and .html, where language
+// is a LANGUAGE_CODE in lower case. The .txt file defines "subject" and
+// "body"; the .html file is the HTML body. Languages without a translation
+// fall back to DefaultLanguage.
+//
+//go:embed templates
+var templates embed.FS
+
+// DefaultLanguage is used when the recipient's language has no templates.
+const DefaultLanguage = "ENG"
+
+// Render builds the named email in lang for to.
+func Render(name, lang, to string, data any) (Message, error) {
+	dir := "templates/" + strings.ToLower(lang)
+	if _, err := fs.Stat(templates, dir+"/"+name+".txt"); err != nil {
+		dir = "templates/" + strings.ToLower(DefaultLanguage)
+	}
+	text, err := texttemplate.ParseFS(templates, dir+"/"+name+".txt")
+	if err != nil {
+		return Message{}, err
+	}
+	msg := Message{To: to}
+	var buf bytes.Buffer
+	if err := text.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return Message{}, err
+	}
+	msg.Subject = strings.TrimSpace(buf.String())
+	buf.Reset()
+	if err := text.ExecuteTemplate(&buf, "body", data); err != nil {
+		return Message{}, err
+	}
+	msg.Text = strings.TrimSpace(buf.String()) + "\n"
+
+	if _, err := fs.Stat(templates, dir+"/"+name+".html"); err == nil {
+		html, err := htmltemplate.ParseFS(templates, "templates/layout.html", dir+"/"+name+".html")
+		if err != nil {
+			return Message{}, err
+		}
+		buf.Reset()
+		if err := html.ExecuteTemplate(&buf, "layout", struct {
+			Lang    string
+			Subject string
+			Data    any
+		}{strings.ToLower(lang), msg.Subject, data}); err != nil {
+			return Message{}, err
+		}
+		msg.HTML = buf.String()
+	}
+	return msg, nil
+}
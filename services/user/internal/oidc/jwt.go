@@ -0,0 +1,270 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Claims are the ID token claims we use.
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// algs maps the accepted JWS algorithms to their hash. "none" and the HMAC
+// algorithms are never accepted.
+var algs = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// audience is the aud claim, a string or an array of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+// flexBool accepts true and "true"; some providers send email_verified as a
+// string.
+type flexBool bool
+
+func (f *flexBool) UnmarshalJSON(b []byte) error {
+	*f = flexBool(string(b) == "true" || string(b) == `"true"`)
+	return nil
+}
+
+// Verify checks rawToken's signature against the provider's keys and its
+// issuer, audience, lifetime and nonce at now.
+func (p *Provider) Verify(ctx context.Context, rawToken, nonce string, now time.Time) (Claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	hash, ok := algs[header.Alg]
+	if !ok {
+		return Claims{}, fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: signature encoding", ErrInvalidToken)
+	}
+	keys, err := p.signingKeys(ctx, header.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	digest := h.Sum(nil)
+	verified := false
+	for _, key := range keys {
+		if verifySignature(header.Alg, key, hash, digest, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return Claims{}, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var c struct {
+		Issuer        string   `json:"iss"`
+		Subject       string   `json:"sub"`
+		Audience      audience `json:"aud"`
+		AZP           string   `json:"azp"`
+		Expiry        int64    `json:"exp"`
+		IssuedAt      int64    `json:"iat"`
+		NotBefore     int64    `json:"nbf"`
+		Nonce         string   `json:"nonce"`
+		Email         string   `json:"email"`
+		EmailVerified flexBool `json:"email_verified"`
+		Name          string   `json:"name"`
+	}
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return Claims{}, fmt.Errorf("%w: payload: %v", ErrInvalidToken, err)
+	}
+	switch {
+	case c.Issuer != p.Issuer:
+		return Claims{}, fmt.Errorf("%w: issuer %q", ErrInvalidToken, c.Issuer)
+	case c.Subject == "":
+		return Claims{}, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	case !c.Audience.has(p.ClientID):
+		return Claims{}, fmt.Errorf("%w: audience %v", ErrInvalidToken, c.Audience)
+	case len(c.Audience) > 1 && c.AZP != p.ClientID:
+		return Claims{}, fmt.Errorf("%w: authorized party %q", ErrInvalidToken, c.AZP)
+	case c.Expiry == 0 || now.After(time.Unix(c.Expiry, 0).Add(leeway)):
+		return Claims{}, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case c.IssuedAt != 0 && now.Add(leeway).Before(time.Unix(c.IssuedAt, 0)):
+		return Claims{}, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	case c.NotBefore != 0 && now.Add(leeway).Before(time.Unix(c.NotBefore, 0)):
+		return Claims{}, fmt.Errorf("%w: not yet valid", ErrInvalidToken)
+	case subtle.ConstantTimeCompare([]byte(c.Nonce), []byte(nonce)) != 1:
+		return Claims{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+	return Claims{Subject: c.Subject, Email: c.Email, EmailVerified: bool(c.EmailVerified), Name: c.Name}, nil
+}
+
+func (a audience) has(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func verifySignature(alg string, key any, hash crypto.Hash, digest, sig []byte) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(k, hash, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(sig) != 2*size {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(k, digest, r, s)
+	}
+	return false
+}
+
+// signingKeys returns the key with ID kid, or every key if kid is empty. The
+// key set is refetched when stale or, rate-limited, when kid is unknown, so
+// provider key rotation is picked up.
+func (p *Provider) signingKeys(ctx context.Context, kid string) ([]any, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stale := p.keysFetched.IsZero() || time.Since(p.keysFetched) >= jwksTTL
+	if _, known := p.keys[kid]; !stale && kid != "" && !known && time.Since(p.keysFetched) >= jwksMinRefresh {
+		stale = true
+	}
+	if stale {
+		keys, err := p.fetchKeys(ctx, meta.JWKSURI)
+		if err != nil {
+			return nil, err
+		}
+		p.keys, p.keysFetched = keys, time.Now()
+	}
+	if kid != "" {
+		if key, ok := p.keys[kid]; ok {
+			return []any{key}, nil
+		}
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	all := make([]any, 0, len(p.keys))
+	for _, key := range p.keys {
+		all = append(all, key)
+	}
+	return all, nil
+}
+
+// fetchKeys loads the RSA and EC signing keys of a JWK set. Keys without an
+// ID are stored under "#<index>".
+func (p *Provider) fetchKeys(ctx context.Context, uri string) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	status, err := p.do(req, &set)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("oidc: JWKS answered %d", status)
+	}
+	keys := make(map[string]any)
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		id := k.Kid
+		if id == "" {
+			id = fmt.Sprintf("#%d", i)
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil || len(e) == 0 || len(e) > 4 {
+				continue
+			}
+			keys[id] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !curve.IsOnCurve(key.X, key.Y) {
+				continue
+			}
+			keys[id] = key
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,247 @@
+// Package oidc signs users in through external OpenID Connect providers with
+// the authorization code flow and PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// discoveryTTL is how long a provider's metadata is cached.
+	discoveryTTL = time.Hour
+	// jwksTTL is how long signing keys are cached; an unknown key ID
+	// refetches them sooner, but at most every jwksMinRefresh.
+	jwksTTL        = time.Hour
+	jwksMinRefresh = time.Minute
+	// leeway allows for clock drift between us and the provider.
+	leeway = time.Minute
+)
+
+// ErrInvalidToken means an ID token failed validation.
+var ErrInvalidToken = errors.New("oidc: invalid ID token")
+
+// Provider is one configured identity provider.
+type Provider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	client *http.Client
+
+	mu          sync.Mutex
+	meta        metadata
+	metaFetched time.Time
+	keys        map[string]any // kid -> *rsa.PublicKey or *ecdsa.PublicKey
+	keysFetched time.Time
+}
+
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Providers are the configured providers by name.
+type Providers map[string]*Provider
+
+// Names lists the providers alphabetically.
+func (ps Providers) Names() []string {
+	names := make([]string, 0, len(ps))
+	for name := range ps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewProvider returns a provider that talks to the issuer through client
+// (http.DefaultClient if nil).
+func NewProvider(name, issuer, clientID, clientSecret, redirectURL string, scopes []string, client *http.Client) *Provider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Provider{
+		Name:         name,
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+		client:       client,
+	}
+}
+
+// ProvidersFromEnv reads OIDC_PROVIDERS, a comma-separated list of names, and
+// for each name OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID,
+// OIDC_<NAME>_CLIENT_SECRET and OIDC_<NAME>_SCOPES (default "openid email
+// profile"). Every provider redirects back to OIDC_REDIRECT_URL, by default
+// BASE_URL + "/login/oidc/callback".
+func ProvidersFromEnv() (Providers, error) {
+	redirect := os.Getenv("OIDC_REDIRECT_URL")
+	if redirect == "" {
+		redirect = strings.TrimRight(os.Getenv("BASE_URL"), "/") + "/login/oidc/callback"
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	ps := Providers{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		env := func(key string) string {
+			return os.Getenv("OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_" + key)
+		}
+		issuer, clientID := env("ISSUER"), env("CLIENT_ID")
+		if issuer == "" || clientID == "" {
+			return nil, fmt.Errorf("oidc: provider %q needs an issuer and a client ID", name)
+		}
+		scopes := strings.Fields(env("SCOPES"))
+		if len(scopes) == 0 {
+			scopes = []string{"openid", "email", "profile"}
+		}
+		ps[name] = NewProvider(name, issuer, clientID, env("CLIENT_SECRET"), redirect, scopes, client)
+	}
+	return ps, nil
+}
+
+// NewVerifier returns a random PKCE code verifier.
+func NewVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// challenge is the S256 PKCE challenge for verifier.
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL is where to send the browser to sign in.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("oidc: authorization endpoint: %w", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.RedirectURL)
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", challenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange redeems an authorization code and returns the validated claims of
+// its ID token.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (Claims, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if p.ClientSecret == "" {
+		form.Set("client_id", p.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+	var tok struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+		Desc    string `json:"error_description"`
+	}
+	status, err := p.do(req, &tok)
+	if err != nil {
+		return Claims{}, err
+	}
+	if status != http.StatusOK || tok.Error != "" {
+		return Claims{}, fmt.Errorf("oidc: token endpoint answered %d %s %s", status, tok.Error, tok.Desc)
+	}
+	if tok.IDToken == "" {
+		return Claims{}, fmt.Errorf("oidc: token response has no id_token")
+	}
+	return p.Verify(ctx, tok.IDToken, nonce, time.Now())
+}
+
+// metadata returns the provider's discovery document, fetching it if the
+// cached one is stale.
+func (p *Provider) metadata(ctx context.Context) (metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.metaFetched.IsZero() && time.Since(p.metaFetched) < discoveryTTL {
+		return p.meta, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(p.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return metadata{}, err
+	}
+	var meta metadata
+	status, err := p.do(req, &meta)
+	if err != nil {
+		return metadata{}, err
+	}
+	if status != http.StatusOK {
+		return metadata{}, fmt.Errorf("oidc: discovery answered %d", status)
+	}
+	if meta.Issuer != p.Issuer {
+		return metadata{}, fmt.Errorf("oidc: discovery issuer %q does not match %q", meta.Issuer, p.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return metadata{}, fmt.Errorf("oidc: discovery document is missing endpoints")
+	}
+	p.meta, p.metaFetched = meta, time.Now()
+	return meta, nil
+}
+
+// do sends req and decodes a JSON answer of at most 1 MiB into v.
+func (p *Provider) do(req *http.Request, v any) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v); err != nil {
+		return resp.StatusCode, fmt.Errorf("oidc: %s answered %d with invalid JSON: %w", req.URL.Path, resp.StatusCode, err)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,187 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"usethislink/services/user/internal/oidc/oidctest"
+)
+
+const (
+	testClientID = "usethislink"
+	testSecret   = "secret"
+	testRedirect = "https://usethislink.test/login/oidc/callback"
+)
+
+var testIdentity = oidctest.Identity{Subject: "sub-1", Email: "dev@example.com", EmailVerified: true, Name: "Dev User"}
+
+// newTestProvider starts a mock provider and a Provider configured for it.
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Server) {
+	t.Helper()
+	idp, ts, err := oidctest.Start(testClientID, testSecret, testRedirect, testIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ts.Close)
+	return NewProvider("mock", idp.Issuer(), testClientID, testSecret, testRedirect, []string{"openid", "email"}, ts.Client()), idp
+}
+
+// authorize follows the browser to the provider and returns the code it
+// redirects back with.
+func authorize(t *testing.T, p *Provider, state, nonce, verifier string) string {
+	t.Helper()
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := back.Query()
+	if q.Get("state") != state || q.Get("code") == "" {
+		t.Fatalf("authorize redirected to %q", back)
+	}
+	return q.Get("code")
+}
+
+// signIn runs the whole flow and returns the result of the exchange.
+func signIn(t *testing.T, p *Provider) (Claims, error) {
+	t.Helper()
+	verifier, err := NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := authorize(t, p, "state", "nonce", verifier)
+	return p.Exchange(context.Background(), code, verifier, "nonce")
+}
+
+func TestExchange(t *testing.T) {
+	p, _ := newTestProvider(t)
+	claims, err := signIn(t, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Claims{Subject: testIdentity.Subject, Email: testIdentity.Email, EmailVerified: true, Name: testIdentity.Name}
+	if claims != want {
+		t.Errorf("claims = %+v, want %+v", claims, want)
+	}
+}
+
+func TestExchangePKCEMismatch(t *testing.T) {
+	p, _ := newTestProvider(t)
+	verifier, _ := NewVerifier()
+	other, _ := NewVerifier()
+	code := authorize(t, p, "state", "nonce", verifier)
+	_, err := p.Exchange(context.Background(), code, other, "nonce")
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("err = %v, want invalid_grant", err)
+	}
+}
+
+func TestExchangeNonceMismatch(t *testing.T) {
+	p, _ := newTestProvider(t)
+	verifier, _ := NewVerifier()
+	code := authorize(t, p, "state", "nonce", verifier)
+	_, err := p.Exchange(context.Background(), code, verifier, "another nonce")
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("err = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestExchangeRejectsBadClaims(t *testing.T) {
+	now := time.Now()
+	for name, claims := range map[string]map[string]any{
+		"wrong issuer":        {"iss": "https://evil.test"},
+		"wrong audience":      {"aud": "someone-else"},
+		"extra audience":      {"aud": []string{testClientID, "someone-else"}},
+		"expired":             {"iat": now.Add(-time.Hour).Unix(), "exp": now.Add(-10 * time.Minute).Unix()},
+		"issued in future":    {"iat": now.Add(time.Hour).Unix(), "exp": now.Add(2 * time.Hour).Unix()},
+		"not yet valid":       {"nbf": now.Add(time.Hour).Unix()},
+		"no subject":          {"sub": ""},
+		"nonce of other flow": {"nonce": "other"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p, idp := newTestProvider(t)
+			idp.SetClaims(claims)
+			if _, err := signIn(t, p); !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("err = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestExchangeAllowsClockDrift(t *testing.T) {
+	p, idp := newTestProvider(t)
+	idp.SetClaims(map[string]any{"exp": time.Now().Add(-leeway / 2).Unix()})
+	if _, err := signIn(t, p); err != nil {
+		t.Fatalf("token expired within leeway rejected: %v", err)
+	}
+}
+
+func TestSigningKeysCached(t *testing.T) {
+	p, idp := newTestProvider(t)
+	for i := 0; i < 3; i++ {
+		if _, err := signIn(t, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := idp.JWKSRequests(); n != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", n)
+	}
+}
+
+func TestSigningKeysRefetchedForUnknownKid(t *testing.T) {
+	p, idp := newTestProvider(t)
+	if _, err := signIn(t, p); err != nil {
+		t.Fatal(err)
+	}
+	if err := idp.RotateKey(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Right after a fetch an unknown kid is refused without asking again.
+	if _, err := signIn(t, p); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("err = %v, want ErrInvalidToken", err)
+	}
+	if n := idp.JWKSRequests(); n != 1 {
+		t.Fatalf("JWKS fetched %d times within jwksMinRefresh, want 1", n)
+	}
+
+	p.mu.Lock()
+	p.keysFetched = time.Now().Add(-jwksMinRefresh)
+	p.mu.Unlock()
+	if _, err := signIn(t, p); err != nil {
+		t.Fatalf("sign-in after key rotation: %v", err)
+	}
+	if n := idp.JWKSRequests(); n != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", n)
+	}
+}
+
+func TestSigningKeysRefetchedWhenStale(t *testing.T) {
+	p, idp := newTestProvider(t)
+	if _, err := signIn(t, p); err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	p.keysFetched = time.Now().Add(-jwksTTL)
+	p.mu.Unlock()
+	if _, err := signIn(t, p); err != nil {
+		t.Fatal(err)
+	}
+	if n := idp.JWKSRequests(); n != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", n)
+	}
+}
@@ -0,0 +1,301 @@
+// Package oidctest is a local OpenID Connect provider for trying and checking
+// OIDC sign-in without network access. It signs in whoever it is configured
+// as, straight away, and enforces what a real provider would: the client's
+// credentials and redirect URI, single-use codes and the PKCE verifier.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Identity is the account the provider signs in.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Server is the mock provider. Its issuer is its base URL.
+type Server struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+
+	mu       sync.Mutex
+	issuer   string
+	identity Identity
+	key      *rsa.PrivateKey
+	kid      string
+	codes    map[string]grant
+	claims   map[string]any
+	jwksHits int
+}
+
+type grant struct {
+	nonce, challenge, redirect string
+	identity                   Identity
+	expires                    time.Time
+}
+
+// New returns a provider for one client, issuing tokens as issuer (its base
+// URL, without a trailing slash).
+func New(issuer, clientID, clientSecret, redirectURL string, id Identity) (*Server, error) {
+	s := &Server{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		issuer:       issuer,
+		identity:     id,
+		codes:        make(map[string]grant),
+	}
+	return s, s.RotateKey()
+}
+
+// Start runs a provider on a local port; Close the returned server when done.
+func Start(clientID, clientSecret, redirectURL string, id Identity) (*Server, *httptest.Server, error) {
+	s, err := New("", clientID, clientSecret, redirectURL, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	ts := httptest.NewServer(s)
+	s.mu.Lock()
+	s.issuer = ts.URL
+	s.mu.Unlock()
+	return s, ts, nil
+}
+
+// Issuer is the provider's issuer URL.
+func (s *Server) Issuer() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issuer
+}
+
+// SetIdentity changes who the next sign-in is for.
+func (s *Server) SetIdentity(id Identity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.identity = id
+}
+
+// SetClaims sets claims that are added to, or replace, the standard ones in
+// every ID token issued from now on, to mint bad tokens. nil clears them.
+func (s *Server) SetClaims(claims map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.claims = claims
+}
+
+// JWKSRequests is how many times the key set has been fetched.
+func (s *Server) JWKSRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jwksHits
+}
+
+// RotateKey replaces the signing key with a new one. Key IDs are derived from
+// the key, so they never repeat across restarts.
+func (s *Server) RotateKey() error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(key.N.Bytes())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.key = key
+	s.kid = base64.RawURLEncoding.EncodeToString(sum[:8])
+	return nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		s.discovery(w)
+	case "/authorize":
+		s.authorize(w, r)
+	case "/token":
+		s.token(w, r)
+	case "/jwks":
+		s.jwks(w)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) discovery(w http.ResponseWriter) {
+	iss := s.Issuer()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                iss,
+		"authorization_endpoint":                iss + "/authorize",
+		"token_endpoint":                        iss + "/token",
+		"jwks_uri":                              iss + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+	})
+}
+
+// authorize approves every request from the client at once and redirects
+// back with a code.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	switch {
+	case q.Get("client_id") != s.ClientID:
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	case q.Get("redirect_uri") != s.RedirectURL:
+		http.Error(w, "redirect_uri not registered", http.StatusBadRequest)
+		return
+	}
+	back, _ := url.Parse(q.Get("redirect_uri"))
+	v := back.Query()
+	v.Set("state", q.Get("state"))
+	if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		v.Set("error", "invalid_request")
+		back.RawQuery = v.Encode()
+		http.Redirect(w, r, back.String(), http.StatusFound)
+		return
+	}
+	code := randomString()
+	s.mu.Lock()
+	s.codes[code] = grant{
+		nonce:     q.Get("nonce"),
+		challenge: q.Get("code_challenge"),
+		redirect:  q.Get("redirect_uri"),
+		identity:  s.identity,
+		expires:   time.Now().Add(time.Minute),
+	}
+	s.mu.Unlock()
+	v.Set("code", code)
+	back.RawQuery = v.Encode()
+	http.Redirect(w, r, back.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if id != s.ClientID || subtle.ConstantTimeCompare([]byte(secret), []byte(s.ClientSecret)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+	code := r.PostForm.Get("code")
+	s.mu.Lock()
+	g, ok := s.codes[code]
+	delete(s.codes, code)
+	s.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok || time.Now().After(g.expires) || r.PostForm.Get("redirect_uri") != g.redirect:
+		tokenError(w, "invalid_grant")
+		return
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge:
+		tokenError(w, "invalid_grant")
+		return
+	}
+	idToken, err := s.sign(g)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (s *Server) sign(g grant) (string, error) {
+	s.mu.Lock()
+	key, kid, iss, extra := s.key, s.kid, s.issuer, s.claims
+	s.mu.Unlock()
+	now := time.Now()
+	claims := map[string]any{
+		"iss":            iss,
+		"sub":            g.identity.Subject,
+		"aud":            s.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.identity.Email,
+		"email_verified": g.identity.EmailVerified,
+		"name":           g.identity.Name,
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signing := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func (s *Server) jwks(w http.ResponseWriter) {
+	s.mu.Lock()
+	s.jwksHits++
+	pub, kid := s.key.PublicKey, s.kid
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": kid,
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,93 @@
+// Package otp issues the 6-digit codes that confirm an email address at sign-up
+// and limits how often they can be sent and guessed.
+package otp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Generate returns a uniformly random 6-digit code.
+func Generate() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// Key is the server secret codes are hashed with. Every replica must share it
+// or codes sent by one can't be checked by another.
+type Key []byte
+
+// KeyFromEnv reads the key from OTP_HASH_KEY, which must be set.
+func KeyFromEnv() (Key, error) {
+	v := os.Getenv("OTP_HASH_KEY")
+	if v == "" {
+		return nil, errors.New("OTP_HASH_KEY not set")
+	}
+	return Key(v), nil
+}
+
+// Hash is how a code is stored: an HMAC keyed with the server secret over the
+// code and the address it was sent to, so a leaked table can't be brute-forced
+// offline.
+func (k Key) Hash(email, code string) string {
+	mac := hmac.New(sha256.New, k)
+	mac.Write([]byte(strings.ToLower(email) + ":" + strings.TrimSpace(code)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Matches reports in constant time whether code hashes to hash.
+func (k Key) Matches(hash, email, code string) bool {
+	return hash != "" && hmac.Equal([]byte(hash), []byte(k.Hash(email, code)))
+}
+
+// Policy bounds guessing and sending: a code is void after MaxAttempts wrong
+// guesses, a new one can't be sent to the same address within ResendCooldown,
+// and at most DailyPerEmail codes go to one address and DailyPerIP are
+// requested from one client in 24 hours.
+type Policy struct {
+	TTL            time.Duration
+	MaxAttempts    int
+	ResendCooldown time.Duration
+	DailyPerEmail  int
+	DailyPerIP     int
+}
+
+// Day is the window of the daily caps.
+const Day = 24 * time.Hour
+
+// PolicyFromEnv reads OTP_TTL (default 10m), OTP_MAX_ATTEMPTS (5),
+// OTP_RESEND_COOLDOWN (60s), OTP_DAILY_LIMIT_EMAIL (5) and
+// OTP_DAILY_LIMIT_IP (20).
+func PolicyFromEnv() Policy {
+	p := Policy{TTL: 10 * time.Minute, MaxAttempts: 5, ResendCooldown: time.Minute, DailyPerEmail: 5, DailyPerIP: 20}
+	for env, n := range map[string]*int{
+		"OTP_MAX_ATTEMPTS":      &p.MaxAttempts,
+		"OTP_DAILY_LIMIT_EMAIL": &p.DailyPerEmail,
+		"OTP_DAILY_LIMIT_IP":    &p.DailyPerIP,
+	} {
+		if v, err := strconv.Atoi(os.Getenv(env)); err == nil && v > 0 {
+			*n = v
+		}
+	}
+	for env, d := range map[string]*time.Duration{
+		"OTP_TTL":             &p.TTL,
+		"OTP_RESEND_COOLDOWN": &p.ResendCooldown,
+	} {
+		if v, err := time.ParseDuration(os.Getenv(env)); err == nil && v > 0 {
+			*d = v
+		}
+	}
+	return p
+}
@@ -0,0 +1,185 @@
+// Package outbox queues outbound email in the database and sends it from a
+// background worker, so handlers never wait on (or fail because of) SMTP.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"usethislink/services/user/internal/mail"
+	"usethislink/services/user/internal/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+// batchSize is how many due emails a worker picks up per pass.
+const batchSize = 20
+
+// Outbox is a mail.Mailer that enqueues, plus the worker that drains the
+// queue through the real Mailer. Several replicas can run workers against
+// the same table; each email is claimed by one of them at a time.
+type Outbox struct {
+	st     store.UserStore
+	mailer mail.Mailer
+
+	interval    time.Duration
+	retryBase   time.Duration
+	retryMax    time.Duration
+	maxAttempts int
+	lease       time.Duration
+
+	wake chan struct{}
+}
+
+// New reads EMAIL_POLL_INTERVAL (default 5s), EMAIL_RETRY_BASE (default 30s,
+// doubled per attempt), EMAIL_RETRY_MAX (default 1h) and EMAIL_MAX_ATTEMPTS
+// (default 8) before an email is dead-lettered.
+func New(st store.UserStore, mailer mail.Mailer) *Outbox {
+	o := &Outbox{
+		st:          st,
+		mailer:      mailer,
+		interval:    5 * time.Second,
+		retryBase:   30 * time.Second,
+		retryMax:    time.Hour,
+		maxAttempts: 8,
+		lease:       2 * time.Minute,
+		wake:        make(chan struct{}, 1),
+	}
+	if v, err := time.ParseDuration(os.Getenv("EMAIL_POLL_INTERVAL")); err == nil && v > 0 {
+		o.interval = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("EMAIL_RETRY_BASE")); err == nil && v > 0 {
+		o.retryBase = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("EMAIL_RETRY_MAX")); err == nil && v > 0 {
+		o.retryMax = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("EMAIL_MAX_ATTEMPTS")); err == nil && v > 0 {
+		o.maxAttempts = v
+	}
+	return o
+}
+
+// Send enqueues msg and nudges the worker. It only fails if the queue can't
+// be written.
+func (o *Outbox) Send(ctx context.Context, msg mail.Message) error {
+	now := time.Now()
+	_, err := o.st.EnqueueEmail(ctx, store.OutboundEmail{
+		To:            msg.To,
+		Subject:       msg.Subject,
+		Text:          msg.Text,
+		HTML:          msg.HTML,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run sends due emails every interval, or as soon as one is enqueued, until
+// ctx is done.
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+	for {
+		o.drain(ctx)
+		select {
+		case <-ticker.C:
+		case <-o.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// drain sends batches until nothing is due.
+func (o *Outbox) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		due, err := o.st.DueEmails(ctx, time.Now(), batchSize)
+		if err != nil {
+			logrus.Errorf("Failed to load email queue: %v", err)
+			return
+		}
+		claimed := 0
+		for _, e := range due {
+			ok, err := o.st.ClaimEmail(ctx, e, time.Now().Add(o.lease))
+			if err != nil {
+				logrus.Errorf("Failed to claim email %d: %v", e.ID, err)
+				return
+			}
+			if ok {
+				claimed++
+				e.Attempts++
+				o.deliver(ctx, e)
+			}
+		}
+		if len(due) < batchSize || claimed == 0 {
+			return
+		}
+	}
+}
+
+// deliver makes one attempt at e and records the outcome.
+func (o *Outbox) deliver(ctx context.Context, e store.OutboundEmail) {
+	suppressed, err := o.st.IsEmailSuppressed(ctx, e.To)
+	if err != nil {
+		logrus.Errorf("Failed to check email suppression: %v", err)
+	} else if suppressed {
+		o.dead(ctx, e, "suppressed: address previously bounced")
+		return
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, o.lease/2)
+	err = o.mailer.Send(sendCtx, mail.Message{To: e.To, Subject: e.Subject, Text: e.Text, HTML: e.HTML})
+	cancel()
+	switch {
+	case err == nil:
+		if err := o.st.MarkEmailSent(ctx, e.ID, time.Now()); err != nil {
+			logrus.Errorf("Failed to mark email %d sent: %v", e.ID, err)
+		}
+	case ctx.Err() != nil:
+		// Shutting down; the claim lapses and another pass retries it.
+	case errors.Is(err, mail.ErrBounced):
+		sup := store.EmailSuppression{Email: e.To, Reason: err.Error(), CreatedAt: time.Now()}
+		if err := o.st.SuppressEmail(ctx, sup); err != nil {
+			logrus.Errorf("Failed to suppress %s: %v", e.To, err)
+		}
+		o.dead(ctx, e, err.Error())
+	case errors.Is(err, mail.ErrInvalidMessage) || e.Attempts >= o.maxAttempts:
+		o.dead(ctx, e, err.Error())
+	default:
+		next := time.Now().Add(o.backoff(e.Attempts))
+		logrus.Errorf("Failed to send email %d (attempt %d), retrying at %s: %v", e.ID, e.Attempts, next.Format(time.RFC3339), err)
+		if err := o.st.RetryEmail(ctx, e.ID, next, err.Error()); err != nil {
+			logrus.Errorf("Failed to reschedule email %d: %v", e.ID, err)
+		}
+	}
+}
+
+func (o *Outbox) dead(ctx context.Context, e store.OutboundEmail, reason string) {
+	logrus.Errorf("Giving up on email %d to %s after %d attempt(s): %s", e.ID, e.To, e.Attempts, reason)
+	if err := o.st.MarkEmailDead(ctx, e.ID, reason); err != nil {
+		logrus.Errorf("Failed to dead-letter email %d: %v", e.ID, err)
+	}
+}
+
+// backoff is the wait after the given number of failed attempts.
+func (o *Outbox) backoff(attempts int) time.Duration {
+	d := o.retryBase
+	for i := 1; i < attempts && d < o.retryMax; i++ {
+		d *= 2
+	}
+	if d > o.retryMax {
+		d = o.retryMax
+	}
+	return d
+}
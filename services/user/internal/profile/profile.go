@@ -0,0 +1,103 @@
+// Package profile validates the preferences a user can set on their account.
+package profile
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxFullNameLength is the longest display name accepted, in characters.
+const MaxFullNameLength = 100
+
+// languages maps every accepted spelling of a language to the ISO 639-2/T
+// code stored in LANGUAGE_CODE: the 639-1 code, the 639-2/T code itself and,
+// where it differs, the 639-2/B one.
+var languages = map[string]string{}
+
+func init() {
+	for _, l := range []struct{ alpha2, terminology, bibliographic string }{
+		{"af", "afr", ""}, {"am", "amh", ""}, {"ar", "ara", ""}, {"as", "asm", ""},
+		{"bg", "bul", ""}, {"bn", "ben", ""}, {"cs", "ces", "cze"}, {"da", "dan", ""},
+		{"de", "deu", "ger"}, {"el", "ell", "gre"}, {"en", "eng", ""}, {"es", "spa", ""},
+		{"fa", "fas", "per"}, {"fi", "fin", ""}, {"fr", "fra", "fre"}, {"gu", "guj", ""},
+		{"he", "heb", ""}, {"hi", "hin", ""}, {"hr", "hrv", ""}, {"hu", "hun", ""},
+		{"id", "ind", ""}, {"it", "ita", ""}, {"ja", "jpn", ""}, {"kn", "kan", ""},
+		{"ko", "kor", ""}, {"ml", "mal", ""}, {"mr", "mar", ""}, {"ms", "msa", "may"},
+		{"ne", "nep", ""}, {"nl", "nld", "dut"}, {"no", "nor", ""}, {"or", "ori", ""},
+		{"pa", "pan", ""}, {"pl", "pol", ""}, {"pt", "por", ""}, {"ro", "ron", "rum"},
+		{"ru", "rus", ""}, {"si", "sin", ""}, {"sk", "slk", "slo"}, {"sr", "srp", ""},
+		{"sv", "swe", ""}, {"sw", "swa", ""}, {"ta", "tam", ""}, {"te", "tel", ""},
+		{"th", "tha", ""}, {"tl", "tgl", ""}, {"tr", "tur", ""}, {"uk", "ukr", ""},
+		{"ur", "urd", ""}, {"vi", "vie", ""}, {"zh", "zho", "chi"},
+	} {
+		code := strings.ToUpper(l.terminology)
+		languages[l.alpha2] = code
+		languages[l.terminology] = code
+		if l.bibliographic != "" {
+			languages[l.bibliographic] = code
+		}
+	}
+}
+
+// Language returns the stored form (e.g. "ENG") of an ISO 639 language code
+// in any case, or false if it isn't one we support.
+func Language(code string) (string, bool) {
+	c, ok := languages[strings.ToLower(strings.TrimSpace(code))]
+	return c, ok
+}
+
+// currencies are the active ISO 4217 currency codes.
+var currencies = map[string]bool{}
+
+func init() {
+	for _, c := range strings.Fields(`
+		AED AFN ALL AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BHD BIF BMD BND BOB BRL
+		BSD BTN BWP BYN BZD CAD CDF CHF CLP CNY COP CRC CUP CVE CZK DJF DKK DOP DZD EGP
+		ERN ETB EUR FJD FKP GBP GEL GHS GIP GMD GNF GTQ GYD HKD HNL HTG HUF IDR ILS INR
+		IQD IRR ISK JMD JOD JPY KES KGS KHR KMF KPW KRW KWD KYD KZT LAK LBP LKR LRD LSL
+		LYD MAD MDL MGA MKD MMK MNT MOP MRU MUR MVR MWK MXN MYR MZN NAD NGN NIO NOK NPR
+		NZD OMR PAB PEN PGK PHP PKR PLN PYG QAR RON RSD RUB RWF SAR SBD SCR SDG SEK SGD
+		SHP SLE SOS SRD SSP STN SVC SYP SZL THB TJS TMT TND TOP TRY TTD TWD TZS UAH UGX
+		USD UYU UZS VES VND VUV WST XAF XCD XCG XOF XPF YER ZAR ZMW ZWL`) {
+		currencies[c] = true
+	}
+}
+
+// Currency returns an ISO 4217 code upper-cased, or false if it isn't one.
+func Currency(code string) (string, bool) {
+	c := strings.ToUpper(strings.TrimSpace(code))
+	return c, currencies[c]
+}
+
+// homeRoutes are the pages a user can land on after signing in; "" means the
+// default.
+var homeRoutes = map[string]bool{
+	"":            true,
+	"/":           true,
+	"/history":    true,
+	"/bio":        true,
+	"/domains":    true,
+	"/workspaces": true,
+	"/settings":   true,
+}
+
+// Home reports whether path is an allowed DEFAULTHOME.
+func Home(path string) bool {
+	return homeRoutes[path]
+}
+
+// FullName trims name and reports whether it is a usable display name: at
+// most MaxFullNameLength characters and free of control characters.
+func FullName(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	if !utf8.ValidString(name) || utf8.RuneCountInString(name) > MaxFullNameLength {
+		return "", false
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return "", false
+		}
+	}
+	return name, true
+}
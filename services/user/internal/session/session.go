@@ -13,15 +13,14 @@ const SessionKey ctxKey = "sessionID"
 
 func SessionMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		const cookieName = "UTL_SESSION"
-		c, err := r.Cookie(cookieName)
+		c, err := r.Cookie(CookieName)
 		var sid string
 		if err == nil && c.Value != "" {
 			sid = c.Value
 		} else {
 			sid = uuid.NewString()
 			http.SetCookie(w, &http.Cookie{
-				Name:     cookieName,
+				Name:     CookieName,
 				Value:    sid,
 				Path:     "/",
 				HttpOnly: true,
@@ -0,0 +1,91 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"strings"
+	"time"
+)
+
+// CookieName is the browser cookie holding the session token.
+const CookieName = "UTL_SESSION"
+
+// TouchInterval throttles last-seen updates: a session's idle timer is only
+// pushed back once it has been this long since the previous update.
+const TouchInterval = time.Minute
+
+// NewToken returns a fresh 256-bit session token for the cookie and the ID it
+// is stored under.
+func NewToken() (token, id string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, ID(token), nil
+}
+
+// ID is the SHA-256 of a token, hex encoded. Only IDs are stored or passed to
+// other services, so neither a database dump nor a log yields a usable cookie.
+func ID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Timeouts end a session after Idle without requests or Absolute after sign-in,
+// whichever comes first.
+type Timeouts struct {
+	Idle     time.Duration
+	Absolute time.Duration
+}
+
+// TimeoutsFromEnv reads SESSION_IDLE_TIMEOUT (default 24h) and
+// SESSION_ABSOLUTE_TIMEOUT (default 7 days).
+func TimeoutsFromEnv() Timeouts {
+	t := Timeouts{Idle: 24 * time.Hour, Absolute: 7 * 24 * time.Hour}
+	if v, err := time.ParseDuration(os.Getenv("SESSION_IDLE_TIMEOUT")); err == nil && v > 0 {
+		t.Idle = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("SESSION_ABSOLUTE_TIMEOUT")); err == nil && v > 0 {
+		t.Absolute = v
+	}
+	return t
+}
+
+// Expired reports whether a session created at createdAt and last used at
+// lastSeen is over either timeout at now.
+func (t Timeouts) Expired(createdAt, lastSeen, now time.Time) bool {
+	return now.Sub(lastSeen) > t.Idle || now.Sub(createdAt) > t.Absolute
+}
+
+// Device summarises a User-Agent for the session list, e.g. "Chrome on Windows".
+func Device(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"edg/", "Edge"}, {"opr/", "Opera"}, {"firefox/", "Firefox"}, {"chrome/", "Chrome"},
+		{"safari/", "Safari"}, {"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	platform := ""
+	for _, o := range []struct{ token, name string }{
+		{"iphone", "iOS"}, {"ipad", "iPadOS"}, {"android", "Android"}, {"windows", "Windows"},
+		{"mac os x", "macOS"}, {"cros", "ChromeOS"}, {"linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			platform = o.name
+			break
+		}
+	}
+	if platform == "" {
+		return browser
+	}
+	return browser + " on " + platform
+}
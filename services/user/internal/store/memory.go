@@ -0,0 +1,680 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-process UserStore for tests and throwaway instances.
+// Links live in the link service, so ClaimSessionLinks only records the claim.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	users    map[string]User
+	pending  map[string]PendingRegistration
+	sessions map[string]Session
+	claims   map[string]string
+
+	unlockTokens   map[string]EmailToken
+	passwordResets map[string]EmailToken
+	magicLinks     map[string]MagicLink
+	recoveryCodes  map[string]map[string]bool // email -> code hashes
+	challenges     map[string]LoginChallenge
+	otpSends       []otpSend
+	oidcLogins     map[string]OIDCLogin
+	identities     map[string]Identity // provider + "\x00" + subject
+	audit          []AuditEvent
+
+	emails       []OutboundEmail // index is ID-1
+	suppressions map[string]EmailSuppression
+}
+
+func NewMemory() *MemoryStore {
+	return &MemoryStore{
+		users:    make(map[string]User),
+		pending:  make(map[string]PendingRegistration),
+		sessions: make(map[string]Session),
+		claims:   make(map[string]string),
+
+		unlockTokens:   make(map[string]EmailToken),
+		passwordResets: make(map[string]EmailToken),
+		magicLinks:     make(map[string]MagicLink),
+		recoveryCodes:  make(map[string]map[string]bool),
+		challenges:     make(map[string]LoginChallenge),
+		oidcLogins:     make(map[string]OIDCLogin),
+		identities:     make(map[string]Identity),
+
+		suppressions: make(map[string]EmailSuppression),
+	}
+}
+
+func (m *MemoryStore) UserExists(ctx context.Context, email string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.users[email]
+	return ok, nil
+}
+
+func (m *MemoryStore) GetUser(ctx context.Context, email string) (User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.users[email]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u, nil
+}
+
+func (m *MemoryStore) CreateUser(ctx context.Context, u User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[u.Email]; ok {
+		return ErrConflict
+	}
+	m.users[u.Email] = u
+	return nil
+}
+
+func (m *MemoryStore) updateUser(email string, fn func(u *User)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[email]
+	if !ok {
+		return nil
+	}
+	fn(&u)
+	m.users[email] = u
+	return nil
+}
+
+func (m *MemoryStore) RecordFailedLogin(ctx context.Context, email string, at time.Time, window time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[email]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	if u.FailedSince.IsZero() || u.FailedSince.Before(at.Add(-window)) {
+		u.FailedLogins, u.FailedSince = 0, at
+	}
+	u.FailedLogins++
+	m.users[email] = u
+	return u.FailedLogins, nil
+}
+
+func (m *MemoryStore) LockUser(ctx context.Context, email string, until time.Time) error {
+	return m.updateUser(email, func(u *User) {
+		u.AcctLock = 1
+		u.LockedUntil = until
+		u.LockCount++
+		u.FailedLogins = 0
+		u.FailedSince = time.Time{}
+	})
+}
+
+func (m *MemoryStore) UnlockUser(ctx context.Context, email string) error {
+	return m.updateUser(email, func(u *User) {
+		u.AcctLock = 0
+		u.LockedUntil = time.Time{}
+		u.FailedLogins = 0
+		u.FailedSince = time.Time{}
+	})
+}
+
+func (m *MemoryStore) RecordLogin(ctx context.Context, email string, at time.Time) error {
+	return m.updateUser(email, func(u *User) {
+		u.FailedLogins = 0
+		u.FailedSince = time.Time{}
+		u.LockCount = 0
+		u.IsSignedIn = 1
+		u.LastSignOn = at
+	})
+}
+
+func (m *MemoryStore) RecordLogout(ctx context.Context, email string, at time.Time) error {
+	return m.updateUser(email, func(u *User) {
+		u.IsSignedIn = 0
+		u.LastSignOff = at
+	})
+}
+
+func (m *MemoryStore) SavePendingRegistration(ctx context.Context, p PendingRegistration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending[p.Email] = p
+	return nil
+}
+
+func (m *MemoryStore) GetPendingRegistration(ctx context.Context, email string) (PendingRegistration, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.pending[email]
+	if !ok {
+		return PendingRegistration{}, ErrNotFound
+	}
+	return p, nil
+}
+
+func (m *MemoryStore) DeletePendingRegistration(ctx context.Context, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.pending, email)
+	return nil
+}
+
+func (m *MemoryStore) FailOTPAttempt(ctx context.Context, email string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.pending[email]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	p.OTPAttempts++
+	m.pending[email] = p
+	return p.OTPAttempts, nil
+}
+
+type otpSend struct {
+	email, ip string
+	at        time.Time
+}
+
+func (m *MemoryStore) RecordOTPSend(ctx context.Context, email, ip string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.otpSends[:0]
+	for _, s := range m.otpSends {
+		if !s.at.Before(at.Add(-24 * time.Hour)) {
+			kept = append(kept, s)
+		}
+	}
+	m.otpSends = append(kept, otpSend{email: strings.ToLower(email), ip: ip, at: at})
+	return nil
+}
+
+func (m *MemoryStore) OTPSends(ctx context.Context, email, ip string, since time.Time) (OTPSendStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var stats OTPSendStats
+	for _, s := range m.otpSends {
+		if !s.at.Before(since) {
+			stats.add(s.email == strings.ToLower(email), ip != "" && s.ip == ip, s.at)
+		}
+	}
+	return stats, nil
+}
+
+func (m *MemoryStore) CreateSession(ctx context.Context, s Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[s.ID]; ok {
+		return ErrConflict
+	}
+	m.sessions[s.ID] = s
+	return nil
+}
+
+func (m *MemoryStore) GetSession(ctx context.Context, id string) (Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return Session{}, ErrNotFound
+	}
+	return s, nil
+}
+
+func (m *MemoryStore) TouchSession(ctx context.Context, id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.sessions[id]; ok {
+		s.LastSeenAt = at
+		m.sessions[id] = s
+	}
+	return nil
+}
+
+func (m *MemoryStore) ListSessions(ctx context.Context, email string) ([]Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var list []Session
+	for _, s := range m.sessions {
+		if s.UserEmail == email {
+			list = append(list, s)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastSeenAt.After(list[j].LastSeenAt) })
+	return list, nil
+}
+
+func (m *MemoryStore) DeleteSession(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *MemoryStore) DeleteUserSessions(ctx context.Context, email, keepID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, s := range m.sessions {
+		if s.UserEmail == email && id != keepID {
+			delete(m.sessions, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) DeleteExpiredSessions(ctx context.Context, idleBefore, createdBefore time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, s := range m.sessions {
+		if s.LastSeenAt.Before(idleBefore) || s.CreatedAt.Before(createdBefore) {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+// consumeToken implements the Consume* methods on one token map.
+func (m *MemoryStore) consumeToken(tokens map[string]EmailToken, hash string, now time.Time) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := tokens[hash]
+	if !ok {
+		return "", ErrNotFound
+	}
+	for h, other := range tokens {
+		if other.Email == t.Email {
+			delete(tokens, h)
+		}
+	}
+	if now.After(t.ExpiresAt) {
+		return "", ErrNotFound
+	}
+	return t.Email, nil
+}
+
+func (m *MemoryStore) CreateUnlockToken(ctx context.Context, t EmailToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.unlockTokens[t.Hash] = t
+	return nil
+}
+
+func (m *MemoryStore) ConsumeUnlockToken(ctx context.Context, hash string, now time.Time) (string, error) {
+	return m.consumeToken(m.unlockTokens, hash, now)
+}
+
+func (m *MemoryStore) CreatePasswordReset(ctx context.Context, t EmailToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.passwordResets[t.Hash] = t
+	return nil
+}
+
+func (m *MemoryStore) ConsumePasswordReset(ctx context.Context, hash string, now time.Time) (string, error) {
+	return m.consumeToken(m.passwordResets, hash, now)
+}
+
+func (m *MemoryStore) CreateMagicLink(ctx context.Context, l MagicLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.magicLinks[l.Hash] = l
+	return nil
+}
+
+func (m *MemoryStore) ConsumeMagicLink(ctx context.Context, hash string, now time.Time) (MagicLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.magicLinks[hash]
+	if !ok {
+		return MagicLink{}, ErrNotFound
+	}
+	for h, other := range m.magicLinks {
+		if other.Email == l.Email || now.After(other.ExpiresAt) {
+			delete(m.magicLinks, h)
+		}
+	}
+	if now.After(l.ExpiresAt) {
+		return MagicLink{}, ErrNotFound
+	}
+	return l, nil
+}
+
+func (m *MemoryStore) UpdateProfile(ctx context.Context, email string, p Profile, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[email]
+	if !ok {
+		return ErrNotFound
+	}
+	u.FullName = p.FullName
+	u.LanguageCode = p.LanguageCode
+	u.CurrencyCode = p.CurrencyCode
+	u.DefaultHome = p.DefaultHome
+	u.LastUpdated = at
+	m.users[email] = u
+	return nil
+}
+
+func (m *MemoryStore) SetPassword(ctx context.Context, email, hash string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[email]
+	if !ok {
+		return ErrNotFound
+	}
+	u.PasswordHash = hash
+	u.LastPswdChange = at
+	u.LastUpdated = at
+	m.users[email] = u
+	return nil
+}
+
+func (m *MemoryStore) SetTOTPSecret(ctx context.Context, email, secret string) error {
+	return m.updateUser(email, func(u *User) {
+		u.TOTPSecret = secret
+		u.TOTPEnabled = false
+		u.TOTPLastStep = 0
+	})
+}
+
+func (m *MemoryStore) EnableTOTP(ctx context.Context, email string, step int64, hashes []string) error {
+	m.updateUser(email, func(u *User) {
+		u.TOTPEnabled = true
+		u.TOTPLastStep = step
+	})
+	return m.ReplaceRecoveryCodes(ctx, email, hashes)
+}
+
+func (m *MemoryStore) DisableTOTP(ctx context.Context, email string) error {
+	m.updateUser(email, func(u *User) {
+		u.TOTPSecret = ""
+		u.TOTPEnabled = false
+		u.TOTPLastStep = 0
+	})
+	return m.ReplaceRecoveryCodes(ctx, email, nil)
+}
+
+func (m *MemoryStore) UseTOTPStep(ctx context.Context, email string, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[email]
+	if !ok || u.TOTPLastStep >= step {
+		return false, nil
+	}
+	u.TOTPLastStep = step
+	m.users[email] = u
+	return true, nil
+}
+
+func (m *MemoryStore) ReplaceRecoveryCodes(ctx context.Context, email string, hashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	codes := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		codes[h] = true
+	}
+	m.recoveryCodes[email] = codes
+	return nil
+}
+
+func (m *MemoryStore) UseRecoveryCode(ctx context.Context, email, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.recoveryCodes[email][hash] {
+		return ErrNotFound
+	}
+	delete(m.recoveryCodes[email], hash)
+	return nil
+}
+
+func (m *MemoryStore) CountRecoveryCodes(ctx context.Context, email string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.recoveryCodes[email]), nil
+}
+
+func (m *MemoryStore) CreateLoginChallenge(ctx context.Context, c LoginChallenge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for h, old := range m.challenges {
+		if now.After(old.ExpiresAt) {
+			delete(m.challenges, h)
+		}
+	}
+	c.Attempts = 0
+	m.challenges[c.Hash] = c
+	return nil
+}
+
+func (m *MemoryStore) GetLoginChallenge(ctx context.Context, hash string) (LoginChallenge, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	c, ok := m.challenges[hash]
+	if !ok {
+		return LoginChallenge{}, ErrNotFound
+	}
+	return c, nil
+}
+
+func (m *MemoryStore) FailLoginChallenge(ctx context.Context, hash string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.challenges[hash]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	c.Attempts++
+	m.challenges[hash] = c
+	return c.Attempts, nil
+}
+
+func (m *MemoryStore) DeleteLoginChallenge(ctx context.Context, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.challenges, hash)
+	return nil
+}
+
+func (m *MemoryStore) CreateOIDCLogin(ctx context.Context, l OIDCLogin) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for h, other := range m.oidcLogins {
+		if now.After(other.ExpiresAt) {
+			delete(m.oidcLogins, h)
+		}
+	}
+	m.oidcLogins[l.StateHash] = l
+	return nil
+}
+
+func (m *MemoryStore) ConsumeOIDCLogin(ctx context.Context, stateHash string, now time.Time) (OIDCLogin, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.oidcLogins[stateHash]
+	delete(m.oidcLogins, stateHash)
+	if !ok || now.After(l.ExpiresAt) {
+		return OIDCLogin{}, ErrNotFound
+	}
+	return l, nil
+}
+
+func (m *MemoryStore) GetIdentity(ctx context.Context, provider, subject string) (Identity, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	id, ok := m.identities[provider+"\x00"+subject]
+	if !ok {
+		return Identity{Provider: provider, Subject: subject}, ErrNotFound
+	}
+	return id, nil
+}
+
+func (m *MemoryStore) LinkIdentity(ctx context.Context, id Identity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := id.Provider + "\x00" + id.Subject
+	if _, ok := m.identities[key]; ok {
+		return ErrConflict
+	}
+	m.identities[key] = id
+	return nil
+}
+
+func (m *MemoryStore) RecordAudit(ctx context.Context, e AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.audit = append(m.audit, e)
+	return nil
+}
+
+func (m *MemoryStore) ClaimSessionLinks(ctx context.Context, sessionID, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.claims[sessionID] = email
+	return nil
+}
+
+func (m *MemoryStore) EnqueueEmail(ctx context.Context, e OutboundEmail) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e.ID = int64(len(m.emails) + 1)
+	e.Status = EmailPending
+	e.Attempts = 0
+	e.LastError = ""
+	m.emails = append(m.emails, e)
+	return e.ID, nil
+}
+
+// email returns the queued email with id, or nil. Callers hold m.mu.
+func (m *MemoryStore) email(id int64) *OutboundEmail {
+	if id < 1 || id > int64(len(m.emails)) {
+		return nil
+	}
+	return &m.emails[id-1]
+}
+
+func (m *MemoryStore) DueEmails(ctx context.Context, now time.Time, limit int) ([]OutboundEmail, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var list []OutboundEmail
+	for _, e := range m.emails {
+		if len(list) == limit {
+			break
+		}
+		if (e.Status == EmailPending || e.Status == EmailSending) && !e.NextAttemptAt.After(now) {
+			list = append(list, e)
+		}
+	}
+	return list, nil
+}
+
+func (m *MemoryStore) ClaimEmail(ctx context.Context, e OutboundEmail, leaseUntil time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur := m.email(e.ID)
+	if cur == nil || cur.Attempts != e.Attempts || (cur.Status != EmailPending && cur.Status != EmailSending) {
+		return false, nil
+	}
+	cur.Status = EmailSending
+	cur.Attempts++
+	cur.NextAttemptAt = leaseUntil
+	return true, nil
+}
+
+func (m *MemoryStore) MarkEmailSent(ctx context.Context, id int64, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.email(id); e != nil {
+		e.Status, e.SentAt, e.Text, e.HTML, e.LastError = EmailSent, at, "", "", ""
+	}
+	return nil
+}
+
+func (m *MemoryStore) RetryEmail(ctx context.Context, id int64, next time.Time, lastErr string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.email(id); e != nil {
+		e.Status, e.NextAttemptAt, e.LastError = EmailPending, next, lastErr
+	}
+	return nil
+}
+
+func (m *MemoryStore) MarkEmailDead(ctx context.Context, id int64, lastErr string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.email(id); e != nil {
+		e.Status, e.LastError = EmailDead, lastErr
+	}
+	return nil
+}
+
+func (m *MemoryStore) ListEmails(ctx context.Context, status string, limit int) ([]OutboundEmail, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var list []OutboundEmail
+	for i := len(m.emails) - 1; i >= 0 && len(list) < limit; i-- {
+		if m.emails[i].Status == status {
+			list = append(list, m.emails[i])
+		}
+	}
+	return list, nil
+}
+
+func (m *MemoryStore) RequeueEmail(ctx context.Context, id int64, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.email(id)
+	if e == nil || e.Status != EmailDead {
+		return ErrNotFound
+	}
+	e.Status, e.Attempts, e.NextAttemptAt = EmailPending, 0, now
+	return nil
+}
+
+func (m *MemoryStore) SuppressEmail(ctx context.Context, s EmailSuppression) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.Email = strings.ToLower(s.Email)
+	m.suppressions[s.Email] = s
+	return nil
+}
+
+func (m *MemoryStore) IsEmailSuppressed(ctx context.Context, email string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.suppressions[strings.ToLower(email)]
+	return ok, nil
+}
+
+func (m *MemoryStore) ListEmailSuppressions(ctx context.Context) ([]EmailSuppression, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := make([]EmailSuppression, 0, len(m.suppressions))
+	for _, s := range m.suppressions {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list, nil
+}
+
+func (m *MemoryStore) DeleteEmailSuppression(ctx context.Context, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	email = strings.ToLower(email)
+	if _, ok := m.suppressions[email]; !ok {
+		return ErrNotFound
+	}
+	delete(m.suppressions, email)
+	return nil
+}
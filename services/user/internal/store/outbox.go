@@ -0,0 +1,65 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Outbound email statuses.
+const (
+	EmailPending = "pending"
+	EmailSending = "sending" // claimed by a worker until NextAttemptAt
+	EmailSent    = "sent"
+	EmailDead    = "dead" // gave up; kept for admins to inspect or requeue
+)
+
+// OutboundEmail is a rendered email waiting in, or done with, the queue.
+// Bodies are cleared once it is sent, since they hold one-time codes.
+type OutboundEmail struct {
+	ID            int64
+	To            string
+	Subject       string
+	Text          string
+	HTML          string
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+	SentAt        time.Time
+}
+
+// EmailSuppression stops all email to an address, e.g. after a hard bounce.
+type EmailSuppression struct {
+	Email     string
+	Reason    string
+	CreatedAt time.Time
+}
+
+// EmailQueueStore persists the outbound email queue.
+type EmailQueueStore interface {
+	// EnqueueEmail stores e as pending, due at e.NextAttemptAt, and returns
+	// its ID.
+	EnqueueEmail(ctx context.Context, e OutboundEmail) (int64, error)
+	// DueEmails returns up to limit pending emails due at now, and sending ones
+	// whose claim has lapsed, oldest first.
+	DueEmails(ctx context.Context, now time.Time, limit int) ([]OutboundEmail, error)
+	// ClaimEmail marks e as sending until leaseUntil and counts an attempt. It
+	// reports false if another worker claimed it first.
+	ClaimEmail(ctx context.Context, e OutboundEmail, leaseUntil time.Time) (bool, error)
+	MarkEmailSent(ctx context.Context, id int64, at time.Time) error
+	// RetryEmail puts the email back as pending, due at next.
+	RetryEmail(ctx context.Context, id int64, next time.Time, lastErr string) error
+	MarkEmailDead(ctx context.Context, id int64, lastErr string) error
+	// ListEmails returns up to limit emails in status, newest first.
+	ListEmails(ctx context.Context, status string, limit int) ([]OutboundEmail, error)
+	// RequeueEmail makes a dead email pending again, due at now, with its
+	// attempts reset; ErrNotFound if there is no such dead email.
+	RequeueEmail(ctx context.Context, id int64, now time.Time) error
+
+	SuppressEmail(ctx context.Context, s EmailSuppression) error
+	IsEmailSuppressed(ctx context.Context, email string) (bool, error)
+	ListEmailSuppressions(ctx context.Context) ([]EmailSuppression, error)
+	// DeleteEmailSuppression returns ErrNotFound if email isn't suppressed.
+	DeleteEmailSuppression(ctx context.Context, email string) error
+}
@@ -0,0 +1,767 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"usethislink/services/internal/storage"
+)
+
+// SQLStore implements UserStore on Postgres or SQLite. Queries are written
+// once with `?` placeholders and rebound for the dialect.
+type SQLStore struct {
+	db      *sql.DB
+	dialect storage.Dialect
+}
+
+func NewPostgres(db *sql.DB) *SQLStore { return &SQLStore{db: db, dialect: storage.Postgres} }
+
+func NewSQLite(db *sql.DB) *SQLStore { return &SQLStore{db: db, dialect: storage.SQLite} }
+
+// New picks the implementation matching dialect.
+func New(db *sql.DB, dialect storage.Dialect) *SQLStore {
+	return &SQLStore{db: db, dialect: dialect}
+}
+
+func (s *SQLStore) q(query string) string { return s.dialect.Rebind(query) }
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+func (s *SQLStore) UserExists(ctx context.Context, email string) (bool, error) {
+	var exists int
+	err := s.db.QueryRowContext(ctx, s.q(`SELECT COUNT(1) FROM USERDEFN WHERE EMAILID = ?`), email).Scan(&exists)
+	return exists > 0, err
+}
+
+func (s *SQLStore) GetUser(ctx context.Context, email string) (User, error) {
+	var u User
+	var totpEnabled int
+	var failedSince, lockedUntil, lastPwdChange, created, lastSignOn, lastSignOff, lastUpd sql.NullTime
+	err := s.db.QueryRowContext(ctx, s.q(`
+		SELECT EMAILID, UNIQUEID, COALESCE(FULLNAMEDESC, ''), USERPSWD, COALESCE(LANGUAGE_CODE, ''), COALESCE(CURRENCY_CODE, ''),
+			COALESCE(DEFAULTHOME, ''), COALESCE(ACCTLOCK, 0), COALESCE(ISSIGNEDIN, 0), COALESCE(FAILEDLOGINS, 0), COALESCE(LOCKCOUNT, 0),
+			COALESCE(TOTPSECRET, ''), COALESCE(TOTPENABLED, 0), COALESCE(TOTPLASTSTEP, 0),
+			FAILEDLOGINDTTM, LOCKEDUNTILDTTM, LASTPSWDCHANGE, CREATEDETTM, LASTSIGNONDTTM, LASTSIGNOFFDTTM, LASTUPDDTTM
+		FROM USERDEFN WHERE EMAILID = ?`), email).Scan(
+		&u.Email, &u.UniqueID, &u.FullName, &u.PasswordHash, &u.LanguageCode, &u.CurrencyCode,
+		&u.DefaultHome, &u.AcctLock, &u.IsSignedIn, &u.FailedLogins, &u.LockCount,
+		&u.TOTPSecret, &totpEnabled, &u.TOTPLastStep,
+		&failedSince, &lockedUntil, &lastPwdChange, &created, &lastSignOn, &lastSignOff, &lastUpd)
+	if err == sql.ErrNoRows {
+		return u, ErrNotFound
+	}
+	u.TOTPEnabled = totpEnabled == 1
+	u.FailedSince = failedSince.Time
+	u.LockedUntil = lockedUntil.Time
+	u.LastPswdChange = lastPwdChange.Time
+	u.CreatedAt = created.Time
+	u.LastSignOn = lastSignOn.Time
+	u.LastSignOff = lastSignOff.Time
+	u.LastUpdated = lastUpd.Time
+	return u, err
+}
+
+func (s *SQLStore) CreateUser(ctx context.Context, u User) error {
+	res, err := s.db.ExecContext(ctx, s.q(`
+		INSERT INTO USERDEFN (EMAILID, UNIQUEID, FULLNAMEDESC, USERPSWD, CREATEDETTM, LASTUPDDTTM, LASTPSWDCHANGE, ACCTLOCK, ISSIGNEDIN, DEFAULTHOME, FAILEDLOGINS, LANGUAGE_CODE, CURRENCY_CODE)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 0, 0, ?, 0, ?, ?)
+		ON CONFLICT DO NOTHING`),
+		u.Email, u.UniqueID, u.FullName, u.PasswordHash, nullTime(u.CreatedAt), nullTime(u.LastUpdated), nullTime(u.LastPswdChange),
+		u.DefaultHome, u.LanguageCode, u.CurrencyCode)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (s *SQLStore) RecordFailedLogin(ctx context.Context, email string, at time.Time, window time.Duration) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// A failure after the window closed starts a new one.
+	_, err = tx.ExecContext(ctx, s.q(`
+		UPDATE USERDEFN SET
+			FAILEDLOGINS = CASE WHEN FAILEDLOGINDTTM IS NULL OR FAILEDLOGINDTTM < ? THEN 1 ELSE COALESCE(FAILEDLOGINS, 0) + 1 END,
+			FAILEDLOGINDTTM = CASE WHEN FAILEDLOGINDTTM IS NULL OR FAILEDLOGINDTTM < ? THEN ? ELSE FAILEDLOGINDTTM END
+		WHERE EMAILID = ?`), at.Add(-window).UTC(), at.Add(-window).UTC(), at.UTC(), email)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	err = tx.QueryRowContext(ctx, s.q(`SELECT COALESCE(FAILEDLOGINS, 0) FROM USERDEFN WHERE EMAILID = ?`), email).Scan(&n)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+func (s *SQLStore) LockUser(ctx context.Context, email string, until time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.q(`
+		UPDATE USERDEFN SET ACCTLOCK = 1, LOCKEDUNTILDTTM = ?, LOCKCOUNT = COALESCE(LOCKCOUNT, 0) + 1,
+			FAILEDLOGINS = 0, FAILEDLOGINDTTM = NULL
+		WHERE EMAILID = ?`), until.UTC(), email)
+	return err
+}
+
+func (s *SQLStore) UnlockUser(ctx context.Context, email string) error {
+	_, err := s.db.ExecContext(ctx, s.q(`
+		UPDATE USERDEFN SET ACCTLOCK = 0, LOCKEDUNTILDTTM = NULL, FAILEDLOGINS = 0, FAILEDLOGINDTTM = NULL
+		WHERE EMAILID = ?`), email)
+	return err
+}
+
+func (s *SQLStore) RecordLogin(ctx context.Context, email string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.q(`
+		UPDATE USERDEFN SET FAILEDLOGINS = 0, FAILEDLOGINDTTM = NULL, LOCKCOUNT = 0, ISSIGNEDIN = 1, LASTSIGNONDTTM = ?
+		WHERE EMAILID = ?`), at.UTC(), email)
+	return err
+}
+
+func (s *SQLStore) RecordLogout(ctx context.Context, email string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.q(`UPDATE USERDEFN SET ISSIGNEDIN = 0, LASTSIGNOFFDTTM = ? WHERE EMAILID = ?`), at.UTC(), email)
+	return err
+}
+
+func (s *SQLStore) SavePendingRegistration(ctx context.Context, p PendingRegistration) error {
+	_, err := s.db.ExecContext(ctx, s.q(`
+		INSERT INTO pending_registrations (EMAILID, OTP, OTP_EXPIRES_AT, OTP_ATTEMPTS, USERPSWD, UNIQUEID, CREATED_AT)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (EMAILID) DO UPDATE SET
+			OTP = excluded.OTP,
+			OTP_EXPIRES_AT = excluded.OTP_EXPIRES_AT,
+			OTP_ATTEMPTS = excluded.OTP_ATTEMPTS,
+			USERPSWD = excluded.USERPSWD,
+			UNIQUEID = excluded.UNIQUEID,
+			CREATED_AT = excluded.CREATED_AT`),
+		p.Email, p.OTPHash, p.OTPExpiresAt.UTC(), p.OTPAttempts, p.PasswordHash, p.UniqueID, p.CreatedAt.UTC())
+	return err
+}
+
+func (s *SQLStore) GetPendingRegistration(ctx context.Context, email string) (PendingRegistration, error) {
+	p := PendingRegistration{Email: email}
+	err := s.db.QueryRowContext(ctx, s.q(`
+		SELECT OTP, OTP_EXPIRES_AT, OTP_ATTEMPTS, USERPSWD, UNIQUEID, CREATED_AT FROM pending_registrations WHERE EMAILID = ?`), email).Scan(
+		&p.OTPHash, &p.OTPExpiresAt, &p.OTPAttempts, &p.PasswordHash, &p.UniqueID, &p.CreatedAt)
+	if err == sql.ErrNoRows {
+		return p, ErrNotFound
+	}
+	return p, err
+}
+
+func (s *SQLStore) DeletePendingRegistration(ctx context.Context, email string) error {
+	_, err := s.db.ExecContext(ctx, s.q(`DELETE FROM pending_registrations WHERE EMAILID = ?`), email)
+	return err
+}
+
+func (s *SQLStore) FailOTPAttempt(ctx context.Context, email string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, s.q(`UPDATE pending_registrations SET OTP_ATTEMPTS = OTP_ATTEMPTS + 1 WHERE EMAILID = ?`), email); err != nil {
+		return 0, err
+	}
+	var n int
+	err = tx.QueryRowContext(ctx, s.q(`SELECT OTP_ATTEMPTS FROM pending_registrations WHERE EMAILID = ?`), email).Scan(&n)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+func (s *SQLStore) RecordOTPSend(ctx context.Context, email, ip string, at time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, s.q(`DELETE FROM otp_sends WHERE sent_at < ?`), at.Add(-24*time.Hour).UTC()); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.q(`
+		INSERT INTO otp_sends (EMAILID, ip_address, sent_at) VALUES (?, ?, ?)`),
+		strings.ToLower(email), ip, at.UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) OTPSends(ctx context.Context, email, ip string, since time.Time) (OTPSendStats, error) {
+	var stats OTPSendStats
+	rows, err := s.db.QueryContext(ctx, s.q(`
+		SELECT EMAILID, ip_address, sent_at FROM otp_sends
+		WHERE (EMAILID = ? OR ip_address = ?) AND sent_at >= ?`),
+		strings.ToLower(email), ip, since.UTC())
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e, addr string
+		var at time.Time
+		if err := rows.Scan(&e, &addr, &at); err != nil {
+			return stats, err
+		}
+		stats.add(strings.EqualFold(e, email), ip != "" && addr == ip, at)
+	}
+	return stats, rows.Err()
+}
+
+const sessionColumns = `session_id, COALESCE(user_agent, ''), COALESCE(ip_address, ''), COALESCE(user_email, ''), created_at, last_seen_at`
+
+func scanSession(row interface{ Scan(...any) error }) (Session, error) {
+	var sess Session
+	var created, lastSeen sql.NullTime
+	err := row.Scan(&sess.ID, &sess.UserAgent, &sess.IPAddress, &sess.UserEmail, &created, &lastSeen)
+	if err == sql.ErrNoRows {
+		return sess, ErrNotFound
+	}
+	sess.CreatedAt = created.Time
+	sess.LastSeenAt = lastSeen.Time
+	return sess, err
+}
+
+func (s *SQLStore) CreateSession(ctx context.Context, sess Session) error {
+	res, err := s.db.ExecContext(ctx, s.q(`
+		INSERT INTO sessions (session_id, user_agent, ip_address, user_email, created_at, last_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`),
+		sess.ID, sess.UserAgent, sess.IPAddress, sess.UserEmail, sess.CreatedAt.UTC(), sess.LastSeenAt.UTC())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (s *SQLStore) GetSession(ctx context.Context, id string) (Session, error) {
+	return scanSession(s.db.QueryRowContext(ctx, s.q(`SELECT `+sessionColumns+` FROM sessions WHERE session_id = ?`), id))
+}
+
+func (s *SQLStore) TouchSession(ctx context.Context, id string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.q(`UPDATE sessions SET last_seen_at = ? WHERE session_id = ?`), at.UTC(), id)
+	return err
+}
+
+func (s *SQLStore) ListSessions(ctx context.Context, email string) ([]Session, error) {
+	rows, err := s.db.QueryContext(ctx, s.q(`
+		SELECT `+sessionColumns+` FROM sessions WHERE user_email = ? ORDER BY last_seen_at DESC`), email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []Session
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, sess)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLStore) DeleteSession(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, s.q(`DELETE FROM sessions WHERE session_id = ?`), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) DeleteUserSessions(ctx context.Context, email, keepID string) (int, error) {
+	res, err := s.db.ExecContext(ctx, s.q(`DELETE FROM sessions WHERE user_email = ? AND session_id <> ?`), email, keepID)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+func (s *SQLStore) DeleteExpiredSessions(ctx context.Context, idleBefore, createdBefore time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.q(`DELETE FROM sessions WHERE last_seen_at < ? OR created_at < ?`),
+		idleBefore.UTC(), createdBefore.UTC())
+	return err
+}
+
+// createToken and consumeToken back the EmailToken tables, which share
+// their layout.
+func (s *SQLStore) createToken(ctx context.Context, table string, t EmailToken) error {
+	_, err := s.db.ExecContext(ctx, s.q(`
+		INSERT INTO `+table+` (token_hash, EMAILID, expires_at, created_at) VALUES (?, ?, ?, ?)`),
+		t.Hash, t.Email, t.ExpiresAt.UTC(), time.Now().UTC())
+	return err
+}
+
+func (s *SQLStore) consumeToken(ctx context.Context, table, hash string, now time.Time) (string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	var email string
+	var expires time.Time
+	err = tx.QueryRowContext(ctx, s.q(`SELECT EMAILID, expires_at FROM `+table+` WHERE token_hash = ?`), hash).Scan(&email, &expires)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", err
+	}
+	if _, err := tx.ExecContext(ctx, s.q(`DELETE FROM `+table+` WHERE EMAILID = ?`), email); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	if now.After(expires) {
+		return "", ErrNotFound
+	}
+	return email, nil
+}
+
+func (s *SQLStore) CreateUnlockToken(ctx context.Context, t EmailToken) error {
+	return s.createToken(ctx, "unlock_tokens", t)
+}
+
+func (s *SQLStore) ConsumeUnlockToken(ctx context.Context, hash string, now time.Time) (string, error) {
+	return s.consumeToken(ctx, "unlock_tokens", hash, now)
+}
+
+func (s *SQLStore) CreatePasswordReset(ctx context.Context, t EmailToken) error {
+	return s.createToken(ctx, "password_resets", t)
+}
+
+func (s *SQLStore) ConsumePasswordReset(ctx context.Context, hash string, now time.Time) (string, error) {
+	return s.consumeToken(ctx, "password_resets", hash, now)
+}
+
+func (s *SQLStore) CreateMagicLink(ctx context.Context, l MagicLink) error {
+	_, err := s.db.ExecContext(ctx, s.q(`
+		INSERT INTO magic_links (token_hash, EMAILID, browser_hash, expires_at, created_at) VALUES (?, ?, ?, ?, ?)`),
+		l.Hash, l.Email, l.BrowserHash, l.ExpiresAt.UTC(), time.Now().UTC())
+	return err
+}
+
+func (s *SQLStore) ConsumeMagicLink(ctx context.Context, hash string, now time.Time) (MagicLink, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return MagicLink{}, err
+	}
+	defer tx.Rollback()
+	l := MagicLink{Hash: hash}
+	err = tx.QueryRowContext(ctx, s.q(`
+		SELECT EMAILID, browser_hash, expires_at FROM magic_links WHERE token_hash = ?`), hash).Scan(&l.Email, &l.BrowserHash, &l.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return MagicLink{}, ErrNotFound
+	} else if err != nil {
+		return MagicLink{}, err
+	}
+	if _, err := tx.ExecContext(ctx, s.q(`DELETE FROM magic_links WHERE EMAILID = ? OR expires_at < ?`), l.Email, now.UTC()); err != nil {
+		return MagicLink{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return MagicLink{}, err
+	}
+	if now.After(l.ExpiresAt) {
+		return MagicLink{}, ErrNotFound
+	}
+	return l, nil
+}
+
+func (s *SQLStore) UpdateProfile(ctx context.Context, email string, p Profile, at time.Time) error {
+	res, err := s.db.ExecContext(ctx, s.q(`
+		UPDATE USERDEFN SET FULLNAMEDESC = ?, LANGUAGE_CODE = ?, CURRENCY_CODE = ?, DEFAULTHOME = ?, LASTUPDDTTM = ?
+		WHERE EMAILID = ?`), p.FullName, p.LanguageCode, p.CurrencyCode, p.DefaultHome, at.UTC(), email)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) SetPassword(ctx context.Context, email, hash string, at time.Time) error {
+	res, err := s.db.ExecContext(ctx, s.q(`
+		UPDATE USERDEFN SET USERPSWD = ?, LASTPSWDCHANGE = ?, LASTUPDDTTM = ? WHERE EMAILID = ?`),
+		hash, at.UTC(), at.UTC(), email)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) SetTOTPSecret(ctx context.Context, email, secret string) error {
+	_, err := s.db.ExecContext(ctx, s.q(`
+		UPDATE USERDEFN SET TOTPSECRET = ?, TOTPENABLED = 0, TOTPLASTSTEP = 0 WHERE EMAILID = ?`), secret, email)
+	return err
+}
+
+func (s *SQLStore) EnableTOTP(ctx context.Context, email string, step int64, hashes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, s.q(`UPDATE USERDEFN SET TOTPENABLED = 1, TOTPLASTSTEP = ? WHERE EMAILID = ?`), step, email)
+	if err != nil {
+		return err
+	}
+	if err := s.replaceRecoveryCodes(ctx, tx, email, hashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) DisableTOTP(ctx context.Context, email string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, s.q(`
+		UPDATE USERDEFN SET TOTPSECRET = '', TOTPENABLED = 0, TOTPLASTSTEP = 0 WHERE EMAILID = ?`), email)
+	if err != nil {
+		return err
+	}
+	if err := s.replaceRecoveryCodes(ctx, tx, email, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) UseTOTPStep(ctx context.Context, email string, step int64) (bool, error) {
+	res, err := s.db.ExecContext(ctx, s.q(`
+		UPDATE USERDEFN SET TOTPLASTSTEP = ? WHERE EMAILID = ? AND COALESCE(TOTPLASTSTEP, 0) < ?`), step, email, step)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (s *SQLStore) replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, email string, hashes []string) error {
+	if _, err := tx.ExecContext(ctx, s.q(`DELETE FROM recovery_codes WHERE EMAILID = ?`), email); err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		_, err := tx.ExecContext(ctx, s.q(`INSERT INTO recovery_codes (EMAILID, code_hash) VALUES (?, ?)`), email, h)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) ReplaceRecoveryCodes(ctx context.Context, email string, hashes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := s.replaceRecoveryCodes(ctx, tx, email, hashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) UseRecoveryCode(ctx context.Context, email, hash string) error {
+	res, err := s.db.ExecContext(ctx, s.q(`DELETE FROM recovery_codes WHERE EMAILID = ? AND code_hash = ?`), email, hash)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) CountRecoveryCodes(ctx context.Context, email string) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, s.q(`SELECT COUNT(1) FROM recovery_codes WHERE EMAILID = ?`), email).Scan(&n)
+	return n, err
+}
+
+func (s *SQLStore) CreateLoginChallenge(ctx context.Context, c LoginChallenge) error {
+	if _, err := s.db.ExecContext(ctx, s.q(`DELETE FROM login_challenges WHERE expires_at < ?`), time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, s.q(`
+		INSERT INTO login_challenges (token_hash, EMAILID, expires_at, attempts, created_at) VALUES (?, ?, ?, 0, ?)`),
+		c.Hash, c.Email, c.ExpiresAt.UTC(), time.Now().UTC())
+	return err
+}
+
+func (s *SQLStore) GetLoginChallenge(ctx context.Context, hash string) (LoginChallenge, error) {
+	c := LoginChallenge{Hash: hash}
+	err := s.db.QueryRowContext(ctx, s.q(`
+		SELECT EMAILID, expires_at, attempts FROM login_challenges WHERE token_hash = ?`), hash).Scan(&c.Email, &c.ExpiresAt, &c.Attempts)
+	if err == sql.ErrNoRows {
+		return c, ErrNotFound
+	}
+	return c, err
+}
+
+func (s *SQLStore) FailLoginChallenge(ctx context.Context, hash string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, s.q(`UPDATE login_challenges SET attempts = attempts + 1 WHERE token_hash = ?`), hash); err != nil {
+		return 0, err
+	}
+	var n int
+	err = tx.QueryRowContext(ctx, s.q(`SELECT attempts FROM login_challenges WHERE token_hash = ?`), hash).Scan(&n)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+func (s *SQLStore) DeleteLoginChallenge(ctx context.Context, hash string) error {
+	_, err := s.db.ExecContext(ctx, s.q(`DELETE FROM login_challenges WHERE token_hash = ?`), hash)
+	return err
+}
+
+func (s *SQLStore) CreateOIDCLogin(ctx context.Context, l OIDCLogin) error {
+	if _, err := s.db.ExecContext(ctx, s.q(`DELETE FROM oidc_logins WHERE expires_at < ?`), time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, s.q(`
+		INSERT INTO oidc_logins (state_hash, provider, nonce, code_verifier, expires_at) VALUES (?, ?, ?, ?, ?)`),
+		l.StateHash, l.Provider, l.Nonce, l.CodeVerifier, l.ExpiresAt.UTC())
+	return err
+}
+
+func (s *SQLStore) ConsumeOIDCLogin(ctx context.Context, stateHash string, now time.Time) (OIDCLogin, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return OIDCLogin{}, err
+	}
+	defer tx.Rollback()
+	l := OIDCLogin{StateHash: stateHash}
+	err = tx.QueryRowContext(ctx, s.q(`
+		SELECT provider, nonce, code_verifier, expires_at FROM oidc_logins WHERE state_hash = ?`), stateHash).Scan(
+		&l.Provider, &l.Nonce, &l.CodeVerifier, &l.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return OIDCLogin{}, ErrNotFound
+	} else if err != nil {
+		return OIDCLogin{}, err
+	}
+	if _, err := tx.ExecContext(ctx, s.q(`DELETE FROM oidc_logins WHERE state_hash = ?`), stateHash); err != nil {
+		return OIDCLogin{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return OIDCLogin{}, err
+	}
+	if now.After(l.ExpiresAt) {
+		return OIDCLogin{}, ErrNotFound
+	}
+	return l, nil
+}
+
+func (s *SQLStore) GetIdentity(ctx context.Context, provider, subject string) (Identity, error) {
+	id := Identity{Provider: provider, Subject: subject}
+	var created sql.NullTime
+	err := s.db.QueryRowContext(ctx, s.q(`
+		SELECT EMAILID, created_at FROM user_identities WHERE provider = ? AND subject = ?`), provider, subject).Scan(&id.Email, &created)
+	if err == sql.ErrNoRows {
+		return id, ErrNotFound
+	}
+	id.CreatedAt = created.Time
+	return id, err
+}
+
+func (s *SQLStore) LinkIdentity(ctx context.Context, id Identity) error {
+	res, err := s.db.ExecContext(ctx, s.q(`
+		INSERT INTO user_identities (provider, subject, EMAILID, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`),
+		id.Provider, id.Subject, id.Email, id.CreatedAt.UTC())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (s *SQLStore) RecordAudit(ctx context.Context, e AuditEvent) error {
+	_, err := s.db.ExecContext(ctx, s.q(`
+		INSERT INTO account_audit (EMAILID, event, reason, ip_address, created_at) VALUES (?, ?, ?, ?, ?)`),
+		e.Email, e.Event, e.Reason, e.IPAddress, e.CreatedAt.UTC())
+	return err
+}
+
+func (s *SQLStore) ClaimSessionLinks(ctx context.Context, sessionID, email string) error {
+	_, err := s.db.ExecContext(ctx, s.q(`UPDATE url_mappings SET user_email = ? WHERE session_id = ? AND (user_email IS NULL OR user_email = '')`), email, sessionID)
+	return err
+}
+
+const emailColumns = `id, recipient, subject, text_body, html_body, status, attempts, next_attempt_at, last_error, created_at, sent_at`
+
+func scanEmail(row interface{ Scan(...any) error }) (OutboundEmail, error) {
+	var e OutboundEmail
+	var sent sql.NullTime
+	err := row.Scan(&e.ID, &e.To, &e.Subject, &e.Text, &e.HTML, &e.Status, &e.Attempts, &e.NextAttemptAt, &e.LastError, &e.CreatedAt, &sent)
+	if err == sql.ErrNoRows {
+		return e, ErrNotFound
+	}
+	e.SentAt = sent.Time
+	return e, err
+}
+
+func (s *SQLStore) queryEmails(ctx context.Context, query string, args ...any) ([]OutboundEmail, error) {
+	rows, err := s.db.QueryContext(ctx, s.q(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []OutboundEmail
+	for rows.Next() {
+		e, err := scanEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLStore) EnqueueEmail(ctx context.Context, e OutboundEmail) (int64, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx, s.q(`
+		INSERT INTO email_queue (recipient, subject, text_body, html_body, status, attempts, next_attempt_at, last_error, created_at)
+		VALUES (?, ?, ?, ?, ?, 0, ?, '', ?)
+		RETURNING id`),
+		e.To, e.Subject, e.Text, e.HTML, EmailPending, e.NextAttemptAt.UTC(), e.CreatedAt.UTC()).Scan(&id)
+	return id, err
+}
+
+func (s *SQLStore) DueEmails(ctx context.Context, now time.Time, limit int) ([]OutboundEmail, error) {
+	return s.queryEmails(ctx, `
+		SELECT `+emailColumns+` FROM email_queue
+		WHERE status IN (?, ?) AND next_attempt_at <= ?
+		ORDER BY id LIMIT ?`, EmailPending, EmailSending, now.UTC(), limit)
+}
+
+func (s *SQLStore) ClaimEmail(ctx context.Context, e OutboundEmail, leaseUntil time.Time) (bool, error) {
+	// The attempt count doubles as a version, so only one claim succeeds.
+	res, err := s.db.ExecContext(ctx, s.q(`
+		UPDATE email_queue SET status = ?, attempts = attempts + 1, next_attempt_at = ?
+		WHERE id = ? AND attempts = ? AND status IN (?, ?)`),
+		EmailSending, leaseUntil.UTC(), e.ID, e.Attempts, EmailPending, EmailSending)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (s *SQLStore) MarkEmailSent(ctx context.Context, id int64, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.q(`
+		UPDATE email_queue SET status = ?, sent_at = ?, text_body = '', html_body = '', last_error = '' WHERE id = ?`),
+		EmailSent, at.UTC(), id)
+	return err
+}
+
+func (s *SQLStore) RetryEmail(ctx context.Context, id int64, next time.Time, lastErr string) error {
+	_, err := s.db.ExecContext(ctx, s.q(`
+		UPDATE email_queue SET status = ?, next_attempt_at = ?, last_error = ? WHERE id = ?`),
+		EmailPending, next.UTC(), lastErr, id)
+	return err
+}
+
+func (s *SQLStore) MarkEmailDead(ctx context.Context, id int64, lastErr string) error {
+	_, err := s.db.ExecContext(ctx, s.q(`UPDATE email_queue SET status = ?, last_error = ? WHERE id = ?`), EmailDead, lastErr, id)
+	return err
+}
+
+func (s *SQLStore) ListEmails(ctx context.Context, status string, limit int) ([]OutboundEmail, error) {
+	return s.queryEmails(ctx, `
+		SELECT `+emailColumns+` FROM email_queue WHERE status = ? ORDER BY id DESC LIMIT ?`, status, limit)
+}
+
+func (s *SQLStore) RequeueEmail(ctx context.Context, id int64, now time.Time) error {
+	res, err := s.db.ExecContext(ctx, s.q(`
+		UPDATE email_queue SET status = ?, attempts = 0, next_attempt_at = ? WHERE id = ? AND status = ?`),
+		EmailPending, now.UTC(), id, EmailDead)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) SuppressEmail(ctx context.Context, sup EmailSuppression) error {
+	_, err := s.db.ExecContext(ctx, s.q(`
+		INSERT INTO email_suppressions (EMAILID, reason, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (EMAILID) DO UPDATE SET reason = excluded.reason, created_at = excluded.created_at`),
+		strings.ToLower(sup.Email), sup.Reason, sup.CreatedAt.UTC())
+	return err
+}
+
+func (s *SQLStore) IsEmailSuppressed(ctx context.Context, email string) (bool, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, s.q(`SELECT COUNT(1) FROM email_suppressions WHERE EMAILID = ?`), strings.ToLower(email)).Scan(&n)
+	return n > 0, err
+}
+
+func (s *SQLStore) ListEmailSuppressions(ctx context.Context) ([]EmailSuppression, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT EMAILID, reason, created_at FROM email_suppressions ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []EmailSuppression
+	for rows.Next() {
+		var sup EmailSuppression
+		if err := rows.Scan(&sup.Email, &sup.Reason, &sup.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, sup)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLStore) DeleteEmailSuppression(ctx context.Context, email string) error {
+	res, err := s.db.ExecContext(ctx, s.q(`DELETE FROM email_suppressions WHERE EMAILID = ?`), strings.ToLower(email))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
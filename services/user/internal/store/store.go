@@ -0,0 +1,264 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"usethislink/services/internal/storage"
+)
+
+// Re-exported so handlers only need to import this package.
+var (
+	ErrNotFound = storage.ErrNotFound
+	ErrConflict = storage.ErrConflict
+)
+
+// User is one row of USERDEFN. Zero times mean the column is NULL.
+type User struct {
+	Email          string
+	UniqueID       string
+	FullName       string
+	PasswordHash   string
+	LanguageCode   string
+	CurrencyCode   string
+	DefaultHome    string
+	AcctLock       int
+	IsSignedIn     int
+	FailedLogins   int
+	LockCount      int       // locks since the last successful sign-in
+	FailedSince    time.Time // first failure of the current window
+	LockedUntil    time.Time // zero with AcctLock set means locked until unlocked by hand
+	TOTPSecret     string    // base32; set but not enabled while enrolling
+	TOTPEnabled    bool
+	TOTPLastStep   int64 // last accepted time step, so codes can't be replayed
+	LastPswdChange time.Time
+	CreatedAt      time.Time
+	LastSignOn     time.Time
+	LastSignOff    time.Time
+	LastUpdated    time.Time
+}
+
+// Profile is the part of a User its owner can edit.
+type Profile struct {
+	FullName     string
+	LanguageCode string
+	CurrencyCode string
+	DefaultHome  string
+}
+
+// PendingRegistration is a sign-up waiting for its emailed OTP. Only the
+// OTP's hash is stored.
+type PendingRegistration struct {
+	Email        string
+	OTPHash      string
+	OTPExpiresAt time.Time
+	OTPAttempts  int // wrong codes entered so far
+	PasswordHash string
+	UniqueID     string
+	CreatedAt    time.Time
+}
+
+// OTPSendStats summarises the OTP emails sent to an address and requested
+// from an IP address since some time.
+type OTPSendStats struct {
+	ByEmail      int
+	ByIP         int
+	FirstToEmail time.Time
+	LastToEmail  time.Time
+	FirstFromIP  time.Time
+}
+
+func (st *OTPSendStats) add(toEmail, fromIP bool, at time.Time) {
+	if toEmail {
+		st.ByEmail++
+		if st.FirstToEmail.IsZero() || at.Before(st.FirstToEmail) {
+			st.FirstToEmail = at
+		}
+		if at.After(st.LastToEmail) {
+			st.LastToEmail = at
+		}
+	}
+	if fromIP {
+		st.ByIP++
+		if st.FirstFromIP.IsZero() || at.Before(st.FirstFromIP) {
+			st.FirstFromIP = at
+		}
+	}
+}
+
+// Session is one signed-in browser. ID is the SHA-256 of the cookie token;
+// the token itself is never stored.
+type Session struct {
+	ID         string
+	UserAgent  string
+	IPAddress  string
+	UserEmail  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+}
+
+// EmailToken is a single-use token sent by email, such as an unlock or
+// password reset link. Only the token's hash is stored.
+type EmailToken struct {
+	Hash      string
+	Email     string
+	ExpiresAt time.Time
+}
+
+// MagicLink is a single-use sign-in link sent by email. BrowserHash is the
+// hash of the nonce cookie set on the browser that asked for it, or "" if
+// the link isn't bound to a browser. Only hashes are stored.
+type MagicLink struct {
+	Hash        string
+	Email       string
+	BrowserHash string
+	ExpiresAt   time.Time
+}
+
+// Identity links an account at an external OpenID Connect provider (its
+// issuer-unique subject) to a user.
+type Identity struct {
+	Provider  string
+	Subject   string
+	Email     string
+	CreatedAt time.Time
+}
+
+// OIDCLogin is a sign-in in progress at an external provider, keyed by the
+// hash of its state parameter.
+type OIDCLogin struct {
+	StateHash    string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+// LoginChallenge is the pre-auth token between the password and second-factor
+// steps of signing in. Only the token's hash is stored.
+type LoginChallenge struct {
+	Hash      string
+	Email     string
+	ExpiresAt time.Time
+	Attempts  int // wrong codes entered so far
+}
+
+// Audit events.
+const (
+	AuditLock   = "lock"
+	AuditUnlock = "unlock"
+
+	AuditPasswordReset  = "password_reset"
+	AuditPasswordChange = "password_change"
+
+	AuditTOTPEnabled      = "2fa_enabled"
+	AuditTOTPDisabled     = "2fa_disabled"
+	AuditRecoveryCodes    = "recovery_codes_regenerated"
+	AuditRecoveryCodeUsed = "recovery_code_used"
+
+	AuditIdentityLinked = "identity_linked"
+)
+
+// AuditEvent is one entry of an account's security audit trail.
+type AuditEvent struct {
+	Email     string
+	Event     string
+	Reason    string
+	IPAddress string
+	CreatedAt time.Time
+}
+
+// UserStore is everything the user service persists.
+type UserStore interface {
+	UserExists(ctx context.Context, email string) (bool, error)
+	GetUser(ctx context.Context, email string) (User, error)
+	// CreateUser returns ErrConflict if the email is already registered.
+	CreateUser(ctx context.Context, u User) error
+	// RecordFailedLogin counts a failed login at at and returns the failures
+	// since the first one within window.
+	RecordFailedLogin(ctx context.Context, email string, at time.Time, window time.Duration) (int, error)
+	// LockUser locks the account until until and clears the failure count.
+	LockUser(ctx context.Context, email string, until time.Time) error
+	UnlockUser(ctx context.Context, email string) error
+	RecordLogin(ctx context.Context, email string, at time.Time) error
+	RecordLogout(ctx context.Context, email string, at time.Time) error
+
+	// SavePendingRegistration replaces any earlier pending sign-up for the email.
+	SavePendingRegistration(ctx context.Context, p PendingRegistration) error
+	GetPendingRegistration(ctx context.Context, email string) (PendingRegistration, error)
+	DeletePendingRegistration(ctx context.Context, email string) error
+	// FailOTPAttempt counts a wrong OTP and returns the attempts so far, or
+	// ErrNotFound if nothing is pending for the email.
+	FailOTPAttempt(ctx context.Context, email string) (int, error)
+	// RecordOTPSend logs an OTP sent to email at ip's request, and drops
+	// entries more than a day older than at.
+	RecordOTPSend(ctx context.Context, email, ip string, at time.Time) error
+	OTPSends(ctx context.Context, email, ip string, since time.Time) (OTPSendStats, error)
+
+	// CreateSession returns ErrConflict if the ID is taken.
+	CreateSession(ctx context.Context, s Session) error
+	GetSession(ctx context.Context, id string) (Session, error)
+	// TouchSession records activity at at, sliding the idle timeout.
+	TouchSession(ctx context.Context, id string, at time.Time) error
+	// ListSessions returns email's sessions, most recently used first.
+	ListSessions(ctx context.Context, email string) ([]Session, error)
+	DeleteSession(ctx context.Context, id string) error
+	// DeleteUserSessions revokes all of email's sessions but keepID ("" for
+	// none) and returns how many it removed.
+	DeleteUserSessions(ctx context.Context, email, keepID string) (int, error)
+	// DeleteExpiredSessions drops sessions last used before idleBefore or
+	// created before createdBefore.
+	DeleteExpiredSessions(ctx context.Context, idleBefore, createdBefore time.Time) error
+
+	// Consume* return the token's email and delete it with every other token
+	// of that email and kind, or return ErrNotFound if it is unknown or
+	// expired at now.
+	CreateUnlockToken(ctx context.Context, t EmailToken) error
+	ConsumeUnlockToken(ctx context.Context, hash string, now time.Time) (string, error)
+	CreatePasswordReset(ctx context.Context, t EmailToken) error
+	ConsumePasswordReset(ctx context.Context, hash string, now time.Time) (string, error)
+	CreateMagicLink(ctx context.Context, l MagicLink) error
+	// ConsumeMagicLink works like the Consume* methods but returns the link.
+	ConsumeMagicLink(ctx context.Context, hash string, now time.Time) (MagicLink, error)
+	UpdateProfile(ctx context.Context, email string, p Profile, at time.Time) error
+	// SetPassword stores a new bcrypt hash changed at at.
+	SetPassword(ctx context.Context, email, hash string, at time.Time) error
+
+	// SetTOTPSecret starts enrollment: secret is stored but not yet enabled.
+	SetTOTPSecret(ctx context.Context, email, secret string) error
+	// EnableTOTP turns on the stored secret, first used at step, and replaces
+	// the recovery codes with hashes.
+	EnableTOTP(ctx context.Context, email string, step int64, hashes []string) error
+	// DisableTOTP clears the secret and the recovery codes.
+	DisableTOTP(ctx context.Context, email string) error
+	// UseTOTPStep records a code accepted at step. It reports false if that
+	// step or a later one was already used.
+	UseTOTPStep(ctx context.Context, email string, step int64) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, email string, hashes []string) error
+	// UseRecoveryCode deletes the code, or returns ErrNotFound.
+	UseRecoveryCode(ctx context.Context, email, hash string) error
+	CountRecoveryCodes(ctx context.Context, email string) (int, error)
+
+	// CreateLoginChallenge also drops expired challenges.
+	CreateLoginChallenge(ctx context.Context, c LoginChallenge) error
+	GetLoginChallenge(ctx context.Context, hash string) (LoginChallenge, error)
+	// FailLoginChallenge counts a wrong code and returns the attempts so far.
+	FailLoginChallenge(ctx context.Context, hash string) (int, error)
+	DeleteLoginChallenge(ctx context.Context, hash string) error
+
+	// CreateOIDCLogin also drops expired sign-ins.
+	CreateOIDCLogin(ctx context.Context, l OIDCLogin) error
+	// ConsumeOIDCLogin deletes and returns the sign-in, or returns
+	// ErrNotFound if it is unknown or expired at now.
+	ConsumeOIDCLogin(ctx context.Context, stateHash string, now time.Time) (OIDCLogin, error)
+	GetIdentity(ctx context.Context, provider, subject string) (Identity, error)
+	// LinkIdentity returns ErrConflict if the identity is already linked.
+	LinkIdentity(ctx context.Context, id Identity) error
+
+	RecordAudit(ctx context.Context, e AuditEvent) error
+
+	EmailQueueStore
+
+	// ClaimSessionLinks hands links created anonymously in sessionID to email.
+	ClaimSessionLinks(ctx context.Context, sessionID, email string) error
+}
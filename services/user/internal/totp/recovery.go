@@ -0,0 +1,47 @@
+package totp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// RecoveryCodeCount is how many recovery codes are issued at a time.
+const RecoveryCodeCount = 10
+
+// recoveryAlphabet leaves out characters that are easily misread.
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// NewRecoveryCodes returns RecoveryCodeCount codes formatted as xxxxx-xxxxx.
+func NewRecoveryCodes() ([]string, error) {
+	// Bytes from limit up are rejected so every character is equally likely.
+	limit := 256 - 256%len(recoveryAlphabet)
+	codes := make([]string, RecoveryCodeCount)
+	b := make([]byte, 1)
+	for i := range codes {
+		var sb strings.Builder
+		for sb.Len() < 11 {
+			if sb.Len() == 5 {
+				sb.WriteByte('-')
+				continue
+			}
+			if _, err := rand.Read(b); err != nil {
+				return nil, err
+			}
+			if int(b[0]) < limit {
+				sb.WriteByte(recoveryAlphabet[int(b[0])%len(recoveryAlphabet)])
+			}
+		}
+		codes[i] = sb.String()
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode is how recovery codes are stored. Case, spaces and dashes
+// are ignored so a code can be typed however it was written down.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
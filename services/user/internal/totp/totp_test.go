@@ -0,0 +1,88 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 Appendix B, "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	// The RFC lists 8-digit codes; ours are their last 6 digits.
+	for _, tc := range []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	} {
+		got, err := Code(rfcSecret, Step(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("Code at T=%d = %s, want %s", tc.unix, got, tc.want)
+		}
+	}
+}
+
+func TestCodeLowercaseSecret(t *testing.T) {
+	got, err := Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "287082" {
+		t.Errorf("Code = %s, want 287082", got)
+	}
+}
+
+func TestCodeBadSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code accepted a secret that is not base32")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+	codeAt := func(step int64) string {
+		t.Helper()
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	for _, tc := range []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", codeAt(current), 0, current, true},
+		{"spaced like an app shows it", codeAt(current)[:3] + " " + codeAt(current)[3:], 0, current, true},
+		{"one step behind", codeAt(current - 1), 0, current - 1, true},
+		{"one step ahead", codeAt(current + 1), 0, current + 1, true},
+		{"two steps behind", codeAt(current - 2), 0, 0, false},
+		{"two steps ahead", codeAt(current + 2), 0, 0, false},
+		{"replayed step", codeAt(current), current, 0, false},
+		{"step before the last one used", codeAt(current - 1), current - 1, 0, false},
+		{"later step after a used one", codeAt(current + 1), current, current + 1, true},
+		{"wrong code", "000000", 0, 0, false},
+		{"too short", codeAt(current)[:5], 0, 0, false},
+		{"too long", codeAt(current) + "0", 0, 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			step, ok := Verify(rfcSecret, tc.code, now, tc.lastStep)
+			if ok != tc.wantOK || step != tc.wantStep {
+				t.Errorf("Verify(%q, lastStep %d) = %d, %v; want %d, %v", tc.code, tc.lastStep, step, ok, tc.wantStep, tc.wantOK)
+			}
+		})
+	}
+}
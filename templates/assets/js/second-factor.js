@@ -0,0 +1,32 @@
+// Finishes a sign-in whose first step answered {"status":"2fa_required"}:
+// shows #twoFactorForm and posts the authenticator or recovery code with the
+// pre-auth token to /api/login/2fa, then goes to the home page.
+function askSecondFactor(preAuthToken, show) {
+  const form = document.getElementById('twoFactorForm');
+  form.style.display = 'block';
+  show('Enter the code from your authenticator app, or a recovery code.', false);
+  form.addEventListener('submit', async (e) => {
+    e.preventDefault();
+    const code = document.getElementById('twoFactorCode').value.trim();
+    const body = { pre_auth_token: preAuthToken };
+    if (/^\d{6}$/.test(code)) {
+      body.code = code;
+    } else {
+      body.recovery_code = code;
+    }
+    try {
+      const res = await fetch('/api/login/2fa', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(body)
+      });
+      if (res.ok) {
+        location.replace('/');
+        return;
+      }
+      show((await res.text()).trim() || 'That code did not work.', true);
+    } catch (err) {
+      show('Could not reach the server. Try again.', true);
+    }
+  });
+}